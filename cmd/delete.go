@@ -16,18 +16,67 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/ksonnet/kubecfg/pkg/kubecfg"
+	"github.com/ksonnet/kubecfg/utils"
 )
 
 const (
-	flagGracePeriod = "grace-period"
+	flagGracePeriod    = "grace-period"
+	flagCascade        = "cascade"
+	flagGcPolicy       = "gc-policy"
+	flagIgnoreNotFound = "ignore-not-found"
+	flagWait           = "wait"
+	flagWaitTimeout    = "wait-timeout"
+	flagRmFinalizers   = "remove-finalizers"
+	flagFinalizerTmout = "finalizer-timeout"
+	flagYes            = "yes"
+	flagContinueOnErr  = "continue-on-error"
 )
 
 func init() {
 	RootCmd.AddCommand(deleteCmd)
-	deleteCmd.PersistentFlags().Int64(flagGracePeriod, -1, "Number of seconds given to resources to terminate gracefully. A negative value is ignored")
+	deleteCmd.PersistentFlags().Bool(flagEmitEvent, false, "Record a Kubernetes Event for each object deleted")
+	deleteCmd.PersistentFlags().Int64(flagGracePeriod, -1, "Number of seconds given to resources to terminate gracefully. A negative value uses the server default, and 0 deletes immediately")
+	deleteCmd.PersistentFlags().Bool(flagCascade, true, "Also delete dependent objects. If false, dependents are orphaned")
+	deleteCmd.PersistentFlags().String(flagGcPolicy, "", "Deletion propagation policy: foreground, background or orphan. Overrides --"+flagCascade)
+	deleteCmd.PersistentFlags().Bool(flagIgnoreNotFound, true, "Treat objects that don't exist as successfully deleted")
+	deleteCmd.PersistentFlags().Bool(flagWait, false, "Wait until deleted objects and their dependents are gone")
+	deleteCmd.PersistentFlags().Duration(flagWaitTimeout, 5*time.Minute, "Maximum time to --"+flagWait)
+	deleteCmd.PersistentFlags().Bool(flagRmFinalizers, false, "Remove the finalizers of objects still terminating after --"+flagFinalizerTmout+". WARNING: this may orphan external resources")
+	deleteCmd.PersistentFlags().Duration(flagFinalizerTmout, time.Minute, "Time to wait before removing finalizers with --"+flagRmFinalizers)
+	deleteCmd.PersistentFlags().BoolP(flagYes, "y", false, "Don't ask for confirmation before deleting. Confirmation is only asked for when stdin is a terminal")
+	deleteCmd.PersistentFlags().Bool(flagContinueOnErr, false, "Keep deleting the remaining objects after a failure, and report all failures at the end")
+}
+
+// confirmDelete lists descs on out, and asks on in whether to
+// delete them
+func confirmDelete(in io.Reader, out io.Writer) func([]string) (bool, error) {
+	return func(descs []string) (bool, error) {
+		fmt.Fprintln(out, "The following objects will be deleted:")
+		for _, desc := range descs {
+			fmt.Fprintf(out, "  %s\n", desc)
+		}
+		fmt.Fprintf(out, "Delete %d objects? [y/N] ", len(descs))
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		}
+		return false, nil
+	}
 }
 
 var deleteCmd = &cobra.Command{
@@ -44,12 +93,69 @@ var deleteCmd = &cobra.Command{
 			return err
 		}
 
+		c.Cascade, err = flags.GetBool(flagCascade)
+		if err != nil {
+			return err
+		}
+
+		gcPolicy, err := flags.GetString(flagGcPolicy)
+		if err != nil {
+			return err
+		}
+		c.PropagationPolicy, err = utils.ParsePropagationPolicy(gcPolicy)
+		if err != nil {
+			return err
+		}
+
+		c.IgnoreNotFound, err = flags.GetBool(flagIgnoreNotFound)
+		if err != nil {
+			return err
+		}
+
+		c.EmitEvents, err = flags.GetBool(flagEmitEvent)
+		if err != nil {
+			return err
+		}
+
+		c.Wait, err = flags.GetBool(flagWait)
+		if err != nil {
+			return err
+		}
+
+		c.WaitTimeout, err = flags.GetDuration(flagWaitTimeout)
+		if err != nil {
+			return err
+		}
+
+		c.RemoveFinalizers, err = flags.GetBool(flagRmFinalizers)
+		if err != nil {
+			return err
+		}
+
+		c.FinalizerTimeout, err = flags.GetDuration(flagFinalizerTmout)
+		if err != nil {
+			return err
+		}
+
+		c.ContinueOnError, err = flags.GetBool(flagContinueOnErr)
+		if err != nil {
+			return err
+		}
+
+		yes, err := flags.GetBool(flagYes)
+		if err != nil {
+			return err
+		}
+		if !yes && terminal.IsTerminal(int(os.Stdin.Fd())) {
+			c.Confirm = confirmDelete(os.Stdin, os.Stderr)
+		}
+
 		c.ClientPool, c.Discovery, err = restClientPool(cmd)
 		if err != nil {
 			return err
 		}
 
-		c.DefaultNamespace, err = defaultNamespace(clientConfig)
+		c.DefaultNamespace, err = clientConfig.DefaultNamespace()
 		if err != nil {
 			return err
 		}
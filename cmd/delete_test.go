@@ -0,0 +1,48 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfirmDelete(t *testing.T) {
+	descs := []string{"configmaps myns.a", "namespaces myns"}
+	for _, tc := range []struct {
+		answer   string
+		expected bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	} {
+		var out bytes.Buffer
+		ok, err := confirmDelete(strings.NewReader(tc.answer), &out)(descs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.expected {
+			t.Errorf("Answer %q returned %v", tc.answer, ok)
+		}
+		if !strings.Contains(out.String(), "  namespaces myns\n") || !strings.Contains(out.String(), "Delete 2 objects?") {
+			t.Errorf("Unexpected prompt:\n%s", out.String())
+		}
+	}
+}
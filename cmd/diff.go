@@ -16,15 +16,38 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 
 	"github.com/ksonnet/kubecfg/pkg/kubecfg"
 )
 
-const flagDiffStrategy = "diff-strategy"
+const (
+	flagDiffStrategy = "diff-strategy"
+	flagDiffAgainst  = "diff-against"
+	flagOnlyAdded    = "only-added"
+	flagOnlyRemoved  = "only-removed"
+	flagDiffMask     = "diff-mask"
+	flagOutChanges   = "out-changes"
+	flagDiffFormat   = "diff-format"
+	flagServerDryRun = "server-dry-run"
+	flagKeepStatus   = "keep-status"
+	flagDiffContext  = "diff-context"
+)
 
 func init() {
-	diffCmd.PersistentFlags().String(flagDiffStrategy, "all", "Diff strategy, all or subset.")
+	diffCmd.PersistentFlags().String(flagDiffStrategy, "all", "Diff strategy, all, subset or last-applied. last-applied compares only the fields set by the config or by the last-applied-configuration annotation, as kubectl apply does, and reports changes keyed by JSONPath.")
+	diffCmd.PersistentFlags().String(flagDiffAgainst, kubecfg.DiffAgainstLive, "Compare config with the live objects (live), or with the config last applied to them as recorded in the last-applied-configuration annotation (last-applied), ignoring changes made by the server or other clients")
+	diffCmd.PersistentFlags().String(flagGcTag, "", "Also report existing objects with this garbage collection tag that are not in config")
+	diffCmd.PersistentFlags().Bool(flagOnlyAdded, false, "Only report objects that don't exist on the server")
+	diffCmd.PersistentFlags().Bool(flagOnlyRemoved, false, "Only report objects that would be garbage collected. Requires --"+flagGcTag)
+	diffCmd.PersistentFlags().StringSlice(flagDiffMask, nil, "Hide the value of a field in diff output, as Kind:path.to.field. May be given multiple times")
+	diffCmd.PersistentFlags().String(flagOutChanges, "", "Output a structured record of changes instead of a diff, for policy evaluation. Supported values are: json")
+	diffCmd.PersistentFlags().String(flagDiffFormat, kubecfg.DiffFormatUnified, "Diff output format, unified, side-by-side, json or yaml. Side-by-side falls back to unified when not writing to a wide enough terminal. json and yaml output a list of {gvk, namespace, name, action, patch} objects, where patch is the JSON merge patch from the live object to the config")
+	diffCmd.PersistentFlags().Int(flagDiffContext, kubecfg.DiffContextAll, "Number of unchanged lines to show around each change in unified and side-by-side diffs. 0 shows only changed lines. Negative shows the whole object")
+	diffCmd.PersistentFlags().Bool(flagServerDryRun, false, "Diff against the result of a server-side dry-run, including defaulting, admission webhooks and CRD conversion. Requires Kubernetes 1.13 or later")
+	diffCmd.PersistentFlags().Bool(flagKeepStatus, false, "Compare status, which is otherwise ignored along with other server-managed fields. Use for (rare) resources whose config deliberately sets status")
 	RootCmd.AddCommand(diffCmd)
 }
 
@@ -42,12 +65,84 @@ var diffCmd = &cobra.Command{
 			return err
 		}
 
+		c.DiffAgainst, err = flags.GetString(flagDiffAgainst)
+		if err != nil {
+			return err
+		}
+
+		c.GcTag, err = flags.GetString(flagGcTag)
+		if err != nil {
+			return err
+		}
+		if err := checkSelectorGc(cmd, c.GcTag != ""); err != nil {
+			return err
+		}
+
+		c.OnlyAdded, err = flags.GetBool(flagOnlyAdded)
+		if err != nil {
+			return err
+		}
+
+		c.OnlyRemoved, err = flags.GetBool(flagOnlyRemoved)
+		if err != nil {
+			return err
+		}
+
+		c.OutChanges, err = flags.GetString(flagOutChanges)
+		if err != nil {
+			return err
+		}
+
+		c.KeepStatus, err = flags.GetBool(flagKeepStatus)
+		if err != nil {
+			return err
+		}
+
+		c.DiffFormat, err = flags.GetString(flagDiffFormat)
+		if err != nil {
+			return err
+		}
+		switch c.DiffFormat {
+		case kubecfg.DiffFormatUnified, kubecfg.DiffFormatSideBySide, kubecfg.DiffFormatJSON, kubecfg.DiffFormatYAML:
+		default:
+			return fmt.Errorf("Unknown --%s %q", flagDiffFormat, c.DiffFormat)
+		}
+		c.Width = terminalWidth(cmd.OutOrStdout())
+
+		c.ContextLines, err = flags.GetInt(flagDiffContext)
+		if err != nil {
+			return err
+		}
+
+		masks, err := flags.GetStringSlice(flagDiffMask)
+		if err != nil {
+			return err
+		}
+		for _, s := range masks {
+			mask, err := kubecfg.ParseDiffMask(s)
+			if err != nil {
+				return err
+			}
+			c.Masks = append(c.Masks, mask)
+		}
+
 		c.ClientPool, c.Discovery, err = restClientPool(cmd)
 		if err != nil {
 			return err
 		}
 
-		c.DefaultNamespace, err = defaultNamespace(clientConfig)
+		serverDryRun, err := flags.GetBool(flagServerDryRun)
+		if err != nil {
+			return err
+		}
+		if serverDryRun {
+			c.DryRunPool, err = dryRunClientPool(cmd)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.DefaultNamespace, err = clientConfig.DefaultNamespace()
 		if err != nil {
 			return err
 		}
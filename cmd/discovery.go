@@ -0,0 +1,48 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+func init() {
+	RootCmd.AddCommand(discoverySnapshotCmd)
+}
+
+var discoverySnapshotCmd = &cobra.Command{
+	Use:   "discovery-snapshot",
+	Short: "Print a snapshot of the cluster's API discovery information, for use with --" + flagDiscoSnap,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, disco, err := restClientPool(cmd)
+		if err != nil {
+			return err
+		}
+
+		snapshot, err := utils.CaptureDiscoverySnapshot(disco)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(snapshot)
+	},
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ksonnet/kubecfg/pkg/kubecfg"
+)
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+	exportCmd.PersistentFlags().StringP(flagFormat, "o", "yaml", "Output format.  Supported values are: json, yaml, jsonnet")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <kind>|all [<name>]",
+	Short: "Print live objects without server-managed fields, for adoption into local config",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("Expected <kind> [<name>] arguments")
+		}
+		kind, name := args[0], ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+
+		flags := cmd.Flags()
+		var err error
+
+		c := kubecfg.ExportCmd{}
+
+		c.Format, err = flags.GetString(flagFormat)
+		if err != nil {
+			return err
+		}
+		switch c.Format {
+		case "yaml", "json", "jsonnet":
+		default:
+			return fmt.Errorf("Unknown --%s: %s", flagFormat, c.Format)
+		}
+
+		c.Selector, err = flags.GetString(flagSelector)
+		if err != nil {
+			return err
+		}
+
+		c.ClientPool, c.Discovery, err = restClientPool(cmd)
+		if err != nil {
+			return err
+		}
+
+		c.DefaultNamespace, err = clientConfig.DefaultNamespace()
+		if err != nil {
+			return err
+		}
+
+		return c.Run(kind, name, cmd.OutOrStdout())
+	},
+}
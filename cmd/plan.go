@@ -0,0 +1,85 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ksonnet/kubecfg/pkg/kubecfg"
+)
+
+const (
+	flagGraph = "graph"
+)
+
+func init() {
+	planCmd.PersistentFlags().String(flagDiffStrategy, "all", "Diff strategy used to find unchanged objects, all, subset or last-applied.")
+	planCmd.PersistentFlags().String(flagGcTag, "", "Also plan the garbage collection of existing objects with this tag that are not in config")
+	planCmd.PersistentFlags().Bool(flagKeepStatus, false, "Compare status, which is otherwise ignored along with other server-managed fields")
+	planCmd.PersistentFlags().String(flagGraph, "", "Output the plan as a dependency graph instead of a list. Supported values are: dot")
+	RootCmd.AddCommand(planCmd)
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the action and apply order of each object in an update",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		var err error
+
+		c := kubecfg.PlanCmd{}
+
+		c.DiffStrategy, err = flags.GetString(flagDiffStrategy)
+		if err != nil {
+			return err
+		}
+
+		c.GcTag, err = flags.GetString(flagGcTag)
+		if err != nil {
+			return err
+		}
+		if err := checkSelectorGc(cmd, c.GcTag != ""); err != nil {
+			return err
+		}
+
+		c.KeepStatus, err = flags.GetBool(flagKeepStatus)
+		if err != nil {
+			return err
+		}
+
+		c.Graph, err = flags.GetString(flagGraph)
+		if err != nil {
+			return err
+		}
+
+		c.ClientPool, c.Discovery, err = restClientPool(cmd)
+		if err != nil {
+			return err
+		}
+
+		c.DefaultNamespace, err = clientConfig.DefaultNamespace()
+		if err != nil {
+			return err
+		}
+
+		objs, err := readObjs(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		return c.Run(objs, cmd.OutOrStdout())
+	},
+}
@@ -26,15 +26,20 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	jsonnet "github.com/strickyak/jsonnet_cgo"
 	"golang.org/x/crypto/ssh/terminal"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
 
 	"github.com/ksonnet/kubecfg/utils"
 
@@ -51,11 +56,83 @@ const (
 	flagTlaVarFile = "tla-str-file"
 	flagResolver   = "resolve-images"
 	flagResolvFail = "resolve-images-error"
+	flagFieldValid = "field-validation"
+	flagUnpinned   = "allow-unpinned-imports"
+	flagOtelEndpt  = "otel-endpoint"
+	flagTimeout    = "timeout"
+	flagApplyPct   = "timeout-apply-percent"
+	flagMaxImport  = "max-import-depth"
+	flagSecretBknd = "secret-backend"
+	flagCaps       = "capabilities"
+	flagPatch      = "patch"
+	flagInputFmt   = "input-format"
+	flagImportBase = "import-base"
+	flagDiscoDir   = "discovery-cache-dir"
+	flagDiscoTTL   = "discovery-cache-ttl"
+	flagNoCluster  = "no-cluster"
+	flagDiscoRetry = "discovery-retries"
+	flagAsUID      = "as-uid"
+	flagQPS        = "qps"
+	flagBurst      = "burst"
+	flagProtobuf   = "use-protobuf"
+	flagDiscoSnap  = "discovery-snapshot"
+	flagWarnErrors = "warnings-as-errors"
+	flagCommonLbl  = "common-label"
+	flagCommonAnno = "common-annotation"
+	flagCommonOver = "overwrite-common-metadata"
+	flagSelector   = "selector"
+	flagRemoteIn   = "allow-remote-inputs"
+	flagFetchTmout = "fetch-timeout"
+	flagKubeconfig = "kubeconfig"
+
+	// capabilitiesExtVar is the ext var populated by --capabilities
+	capabilitiesExtVar = "capabilities"
 )
 
-var clientConfig clientcmd.ClientConfig
+var clientConfig *utils.ClientConfig
+var loadingRules *clientcmd.ClientConfigLoadingRules
 var overrides clientcmd.ConfigOverrides
 
+// gcClientPool returns a client pool for listing objects during
+// garbage collection.  Unless --use-protobuf=false, lists of built-in
+// kinds use protobuf, which is lossy but only metadata is read.
+func gcClientPool(cmd *cobra.Command) (dynamic.ClientPool, error) {
+	pool, _, err := restClientPool(cmd)
+	if err != nil {
+		return nil, err
+	}
+	useProtobuf, err := cmd.Flags().GetBool(flagProtobuf)
+	if err != nil || !useProtobuf {
+		return pool, err
+	}
+
+	conf := *restConfig
+	wrap := restConfig.WrapTransport
+	conf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return wrap(utils.NewProtobufTransport(rt))
+	}
+	return dynamic.NewClientPool(&conf, restMapper, dynamic.LegacyAPIPathResolverFunc), nil
+}
+
+// tracer is nil unless --otel-endpoint is given
+var tracer *utils.Tracer
+
+// budget is nil unless --timeout is given
+var budget *utils.Budget
+
+// warnings collects the API server's warnings, for ReportWarnings
+var warnings *utils.WarningCollector
+var warningsAsErrors bool
+
+// Clients are shared by everything within a single command run
+var clientPool dynamic.ClientPool
+var discoClient discovery.DiscoveryInterface
+var restConfig *rest.Config
+var restMapper meta.RESTMapper
+
+// snapshotDisco is the discovery client for --discovery-snapshot
+var snapshotDisco discovery.DiscoveryInterface
+
 func init() {
 	RootCmd.PersistentFlags().CountP(flagVerbose, "v", "Increase verbosity. May be given multiple times.")
 	RootCmd.PersistentFlags().StringP(flagJpath, "J", "", "Additional jsonnet library search path")
@@ -65,14 +142,42 @@ func init() {
 	RootCmd.PersistentFlags().StringSlice(flagTlaVarFile, nil, "Read top level argument from a file")
 	RootCmd.PersistentFlags().String(flagResolver, "noop", "Change implementation of resolveImage native function. One of: noop, registry")
 	RootCmd.PersistentFlags().String(flagResolvFail, "warn", "Action when resolveImage fails. One of ignore,warn,error")
+	RootCmd.PersistentFlags().String(flagFieldValid, "strict", "Server-side validation of unknown/duplicate fields on write requests. One of strict,warn,ignore")
+	RootCmd.PersistentFlags().Int(flagMaxImport, utils.DefaultMaxImportDepth, "Maximum jsonnet stack depth, which limits the length of a chain of imports")
+	RootCmd.PersistentFlags().Bool(flagUnpinned, false, "Allow importManifest to fetch over http, or without a sha256 digest")
+	RootCmd.PersistentFlags().String(flagSecretBknd, os.Getenv("KUBECFG_SECRET_BACKEND"), "Backend used by externalSecret. One of vault (configured by VAULT_ADDR and VAULT_TOKEN), or empty to disable")
+	RootCmd.PersistentFlags().Bool(flagCaps, false, "Discover cluster capabilities, and provide them to templates as std.extVar(\""+capabilitiesExtVar+"\"). Otherwise, an empty capabilities object is provided")
+	RootCmd.PersistentFlags().StringSlice(flagCommonLbl, nil, "Add this label (key=value) to every object, eg: app.kubernetes.io/managed-by=kubecfg. May be given multiple times")
+	RootCmd.PersistentFlags().StringSlice(flagCommonAnno, nil, "Add this annotation (key=value) to every object. May be given multiple times")
+	RootCmd.PersistentFlags().Bool(flagCommonOver, false, "Replace existing values of the labels and annotations given by --"+flagCommonLbl+" and --"+flagCommonAnno+". By default, objects keep their own values")
+	RootCmd.PersistentFlags().StringP(flagSelector, "l", "", "Only act on objects matching this label selector (after --"+flagCommonLbl+"), eg: tier=frontend")
+	RootCmd.PersistentFlags().StringSlice(flagPatch, nil, "Apply a kustomize-style (strategic merge or JSON6902) patch file to the evaluated objects. May be given multiple times")
+	RootCmd.PersistentFlags().String(flagInputFmt, "", "Format of config read from stdin (given as -). One of jsonnet, json, yaml, or empty to guess")
+	RootCmd.PersistentFlags().String(flagImportBase, "", "Directory that jsonnet imports in config read from stdin are relative to. Defaults to the current directory")
+	RootCmd.PersistentFlags().Bool(flagRemoteIn, os.Getenv("KUBECFG_ALLOW_REMOTE_INPUTS") != "", "Allow config to be read from http(s) URLs, eg: https://example.com/bundle.yaml#sha256=<digest>. Defaults to true if KUBECFG_ALLOW_REMOTE_INPUTS is set")
+	RootCmd.PersistentFlags().Duration(flagFetchTmout, 30*time.Second, "Time limit for each remote config or importManifest fetch. Zero means no limit")
+	RootCmd.PersistentFlags().String(flagDiscoDir, filepath.Join(clientcmd.RecommendedConfigDir, "cache", "kubecfg-discovery"), "Directory for the on-disk API discovery cache")
+	RootCmd.PersistentFlags().String(flagDiscoSnap, "", "Resolve resources with this discovery snapshot (see the discovery-snapshot command) instead of contacting the cluster. Only used by commands that don't need to read or write objects")
+	RootCmd.PersistentFlags().Duration(flagDiscoTTL, 0, "Reuse on-disk API discovery results younger than this. The OpenAPI schema is reused until the server version changes. Zero disables the on-disk cache")
+	RootCmd.PersistentFlags().Int(flagDiscoRetry, utils.DefaultDiscoveryBackoff.Retries, "Number of times to retry API discovery requests that fail with transient errors (eg: 503)")
+	RootCmd.PersistentFlags().Float32(flagQPS, 20, "Maximum average rate of requests to the API server, shared between discovery and apply. Zero uses the client-go default limit for each client")
+	RootCmd.PersistentFlags().Bool(flagProtobuf, true, "Use the smaller protobuf encoding to list built-in kinds for garbage collection, which only reads object metadata. Set to false to always use JSON")
+	RootCmd.PersistentFlags().Int(flagBurst, 50, "Maximum burst of requests to the API server, above --"+flagQPS)
+	RootCmd.PersistentFlags().Bool(flagNoCluster, false, "Never contact the cluster while evaluating config. kubeServerVersion(), kubeResourceExists(), kubeResourceScope() and kubeGet() fail, and kubeDiscovery() returns nothing")
+	RootCmd.PersistentFlags().Duration(flagTimeout, 0, "Overall time limit for the command, shared between discovery and apply. Zero means no limit")
+	RootCmd.PersistentFlags().Int(flagApplyPct, 50, "Percentage of --"+flagTimeout+" reserved for apply operations")
+	RootCmd.PersistentFlags().String(flagOtelEndpt, "", "Export OpenTelemetry trace spans to this OTLP/HTTP collector URL")
+	RootCmd.PersistentFlags().Bool(flagWarnErrors, false, "Fail if the API server returned any warnings (eg: for deprecated API versions)")
 
 	// The "usual" clientcmd/kubectl flags
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules = clientcmd.NewDefaultClientConfigLoadingRules()
 	loadingRules.DefaultClientConfig = &clientcmd.DefaultClientConfig
 	kflags := clientcmd.RecommendedConfigOverrideFlags("")
-	RootCmd.PersistentFlags().StringVar(&loadingRules.ExplicitPath, "kubeconfig", "", "Path to a kube config. Only required if out-of-cluster")
+	RootCmd.PersistentFlags().StringArray(flagKubeconfig, nil, "Path to a kube config. Only required if out-of-cluster. May be given multiple times, to merge several files as for a $KUBECONFIG path list")
 	clientcmd.BindOverrideFlags(&overrides, RootCmd.PersistentFlags(), kflags)
-	clientConfig = clientcmd.NewInteractiveDeferredLoadingClientConfig(loadingRules, &overrides, os.Stdin)
+	// --as and --as-group are included in the clientcmd flags
+	RootCmd.PersistentFlags().String(flagAsUID, "", "UID to impersonate for the operation. Requires --"+clientcmd.FlagImpersonate)
+	clientConfig = utils.NewClientConfigFromOverrides(loadingRules, &overrides, os.Stdin)
 
 	RootCmd.PersistentFlags().Set("logtostderr", "true")
 }
@@ -98,18 +203,83 @@ var RootCmd = &cobra.Command{
 		}
 		log.SetLevel(logLevel(verbosity))
 
+		clientPool, discoClient = nil, nil
+		restConfig, restMapper = nil, nil
+
+		kubeconfigs, err := flags.GetStringArray(flagKubeconfig)
+		if err != nil {
+			return err
+		}
+		for _, path := range kubeconfigs {
+			// clientcmd silently skips missing files when
+			// merging several
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+		}
+		utils.SetKubeconfigs(loadingRules, kubeconfigs)
+
+		timeout, err := flags.GetDuration(flagTimeout)
+		if err != nil {
+			return err
+		}
+		applyPct, err := flags.GetInt(flagApplyPct)
+		if err != nil {
+			return err
+		}
+		if applyPct < 0 || applyPct > 100 {
+			return fmt.Errorf("Bad value for --%s: %d", flagApplyPct, applyPct)
+		}
+		if timeout > 0 {
+			budget = utils.NewBudget(timeout, applyPct)
+		}
+
+		warnings = utils.NewWarningCollector()
+		warningsAsErrors, err = flags.GetBool(flagWarnErrors)
+		if err != nil {
+			return err
+		}
+
+		endpoint, err := flags.GetString(flagOtelEndpt)
+		if err != nil {
+			return err
+		}
+		if endpoint != "" {
+			tracer = utils.NewTracer(utils.NewOTLPExporter(endpoint))
+			tracer.Root = tracer.Start(nil, cmd.CommandPath())
+		}
+
 		return nil
 	},
 }
 
-// clientConfig.Namespace() is broken in client-go 3.0:
-// namespace in config erroneously overrides explicit --namespace
-func defaultNamespace(c clientcmd.ClientConfig) (string, error) {
-	if overrides.Context.Namespace != "" {
-		return overrides.Context.Namespace, nil
+// FlushTraces completes the top-level trace span, and exports all
+// recorded spans.  Does nothing unless --otel-endpoint was given.
+func FlushTraces() {
+	if tracer == nil {
+		return
+	}
+	tracer.Root.End()
+	if err := tracer.Flush(); err != nil {
+		log.Warnf("Failed to export trace spans: %v", err)
+	}
+}
+
+// ReportWarnings logs a summary of the warnings returned by the API
+// server during the command, each listed once.  If
+// --warnings-as-errors was given, a successful command with warnings
+// becomes an error.
+func ReportWarnings(err error) error {
+	if warnings == nil || warnings.Len() == 0 {
+		return err
 	}
-	ns, _, err := c.Namespace()
-	return ns, err
+	for _, w := range warnings.Summary() {
+		log.Warning(w)
+	}
+	if err == nil && warningsAsErrors {
+		return fmt.Errorf("API server returned %d warnings, and --%s was given", warnings.Len(), flagWarnErrors)
+	}
+	return err
 }
 
 func logLevel(verbosity int) log.Level {
@@ -136,6 +306,20 @@ func NewLogFormatter(out io.Writer) log.Formatter {
 	return &ret
 }
 
+// terminalWidth returns the width of the terminal out writes to, or
+// 0 if out is not a terminal
+func terminalWidth(out io.Writer) int {
+	f, ok := out.(*os.File)
+	if !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return 0
+	}
+	width, _, err := terminal.GetSize(int(f.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
 func (f *logFormatter) levelEsc(level log.Level) []byte {
 	switch level {
 	case log.DebugLevel:
@@ -163,16 +347,20 @@ func (f *logFormatter) Format(e *log.Entry) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// JsonnetVM constructs a new jsonnet.VM, according to command line
-// flags
-func JsonnetVM(cmd *cobra.Command) (*jsonnet.VM, error) {
-	vm := jsonnet.Make()
+// NewEvaluator constructs a utils.Evaluator, according to command
+// line flags
+func NewEvaluator(cmd *cobra.Command) (*utils.Evaluator, error) {
 	flags := cmd.Flags()
+	e := &utils.Evaluator{
+		ExtCode: map[string]string{},
+		ExtVars: map[string]string{},
+		TlaVars: map[string]string{},
+	}
 
 	jpath := os.Getenv("KUBECFG_JPATH")
 	for _, p := range filepath.SplitList(jpath) {
 		log.Debugln("Adding jsonnet search path", p)
-		vm.JpathAdd(p)
+		e.SearchPaths = append(e.SearchPaths, p)
 	}
 
 	jpath, err := flags.GetString(flagJpath)
@@ -181,9 +369,25 @@ func JsonnetVM(cmd *cobra.Command) (*jsonnet.VM, error) {
 	}
 	for _, p := range filepath.SplitList(jpath) {
 		log.Debugln("Adding jsonnet search path", p)
-		vm.JpathAdd(p)
+		e.SearchPaths = append(e.SearchPaths, p)
+	}
+	e.MaxImportDepth, err = flags.GetInt(flagMaxImport)
+	if err != nil {
+		return nil, err
 	}
 
+	// ExtCode is set before user-supplied ext vars, so these can
+	// override
+	caps, err := capabilities(cmd)
+	if err != nil {
+		return nil, err
+	}
+	capsJSON, err := json.Marshal(caps)
+	if err != nil {
+		return nil, err
+	}
+	e.ExtCode[capabilitiesExtVar] = string(capsJSON)
+
 	extvars, err := flags.GetStringSlice(flagExtVar)
 	if err != nil {
 		return nil, err
@@ -194,12 +398,12 @@ func JsonnetVM(cmd *cobra.Command) (*jsonnet.VM, error) {
 		case 1:
 			v, present := os.LookupEnv(kv[0])
 			if present {
-				vm.ExtVar(kv[0], v)
+				e.ExtVars[kv[0]] = v
 			} else {
 				return nil, fmt.Errorf("Missing environment variable: %s", kv[0])
 			}
 		case 2:
-			vm.ExtVar(kv[0], kv[1])
+			e.ExtVars[kv[0]] = kv[1]
 		}
 	}
 
@@ -216,7 +420,7 @@ func JsonnetVM(cmd *cobra.Command) (*jsonnet.VM, error) {
 		if err != nil {
 			return nil, err
 		}
-		vm.ExtVar(kv[0], string(v))
+		e.ExtVars[kv[0]] = string(v)
 	}
 
 	tlavars, err := flags.GetStringSlice(flagTlaVar)
@@ -229,12 +433,12 @@ func JsonnetVM(cmd *cobra.Command) (*jsonnet.VM, error) {
 		case 1:
 			v, present := os.LookupEnv(kv[0])
 			if present {
-				vm.TlaVar(kv[0], v)
+				e.TlaVars[kv[0]] = v
 			} else {
 				return nil, fmt.Errorf("Missing environment variable: %s", kv[0])
 			}
 		case 2:
-			vm.TlaVar(kv[0], kv[1])
+			e.TlaVars[kv[0]] = kv[1]
 		}
 	}
 
@@ -251,16 +455,65 @@ func JsonnetVM(cmd *cobra.Command) (*jsonnet.VM, error) {
 		if err != nil {
 			return nil, err
 		}
-		vm.TlaVar(kv[0], string(v))
+		e.TlaVars[kv[0]] = string(v)
+	}
+
+	e.Resolver, err = buildResolver(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchTimeout, err := flags.GetDuration(flagFetchTmout)
+	if err != nil {
+		return nil, err
+	}
+	e.Fetcher = utils.NewManifestFetcher(utils.NewFetchClient(fetchTimeout))
+	e.Fetcher.AllowUnpinned, err = flags.GetBool(flagUnpinned)
+	if err != nil {
+		return nil, err
 	}
 
-	resolver, err := buildResolver(cmd)
+	e.SecretBackend, err = buildSecretBackend(cmd)
 	if err != nil {
 		return nil, err
 	}
-	utils.RegisterNativeFuncs(vm, resolver)
 
-	return vm, nil
+	noCluster, err := flags.GetBool(flagNoCluster)
+	if err != nil {
+		return nil, err
+	}
+	if !noCluster {
+		// Only connect if the config queries the cluster
+		e.Cluster = func() (discovery.DiscoveryInterface, error) {
+			return discoveryClient(cmd)
+		}
+		e.ClientPool = func() (dynamic.ClientPool, error) {
+			pool, _, err := restClientPool(cmd)
+			return pool, err
+		}
+	}
+
+	return e, nil
+}
+
+func buildSecretBackend(cmd *cobra.Command) (utils.SecretBackend, error) {
+	backend, err := cmd.Flags().GetString(flagSecretBknd)
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case "":
+		return nil, nil
+	case "vault":
+		addr := os.Getenv("VAULT_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("--%s=vault requires VAULT_ADDR", flagSecretBknd)
+		}
+		return utils.NewVaultBackend(addr, os.Getenv("VAULT_TOKEN"), http.DefaultClient), nil
+	default:
+		return nil, fmt.Errorf("Bad value for --%s: %s", flagSecretBknd, backend)
+	}
 }
 
 func buildResolver(cmd *cobra.Command) (utils.Resolver, error) {
@@ -318,23 +571,126 @@ func (r *resolverErrorWrapper) Resolve(image *utils.ImageName) error {
 }
 
 func readObjs(cmd *cobra.Command, paths []string) ([]*unstructured.Unstructured, error) {
-	vm, err := JsonnetVM(cmd)
+	return readObjsRedacting(cmd, paths, false)
+}
+
+// readObjsRedacting is readObjs, except with redactSecrets
+// externalSecret values are replaced by placeholders
+func readObjsRedacting(cmd *cobra.Command, paths []string, redactSecrets bool) ([]*unstructured.Unstructured, error) {
+	e, err := NewEvaluator(cmd)
 	if err != nil {
 		return nil, err
 	}
-	defer vm.Destroy()
+	e.RedactSecrets = redactSecrets
 
-	res := []*unstructured.Unstructured{}
+	e.StdinFormat, err = cmd.Flags().GetString(flagInputFmt)
+	if err != nil {
+		return nil, err
+	}
+	e.StdinBase, err = cmd.Flags().GetString(flagImportBase)
+	if err != nil {
+		return nil, err
+	}
+	e.AllowRemoteInputs, err = cmd.Flags().GetBool(flagRemoteIn)
+	if err != nil {
+		return nil, err
+	}
 	for _, path := range paths {
-		objs, err := utils.Read(vm, path)
+		if utils.IsRemoteInput(path) && !e.AllowRemoteInputs {
+			return nil, fmt.Errorf("Reading config from %s requires --%s", path, flagRemoteIn)
+		}
+	}
+	e.Tracer = tracer
+
+	res, err := e.LoadObjects(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	patchFiles, err := cmd.Flags().GetStringSlice(flagPatch)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range patchFiles {
+		patches, err := utils.ReadPatches(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := utils.ApplyPatches(patches, res); err != nil {
+			return nil, err
+		}
+	}
+
+	commonLabels, err := keyValueFlag(cmd, flagCommonLbl, validation.IsValidLabelValue)
+	if err != nil {
+		return nil, err
+	}
+	commonAnnotations, err := keyValueFlag(cmd, flagCommonAnno, nil)
+	if err != nil {
+		return nil, err
+	}
+	overwrite, err := cmd.Flags().GetBool(flagCommonOver)
+	if err != nil {
+		return nil, err
+	}
+	utils.ApplyCommonMetadata(res, commonLabels, commonAnnotations, overwrite)
+
+	selector, err := cmd.Flags().GetString(flagSelector)
+	if err != nil {
+		return nil, err
+	}
+	if selector != "" {
+		sel, err := labels.Parse(selector)
 		if err != nil {
-			return nil, fmt.Errorf("Error reading %s: %v", path, err)
+			return nil, fmt.Errorf("Invalid --%s %q: %v", flagSelector, selector, err)
 		}
-		res = append(res, utils.FlattenToV1(objs)...)
+		res = utils.FilterBySelector(res, sel)
 	}
+
 	return res, nil
 }
 
+// checkSelectorGc returns an error if --selector was given for a
+// command that will garbage collect (if gc is true), since the
+// objects filtered out would look like they had been removed from
+// config.
+func checkSelectorGc(cmd *cobra.Command, gc bool) error {
+	selector, err := cmd.Flags().GetString(flagSelector)
+	if err != nil {
+		return err
+	}
+	if gc && selector != "" {
+		return fmt.Errorf("--%s can't be combined with garbage collection, since objects that don't match would be garbage collected", flagSelector)
+	}
+	return nil
+}
+
+// keyValueFlag parses the key=value pairs given by the flag name.
+// Keys must be qualified names (as for labels and annotations), and
+// values are checked with validateValue, if not nil.
+func keyValueFlag(cmd *cobra.Command, name string, validateValue func(string) []string) (map[string]string, error) {
+	pairs, err := cmd.Flags().GetStringSlice(name)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Failed to parse %s: missing '=' in %s", name, pair)
+		}
+		errs := validation.IsQualifiedName(kv[0])
+		if validateValue != nil {
+			errs = append(errs, validateValue(kv[1])...)
+		}
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("Invalid --%s %s: %s", name, pair, strings.Join(errs, "; "))
+		}
+		ret[kv[0]] = kv[1]
+	}
+	return ret, nil
+}
+
 // For debugging
 func dumpJSON(v interface{}) string {
 	buf := bytes.NewBuffer(nil)
@@ -346,21 +702,192 @@ func dumpJSON(v interface{}) string {
 	return string(buf.Bytes())
 }
 
+func capabilities(cmd *cobra.Command) (*utils.Capabilities, error) {
+	enabled, err := cmd.Flags().GetBool(flagCaps)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return utils.EmptyCapabilities(), nil
+	}
+
+	disco, err := discoveryClient(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return utils.FetchCapabilities(disco)
+}
+
+// discoveryClient returns the discovery client for commands that
+// don't otherwise need the cluster: a static client if
+// --discovery-snapshot is given, or the cluster's discovery client.
+func discoveryClient(cmd *cobra.Command) (discovery.DiscoveryInterface, error) {
+	if snapshotDisco != nil {
+		return snapshotDisco, nil
+	}
+	path, err := cmd.Flags().GetString(flagDiscoSnap)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		_, disco, err := restClientPool(cmd)
+		return disco, err
+	}
+	snapshot, err := utils.ReadDiscoverySnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshotDisco = utils.NewMemcachedDiscoveryClient(utils.NewStaticDiscoveryClient(snapshot))
+	return snapshotDisco, nil
+}
+
 func restClientPool(cmd *cobra.Command) (dynamic.ClientPool, discovery.DiscoveryInterface, error) {
-	conf, err := clientConfig.ClientConfig()
+	if clientPool != nil {
+		return clientPool, discoClient, nil
+	}
+
+	span := tracer.Start(nil, "config")
+	conf, err := clientConfig.RESTConfig()
+	span.End()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	disco, err := discovery.NewDiscoveryClientForConfig(conf)
+	fieldValidation, err := cmd.Flags().GetString(flagFieldValid)
 	if err != nil {
 		return nil, nil, err
 	}
+	fieldValidation, err = utils.ParseFieldValidation(fieldValidation)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Bad value for --%s: %v", flagFieldValid, err)
+	}
+
+	asUID, err := cmd.Flags().GetString(flagAsUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if asUID != "" && conf.Impersonate.UserName == "" {
+		return nil, nil, fmt.Errorf("--%s requires --%s", flagAsUID, clientcmd.FlagImpersonate)
+	}
+
+	qps, err := cmd.Flags().GetFloat32(flagQPS)
+	if err != nil {
+		return nil, nil, err
+	}
+	burst, err := cmd.Flags().GetInt(flagBurst)
+	if err != nil {
+		return nil, nil, err
+	}
+	if qps > 0 {
+		// A single limiter, shared by every client built
+		// from (a copy of) conf
+		conf.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	}
+
+	// NB: conf.Impersonate (from --as and --as-group) applies to
+	// both discovery and the client pool, since both are built
+	// from conf.
+	wrap := conf.WrapTransport
+	conf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		if asUID != "" {
+			rt = utils.NewImpersonateUIDTransport(asUID, rt)
+		}
+		rt = utils.NewFieldValidationTransport(fieldValidation, rt)
+		if budget != nil {
+			rt = utils.NewBudgetTransport(budget, rt)
+		}
+		if warnings == nil {
+			return utils.NewWarningTransport(utils.WarningLogger{}, rt)
+		}
+		return utils.NewWarningTransport(warnings, rt)
+	}
 
-	discoCache := utils.NewMemcachedDiscoveryClient(disco)
+	discoConf := *conf
+	if tracer != nil {
+		// Discovery requests are only made on cache misses
+		discoConf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+			return utils.NewTracingTransport(tracer, conf.WrapTransport(rt))
+		}
+	}
+
+	rawDisco, err := discovery.NewDiscoveryClientForConfig(&discoConf)
+	if err != nil {
+		return nil, nil, err
+	}
+	backoff := utils.DefaultDiscoveryBackoff
+	backoff.Retries, err = cmd.Flags().GetInt(flagDiscoRetry)
+	if err != nil {
+		return nil, nil, err
+	}
+	disco := utils.NewRetryingDiscoveryClient(rawDisco, backoff)
+
+	cacheTTL, err := cmd.Flags().GetDuration(flagDiscoTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	var discoCache discovery.CachedDiscoveryInterface
+	if cacheTTL > 0 {
+		cacheDir, err := cmd.Flags().GetString(flagDiscoDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		discoCache = utils.NewCachedDiscoveryClient(disco, cacheDir, utils.CacheUser(conf), cacheTTL)
+	} else {
+		discoCache = utils.NewMemcachedDiscoveryClient(disco)
+	}
 	mapper := discovery.NewDeferredDiscoveryRESTMapper(discoCache, dynamic.VersionInterfaces)
 	pathresolver := dynamic.LegacyAPIPathResolverFunc
 
-	pool := dynamic.NewClientPool(conf, mapper, pathresolver)
-	return pool, discoCache, nil
+	clientPool = dynamic.NewClientPool(conf, mapper, pathresolver)
+	discoClient = discoCache
+	restConfig, restMapper = conf, mapper
+	return clientPool, discoClient, nil
+}
+
+// dryRunClientPool returns a client pool whose write requests are
+// server-side dry-runs
+func dryRunClientPool(cmd *cobra.Command) (dynamic.ClientPool, error) {
+	if _, _, err := restClientPool(cmd); err != nil {
+		return nil, err
+	}
+
+	conf := *restConfig
+	wrap := restConfig.WrapTransport
+	conf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return utils.NewDryRunTransport(wrap(rt))
+	}
+	return dynamic.NewClientPool(&conf, restMapper, dynamic.LegacyAPIPathResolverFunc), nil
+}
+
+// fieldManagerClientPool returns a client pool whose write requests
+// are recorded under the given field manager
+func fieldManagerClientPool(cmd *cobra.Command, fieldManager string) (dynamic.ClientPool, error) {
+	if _, _, err := restClientPool(cmd); err != nil {
+		return nil, err
+	}
+
+	conf := *restConfig
+	wrap := restConfig.WrapTransport
+	conf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return utils.NewFieldManagerTransport(fieldManager, wrap(rt))
+	}
+	return dynamic.NewClientPool(&conf, restMapper, dynamic.LegacyAPIPathResolverFunc), nil
+}
+
+// serverSideApplyClientPool returns a client pool whose server-side
+// apply requests use the given field manager and force option
+func serverSideApplyClientPool(cmd *cobra.Command, fieldManager string, force bool) (dynamic.ClientPool, error) {
+	if _, _, err := restClientPool(cmd); err != nil {
+		return nil, err
+	}
+
+	conf := *restConfig
+	wrap := restConfig.WrapTransport
+	conf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return utils.NewServerSideApplyTransport(fieldManager, force, wrap(rt))
+	}
+	return dynamic.NewClientPool(&conf, restMapper, dynamic.LegacyAPIPathResolverFunc), nil
 }
@@ -16,18 +16,31 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 
 	"github.com/ksonnet/kubecfg/pkg/kubecfg"
 )
 
 const (
-	flagFormat = "format"
+	flagFormat    = "format"
+	flagInventory = "inventory"
+	flagOutputDir = "output-dir"
+	flagGroupBy   = "group-by-label"
+	flagImages    = "images"
+	flagShowSecr  = "show-secrets"
 )
 
 func init() {
 	RootCmd.AddCommand(showCmd)
 	showCmd.PersistentFlags().StringP(flagFormat, "o", "yaml", "Output format.  Supported values are: json, yaml")
+	showCmd.PersistentFlags().Bool(flagInventory, false, "Output a compact inventory of objects, rather than the full objects")
+	showCmd.PersistentFlags().String(flagOutputDir, "", "Write each object to a separate file in this directory")
+	showCmd.PersistentFlags().String(flagGroupBy, "", "With --"+flagOutputDir+", group files into subdirectories by the value of this label")
+	showCmd.PersistentFlags().Bool(flagImages, false, "Output the container images used by the objects, rather than the objects. Images are resolved to digests according to --"+flagResolver)
+	showCmd.PersistentFlags().String(flagGcTag, "", "Apply-set (garbage collection tag) to report in --"+flagInventory+" output")
+	showCmd.PersistentFlags().Bool(flagShowSecr, false, "Output the values fetched by externalSecret, rather than placeholders")
 }
 
 var showCmd = &cobra.Command{
@@ -44,7 +57,46 @@ var showCmd = &cobra.Command{
 			return err
 		}
 
-		objs, err := readObjs(cmd, args)
+		c.Inventory, err = flags.GetBool(flagInventory)
+		if err != nil {
+			return err
+		}
+
+		c.GcTag, err = flags.GetString(flagGcTag)
+		if err != nil {
+			return err
+		}
+
+		c.OutputDir, err = flags.GetString(flagOutputDir)
+		if err != nil {
+			return err
+		}
+
+		c.GroupByLabel, err = flags.GetString(flagGroupBy)
+		if err != nil {
+			return err
+		}
+		if c.GroupByLabel != "" && c.OutputDir == "" {
+			return fmt.Errorf("--%s requires --%s", flagGroupBy, flagOutputDir)
+		}
+
+		c.Images, err = flags.GetBool(flagImages)
+		if err != nil {
+			return err
+		}
+		if c.Images {
+			c.Resolver, err = buildResolver(cmd)
+			if err != nil {
+				return err
+			}
+		}
+
+		showSecrets, err := flags.GetBool(flagShowSecr)
+		if err != nil {
+			return err
+		}
+
+		objs, err := readObjsRedacting(cmd, args, !showSecrets)
 		if err != nil {
 			return err
 		}
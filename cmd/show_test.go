@@ -18,9 +18,11 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v2"
@@ -96,3 +98,76 @@ func TestShow(t *testing.T) {
 		}
 	}
 }
+
+func TestShowInventory(t *testing.T) {
+	args := []string{"show",
+		"-J", filepath.FromSlash("../testdata/lib"),
+		"-o", "json",
+		"--inventory",
+		"--gc-tag", "myapp",
+		filepath.FromSlash("../testdata/test.jsonnet"),
+		"-V", "aVar=aVal",
+		"-V", "anVar",
+		"--ext-str-file", "filevar=" + filepath.FromSlash("../testdata/extvar.file"),
+	}
+	os.Setenv("anVar", "aVal2")
+	defer os.Unsetenv("anVar")
+	defer showCmd.PersistentFlags().Set(flagInventory, "false")
+	defer showCmd.PersistentFlags().Set(flagGcTag, "")
+
+	output := cmdOutput(t, args)
+	t.Log("output is", output)
+
+	var items []map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &items); err != nil {
+		t.Fatalf("error parsing output: %s", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 inventory item, got %d", len(items))
+	}
+	item := items[0]
+	if item["apiVersion"] != "v0alpha1" || item["kind"] != "TestObject" || item["applySet"] != "myapp" {
+		t.Errorf("Unexpected inventory item %v", item)
+	}
+	if _, ok := item["string"]; ok {
+		t.Errorf("Inventory included object content")
+	}
+	hash, _ := item["hash"].(string)
+	if !strings.HasPrefix(hash, "sha256:") {
+		t.Errorf("Unexpected hash %q", hash)
+	}
+
+	if again := cmdOutput(t, args); again != output {
+		t.Errorf("Inventory was not stable for unchanged input: %s != %s", again, output)
+	}
+}
+
+func TestShowSelector(t *testing.T) {
+	defer RootCmd.PersistentFlags().Set(flagSelector, "")
+	// -V flags from earlier tests are still set
+	os.Setenv("anVar", "aVal2")
+	defer os.Unsetenv("anVar")
+
+	tmpdir, err := ioutil.TempDir("", "selector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "objs.jsonnet")
+	src := `[
+  {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "front", labels: {tier: "frontend"}}},
+  {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "back", labels: {tier: "backend"}}},
+]`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := cmdOutput(t, []string{"show", "-o", "json", "-l", "tier=frontend", path})
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &obj); err != nil {
+		t.Fatalf("error parsing output: %s: %s", err, output)
+	}
+	if name := obj["metadata"].(map[string]interface{})["name"]; name != "front" {
+		t.Errorf("Expected only the selected object, got %s", output)
+	}
+}
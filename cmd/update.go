@@ -16,16 +16,42 @@
 package cmd
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/spf13/cobra"
 
 	"github.com/ksonnet/kubecfg/pkg/kubecfg"
+	"github.com/ksonnet/kubecfg/utils"
 )
 
 const (
-	flagCreate = "create"
-	flagSkipGc = "skip-gc"
-	flagGcTag  = "gc-tag"
-	flagDryRun = "dry-run"
+	flagCreate    = "create"
+	flagCreateNs  = "create-namespace"
+	flagSkipGc    = "skip-gc"
+	flagGcTag     = "gc-tag"
+	flagDryRun    = "dry-run"
+	flagMetaOnly  = "metadata-only"
+	flagOwnCheck  = "check-ownership"
+	flagPermCheck = "check-permissions"
+	flagStrict    = "strict"
+	flagOverwrite = "overwrite"
+	flagEmitEvent = "emit-events"
+	flagAtomic    = "atomic"
+	flagRecreate  = "recreate-on-immutable"
+	flagPDBGate   = "pdb-gate"
+	flagPDBTmout  = "pdb-gate-timeout"
+	flagDeployID  = "deploy-id"
+	flagGcSel     = "gc-selector"
+	flagGcProp    = "gc-propagation"
+	flagGcAllow   = "prune-whitelist"
+	flagSSA       = "server-side"
+	flagFieldMgr  = "field-manager"
+	flagForceConf = "force-conflicts"
+	flagQuiet     = "quiet"
+	flagConfCheck = "check-conflicts"
+	flagApplyConc = "apply-concurrency"
+	flagWaitCRDs  = "wait-for-crds"
 
 	// AnnotationGcTag annotation that triggers
 	// garbage collection. Objects with value equal to
@@ -46,9 +72,35 @@ const (
 func init() {
 	RootCmd.AddCommand(updateCmd)
 	updateCmd.PersistentFlags().Bool(flagCreate, true, "Create missing resources")
+	updateCmd.PersistentFlags().Bool(flagCreateNs, false, "Create missing namespaces of namespaced resources")
+	updateCmd.PersistentFlags().Bool(flagWaitCRDs, false, "Wait for custom resource kinds whose CustomResourceDefinition exists but is not yet served. Requires permission to list CustomResourceDefinitions")
 	updateCmd.PersistentFlags().Bool(flagSkipGc, false, "Don't perform garbage collection, even with --"+flagGcTag)
 	updateCmd.PersistentFlags().String(flagGcTag, "", "Add this tag to updated objects, and garbage collect existing objects with this tag and not in config")
+	updateCmd.PersistentFlags().String(flagGcSel, "", "Record a digest of the config on updated objects, and garbage collect existing objects matching this label selector that were applied from a different config")
+	updateCmd.PersistentFlags().StringSlice(flagGcAllow, nil, "Only garbage collect objects of these kinds (group/version/Kind, eg: apps/v1beta1/Deployment or core/v1/ConfigMap)")
+	updateCmd.PersistentFlags().String(flagGcProp, "foreground", "Deletion propagation policy for --"+flagGcSel+" garbage collection: foreground, background or orphan")
 	updateCmd.PersistentFlags().Bool(flagDryRun, false, "Perform only read-only operations")
+	updateCmd.PersistentFlags().Bool(flagMetaOnly, false, "Only update labels and annotations of existing objects")
+	updateCmd.PersistentFlags().Bool(flagOverwrite, false, "Reset any drift in fields specified by config, using replace rather than patch")
+	updateCmd.PersistentFlags().Bool(flagSSA, false, "Use server-side apply, rather than client-side merge patches. Requires Kubernetes 1.16 or later")
+	updateCmd.PersistentFlags().String(flagFieldMgr, kubecfg.FieldManager, "Field manager name recorded by the server for updates")
+	updateCmd.PersistentFlags().Bool(flagForceConf, false, "With --"+flagSSA+", take ownership of fields owned by other field managers. With --"+flagConfCheck+", overwrite conflicting fields")
+	updateCmd.PersistentFlags().Bool(flagKeepStatus, false, "Apply status from config, which is otherwise stripped along with other server-managed fields. Use for (rare) resources whose config deliberately sets status")
+	updateCmd.PersistentFlags().Bool(flagQuiet, false, "Don't periodically report progress while applying many objects")
+	updateCmd.PersistentFlags().Bool(flagEmitEvent, false, "Record a Kubernetes Event for each object changed")
+	updateCmd.PersistentFlags().Bool(flagAtomic, false, "If any object fails to apply, roll back the changes already made")
+	updateCmd.PersistentFlags().Bool(flagRecreate, false, "DELETE and recreate objects whose update changes an immutable field (eg: a Job's selector). Dependents of the deleted object are also deleted")
+	updateCmd.PersistentFlags().Int(flagApplyConc, 4, "Number of objects to apply concurrently.  Only objects with no dependencies on each other are applied at once")
+	updateCmd.PersistentFlags().Bool(flagPDBGate, false, "After updating each workload, wait for its rollout and report any PodDisruptionBudget it violates")
+	updateCmd.PersistentFlags().Duration(flagPDBTmout, 10*time.Minute, "Maximum time to wait for each rollout with --"+flagPDBGate)
+	updateCmd.PersistentFlags().Bool(flagWait, false, "After updating, wait until Deployments, StatefulSets, DaemonSets and ReplicaSets have completed their rollout")
+	updateCmd.PersistentFlags().Duration(flagWaitTimeout, 5*time.Minute, "Maximum time to --"+flagWait)
+	updateCmd.PersistentFlags().String(flagDeployID, "", "Identifier for this deploy, recorded on each applied object (as kubecfg.io/deploy-id) and in logs and events. Defaults to a random UUID, which is only used in logs and events")
+	updateCmd.PersistentFlags().Bool(flagServerDryRun, false, "Make no changes. Instead, submit each object as a server-side dry-run and report whether it would be created or changed, or would be rejected (eg: by an admission webhook). Requires Kubernetes 1.13 or later")
+	updateCmd.PersistentFlags().Bool(flagOwnCheck, false, "Warn about existing objects with fields owned by other tools")
+	updateCmd.PersistentFlags().Bool(flagPermCheck, false, "Check that you may update every object before updating anything")
+	updateCmd.PersistentFlags().Bool(flagStrict, false, "Abort if --"+flagOwnCheck+" finds conflicts")
+	updateCmd.PersistentFlags().Bool(flagConfCheck, false, "Record the applied config in the "+kubecfg.AnnotationLastApplied+" annotation, and abort if fields changed by other tools since the last update would be overwritten")
 }
 
 var updateCmd = &cobra.Command{
@@ -57,43 +109,219 @@ var updateCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		flags := cmd.Flags()
 		var err error
-		c := kubecfg.UpdateCmd{}
+		c := kubecfg.UpdateCmd{Tracer: tracer, Budget: budget, Hooks: kubecfg.DefaultApplyHooks()}
 
 		c.Create, err = flags.GetBool(flagCreate)
 		if err != nil {
 			return err
 		}
 
+		c.CreateNamespace, err = flags.GetBool(flagCreateNs)
+		if err != nil {
+			return err
+		}
+
+		c.WaitForCRDs, err = flags.GetBool(flagWaitCRDs)
+		if err != nil {
+			return err
+		}
+
 		c.GcTag, err = flags.GetString(flagGcTag)
 		if err != nil {
 			return err
 		}
 
+		c.GcSelector, err = flags.GetString(flagGcSel)
+		if err != nil {
+			return err
+		}
+
+		gcProp, err := flags.GetString(flagGcProp)
+		if err != nil {
+			return err
+		}
+		c.GcPropagation, err = utils.ParsePropagationPolicy(gcProp)
+		if err != nil {
+			return err
+		}
+
+		gcAllow, err := flags.GetStringSlice(flagGcAllow)
+		if err != nil {
+			return err
+		}
+		for _, s := range gcAllow {
+			gvk, err := utils.ParseGroupVersionKind(s)
+			if err != nil {
+				return err
+			}
+			c.GcAllowlist = append(c.GcAllowlist, gvk)
+		}
+
 		c.SkipGc, err = flags.GetBool(flagSkipGc)
 		if err != nil {
 			return err
 		}
+		if err := checkSelectorGc(cmd, (c.GcTag != "" || c.GcSelector != "") && !c.SkipGc); err != nil {
+			return err
+		}
 
 		c.DryRun, err = flags.GetBool(flagDryRun)
 		if err != nil {
 			return err
 		}
 
+		c.MetadataOnly, err = flags.GetBool(flagMetaOnly)
+		if err != nil {
+			return err
+		}
+
+		c.Overwrite, err = flags.GetBool(flagOverwrite)
+		if err != nil {
+			return err
+		}
+
+		c.ServerSide, err = flags.GetBool(flagSSA)
+		if err != nil {
+			return err
+		}
+
+		c.Quiet, err = flags.GetBool(flagQuiet)
+		if err != nil {
+			return err
+		}
+
+		c.KeepStatus, err = flags.GetBool(flagKeepStatus)
+		if err != nil {
+			return err
+		}
+
+		c.CheckOwnership, err = flags.GetBool(flagOwnCheck)
+		if err != nil {
+			return err
+		}
+
+		c.Strict, err = flags.GetBool(flagStrict)
+		if err != nil {
+			return err
+		}
+
+		c.CheckPermissions, err = flags.GetBool(flagPermCheck)
+		if err != nil {
+			return err
+		}
+
+		c.CheckConflicts, err = flags.GetBool(flagConfCheck)
+		if err != nil {
+			return err
+		}
+
+		c.EmitEvents, err = flags.GetBool(flagEmitEvent)
+		if err != nil {
+			return err
+		}
+
+		c.Atomic, err = flags.GetBool(flagAtomic)
+		if err != nil {
+			return err
+		}
+
+		c.RecreateOnImmutable, err = flags.GetBool(flagRecreate)
+		if err != nil {
+			return err
+		}
+
+		c.ApplyConcurrency, err = flags.GetInt(flagApplyConc)
+		if err != nil {
+			return err
+		}
+		if c.ApplyConcurrency < 1 {
+			return fmt.Errorf("--%s must be at least 1", flagApplyConc)
+		}
+
+		c.PDBGate, err = flags.GetBool(flagPDBGate)
+		if err != nil {
+			return err
+		}
+
+		c.PDBGateTimeout, err = flags.GetDuration(flagPDBTmout)
+		if err != nil {
+			return err
+		}
+
+		c.Wait, err = flags.GetBool(flagWait)
+		if err != nil {
+			return err
+		}
+
+		c.WaitTimeout, err = flags.GetDuration(flagWaitTimeout)
+		if err != nil {
+			return err
+		}
+
+		c.DeployID, err = flags.GetString(flagDeployID)
+		if err != nil {
+			return err
+		}
+		// Only an explicit ID is recorded on objects
+		c.StampDeployID = c.DeployID != ""
+		if c.DeployID == "" {
+			c.DeployID = kubecfg.NewDeployID()
+		}
+
 		c.ClientPool, c.Discovery, err = restClientPool(cmd)
 		if err != nil {
 			return err
 		}
+		c.GcClientPool, err = gcClientPool(cmd)
+		if err != nil {
+			return err
+		}
 
-		c.DefaultNamespace, err = defaultNamespace(clientConfig)
+		fieldManager, err := flags.GetString(flagFieldMgr)
+		if err != nil {
+			return err
+		}
+		force, err := flags.GetBool(flagForceConf)
+		if err != nil {
+			return err
+		}
+		if c.ServerSide {
+			c.ClientPool, err = serverSideApplyClientPool(cmd, fieldManager, force)
+			if err != nil {
+				return err
+			}
+		} else if fieldManager != kubecfg.FieldManager {
+			c.ClientPool, err = fieldManagerClientPool(cmd, fieldManager)
+			if err != nil {
+				return err
+			}
+		}
+		c.ForceConflicts = force
+
+		c.DefaultNamespace, err = clientConfig.DefaultNamespace()
 		if err != nil {
 			return err
 		}
 
+		serverDryRun, err := flags.GetBool(flagServerDryRun)
+		if err != nil {
+			return err
+		}
+		if serverDryRun {
+			c.DryRunPool, err = dryRunClientPool(cmd)
+			if err != nil {
+				return err
+			}
+		}
+
 		objs, err := readObjs(cmd, args)
 		if err != nil {
 			return err
 		}
 
+		if serverDryRun {
+			return c.RunServerDryRun(objs, cmd.OutOrStdout())
+		}
 		return c.Run(objs)
 	},
 }
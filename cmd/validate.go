@@ -19,24 +19,51 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/ksonnet/kubecfg/pkg/kubecfg"
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+const (
+	flagJSONSchema   = "json-schema"
+	flagServerSchema = "server-schema"
 )
 
 func init() {
 	RootCmd.AddCommand(validateCmd)
+	validateCmd.PersistentFlags().StringSlice(flagJSONSchema, nil, "Also validate against this JSON schema file. Use [group/]Kind=path to only apply to objects of that kind")
+	validateCmd.PersistentFlags().Bool(flagServerSchema, true, "Validate against the server's schema. Requires cluster access, or --"+flagDiscoSnap+" with a captured schema")
 }
 
 var validateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Compare generated manifest against server OpenAPI spec",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
 		var err error
 
 		c := kubecfg.ValidateCmd{}
 
-		_, c.Discovery, err = restClientPool(cmd)
+		schemas, err := flags.GetStringSlice(flagJSONSchema)
+		if err != nil {
+			return err
+		}
+		for _, s := range schemas {
+			rule, err := utils.ParseJSONSchemaRule(s)
+			if err != nil {
+				return err
+			}
+			c.JSONSchemas = append(c.JSONSchemas, rule)
+		}
+
+		server, err := flags.GetBool(flagServerSchema)
 		if err != nil {
 			return err
 		}
+		if server {
+			c.Discovery, err = discoveryClient(cmd)
+			if err != nil {
+				return err
+			}
+		}
 
 		objs, err := readObjs(cmd, args)
 		if err != nil {
@@ -19,8 +19,9 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
-	jsonnet "github.com/strickyak/jsonnet_cgo"
 	"k8s.io/client-go/pkg/version"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
 )
 
 func init() {
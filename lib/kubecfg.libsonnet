@@ -45,6 +45,25 @@
     f(std.toString(value))
   ),
 
+  // manifestYamlStream(values): convert the *array* of jsonnet
+  // objects `values` to a string encoded as a YAML stream, with each
+  // element as a separate ("---" prefixed) document.  The inverse of
+  // parseYaml.
+  manifestYamlStream(values):: std.join("", ["---\n" + $.manifestYaml(v) for v in values]),
+
+  // base64(str): encode the `str` string as standard (padded)
+  // base64, eg for the `data` of a Secret.
+  base64:: std.native("base64"),
+
+  // base64Decode(str): decode the standard base64 `str` string.  The
+  // decoded data should be UTF-8 text; use base64DecodeBytes for
+  // binary data.
+  base64Decode:: std.native("base64Decode"),
+
+  // base64DecodeBytes(str): decode the standard base64 `str` string,
+  // and return an *array* of the resulting byte values.
+  base64DecodeBytes:: std.native("base64DecodeBytes"),
+
   // escapeStringRegex(s): Quote the regex metacharacters found in s.
   // The result is a regex that will match the original literal
   // characters.
@@ -65,4 +84,85 @@
   // to refer to submatches.  Regex is as implemented in golang regexp
   // package (python-ish).
   regexSubst:: std.native("regexSubst"),
+
+  // importManifest(url, sha256): fetch the YAML (or JSON) stream at
+  // `url`, and return an *array* of the resulting objects.  The
+  // sha256 digest of the content is required (and verified) unless
+  // kubecfg is run with --allow-unpinned-imports.
+  importManifest(url, sha256=""):: std.native("importManifest")(url, sha256),
+
+  // importDir(glob, thisFile): parse every YAML (or JSON) file
+  // matching `glob`, relative to the calling jsonnet file, and return
+  // an *array* of the resulting objects.  Pass `std.thisFile` as
+  // `thisFile`, eg `kubecfg.importDir("manifests/*", std.thisFile)`.
+  // Files are read in lexicographic order, and must be within the
+  // directory of the top-level jsonnet file.
+  importDir(glob, thisFile):: std.native("importDir")(glob, thisFile),
+
+  // externalSecret(ref): fetch the secret value identified by `ref`
+  // from the external secret manager selected by --secret-backend.
+  // For vault, `ref` is "path#field", eg "secret/data/myapp#password".
+  // `kubecfg show` outputs a placeholder instead, unless run with
+  // --show-secrets.
+  externalSecret:: std.native("externalSecret"),
+
+  // kubeServerVersion(): returns the `{major, minor, gitVersion}`
+  // version strings of the target cluster, eg
+  // `std.parseInt(kubecfg.kubeServerVersion().minor) >= 21`.  Fails
+  // when kubecfg is run with --no-cluster.
+  kubeServerVersion:: std.native("kubeServerVersion"),
+
+  // kubeResourceExists(group, version, kind): returns true if the
+  // target cluster serves the kind, eg
+  // `kubecfg.kubeResourceExists("cert-manager.io", "v1", "Certificate")`.
+  // Fails when kubecfg is run with --no-cluster.
+  kubeResourceExists:: std.native("kubeResourceExists"),
+
+  // kubeResourceScope(apiVersion, kind): returns "Namespaced" or
+  // "Cluster", according to the scope of the kind in the target
+  // cluster, eg to only set `metadata.namespace` where it means
+  // something.  Fails if the cluster does not serve the kind (see
+  // kubeResourceExists), and when kubecfg is run with --no-cluster.
+  kubeResourceScope:: std.native("kubeResourceScope"),
+
+  // kubeDiscovery(): returns the resources served by the target
+  // cluster (in their preferred versions), grouped by API group, eg
+  // `kubecfg.kubeDiscovery()["apps"]` is an array of
+  // `{name, kind, namespaced, verbs}`.  Returns `{}` when kubecfg is
+  // run with --no-cluster.
+  kubeDiscovery:: std.native("kubeDiscovery"),
+
+  // kubeGet(apiVersion, kind, namespace, name): returns the live
+  // object from the target cluster, or null if it does not exist, eg
+  // `kubecfg.kubeGet("v1", "Secret", "default", "tls").data`.  Use ""
+  // as the namespace of cluster-scoped kinds.  The object is read
+  // afresh on every evaluation.  Fails when kubecfg is run with
+  // --no-cluster.
+  kubeGet:: std.native("kubeGet"),
+
+  // deepMerge(a, b): Recursively merge object `b` into object `a`.
+  // Fields present in both are merged if both values are objects,
+  // otherwise the value from `b` wins.
+  deepMerge(a, b):: (
+    if std.type(a) == "object" && std.type(b) == "object" then
+      a + {
+        [k]: if std.objectHas(a, k) then $.deepMerge(a[k], b[k]) else b[k]
+        for k in std.objectFields(b)
+      }
+    else b
+  ),
+
+  // labelSet(name, component, partOf, version): Returns the
+  // recommended `app.kubernetes.io/*` labels.  Arguments that are
+  // null are omitted.
+  labelSet(name, component=null, partOf=null, version=null):: {
+    [k.key]: k.value
+    for k in [
+      {key: "app.kubernetes.io/name", value: name},
+      {key: "app.kubernetes.io/component", value: component},
+      {key: "app.kubernetes.io/part-of", value: partOf},
+      {key: "app.kubernetes.io/version", value: version},
+    ]
+    if k.value != null
+  },
 }
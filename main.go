@@ -30,7 +30,10 @@ var version = "(dev build)"
 func main() {
 	cmd.Version = version
 
-	if err := cmd.RootCmd.Execute(); err != nil {
+	err := cmd.RootCmd.Execute()
+	cmd.FlushTraces()
+	err = cmd.ReportWarnings(err)
+	if err != nil {
 		// PersistentPreRunE may not have been run for early
 		// errors, like invalid command line flags.
 		logFmt := cmd.NewLogFormatter(log.StandardLogger().Out)
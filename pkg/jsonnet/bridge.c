@@ -5,8 +5,8 @@
 #include "_cgo_export.h"
 
 char* CallImport_cgo(void *ctx, const char *base, const char *rel, char **found_here, int *success) {
-  struct JsonnetVm* vm = ctx;
-  return go_call_import(vm, (char*)base, (char*)rel, found_here, success);
+  GoUintptr key = (GoUintptr)ctx;
+  return go_call_import(key, (char*)base, (char*)rel, found_here, success);
 }
 
 struct JsonnetJsonValue* CallNative_cgo(void* ctx, const struct JsonnetJsonValue* const* argv, int* success) {
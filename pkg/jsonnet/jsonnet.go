@@ -13,8 +13,13 @@ package jsonnet
 #include <string.h>
 #include <stdio.h>
 #include <stdlib.h>
+#include <stdint.h>
 #include <libjsonnet.h>
 
+// Callback registry keys are integers, not Go pointers; convert them
+// on the C side so go vet doesn't flag the uintptr to pointer cast.
+static void *key_ctx(uintptr_t key) { return (void *)key; }
+
 char *CallImport_cgo(void *ctx, const char *base, const char *rel, char **found_here, int *success);
 struct JsonnetJsonValue *CallNative_cgo(void *ctx, const struct JsonnetJsonValue *const *argv, int *success);
 
@@ -83,6 +88,43 @@ func unregisterFuncs(vm *VM) {
 	}
 }
 
+// Import callbacks are looked up via the same indirection, since the
+// VM itself contains Go pointers.
+var importVMsMu sync.Mutex
+var importVMsIdx uintptr
+var importVMs = make(map[uintptr]*VM)
+
+func registerImport(vm *VM) uintptr {
+	importVMsMu.Lock()
+	defer importVMsMu.Unlock()
+
+	importVMsIdx++
+	for importVMs[importVMsIdx] != nil {
+		importVMsIdx++
+	}
+
+	importVMs[importVMsIdx] = vm
+	return importVMsIdx
+}
+
+func getImportVM(key uintptr) *VM {
+	importVMsMu.Lock()
+	defer importVMsMu.Unlock()
+
+	return importVMs[key]
+}
+
+func unregisterImport(vm *VM) {
+	importVMsMu.Lock()
+	defer importVMsMu.Unlock()
+
+	for idx, v := range importVMs {
+		if v == vm {
+			delete(importVMs, idx)
+		}
+	}
+}
+
 type VM struct {
 	guts           *C.struct_JsonnetVm
 	importCallback ImportCallback
@@ -118,8 +160,8 @@ func go_call_native(key uintptr, argv **C.struct_JsonnetJsonValue, okPtr *C.int)
 }
 
 //export go_call_import
-func go_call_import(vmPtr unsafe.Pointer, base, rel *C.char, pathPtr **C.char, okPtr *C.int) *C.char {
-	vm := (*VM)(vmPtr)
+func go_call_import(key uintptr, base, rel *C.char, pathPtr **C.char, okPtr *C.int) *C.char {
+	vm := getImportVM(key)
 	result, path, err := vm.importCallback(C.GoString(base), C.GoString(rel))
 	if err != nil {
 		*okPtr = C.int(0)
@@ -144,6 +186,7 @@ func Make() *VM {
 // Complement of Make().
 func (vm *VM) Destroy() {
 	unregisterFuncs(vm)
+	unregisterImport(vm)
 	C.jsonnet_destroy(vm.guts)
 	vm.guts = nil
 }
@@ -210,8 +253,10 @@ func (vm *VM) FormatSnippet(filename, snippet string) (string, error) {
 
 // Override the callback used to locate imports.
 func (vm *VM) ImportCallback(f ImportCallback) {
+	unregisterImport(vm)
 	vm.importCallback = f
-	C.jsonnet_import_callback(vm.guts, (*C.JsonnetImportCallback)(unsafe.Pointer(C.CallImport_cgo)), unsafe.Pointer(vm))
+	key := registerImport(vm)
+	C.jsonnet_import_callback(vm.guts, (*C.JsonnetImportCallback)(unsafe.Pointer(C.CallImport_cgo)), C.key_ctx(C.uintptr_t(key)))
 }
 
 // NativeCallback is a helper around NativeCallbackRaw that uses
@@ -264,7 +309,7 @@ func (vm *VM) NativeCallbackRaw(name string, params []string, f NativeCallback)
 	}
 
 	key := registerFunc(vm, len(params), f)
-	C.jsonnet_native_callback(vm.guts, cname, (*C.JsonnetNativeCallback)(C.CallNative_cgo), unsafe.Pointer(key), (**C.char)(unsafe.Pointer(&cparams[0])))
+	C.jsonnet_native_callback(vm.guts, cname, (*C.JsonnetNativeCallback)(C.CallNative_cgo), C.key_ctx(C.uintptr_t(key)), (**C.char)(unsafe.Pointer(&cparams[0])))
 }
 
 // Bind a Jsonnet external var to the given value.
@@ -0,0 +1,129 @@
+package jsonnet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// The callbacks below cross from C back into Go, which is exactly
+// what cgo's pointer checks police.  Make sure they are enabled.
+func checkCgo(t *testing.T) {
+	if strings.Contains(os.Getenv("GODEBUG"), "cgocheck=0") {
+		t.Fatalf("cgo pointer checks are disabled (GODEBUG=%s)", os.Getenv("GODEBUG"))
+	}
+}
+
+func TestEvaluateSnippet(t *testing.T) {
+	vm := Make()
+	defer vm.Destroy()
+
+	vm.ExtVar("color", "purple")
+	x, err := vm.EvaluateSnippet("test", `"dark " + std.extVar("color")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != "\"dark purple\"\n" {
+		t.Errorf("Unexpected result %q", x)
+	}
+}
+
+func TestImportCallback(t *testing.T) {
+	checkCgo(t)
+
+	files := map[string]string{
+		"/lib/a.jsonnet": `local b = import "b.jsonnet"; { a: b.b + 1 }`,
+		"/lib/b.jsonnet": `{ b: 41 }`,
+	}
+	var imports []string
+
+	vm := Make()
+	defer vm.Destroy()
+
+	vm.ImportCallback(func(base, rel string) (string, string, error) {
+		imports = append(imports, fmt.Sprintf("%s %s", base, rel))
+		p := path.Join(base, rel)
+		content, ok := files[p]
+		if !ok {
+			return "", "", fmt.Errorf("%s not found", p)
+		}
+		return content, p, nil
+	})
+
+	x, err := vm.EvaluateSnippet("/test.jsonnet", `(import "lib/a.jsonnet").a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != "42\n" {
+		t.Errorf("Unexpected result %q", x)
+	}
+
+	// Relative imports are resolved against the importing file
+	expected := []string{"/ lib/a.jsonnet", "/lib/ b.jsonnet"}
+	if strings.Join(imports, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected imports %q", imports)
+	}
+
+	_, err = vm.EvaluateSnippet("/test.jsonnet", `import "missing.jsonnet"`)
+	if err == nil || !strings.Contains(err.Error(), "/missing.jsonnet not found") {
+		t.Errorf("Expected import error, got %v", err)
+	}
+
+	// Replacing the callback must drop the old one
+	vm.ImportCallback(func(base, rel string) (string, string, error) {
+		return `"replaced"`, path.Join(base, rel), nil
+	})
+	x, err = vm.EvaluateSnippet("/test.jsonnet", `import "other.jsonnet"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != "\"replaced\"\n" {
+		t.Errorf("Unexpected result %q", x)
+	}
+}
+
+func TestNativeCallback(t *testing.T) {
+	checkCgo(t)
+
+	vm := Make()
+	defer vm.Destroy()
+
+	vm.NativeCallback("join", []string{"sep", "a", "b"}, func(sep, a, b string) (string, error) {
+		return a + sep + b, nil
+	})
+	vm.NativeCallback("double", []string{"x"}, func(x float64) (interface{}, error) {
+		return map[string]interface{}{"x": x * 2, "list": []interface{}{true, nil}}, nil
+	})
+	vm.NativeCallback("fail", []string{}, func() (interface{}, error) {
+		return nil, errors.New("native failure")
+	})
+
+	x, err := vm.EvaluateSnippet("test", `std.native("join")("-", "a", "b")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != "\"a-b\"\n" {
+		t.Errorf("Unexpected result %q", x)
+	}
+
+	x, err = vm.EvaluateSnippet("test", `local d = std.native("double")(21); [d.x, d.list]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(strings.Fields(x), "") != "[42,[true,null]]" {
+		t.Errorf("Unexpected result %q", x)
+	}
+
+	_, err = vm.EvaluateSnippet("test", `std.native("fail")()`)
+	if err == nil || !strings.Contains(err.Error(), "native failure") {
+		t.Errorf("Expected native error, got %v", err)
+	}
+
+	_, err = vm.EvaluateSnippet("test", `std.native("join")("-", 1, "b")`)
+	if err == nil || !strings.Contains(err.Error(), "cannot be converted") {
+		t.Errorf("Expected conversion error, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// rollbackEntry records how to undo the change made to a single
+// object
+type rollbackEntry struct {
+	rc   *dynamic.ResourceClient
+	desc string
+	name string
+	// snapshot is the pre-apply object, or nil if the object
+	// was created by this run.
+	snapshot *unstructured.Unstructured
+	// recreated is true if this run deleted and recreated the
+	// object (see UpdateCmd.RecreateOnImmutable), so restoring it
+	// may need to recreate it again.
+	recreated bool
+}
+
+// rollbackLog is the list of changes made by an --atomic update.  A
+// nil *rollbackLog records nothing.
+type rollbackLog struct {
+	entries []rollbackEntry
+}
+
+func newRollbackLog(enabled bool) *rollbackLog {
+	if !enabled {
+		return nil
+	}
+	return &rollbackLog{}
+}
+
+// Snapshot captures the current state of obj, before it is
+// modified.  The result should be passed to Record once the
+// modification succeeds.
+func (l *rollbackLog) Snapshot(rc *dynamic.ResourceClient, desc string, obj *unstructured.Unstructured) (*rollbackEntry, error) {
+	if l == nil {
+		return nil, nil
+	}
+	live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		live = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error fetching %s for rollback snapshot: %v", desc, err)
+	} else {
+		sanitizeSnapshot(live)
+	}
+	return &rollbackEntry{
+		rc:       rc,
+		desc:     desc,
+		name:     obj.GetName(),
+		snapshot: live,
+	}, nil
+}
+
+// Record adds a successfully applied change to the log
+func (l *rollbackLog) Record(e *rollbackEntry) {
+	if l == nil {
+		return
+	}
+	l.entries = append(l.entries, *e)
+}
+
+// Rollback undoes the recorded changes, in reverse order, and
+// returns the (annotated) original error.  Rollback is best-effort:
+// failures are reported but do not stop later objects being
+// restored.
+func (l *rollbackLog) Rollback(cause error) error {
+	if l == nil {
+		return cause
+	}
+
+	log.Warningf("Rolling back %d objects after error: %v", len(l.entries), cause)
+	failed := 0
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if err := l.entries[i].undo(); err != nil {
+			log.Errorf("Error rolling back %s: %v", l.entries[i].desc, err)
+			failed++
+		}
+	}
+	l.entries = nil
+
+	if failed > 0 {
+		return fmt.Errorf("%v (rollback failed for %d objects)", cause, failed)
+	}
+	return fmt.Errorf("%v (rolled back)", cause)
+}
+
+func (e rollbackEntry) undo() error {
+	if e.snapshot == nil {
+		log.Info(" Deleting created ", e.desc)
+		err := e.rc.Delete(e.name, &metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	log.Info(" Restoring ", e.desc)
+	live, err := e.rc.Get(e.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		e.snapshot.SetResourceVersion("")
+		_, err = e.rc.Create(e.snapshot)
+		return err
+	} else if err != nil {
+		return err
+	}
+	e.snapshot.SetResourceVersion(live.GetResourceVersion())
+	_, err = e.rc.Update(e.snapshot)
+	if isImmutableFieldError(err) && e.recreated {
+		// The object was recreated to change immutable fields,
+		// so must be recreated again to restore it.  Other
+		// objects are never deleted by a rollback: eg: a PVC
+		// that can't be shrunk back is reported as a failure.
+		log.Info(" Recreating ", e.desc)
+		if err := utils.DeleteWithPropagation(e.rc, e.name, metav1.DeleteOptions{}, metav1.DeletePropagationForeground); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err := waitForGone(e.rc, e.name, recreatePollInterval, recreateTimeout); err != nil {
+			return err
+		}
+		e.snapshot.SetResourceVersion("")
+		_, err = e.rc.Create(e.snapshot)
+	}
+	return err
+}
+
+// sanitizeSnapshot removes server-maintained fields that should not
+// be written back when restoring obj.
+func sanitizeSnapshot(obj *unstructured.Unstructured) {
+	delete(obj.Object, "status")
+	if metadata, ok := obj.Object["metadata"].(map[string]interface{}); ok {
+		for _, f := range []string{"managedFields", "generation", "creationTimestamp", "selfLink"} {
+			delete(metadata, f)
+		}
+	}
+}
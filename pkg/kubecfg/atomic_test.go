@@ -0,0 +1,223 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestUpdateAtomic(t *testing.T) {
+	const prefix = "/apis/tests/v1alpha1/namespaces/default/tests/"
+	const existing = `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"first","namespace":"default","resourceVersion":"%s","generation":3},"spec":{"replicas":1},"status":{"ready":true}}`
+
+	var requests []string
+	var restored map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		requests = append(requests, r.Method+" "+name)
+		notFound := func() {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+		switch {
+		case name == "first" && r.Method == "GET":
+			fmt.Fprintf(w, existing, "43")
+		case name == "first" && r.Method == "PATCH":
+			fmt.Fprintf(w, existing, "43")
+		case name == "first" && r.Method == "PUT":
+			if err := json.NewDecoder(r.Body).Decode(&restored); err != nil {
+				t.Error(err)
+			}
+			json.NewEncoder(w).Encode(restored)
+		case name == "second" && (r.Method == "GET" || r.Method == "PATCH"):
+			notFound()
+		case r.URL.Path == strings.TrimSuffix(prefix, "/") && r.Method == "POST":
+			requests[len(requests)-1] = "POST second"
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"second","namespace":"default","uid":"2"}}`)
+		case name == "second" && r.Method == "DELETE":
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		case name == "third" && r.Method == "GET":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"third","namespace":"default"}}`)
+		case name == "third" && r.Method == "PATCH":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Invalid","code":422}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			notFound()
+		}
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		Create:           true,
+		Atomic:           true,
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, name := range []string{"first", "second", "third"} {
+		objs = append(objs, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "tests/v1alpha1",
+				"kind":       "Test",
+				"metadata":   map[string]interface{}{"name": name},
+				"spec":       map[string]interface{}{"replicas": 2},
+			},
+		})
+	}
+
+	err := c.Run(objs)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Expected rolled back error, got %v", err)
+	}
+
+	expected := []string{
+		"GET first", "PATCH first",
+		"GET second", "PATCH second", "POST second",
+		"GET third", "PATCH third",
+		// Rollback, in reverse order
+		"DELETE second",
+		"GET first", "PUT first",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+
+	metadata := restored["metadata"].(map[string]interface{})
+	if metadata["resourceVersion"] != "43" {
+		t.Errorf("Restore did not use live resourceVersion: %v", metadata)
+	}
+	if _, ok := metadata["generation"]; ok {
+		t.Errorf("Restored object was not sanitized: %v", metadata)
+	}
+	if _, ok := restored["status"]; ok {
+		t.Errorf("Restored object included status")
+	}
+	if spec := restored["spec"].(map[string]interface{}); spec["replicas"] != 1.0 {
+		t.Errorf("Restored object did not match snapshot: %v", spec)
+	}
+}
+
+func TestRollbackImmutable(t *testing.T) {
+	defer func(d time.Duration) { recreatePollInterval = d }(recreatePollInterval)
+	recreatePollInterval = time.Millisecond
+
+	var requests []string
+	exists := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+				return
+			}
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"myobj","namespace":"default","resourceVersion":"2"},"spec":{"size":"2Gi"}}`)
+		case "PUT":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Invalid","code":422,"message":"Test.tests \"myobj\" is invalid: spec.size: Invalid value: \"1Gi\": field is immutable"}`)
+		case "DELETE":
+			var opts metav1.DeleteOptions
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				t.Error(err)
+			}
+			if opts.PropagationPolicy == nil || *opts.PropagationPolicy != metav1.DeletePropagationForeground {
+				t.Errorf("Expected foreground deletion, got %v", opts.PropagationPolicy)
+			}
+			exists = false
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		case "POST":
+			exists = true
+			io.Copy(w, r.Body)
+		default:
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+	}))
+	defer srv.Close()
+
+	pool := dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL})
+	disco := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "tests/v1alpha1",
+				APIResources: []metav1.APIResource{
+					{Name: "tests", Kind: "Test", Namespaced: true},
+				},
+			},
+		},
+	}}
+	snapshot := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "tests/v1alpha1",
+			"kind":       "Test",
+			"metadata":   map[string]interface{}{"name": "myobj", "namespace": "default"},
+			"spec":       map[string]interface{}{"size": "1Gi"},
+		}}
+	}
+	rc, err := clientForResource(pool, disco, snapshot(), "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An object that was only updated (eg: a grown PVC) is never
+	// deleted by a rollback
+	e := rollbackEntry{rc: rc, desc: "myobj", name: "myobj", snapshot: snapshot()}
+	if err := e.undo(); !isImmutableFieldError(err) {
+		t.Errorf("Expected immutable field error, got %v", err)
+	}
+	if expected := []string{"GET", "PUT"}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests)
+	}
+
+	// An object recreated by the run is recreated again
+	requests = nil
+	e = rollbackEntry{rc: rc, desc: "myobj", name: "myobj", snapshot: snapshot(), recreated: true}
+	if err := e.undo(); err != nil {
+		t.Error(err)
+	}
+	if expected := []string{"GET", "PUT", "DELETE", "GET", "POST"}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// objectRef identifies a (core/v1) object referenced from a pod
+// spec
+type objectRef struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// blastRadius returns a warning for each live object that refers to
+// an object in pruned.  Live objects that are themselves being
+// pruned, or are managed by a controller (eg: the Pods of a
+// ReplicaSet), are ignored.
+func blastRadius(pruned, live []*unstructured.Unstructured) []string {
+	prunedRefs := map[objectRef]*unstructured.Unstructured{}
+	prunedUids := sets.NewString()
+	for _, o := range pruned {
+		prunedUids.Insert(string(o.GetUID()))
+		if o.GroupVersionKind().Group != "" {
+			continue
+		}
+		prunedRefs[objectRef{Kind: o.GetKind(), Namespace: o.GetNamespace(), Name: o.GetName()}] = o
+	}
+
+	var warnings []string
+	for _, o := range live {
+		if prunedUids.Has(string(o.GetUID())) || hasController(o) {
+			continue
+		}
+		seen := map[objectRef]bool{}
+		for _, ref := range podSpecRefs(o) {
+			target, ok := prunedRefs[ref]
+			if !ok || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			warnings = append(warnings, fmt.Sprintf("Deleting %s %s would break %s %s",
+				target.GetKind(), target.GetName(), o.GetKind(), o.GetName()))
+		}
+	}
+	return warnings
+}
+
+func hasController(o *unstructured.Unstructured) bool {
+	for _, ref := range o.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller {
+			return true
+		}
+	}
+	return false
+}
+
+// podSpecOf returns the pod spec embedded in o, or nil
+func podSpecOf(o *unstructured.Unstructured) map[string]interface{} {
+	for _, path := range [][]string{
+		{"spec"},                     // Pod
+		{"spec", "template", "spec"}, // Deployment, StatefulSet, Job, etc
+		{"spec", "jobTemplate", "spec", "template", "spec"}, // CronJob
+	} {
+		if v, ok := fieldAt(o.Object, path); ok {
+			if m, ok := v.(map[string]interface{}); ok {
+				if _, ok := m["containers"]; ok {
+					return m
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// podSpecRefs returns the objects referenced by the pod spec
+// embedded in o, if any.
+func podSpecRefs(o *unstructured.Unstructured) []objectRef {
+	spec := podSpecOf(o)
+	if spec == nil {
+		return nil
+	}
+
+	ns := o.GetNamespace()
+	var refs []objectRef
+	add := func(kind string, name interface{}) {
+		if s, ok := name.(string); ok && s != "" {
+			refs = append(refs, objectRef{Kind: kind, Namespace: ns, Name: s})
+		}
+	}
+	str := func(m interface{}, path ...string) interface{} {
+		obj, ok := m.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v, _ := fieldAt(obj, path)
+		return v
+	}
+	list := func(v interface{}) []interface{} {
+		l, _ := v.([]interface{})
+		return l
+	}
+
+	add("ServiceAccount", spec["serviceAccountName"])
+	for _, s := range list(spec["imagePullSecrets"]) {
+		add("Secret", str(s, "name"))
+	}
+	for _, v := range list(spec["volumes"]) {
+		add("ConfigMap", str(v, "configMap", "name"))
+		add("Secret", str(v, "secret", "secretName"))
+		add("PersistentVolumeClaim", str(v, "persistentVolumeClaim", "claimName"))
+		for _, p := range list(str(v, "projected", "sources")) {
+			add("ConfigMap", str(p, "configMap", "name"))
+			add("Secret", str(p, "secret", "name"))
+		}
+	}
+	for _, field := range []string{"initContainers", "containers"} {
+		for _, c := range list(spec[field]) {
+			for _, e := range list(str(c, "env")) {
+				add("ConfigMap", str(e, "valueFrom", "configMapKeyRef", "name"))
+				add("Secret", str(e, "valueFrom", "secretKeyRef", "name"))
+			}
+			for _, e := range list(str(c, "envFrom")) {
+				add("ConfigMap", str(e, "configMapRef", "name"))
+				add("Secret", str(e, "secretRef", "name"))
+			}
+		}
+	}
+	return refs
+}
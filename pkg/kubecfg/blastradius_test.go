@@ -0,0 +1,74 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBlastRadius(t *testing.T) {
+	configMap := func(name, uid string) *unstructured.Unstructured {
+		return mustUnstructured(t, `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "`+name+`", "namespace": "myns", "uid": "`+uid+`"}}`)
+	}
+	deployment := mustUnstructured(t, `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {"name": "web", "namespace": "myns", "uid": "d1"},
+  "spec": {"template": {"spec": {
+    "containers": [{"name": "web", "envFrom": [{"configMapRef": {"name": "referenced"}}]}],
+    "volumes": [{"name": "v", "configMap": {"name": "referenced"}}]
+  }}}
+}`)
+	// Owned by the deployment, so not reported separately
+	replicaSet := mustUnstructured(t, `{
+  "apiVersion": "apps/v1",
+  "kind": "ReplicaSet",
+  "metadata": {"name": "web-1234", "namespace": "myns", "uid": "r1",
+    "ownerReferences": [{"apiVersion": "apps/v1", "kind": "Deployment", "name": "web", "uid": "d1", "controller": true}]},
+  "spec": {"template": {"spec": {
+    "containers": [{"name": "web", "envFrom": [{"configMapRef": {"name": "referenced"}}]}]
+  }}}
+}`)
+	// Refers to a same-named ConfigMap in another namespace
+	other := mustUnstructured(t, `{
+  "apiVersion": "v1",
+  "kind": "Pod",
+  "metadata": {"name": "other", "namespace": "otherns", "uid": "p1"},
+  "spec": {"containers": [{"name": "c", "env": [{"name": "X", "valueFrom": {"configMapKeyRef": {"name": "unreferenced", "key": "x"}}}]}]}
+}`)
+
+	referenced := configMap("referenced", "c1")
+	unreferenced := configMap("unreferenced", "c2")
+	live := []*unstructured.Unstructured{referenced, unreferenced, deployment, replicaSet, other}
+
+	warnings := blastRadius([]*unstructured.Unstructured{referenced, unreferenced}, live)
+	expected := []string{"Deleting ConfigMap referenced would break Deployment web"}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected %v, got %v", expected, warnings)
+	}
+
+	if warnings := blastRadius([]*unstructured.Unstructured{unreferenced}, live); len(warnings) != 0 {
+		t.Errorf("Unexpected warnings for unreferenced ConfigMap: %v", warnings)
+	}
+
+	// Pruning the referencing object too is fine
+	if warnings := blastRadius([]*unstructured.Unstructured{referenced, deployment}, live); len(warnings) != 0 {
+		t.Errorf("Unexpected warnings when pruning referrer: %v", warnings)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// CapacityCmd represents the capacity-check subcommand
+type CapacityCmd struct {
+	ClientPool dynamic.ClientPool
+}
+
+// resourceList is a set of named resource quantities, eg: "cpu" and
+// "memory"
+type resourceList map[string]resource.Quantity
+
+func (l resourceList) add(other resourceList, times int64) {
+	for name, q := range other {
+		total := l[name]
+		for i := int64(0); i < times; i++ {
+			total.Add(q)
+		}
+		l[name] = total
+	}
+}
+
+// max raises each quantity in l to at least the value in other
+func (l resourceList) max(other resourceList) {
+	for name, q := range other {
+		if cur, ok := l[name]; !ok || q.Cmp(cur) > 0 {
+			l[name] = q
+		}
+	}
+}
+
+func parseResourceList(v interface{}) resourceList {
+	ret := resourceList{}
+	m, _ := v.(map[string]interface{})
+	for name, value := range m {
+		if q, ok := parseQuantity(value); ok {
+			ret[name] = q
+		}
+	}
+	return ret
+}
+
+// podRequests returns the resources requested by a single pod with
+// the given spec.  Init containers run one at a time before the
+// regular containers, so only the largest request counts.
+func podRequests(spec map[string]interface{}) resourceList {
+	sum := resourceList{}
+	containers, _ := spec["containers"].([]interface{})
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if req, ok := fieldAt(container, []string{"resources", "requests"}); ok {
+			sum.add(parseResourceList(req), 1)
+		}
+	}
+
+	initContainers, _ := spec["initContainers"].([]interface{})
+	for _, c := range initContainers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if req, ok := fieldAt(container, []string{"resources", "requests"}); ok {
+			sum.max(parseResourceList(req))
+		}
+	}
+	return sum
+}
+
+func intField(obj map[string]interface{}, path []string) (int64, bool) {
+	v, ok := fieldAt(obj, path)
+	if !ok {
+		return 0, false
+	}
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// podCount returns the number of pods o will run, given the number
+// of schedulable nodes in the cluster.
+func podCount(o *unstructured.Unstructured, nodes int64) int64 {
+	path := []string{"spec", "replicas"}
+	switch o.GetKind() {
+	case "Pod":
+		return 1
+	case "DaemonSet":
+		return nodes
+	case "Job":
+		path = []string{"spec", "parallelism"}
+	case "CronJob":
+		path = []string{"spec", "jobTemplate", "spec", "parallelism"}
+	}
+	if n, ok := intField(o.Object, path); ok {
+		return n
+	}
+	return 1
+}
+
+// requestedResources returns the total resources requested by the
+// pods that objs would run
+func requestedResources(objs []*unstructured.Unstructured, nodes int64) resourceList {
+	ret := resourceList{}
+	for _, o := range objs {
+		spec := podSpecOf(o)
+		if spec == nil {
+			continue
+		}
+		ret.add(podRequests(spec), podCount(o, nodes))
+	}
+	return ret
+}
+
+func (c CapacityCmd) list(gvk schema.GroupVersionKind, rsrc metav1.APIResource, callback func(*unstructured.Unstructured)) error {
+	client, err := c.ClientPool.ClientForGroupVersionKind(gvk)
+	if err != nil {
+		return err
+	}
+	ns := metav1.NamespaceNone
+	if rsrc.Namespaced {
+		ns = metav1.NamespaceAll
+	}
+	list, err := client.Resource(&rsrc, ns).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Error listing %s: %v", rsrc.Name, err)
+	}
+	return meta.EachListItem(list, func(o runtime.Object) error {
+		callback(o.(*unstructured.Unstructured))
+		return nil
+	})
+}
+
+// clusterCapacity returns the total allocatable resources of the
+// schedulable nodes, the resources requested by pods already running
+// on them, and the number of schedulable nodes.
+func (c CapacityCmd) clusterCapacity() (allocatable, used resourceList, nodes int64, err error) {
+	allocatable = resourceList{}
+	used = resourceList{}
+
+	err = c.list(schema.GroupVersionKind{Version: "v1", Kind: "Node"}, metav1.APIResource{Name: "nodes", Kind: "Node"}, func(node *unstructured.Unstructured) {
+		if unschedulable, _ := fieldAt(node.Object, []string{"spec", "unschedulable"}); unschedulable == true {
+			return
+		}
+		alloc, _ := fieldAt(node.Object, []string{"status", "allocatable"})
+		allocatable.add(parseResourceList(alloc), 1)
+		nodes++
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	err = c.list(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, metav1.APIResource{Name: "pods", Kind: "Pod", Namespaced: true}, func(pod *unstructured.Unstructured) {
+		phase, _ := fieldAt(pod.Object, []string{"status", "phase"})
+		if phase == "Succeeded" || phase == "Failed" {
+			return
+		}
+		if spec := podSpecOf(pod); spec != nil {
+			used.add(podRequests(spec), 1)
+		}
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return allocatable, used, nodes, nil
+}
+
+// capacityWarnings returns a warning for each resource where
+// requested exceeds allocatable minus used
+func capacityWarnings(requested, allocatable, used resourceList) []string {
+	var warnings []string
+	for _, name := range resourceNames(requested) {
+		// Resources not advertised by any node are treated
+		// as zero, and can never be scheduled
+		avail := allocatable[name]
+		avail.Sub(used[name])
+		if req := requested[name]; req.Cmp(avail) > 0 {
+			warnings = append(warnings, fmt.Sprintf("Requested %s %s exceeds available %s", name, req.String(), avail.String()))
+		}
+	}
+	return warnings
+}
+
+func resourceNames(l resourceList) []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run compares the resources requested by apiObjects against the
+// cluster's free capacity.  The check is advisory: it ignores
+// existing versions of the objects that will be replaced, node
+// selectors, taints and fragmentation across nodes, so a warning
+// does not cause an error.
+func (c CapacityCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	allocatable, used, nodes, err := c.clusterCapacity()
+	if err != nil {
+		return err
+	}
+	requested := requestedResources(apiObjects, nodes)
+
+	fmt.Fprintf(out, "%d schedulable nodes\n", nodes)
+	for _, name := range resourceNames(requested) {
+		alloc, use, req := allocatable[name], used[name], requested[name]
+		fmt.Fprintf(out, "%s: requested %s, allocatable %s, in use %s\n", name, req.String(), alloc.String(), use.String())
+	}
+
+	warnings := capacityWarnings(requested, allocatable, used)
+	for _, w := range warnings {
+		log.Warning(w)
+	}
+	if len(warnings) == 0 {
+		fmt.Fprintln(out, "Requested resources fit in the cluster's free capacity")
+	} else {
+		fmt.Fprintf(out, "Requested resources do not fit in the cluster's free capacity (%d warnings)\n", len(warnings))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func capacityTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"NodeList","items":[
+  {"apiVersion":"v1","kind":"Node","metadata":{"name":"a"},"status":{"allocatable":{"cpu":"2","memory":"4Gi"}}},
+  {"apiVersion":"v1","kind":"Node","metadata":{"name":"b"},"status":{"allocatable":{"cpu":"2","memory":"4Gi"}}},
+  {"apiVersion":"v1","kind":"Node","metadata":{"name":"cordoned"},"spec":{"unschedulable":true},"status":{"allocatable":{"cpu":"64","memory":"256Gi"}}}
+]}`)
+		case "/api/v1/pods":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"PodList","items":[
+  {"apiVersion":"v1","kind":"Pod","metadata":{"name":"running","namespace":"default"},"spec":{"containers":[{"name":"c","resources":{"requests":{"cpu":"1","memory":"1Gi"}}}]},"status":{"phase":"Running"}},
+  {"apiVersion":"v1","kind":"Pod","metadata":{"name":"done","namespace":"default"},"spec":{"containers":[{"name":"c","resources":{"requests":{"cpu":"3"}}}]},"status":{"phase":"Succeeded"}}
+]}`)
+		default:
+			t.Errorf("Unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func capacityTestDeployment(t *testing.T, replicas int, cpu string) *unstructured.Unstructured {
+	return mustUnstructured(t, fmt.Sprintf(`{
+  "apiVersion": "apps/v1", "kind": "Deployment",
+  "metadata": {"name": "web", "namespace": "default"},
+  "spec": {
+    "replicas": %d,
+    "template": {"spec": {
+      "initContainers": [{"name": "init", "resources": {"requests": {"cpu": "2"}}}],
+      "containers": [
+        {"name": "app", "resources": {"requests": {"cpu": %q, "memory": "256Mi"}}},
+        {"name": "sidecar", "resources": {"requests": {"cpu": "100m"}}}
+      ]
+    }}
+  }
+}`, replicas, cpu))
+}
+
+func TestPodRequests(t *testing.T) {
+	d := capacityTestDeployment(t, 1, "500m")
+	req := podRequests(podSpecOf(d))
+	// The init container's request is larger than the sum of the
+	// regular containers
+	if cpu := req["cpu"]; cpu.String() != "2" {
+		t.Errorf("Unexpected cpu request %s", cpu.String())
+	}
+	if mem := req["memory"]; mem.String() != "256Mi" {
+		t.Errorf("Unexpected memory request %s", mem.String())
+	}
+
+	ds := mustUnstructured(t, `{"apiVersion": "apps/v1", "kind": "DaemonSet", "metadata": {"name": "agent"},
+  "spec": {"template": {"spec": {"containers": [{"name": "agent", "resources": {"requests": {"cpu": "100m"}}}]}}}}`)
+	total := requestedResources([]*unstructured.Unstructured{d, ds}, 3)
+	if cpu := total["cpu"]; cpu.String() != "2300m" {
+		t.Errorf("Unexpected total cpu request %s", cpu.String())
+	}
+}
+
+func TestCapacityCheck(t *testing.T) {
+	srv := capacityTestServer(t)
+	defer srv.Close()
+
+	c := CapacityCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+	}
+
+	allocatable, used, nodes, err := c.clusterCapacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes != 2 {
+		t.Errorf("Expected 2 schedulable nodes, got %d", nodes)
+	}
+
+	// 3 cpu free: 4 allocatable, 1 in use
+	fits := requestedResources([]*unstructured.Unstructured{capacityTestDeployment(t, 1, "1")}, nodes)
+	if w := capacityWarnings(fits, allocatable, used); len(w) != 0 {
+		t.Errorf("Unexpected warnings for a fitting config: %v", w)
+	}
+
+	tooBig := requestedResources([]*unstructured.Unstructured{capacityTestDeployment(t, 3, "1")}, nodes)
+	w := capacityWarnings(tooBig, allocatable, used)
+	if len(w) != 1 || w[0] != "Requested cpu 6 exceeds available 3" {
+		t.Errorf("Unexpected warnings for an oversized config: %v", w)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Run([]*unstructured.Unstructured{capacityTestDeployment(t, 3, "1")}, &buf); err != nil {
+		t.Fatalf("Capacity check returned an error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "do not fit") {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// fieldConflict is a field that both config and another client have
+// changed since config was last applied
+type fieldConflict struct {
+	path   []string
+	live   interface{}
+	config interface{}
+}
+
+func (c fieldConflict) String() string {
+	live, _ := json.Marshal(c.live)
+	config, _ := json.Marshal(c.config)
+	return fmt.Sprintf("%s: live %s, config %s", utils.JSONPath(c.path), live, config)
+}
+
+// threeWayConflicts compares config and live against live's
+// AnnotationLastApplied, and returns the fields that both have
+// changed (to different values).  Fields missing from the
+// last-applied config are assumed to have been defaulted by the
+// server, rather than set by another client.  Returns nil if live
+// has no (valid) annotation.
+func threeWayConflicts(live, config *unstructured.Unstructured) []fieldConflict {
+	data, ok := live.GetAnnotations()[AnnotationLastApplied]
+	if !ok {
+		return nil
+	}
+	var lastApplied map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &lastApplied); err != nil {
+		log.Debugf("Ignoring unparseable %s annotation: %v", AnnotationLastApplied, err)
+		return nil
+	}
+
+	paths := leafPaths(nil, config.Object, nil)
+	sort.Sort(pathList(paths))
+
+	var ret []fieldConflict
+	for _, p := range paths {
+		base, found := fieldAt(lastApplied, p)
+		if !found {
+			continue
+		}
+		want, _ := fieldAt(config.Object, p)
+		got, _ := fieldAt(live.Object, p)
+		if !jsonEqual(want, base) && !jsonEqual(got, base) && !jsonEqual(want, got) {
+			ret = append(ret, fieldConflict{path: p, live: got, config: want})
+		}
+	}
+	return ret
+}
+
+// checkConflicts fetches the live version of each existing object,
+// and warns about fields changed by another client since the
+// object was last applied, that config would now overwrite.  Unless
+// force is true, any conflict is an error.
+func checkConflicts(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, objs []*unstructured.Unstructured, defNs string, force bool) error {
+	conflicts := 0
+	for _, obj := range objs {
+		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(disco, obj), utils.FqName(obj))
+
+		rc, err := utils.ClientForResource(pool, disco, obj, defNs)
+		if err != nil {
+			// Probably a kind that doesn't exist yet
+			log.Debugf("Skipping conflict check for %s: %v", desc, err)
+			continue
+		}
+
+		live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error fetching %s: %v", desc, err)
+		}
+
+		for _, c := range threeWayConflicts(live, obj) {
+			conflicts++
+			log.Warnf("%s was changed by another client since it was last applied: %s", desc, c)
+		}
+	}
+
+	if conflicts > 0 && !force {
+		return fmt.Errorf("Found %d field(s) changed by other clients, use --force-conflicts to overwrite them", conflicts)
+	}
+	return nil
+}
+
+// setLastApplied records obj itself as its AnnotationLastApplied,
+// as `kubectl apply` does
+func setLastApplied(obj *unstructured.Unstructured) error {
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[AnnotationLastApplied]; ok {
+		delete(annotations, AnnotationLastApplied)
+		obj.SetAnnotations(annotations)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	utils.SetMetaDataAnnotation(obj, AnnotationLastApplied, string(data))
+	return nil
+}
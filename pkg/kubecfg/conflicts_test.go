@@ -0,0 +1,159 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// A Deployment last applied with 2 replicas and image v1, since
+// scaled to 5 by an autoscaler and relabelled by hand
+const liveConflicting = `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {
+    "name": "myapp",
+    "namespace": "default",
+    "labels": {"app": "myapp", "tier": "frontend"},
+    "annotations": {
+      "kubectl.kubernetes.io/last-applied-configuration": "{\"apiVersion\":\"apps/v1\",\"kind\":\"Deployment\",\"metadata\":{\"labels\":{\"app\":\"myapp\",\"tier\":\"web\"},\"name\":\"myapp\",\"namespace\":\"default\"},\"spec\":{\"replicas\":2,\"template\":{\"spec\":{\"image\":\"myapp:v1\"}}}}"
+    }
+  },
+  "spec": {"replicas": 5, "paused": false, "template": {"spec": {"image": "myapp:v1"}}}
+}`
+
+func conflictingConfig(t *testing.T) *unstructured.Unstructured {
+	return mustUnstructured(t, `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {"name": "myapp", "namespace": "default", "labels": {"app": "myapp", "tier": "backend"}},
+  "spec": {"replicas": 3, "paused": true, "template": {"spec": {"image": "myapp:v2"}}}
+}`)
+}
+
+func TestThreeWayConflicts(t *testing.T) {
+	live := mustUnstructured(t, liveConflicting)
+
+	var conflicts []string
+	for _, c := range threeWayConflicts(live, conflictingConfig(t)) {
+		conflicts = append(conflicts, c.String())
+	}
+	// spec.paused is not in last-applied, and image was only
+	// changed by config
+	expected := []string{
+		`.metadata.labels.tier: live "frontend", config "backend"`,
+		`.spec.replicas: live 5, config 3`,
+	}
+	if strings.Join(conflicts, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected conflicts:\n%s", strings.Join(conflicts, "\n"))
+	}
+
+	// Both changed, but to the same value
+	config := conflictingConfig(t)
+	config.Object["spec"].(map[string]interface{})["replicas"] = 5
+	config.SetLabels(map[string]string{"app": "myapp", "tier": "frontend"})
+	if c := threeWayConflicts(live, config); len(c) != 0 {
+		t.Errorf("Expected no conflicts, got %v", c)
+	}
+
+	live.SetAnnotations(nil)
+	if c := threeWayConflicts(live, conflictingConfig(t)); c != nil {
+		t.Errorf("Expected no conflicts without last-applied, got %v", c)
+	}
+}
+
+func TestUpdateCheckConflicts(t *testing.T) {
+	var patched []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/apis/apps/v1/namespaces/default/deployments/myapp" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "PATCH" {
+			var obj map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+				t.Error(err)
+			}
+			patched = append(patched, obj)
+		}
+		fmt.Fprint(w, liveConflicting)
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "apps/v1",
+					APIResources: []metav1.APIResource{
+						{Name: "deployments", Kind: "Deployment", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		CheckConflicts:   true,
+		GcTag:            "mytag",
+		SkipGc:           true,
+	}
+
+	err := c.Run([]*unstructured.Unstructured{conflictingConfig(t)})
+	if err == nil || !strings.Contains(err.Error(), "Found 2 field(s) changed by other clients") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(patched) != 0 {
+		t.Errorf("Object was patched despite conflicts: %v", patched)
+	}
+
+	c.ForceConflicts = true
+	if err := c.Run([]*unstructured.Unstructured{conflictingConfig(t)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(patched) != 1 {
+		t.Fatalf("Expected 1 patch, got %v", patched)
+	}
+	annotations := patched[0]["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	var lastApplied map[string]interface{}
+	if err := json.Unmarshal([]byte(annotations[AnnotationLastApplied].(string)), &lastApplied); err != nil {
+		t.Fatal(err)
+	}
+	if !jsonEqual(lastApplied, conflictingConfig(t).Object) {
+		t.Errorf("Unexpected last-applied config %v", lastApplied)
+	}
+	if annotations[AnnotationGcTag] != "mytag" {
+		t.Errorf("Missing gc tag: %v", annotations)
+	}
+
+	c.ServerSide = true
+	if err := c.Run([]*unstructured.Unstructured{conflictingConfig(t)}); err == nil {
+		t.Errorf("Expected error combining server-side apply and conflict checks")
+	}
+}
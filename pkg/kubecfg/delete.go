@@ -17,12 +17,15 @@ package kubecfg
 
 import (
 	"fmt"
-	"sort"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 
@@ -36,6 +39,51 @@ type DeleteCmd struct {
 	DefaultNamespace string
 
 	GracePeriod int64
+
+	// Cascade deletes dependents (eg: the Pods of a ReplicaSet),
+	// rather than orphaning them.
+	Cascade bool
+
+	// PropagationPolicy, if set, overrides the propagation policy
+	// chosen by Cascade.
+	PropagationPolicy metav1.DeletionPropagation
+
+	// IgnoreNotFound treats already-deleted objects as success
+	IgnoreNotFound bool
+
+	// EmitEvents records a Kubernetes Event for each object deleted
+	EmitEvents bool
+
+	// Wait blocks until deleted objects (and, with Cascade,
+	// their dependents) are gone, or WaitTimeout has passed.
+	Wait        bool
+	WaitTimeout time.Duration
+
+	// RemoveFinalizers removes the finalizers from objects still
+	// terminating after FinalizerTimeout, forcing their deletion.
+	// This may orphan external resources the finalizers protect.
+	RemoveFinalizers bool
+	FinalizerTimeout time.Duration
+
+	// Confirm, if set, is called with the descriptions of the
+	// objects to delete (in deletion order) before any are
+	// deleted.  Nothing is deleted unless it returns true.
+	Confirm func(descs []string) (bool, error)
+
+	// ContinueOnError deletes the remaining objects after a
+	// failure, rather than stopping.  The failures are reported
+	// together at the end.
+	ContinueOnError bool
+}
+
+// How often to poll for deleted objects with --wait
+var deletePollInterval = 2 * time.Second
+
+// deletedObject is an object waiting to disappear from the server
+type deletedObject struct {
+	client *dynamic.ResourceClient
+	desc   string
+	name   string
 }
 
 func (c DeleteCmd) Run(apiObjects []*unstructured.Unstructured) error {
@@ -45,42 +93,161 @@ func (c DeleteCmd) Run(apiObjects []*unstructured.Unstructured) error {
 	}
 
 	log.Infof("Fetching schemas for %d resources", len(apiObjects))
-	depOrder, err := utils.DependencyOrder(c.Discovery, apiObjects)
-	if err != nil {
+	if err := utils.SortForDelete(c.Discovery, apiObjects); err != nil {
 		return err
 	}
-	sort.Sort(sort.Reverse(depOrder))
 
-	deleteOpts := metav1.DeleteOptions{}
-	if version.Compare(1, 6) < 0 {
-		// 1.5.x option
-		boolFalse := false
-		deleteOpts.OrphanDependents = &boolFalse
-	} else {
-		// 1.6.x option (NB: Background is broken)
-		fg := metav1.DeletePropagationForeground
-		deleteOpts.PropagationPolicy = &fg
+	deleteOpts := deleteOptions(version, c.Cascade, c.GracePeriod)
+	if c.GracePeriod == 0 {
+		log.Warning("A grace period of 0 deletes immediately, without waiting for confirmation that resources have terminated")
 	}
-	if c.GracePeriod >= 0 {
-		deleteOpts.GracePeriodSeconds = &c.GracePeriod
+
+	descs := make([]string, len(apiObjects))
+	for i, obj := range apiObjects {
+		descs[i] = fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
+	}
+	if c.Confirm != nil {
+		ok, err := c.Confirm(descs)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("Delete cancelled")
+		}
 	}
 
-	for _, obj := range apiObjects {
-		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
+	events := newEventRecorder(c.ClientPool, c.Discovery, c.EmitEvents)
+	defer events.Flush()
+
+	deleted, notFound := []deletedObject{}, 0
+	var failed []string
+	for i, obj := range apiObjects {
+		desc := descs[i]
 		log.Info("Deleting ", desc)
 
 		client, err := utils.ClientForResource(c.ClientPool, c.Discovery, obj, c.DefaultNamespace)
+		if err == nil {
+			err = utils.DeleteWithPropagation(client, obj.GetName(), deleteOpts, c.PropagationPolicy)
+			if errors.IsNotFound(err) && c.IgnoreNotFound {
+				log.Debugf("%s doesn't exist on the server", desc)
+				notFound++
+				continue
+			}
+		}
 		if err != nil {
+			err = fmt.Errorf("Error deleting %s: %s", desc, err)
+			if !c.ContinueOnError {
+				return err
+			}
+			log.Error(err)
+			failed = append(failed, desc)
+			continue
+		}
+		deleted = append(deleted, deletedObject{client: client, desc: desc, name: obj.GetName()})
+		events.Record(obj, obj.GroupVersionKind(), EventReasonDeleted, "Deleted by kubecfg")
+
+		log.Debug("Deleted object: ", obj)
+	}
+
+	log.Infof("Deleted %d objects, %d already absent, %d failed", len(deleted), notFound, len(failed))
+
+	if c.RemoveFinalizers {
+		if err := removeStuckFinalizers(deleted, c.FinalizerTimeout); err != nil {
 			return err
 		}
+	}
 
-		err = client.Delete(obj.GetName(), &deleteOpts)
-		if err != nil && !errors.IsNotFound(err) {
-			return fmt.Errorf("Error deleting %s: %s", desc, err)
+	if c.Wait {
+		if err := waitForDeletion(deleted, c.WaitTimeout); err != nil {
+			return err
 		}
+	}
 
-		log.Debugf("Deleted object: ", obj)
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to delete %d objects: %s", len(failed), strings.Join(failed, ", "))
 	}
+	return nil
+}
 
+// waitForDeletion polls until each object is gone from the server.
+// Foreground deletion keeps an object visible until its dependents
+// are deleted, so this also waits for dependents.
+func waitForDeletion(objs []deletedObject, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, o := range objs {
+		log.Info("Waiting for deletion of ", o.desc)
+		err := wait.PollImmediate(deletePollInterval, deadline.Sub(time.Now()), func() (bool, error) {
+			_, err := o.client.Get(o.name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err == wait.ErrWaitTimeout {
+			return fmt.Errorf("Timed out waiting for deletion of %s", o.desc)
+		} else if err != nil {
+			return fmt.Errorf("Error waiting for deletion of %s: %v", o.desc, err)
+		}
+	}
 	return nil
 }
+
+// removeStuckFinalizers waits up to timeout for objs to be deleted,
+// and then clears the finalizers of any that are still terminating.
+func removeStuckFinalizers(objs []deletedObject, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, o := range objs {
+		var live *unstructured.Unstructured
+		err := wait.PollImmediate(deletePollInterval, deadline.Sub(time.Now()), func() (bool, error) {
+			var err error
+			live, err = o.client.Get(o.name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				live = nil
+				return true, nil
+			}
+			return false, err
+		})
+		if err == nil {
+			continue
+		} else if err != wait.ErrWaitTimeout {
+			return fmt.Errorf("Error waiting for deletion of %s: %v", o.desc, err)
+		}
+
+		if live == nil || live.GetDeletionTimestamp() == nil || len(live.GetFinalizers()) == 0 {
+			// Not stuck on finalizers
+			continue
+		}
+
+		log.Warningf("%s is stuck terminating after %s", o.desc, timeout)
+		log.Warningf("REMOVING FINALIZERS %v from %s. Any external resources they protect may be orphaned!", live.GetFinalizers(), o.desc)
+		_, err = o.client.Patch(o.name, types.MergePatchType, []byte(`{"metadata":{"finalizers":null}}`))
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Error removing finalizers from %s: %v", o.desc, err)
+		}
+	}
+	return nil
+}
+
+// deleteOptions returns DeleteOptions suitable for the server
+// version.  A negative gracePeriod uses the server default.
+func deleteOptions(version utils.ServerVersion, cascade bool, gracePeriod int64) metav1.DeleteOptions {
+	deleteOpts := metav1.DeleteOptions{}
+	if version.Compare(1, 6) < 0 {
+		// 1.5.x option
+		orphan := !cascade
+		deleteOpts.OrphanDependents = &orphan
+	} else if cascade {
+		// 1.6.x option (NB: Background is broken)
+		fg := metav1.DeletePropagationForeground
+		deleteOpts.PropagationPolicy = &fg
+	} else {
+		orphan := metav1.DeletePropagationOrphan
+		deleteOpts.PropagationPolicy = &orphan
+	}
+	if gracePeriod >= 0 {
+		deleteOpts.GracePeriodSeconds = &gracePeriod
+	}
+	return deleteOpts
+}
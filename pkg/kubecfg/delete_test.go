@@ -0,0 +1,371 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+func TestDeleteOrder(t *testing.T) {
+	var deleted []string
+	var policies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "DELETE" {
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+		var opts metav1.DeleteOptions
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			t.Error(err)
+		}
+		if opts.PropagationPolicy != nil {
+			policies = append(policies, string(*opts.PropagationPolicy))
+		}
+		if r.URL.Path == "/api/v1/namespaces/myns/configmaps/gone" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+			return
+		}
+		deleted = append(deleted, r.URL.Path)
+		fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+	}))
+	defer srv.Close()
+
+	c := DeleteCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "namespaces", Kind: "Namespace", Namespaced: false},
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		GracePeriod:      -1,
+		Cascade:          false,
+		IgnoreNotFound:   true,
+	}
+
+	newObj := func(kind, ns, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind(kind)
+		obj.SetNamespace(ns)
+		obj.SetName(name)
+		return obj
+	}
+	objs := []*unstructured.Unstructured{
+		newObj("Namespace", "", "myns"),
+		newObj("ConfigMap", "myns", "config"),
+		newObj("ConfigMap", "myns", "gone"),
+	}
+
+	if err := c.Run(objs); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"/api/v1/namespaces/myns/configmaps/config",
+		"/api/v1/namespaces/myns",
+	}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes %v, got %v", expected, deleted)
+	}
+	for _, p := range policies {
+		if p != string(metav1.DeletePropagationOrphan) {
+			t.Errorf("Expected Orphan propagation, got %s", p)
+		}
+	}
+
+	policies = nil
+	c.PropagationPolicy = metav1.DeletePropagationBackground
+	if err := c.Run(objs); err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != len(objs) {
+		t.Errorf("Expected %d propagation policies, got %v", len(objs), policies)
+	}
+	for _, p := range policies {
+		if p != string(metav1.DeletePropagationBackground) {
+			t.Errorf("Expected Background propagation, got %s", p)
+		}
+	}
+
+	c.IgnoreNotFound = false
+	if err := c.Run(objs); err == nil {
+		t.Errorf("Missing object was ignored")
+	}
+}
+
+func TestDeleteConfirmAndContinue(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/v1/namespaces/myns/configmaps/broken" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`)
+			return
+		}
+		deleted = append(deleted, r.URL.Path)
+		fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+	}))
+	defer srv.Close()
+
+	var confirmed []string
+	answer := false
+	c := DeleteCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		GracePeriod:      -1,
+		Confirm: func(descs []string) (bool, error) {
+			confirmed = descs
+			return answer, nil
+		},
+	}
+
+	newObj := func(name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace("myns")
+		obj.SetName(name)
+		return obj
+	}
+	objs := []*unstructured.Unstructured{newObj("a"), newObj("broken"), newObj("c")}
+
+	if err := c.Run(objs); err == nil {
+		t.Errorf("Declined delete returned success")
+	}
+	if len(deleted) != 0 {
+		t.Errorf("Declined delete deleted %v", deleted)
+	}
+	if len(confirmed) != len(objs) {
+		t.Errorf("Expected %d objects to confirm, got %v", len(objs), confirmed)
+	}
+
+	answer = true
+	if err := c.Run(objs); err == nil {
+		t.Errorf("Failed delete returned success")
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Expected delete to stop at the first failure, got %v", deleted)
+	}
+
+	deleted = nil
+	c.ContinueOnError = true
+	err := c.Run(objs)
+	if err == nil || !strings.Contains(err.Error(), "configmaps myns.broken") {
+		t.Errorf("Expected failure of myns.broken, got %v", err)
+	}
+	// SortForDelete reverses the (alphabetical) apply order
+	expected := []string{
+		"/api/v1/namespaces/myns/configmaps/c",
+		"/api/v1/namespaces/myns/configmaps/a",
+	}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletes %v, got %v", expected, deleted)
+	}
+}
+
+func TestDeleteWait(t *testing.T) {
+	var gracePeriod *int64
+	gets := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/namespaces/default/pods/mypod" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+		}
+		switch r.Method {
+		case "DELETE":
+			var opts metav1.DeleteOptions
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				t.Error(err)
+			}
+			gracePeriod = opts.GracePeriodSeconds
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		case "GET":
+			// Still terminating for the first 2 polls
+			gets++
+			if gets <= 2 {
+				fmt.Fprint(w, `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"mypod","namespace":"default"}}`)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(d time.Duration) { deletePollInterval = d }(deletePollInterval)
+	deletePollInterval = time.Millisecond
+
+	c := DeleteCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "pods", Kind: "Pod", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		GracePeriod:      0,
+		Cascade:          true,
+		Wait:             true,
+		WaitTimeout:      time.Minute,
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Pod")
+	obj.SetName("mypod")
+
+	if err := c.Run([]*unstructured.Unstructured{obj}); err != nil {
+		t.Fatal(err)
+	}
+	if gracePeriod == nil || *gracePeriod != 0 {
+		t.Errorf("Grace period was not set on delete: %v", gracePeriod)
+	}
+	if gets != 3 {
+		t.Errorf("Expected to poll until object was gone, got %d polls", gets)
+	}
+
+	gets = -100
+	c.WaitTimeout = 10 * time.Millisecond
+	if err := c.Run([]*unstructured.Unstructured{obj}); err == nil {
+		t.Errorf("Wait did not time out")
+	}
+}
+
+func TestDeleteRemoveFinalizers(t *testing.T) {
+	finalized := false
+	patched := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "DELETE":
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		case r.Method == "PATCH":
+			body, _ := ioutil.ReadAll(r.Body)
+			patched[r.URL.Path] = string(body)
+			finalized = true
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"stuck","namespace":"default"}}`)
+		case r.URL.Path == "/api/v1/namespaces/default/configmaps/stuck" && !finalized:
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"stuck","namespace":"default","deletionTimestamp":"2017-01-01T00:00:00Z","finalizers":["example.com/dead-controller"]}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(d time.Duration) { deletePollInterval = d }(deletePollInterval)
+	deletePollInterval = time.Millisecond
+
+	c := DeleteCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		GracePeriod:      -1,
+		Cascade:          true,
+		Wait:             true,
+		WaitTimeout:      time.Minute,
+		RemoveFinalizers: true,
+		FinalizerTimeout: 20 * time.Millisecond,
+	}
+
+	objs := []*unstructured.Unstructured{}
+	for _, name := range []string{"normal", "stuck"} {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetName(name)
+		objs = append(objs, obj)
+	}
+
+	if err := c.Run(objs); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"/api/v1/namespaces/default/configmaps/stuck": `{"metadata":{"finalizers":null}}`,
+	}
+	if !reflect.DeepEqual(patched, expected) {
+		t.Errorf("Expected only the stuck object to be patched, got %v", patched)
+	}
+}
+
+func TestDeleteOptions(t *testing.T) {
+	v15 := utils.ServerVersion{Major: 1, Minor: 5}
+	v17 := utils.ServerVersion{Major: 1, Minor: 7}
+
+	opts := deleteOptions(v15, true, -1)
+	if opts.OrphanDependents == nil || *opts.OrphanDependents || opts.GracePeriodSeconds != nil {
+		t.Errorf("Unexpected 1.5 cascading options %v", opts)
+	}
+
+	opts = deleteOptions(v17, true, 30)
+	if *opts.PropagationPolicy != metav1.DeletePropagationForeground || *opts.GracePeriodSeconds != 30 {
+		t.Errorf("Unexpected 1.7 cascading options %v", opts)
+	}
+
+	opts = deleteOptions(v17, false, -1)
+	if *opts.PropagationPolicy != metav1.DeletePropagationOrphan {
+		t.Errorf("Unexpected 1.7 orphaning options %v", opts)
+	}
+}
@@ -20,14 +20,19 @@ import (
 	"io"
 	"os"
 	"sort"
+	"strings"
 
 	isatty "github.com/mattn/go-isatty"
 	log "github.com/sirupsen/logrus"
 	"github.com/yudai/gojsondiff"
 	"github.com/yudai/gojsondiff/formatter"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 
@@ -43,15 +48,263 @@ type DiffCmd struct {
 	DefaultNamespace string
 
 	DiffStrategy string
+
+	// DiffAgainst is DiffAgainstLive (or "") or
+	// DiffAgainstLastApplied
+	DiffAgainst string
+
+	// GcTag, if set, also reports live objects that would be
+	// garbage collected by `update --gc-tag`.
+	GcTag string
+
+	// GcFilters are as for UpdateCmd
+	GcFilters []GcFilter
+
+	// OnlyAdded and OnlyRemoved restrict output to objects that
+	// would be created or garbage collected, respectively.
+	OnlyAdded   bool
+	OnlyRemoved bool
+
+	// Masks hide the values of matching fields in the rendered
+	// diff.  They never affect the objects themselves.
+	Masks []DiffMask
+
+	// OutChanges selects structured output of ChangeRecords
+	// instead of a human-readable diff.  Supported values are
+	// "" (disabled) and "json".
+	OutChanges string
+
+	// DiffFormat is DiffFormatUnified (or ""),
+	// DiffFormatSideBySide, or DiffFormatJSON or DiffFormatYAML
+	// for a machine-readable list of DiffResults.
+	DiffFormat string
+
+	// DryRunPool, if set, is a client pool whose writes are
+	// server-side dry-runs (see utils.NewDryRunTransport).  Each
+	// object is submitted through it, and the diff is against the
+	// server's response, so that defaulting, admission webhooks and
+	// CRD version conversion are reflected.
+	DryRunPool dynamic.ClientPool
+
+	// KeepStatus compares status, which is otherwise stripped
+	// (along with utils.ServerManagedMetadata) from both live and
+	// config objects.
+	KeepStatus bool
+
+	// Width is the terminal width, or 0 if output is not to a
+	// terminal.  Side-by-side diffs fall back to unified if Width
+	// is too narrow.
+	Width int
+
+	// ContextLines is the number of unchanged lines (or fields,
+	// side-by-side) shown around each change in unified and
+	// side-by-side diffs.  DiffContextAll (or any negative value)
+	// shows the whole object.
+	ContextLines int
+}
+
+// ChangeRecord describes the changes an update would make to a
+// single object, for consumption by policy engines.
+type ChangeRecord struct {
+	// Action is one of "create", "update" or "delete"
+	Action     string        `json:"action"`
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Name       string        `json:"name"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+// FieldChange is a single changed field.  Old or New are omitted if
+// the field is absent before or after the change.  Values of Secret
+// data and masked fields are replaced by placeholders.
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+const (
+	maskedValue        = "(masked)"
+	maskedChangedValue = "(masked, changed)"
+)
+
+// DiffMask hides the value of a field in diff output
+type DiffMask struct {
+	// Kind restricts the mask to objects of this kind
+	Kind string
+	Path []string
+}
+
+// ParseDiffMask parses a mask of the form `Kind:path.to.field`
+func ParseDiffMask(s string) (DiffMask, error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return DiffMask{}, fmt.Errorf("Bad diff mask %q, expected Kind:path.to.field", s)
+	}
+	return DiffMask{Kind: kv[0], Path: strings.Split(kv[1], ".")}, nil
+}
+
+// maskFields returns display copies of live and config with the
+// values of masked fields replaced by placeholders.  Placeholders
+// still differ if the underlying values do.
+func (c DiffCmd) maskFields(kind string, live, config map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	copied := false
+	for _, m := range c.Masks {
+		if m.Kind != kind {
+			continue
+		}
+		if !copied {
+			live = deepCopyJSON(live).(map[string]interface{})
+			config = deepCopyJSON(config).(map[string]interface{})
+			copied = true
+		}
+		lv, lok := fieldAt(live, m.Path)
+		cv, cok := fieldAt(config, m.Path)
+		if lok {
+			setFieldAt(live, m.Path, maskedValue)
+		}
+		if cok {
+			if lok && !jsonEqual(lv, cv) {
+				setFieldAt(config, m.Path, maskedChangedValue)
+			} else {
+				setFieldAt(config, m.Path, maskedValue)
+			}
+		}
+	}
+	return live, config
+}
+
+// maskForOutput is maskFields, and also masks all Secret data
+func (c DiffCmd) maskForOutput(gvk schema.GroupVersionKind, live, config map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	masked := c
+	if gvk.Group == "" && gvk.Kind == "Secret" {
+		masked.Masks = append(secretMasks(live, config), c.Masks...)
+	}
+	return masked.maskFields(gvk.Kind, live, config)
+}
+
+// changeRecord builds the (masked) ChangeRecord for obj
+func (c DiffCmd) changeRecord(action string, obj metav1.Object, gvk schema.GroupVersionKind, live, config map[string]interface{}) ChangeRecord {
+	live, config = c.maskForOutput(gvk, live, config)
+
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	rec := ChangeRecord{
+		Action:     action,
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Changes:    []FieldChange{},
+	}
+	changedFields(nil, live, config, &rec.Changes)
+	return rec
+}
+
+// secretMasks returns masks covering every data value of a Secret
+func secretMasks(objs ...map[string]interface{}) []DiffMask {
+	keys := sets.NewString()
+	var masks []DiffMask
+	for _, obj := range objs {
+		for _, field := range []string{"data", "stringData"} {
+			data, _ := obj[field].(map[string]interface{})
+			for k := range data {
+				if keys.Has(field + "." + k) {
+					continue
+				}
+				keys.Insert(field + "." + k)
+				masks = append(masks, DiffMask{Kind: "Secret", Path: []string{field, k}})
+			}
+		}
+	}
+	return masks
+}
+
+// changedFields appends a FieldChange for each leaf field that
+// differs between old and new.  Lists are compared as a whole.
+func changedFields(path []string, old, new interface{}, ret *[]FieldChange) {
+	walkChanges(path, old, new, func(path []string, old, new interface{}) {
+		*ret = append(*ret, FieldChange{
+			Path: strings.Join(path, "."),
+			Old:  old,
+			New:  new,
+		})
+	})
+}
+
+// walkChanges calls f, in path order, for each leaf field that
+// differs between old and new.  Lists are compared as a whole.
+func walkChanges(path []string, old, new interface{}, f func(path []string, old, new interface{})) {
+	oldMap, oldOk := old.(map[string]interface{})
+	newMap, newOk := new.(map[string]interface{})
+	// Report the individual fields of added/removed objects
+	if (oldOk || old == nil) && (newOk || new == nil) && (oldOk || newOk) {
+		keys := sets.NewString()
+		for k := range oldMap {
+			keys.Insert(k)
+		}
+		for k := range newMap {
+			keys.Insert(k)
+		}
+		for _, k := range keys.List() {
+			walkChanges(append(path[:len(path):len(path)], k), oldMap[k], newMap[k], f)
+		}
+		return
+	}
+	if jsonEqual(old, new) {
+		return
+	}
+	f(path, old, new)
+}
+
+// renderDiff writes the (masked) differences between live and
+// config to out.  Changed resource quantities are annotated with
+// their delta.
+func (c DiffCmd) renderDiff(out io.Writer, kind string, live, config map[string]interface{}) error {
+	live, config = c.maskFields(kind, live, config)
+	if c.DiffStrategy == DiffStrategyLastApplied {
+		return renderPathDiff(out, live, config)
+	}
+	config = annotateQuantities(live, config)
+	if c.DiffFormat == DiffFormatSideBySide && c.Width >= minSideBySideWidth {
+		return renderSideBySide(out, live, config, c.Width, c.ContextLines, istty(out))
+	}
+	return renderDiff(out, live, config, c.ContextLines)
+}
+
+// shows returns true if objects with action a should be reported
+func (c DiffCmd) shows(a DiffAction) bool {
+	if !c.OnlyAdded && !c.OnlyRemoved {
+		return true
+	}
+	return (c.OnlyAdded && a == DiffAdded) || (c.OnlyRemoved && a == DiffRemoved)
 }
 
 func (c DiffCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	if c.OnlyRemoved && c.GcTag == "" {
+		return fmt.Errorf("Reporting removed objects requires a garbage collection tag")
+	}
+	switch c.DiffAgainst {
+	case "", DiffAgainstLive:
+	case DiffAgainstLastApplied:
+		if c.DryRunPool != nil {
+			return fmt.Errorf("Server dry-run can only be compared with live objects")
+		}
+	default:
+		return fmt.Errorf("Unknown diff base %q, expected %s or %s", c.DiffAgainst, DiffAgainstLive, DiffAgainstLastApplied)
+	}
+	f, err := c.formatter(out)
+	if err != nil {
+		return err
+	}
+
 	sort.Sort(utils.AlphabeticalOrder(apiObjects))
 
+	seenUids := sets.NewString()
 	diffFound := false
 	for _, obj := range apiObjects {
 		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
-		log.Debugf("Fetching ", desc)
+		log.Debug("Fetching ", desc)
 
 		client, err := utils.ClientForResource(c.ClientPool, c.Discovery, obj, c.DefaultNamespace)
 		if err != nil {
@@ -66,51 +319,232 @@ func (c DiffCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) err
 			return fmt.Errorf("Error fetching %s: %v", desc, err)
 		}
 
-		fmt.Fprintln(out, "---")
-		fmt.Fprintf(out, "- live %s\n+ config %s\n", desc, desc)
+		configObject := stripServerManaged(obj.Object, c.KeepStatus)
+		if c.DryRunPool != nil && (liveObj != nil || c.shows(DiffAdded)) {
+			desired, err := c.serverDryRun(obj, liveObj != nil)
+			if ns := namespaceOf(obj, c.DefaultNamespace); isNamespaceNotFound(err, ns) {
+				log.Infof("Namespace %s doesn't exist yet, showing %s without a server dry-run", ns, desc)
+			} else if err != nil {
+				return fmt.Errorf("Error in server dry-run of %s: %v", desc, err)
+			} else {
+				configObject = dryRunComparable(desired)
+			}
+		}
+
 		if liveObj == nil {
-			fmt.Fprintf(out, "%s doesn't exist on server\n", desc)
+			if !c.shows(DiffAdded) {
+				continue
+			}
 			diffFound = true
+			err := f.Object(ObjectDiff{
+				Action: DiffAdded,
+				GVK:    obj.GroupVersionKind(),
+				Object: obj,
+				Desc:   desc,
+				Live:   map[string]interface{}{},
+				Config: configObject,
+			})
+			if err != nil {
+				return err
+			}
 			continue
 		}
+		seenUids.Insert(string(liveObj.GetUID()))
 
-		liveObjObject := liveObj.Object
-		if c.DiffStrategy == "subset" {
-			liveObjObject = removeMapFields(obj.Object, liveObjObject)
+		liveObjObject := stripServerManaged(liveObj.Object, c.KeepStatus)
+		if c.DryRunPool != nil {
+			liveObjObject = dryRunComparable(liveObj)
 		}
-		diff := gojsondiff.New().CompareObjects(liveObjObject, obj.Object)
+		if c.DiffAgainst == DiffAgainstLastApplied {
+			lastApplied, ok := lastAppliedConfig(liveObj)
+			if !ok {
+				if err := f.Object(ObjectDiff{
+					Action: DiffNoLastApplied,
+					GVK:    obj.GroupVersionKind(),
+					Object: obj,
+					Desc:   desc,
+					Live:   liveObjObject,
+					Config: configObject,
+				}); err != nil {
+					return err
+				}
+				continue
+			}
+			liveObjObject = stripServerManaged(lastApplied, c.KeepStatus)
+		}
+		switch c.DiffStrategy {
+		case "subset":
+			liveObjObject = removeMapFields(configObject, liveObjObject)
+		case DiffStrategyLastApplied:
+			if managed, ok := lastAppliedFields(configObject, liveObj); ok {
+				liveObjObject = removeMapFields(managed, liveObjObject)
+			} else {
+				log.Debugf("%s has no %s annotation, comparing all fields", desc, AnnotationLastApplied)
+			}
+		}
+		diff := gojsondiff.New().CompareObjects(liveObjObject, configObject)
 
+		action := DiffUnchanged
 		if diff.Modified() {
+			action = DiffModified
+		}
+		if !c.shows(action) {
+			continue
+		}
+
+		if action == DiffModified {
 			diffFound = true
-			fcfg := formatter.AsciiFormatterConfig{
-				Coloring: istty(out),
-			}
-			formatter := formatter.NewAsciiFormatter(liveObjObject, fcfg)
-			text, err := formatter.Format(diff)
+		}
+		err = f.Object(ObjectDiff{
+			Action: action,
+			GVK:    obj.GroupVersionKind(),
+			Object: obj,
+			Desc:   desc,
+			Live:   liveObjObject,
+			Config: configObject,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.GcTag != "" && c.shows(DiffRemoved) {
+		err := walkObjects(c.ClientPool, c.Discovery, metav1.ListOptions{}, func(o runtime.Object) error {
+			meta, err := meta.Accessor(o)
 			if err != nil {
 				return err
 			}
-			fmt.Fprintf(out, "%s", text)
-		} else {
-			fmt.Fprintf(out, "%s unchanged\n", desc)
+			if !eligibleForGc(meta, c.GcTag) || seenUids.Has(string(meta.GetUID())) {
+				return nil
+			}
+			if gcKeptBy(meta, c.GcFilters) != "" {
+				return nil
+			}
+			seenUids.Insert(string(meta.GetUID()))
+
+			liveObj, ok := o.(*unstructured.Unstructured)
+			if !ok {
+				return fmt.Errorf("Unexpected object type %T", o)
+			}
+			desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, o), utils.FqName(meta))
+
+			diffFound = true
+			return f.Object(ObjectDiff{
+				Action: DiffRemoved,
+				GVK:    liveObj.GroupVersionKind(),
+				Object: meta,
+				Desc:   desc,
+				Live:   liveObj.Object,
+				Config: map[string]interface{}{},
+			})
+		})
+		if err != nil {
+			return err
 		}
 	}
 
+	if err := f.Close(); err != nil {
+		return err
+	}
+
 	if diffFound {
 		return ErrDiffFound
 	}
 	return nil
 }
 
+// serverDryRun submits obj to the server as a dry-run patch (or
+// create, if it doesn't yet exist), and returns the object the
+// server would have stored.
+func (c DiffCmd) serverDryRun(obj *unstructured.Unstructured, exists bool) (*unstructured.Unstructured, error) {
+	rc, err := utils.ClientForResource(c.DryRunPool, c.Discovery, obj, c.DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return dryRunWrite(rc, obj, exists)
+}
+
+// stripServerManaged returns a copy of obj without status (unless
+// keepStatus) or server-managed metadata
+func stripServerManaged(obj map[string]interface{}, keepStatus bool) map[string]interface{} {
+	ret := &unstructured.Unstructured{Object: deepCopyJSON(obj).(map[string]interface{})}
+	if keepStatus {
+		utils.StripServerManagedMetadata(ret)
+	} else {
+		utils.StripServerManagedFields(ret)
+	}
+	return ret.Object
+}
+
+// dryRunComparable returns a copy of obj without the
+// server-maintained fields that always differ between a live object
+// and a dry-run response
+func dryRunComparable(obj *unstructured.Unstructured) map[string]interface{} {
+	ret := &unstructured.Unstructured{Object: deepCopyJSON(obj.Object).(map[string]interface{})}
+	sanitizeSnapshot(ret)
+	stripKubecfgAnnotations(ret)
+	if metadata, ok := ret.Object["metadata"].(map[string]interface{}); ok {
+		for _, f := range []string{"resourceVersion", "uid"} {
+			delete(metadata, f)
+		}
+	}
+	return ret.Object
+}
+
+// renderDiff writes the differences between live and config to
+// out, with context lines around each change (see limitContext)
+func renderDiff(out io.Writer, live, config map[string]interface{}, context int) error {
+	diff := gojsondiff.New().CompareObjects(live, config)
+	fcfg := formatter.AsciiFormatterConfig{
+		Coloring: istty(out),
+	}
+	text, err := formatter.NewAsciiFormatter(live, fcfg).Format(diff)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s", limitContext(text, context))
+	return nil
+}
+
+// setFieldAt sets the (existing) field at path to v
+func setFieldAt(obj map[string]interface{}, path []string, v interface{}) {
+	for _, f := range path[:len(path)-1] {
+		obj = obj[f].(map[string]interface{})
+	}
+	obj[path[len(path)-1]] = v
+}
+
+func deepCopyJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			ret[k] = deepCopyJSON(v)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i, v := range t {
+			ret[i] = deepCopyJSON(v)
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
 func removeFields(config, live interface{}) interface{} {
 	switch c := config.(type) {
 	case map[string]interface{}:
-		return removeMapFields(c, live.(map[string]interface{}))
+		if l, ok := live.(map[string]interface{}); ok {
+			return removeMapFields(c, l)
+		}
 	case []interface{}:
-		return removeListFields(c, live.([]interface{}))
-	default:
-		return live
+		if l, ok := live.([]interface{}); ok {
+			return removeListFields(c, l)
+		}
 	}
+	return live
 }
 
 func removeMapFields(config, live map[string]interface{}) map[string]interface{} {
@@ -16,9 +16,25 @@
 package kubecfg
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+
+	"github.com/ksonnet/kubecfg/utils"
 )
 
 func TestRemoveListFields(t *testing.T) {
@@ -166,3 +182,497 @@ func TestRemoveFields(t *testing.T) {
 		require.Equal(t, tc.expected, removeFields(tc.config, tc.live))
 	}
 }
+
+func diffTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/tests/v1alpha1/namespaces/default/tests/existing":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default","uid":"1"},"spec":{"replicas":1}}`)
+		case "/apis/tests/v1alpha1/tests":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"TestList","items":[
+  {"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default","uid":"1","annotations":{"kubecfg.ksonnet.io/garbage-collect-tag":"mytag"}}},
+  {"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"stale","namespace":"default","uid":"2","annotations":{"kubecfg.ksonnet.io/garbage-collect-tag":"mytag"}}},
+  {"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"kept","namespace":"default","uid":"3","annotations":{"kubecfg.ksonnet.io/garbage-collect-tag":"mytag","kubecfg.io/gc-policy":"disabled"}}}
+]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+}
+
+func diffTestCmd(host string) DiffCmd {
+	return DiffCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: host}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true, Verbs: []string{"get", "list"}},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		DiffStrategy:     "all",
+	}
+}
+
+func diffTestObjs() []*unstructured.Unstructured {
+	newObj := func(name string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "tests/v1alpha1",
+				"kind":       "Test",
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": "default",
+				},
+				"spec": map[string]interface{}{
+					"replicas": 2,
+				},
+			},
+		}
+	}
+	return []*unstructured.Unstructured{newObj("existing"), newObj("added")}
+}
+
+func TestDiffOnlyAdded(t *testing.T) {
+	srv := diffTestServer(t)
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.OnlyAdded = true
+
+	var buf bytes.Buffer
+	if err := c.Run(diffTestObjs(), &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "tests default.added") || !strings.Contains(out, `"replicas": 2`) {
+		t.Errorf("Added object missing from output:\n%s", out)
+	}
+	if strings.Contains(out, "existing") {
+		t.Errorf("Modified object included in output:\n%s", out)
+	}
+}
+
+func TestDiffOnlyRemoved(t *testing.T) {
+	srv := diffTestServer(t)
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.OnlyRemoved = true
+	if err := c.Run(diffTestObjs(), ioutil.Discard); err == nil {
+		t.Errorf("--only-removed without a gc tag was accepted")
+	}
+
+	c.GcTag = "mytag"
+	var buf bytes.Buffer
+	if err := c.Run(diffTestObjs(), &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "tests default.stale") {
+		t.Errorf("Removed object missing from output:\n%s", out)
+	}
+	if strings.Contains(out, "existing") || strings.Contains(out, "added") || strings.Contains(out, "kept") {
+		t.Errorf("Unexpected object included in output:\n%s", out)
+	}
+}
+
+func TestDiffMask(t *testing.T) {
+	srv := diffTestServer(t)
+	defer srv.Close()
+
+	mask, err := ParseDiffMask("Test:spec.replicas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseDiffMask("spec.replicas"); err == nil {
+		t.Errorf("Diff mask without a kind was accepted")
+	}
+
+	c := diffTestCmd(srv.URL)
+	c.Masks = []DiffMask{mask, {Kind: "Other", Path: []string{"spec", "paused"}}}
+
+	objs := diffTestObjs()[:1]
+	spec := objs[0].Object["spec"].(map[string]interface{})
+	spec["paused"] = true
+
+	var buf bytes.Buffer
+	if err := c.Run(objs, &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	out := buf.String()
+	t.Log("output is", out)
+	if !strings.Contains(out, `"replicas": "(masked, changed)"`) {
+		t.Errorf("Masked field was not shown as changed:\n%s", out)
+	}
+	if strings.Contains(out, `"replicas": 1`) || strings.Contains(out, `"replicas": 2`) {
+		t.Errorf("Masked value was revealed:\n%s", out)
+	}
+	if !strings.Contains(out, `"paused": true`) {
+		t.Errorf("Unmasked field was not rendered:\n%s", out)
+	}
+	if spec["replicas"] != 2 {
+		t.Errorf("Masking modified the config object: %v", spec["replicas"])
+	}
+}
+
+func TestDiffOutChanges(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/tests/v1alpha1/namespaces/default/tests/existing":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default"},"spec":{"replicas":1,"image":"a"}}`)
+		case "/api/v1/namespaces/default/secrets/creds":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"creds","namespace":"default"},"data":{"password":"b2xk","user":"YWRtaW4="}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.Discovery.(*fakediscovery.FakeDiscovery).Resources = append(c.Discovery.(*fakediscovery.FakeDiscovery).Resources, &metav1.APIResourceList{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "secrets", Kind: "Secret", Namespaced: true},
+		},
+	})
+	c.OutChanges = "json"
+
+	objs := diffTestObjs()[:1]
+	objs[0].Object["spec"] = map[string]interface{}{"replicas": 2, "image": "a"}
+	objs = append(objs, &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "creds", "namespace": "default"},
+			"data":       map[string]interface{}{"password": "bmV3", "user": "YWRtaW4="},
+		},
+	})
+
+	var buf bytes.Buffer
+	if err := c.Run(objs, &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	t.Log("output is", buf.String())
+
+	var records []ChangeRecord
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ChangeRecord{
+		{
+			Action:     "update",
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Namespace:  "default",
+			Name:       "creds",
+			Changes: []FieldChange{
+				{Path: "data.password", Old: maskedValue, New: maskedChangedValue},
+			},
+		},
+		{
+			Action:     "update",
+			APIVersion: "tests/v1alpha1",
+			Kind:       "Test",
+			Namespace:  "default",
+			Name:       "existing",
+			Changes: []FieldChange{
+				{Path: "spec.replicas", Old: 1.0, New: 2.0},
+			},
+		},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, records)
+	}
+	if strings.Contains(buf.String(), "bmV3") || strings.Contains(buf.String(), "b2xk") {
+		t.Errorf("Secret value was revealed:\n%s", buf.String())
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	var changes []FieldChange
+	changedFields(nil, map[string]interface{}{}, map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo"},
+		"list":     []interface{}{1},
+	}, &changes)
+	expected := []FieldChange{
+		{Path: "list", New: []interface{}{1}},
+		{Path: "metadata.name", New: "foo"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+}
+
+func TestRenderSideBySide(t *testing.T) {
+	live := mustUnstructured(t, `{
+  "kind": "Deployment",
+  "metadata": {"name": "web"},
+  "spec": {"replicas": 1, "template": {"spec": {"containers": [{"image": "nginx:1.12", "name": "web"}]}}}
+}`).Object
+	config := mustUnstructured(t, `{
+  "kind": "Deployment",
+  "metadata": {"name": "web", "labels": {"app": "web"}},
+  "spec": {"template": {"spec": {"containers": [{"image": "nginx:1.13-alpine-with-a-very-long-tag", "name": "web"}]}}}
+}`).Object
+
+	var buf bytes.Buffer
+	if err := renderSideBySide(&buf, live, config, 63, DiffContextAll, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := `kind: "Deployment"               kind: "Deployment"
+                               > metadata.labels.app: "web"
+metadata.name: "web"             metadata.name: "web"
+spec.replicas: 1               <
+spec.template.spec.containers. | spec.template.spec.containers.
+0.image: "nginx:1.12"            0.image: "nginx:1.13-alpine-wi
+                                 th-a-very-long-tag"
+spec.template.spec.containers.   spec.template.spec.containers.
+0.name: "web"                    0.name: "web"
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestLimitContext(t *testing.T) {
+	text := ` {
+   "a": 1,
+-  "b": 2,
++  "b": 3,
+   "c": 4,
+   "d": 5,
+   "e": 6,
+` + "\x1b[32m+  \"f\": 7,\x1b[0m" + `
+ }
+`
+	for n, expected := range map[int]string{
+		0: `...
+-  "b": 2,
++  "b": 3,
+...
+` + "\x1b[32m+  \"f\": 7,\x1b[0m" + `
+...
+`,
+		1: `...
+   "a": 1,
+-  "b": 2,
++  "b": 3,
+   "c": 4,
+...
+   "e": 6,
+` + "\x1b[32m+  \"f\": 7,\x1b[0m" + `
+ }
+`,
+		2:              text,
+		DiffContextAll: text,
+	} {
+		if got := limitContext(text, n); got != expected {
+			t.Errorf("With %d context lines, expected:\n%s\ngot:\n%s", n, expected, got)
+		}
+	}
+
+	live := map[string]interface{}{"a": 1, "b": 2, "c": 3, "d": 4}
+	config := map[string]interface{}{"a": 1, "b": 2, "c": 3, "d": 5}
+	var buf bytes.Buffer
+	if err := renderSideBySide(&buf, live, config, 30, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := `...
+c: 3            c: 3
+d: 4          | d: 5
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestDiffSideBySideFallback(t *testing.T) {
+	srv := diffTestServer(t)
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.DiffFormat = DiffFormatSideBySide
+	objs := diffTestObjs()[:1]
+
+	// Too narrow (or not a terminal): unified
+	c.Width = minSideBySideWidth - 1
+	var buf bytes.Buffer
+	if err := c.Run(objs, &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	if !strings.Contains(buf.String(), `-    "replicas": 1`) {
+		t.Errorf("Narrow terminal did not fall back to unified diff:\n%s", buf.String())
+	}
+
+	c.Width = 80
+	buf.Reset()
+	if err := c.Run(objs, &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "spec.replicas: 1") || !strings.Contains(buf.String(), "| spec.replicas: 2") {
+		t.Errorf("Unexpected side-by-side diff:\n%s", buf.String())
+	}
+}
+
+func TestDiffQuantityDelta(t *testing.T) {
+	live := mustUnstructured(t, `{
+  "kind": "Deployment",
+  "spec": {"replicas": 1, "template": {"spec": {"containers": [
+    {"name": "web", "resources": {"limits": {"cpu": "500m", "memory": "512Mi"}, "requests": {"cpu": 1}}}
+  ]}}}
+}`).Object
+	config := mustUnstructured(t, `{
+  "kind": "Deployment",
+  "spec": {"replicas": 2, "template": {"spec": {"containers": [
+    {"name": "web", "resources": {"limits": {"cpu": "750m", "memory": "384Mi"}, "requests": {"cpu": "1000m", "memory": "1Gi"}}}
+  ]}}}
+}`).Object
+
+	containerResources := func(obj map[string]interface{}) map[string]interface{} {
+		spec, _ := fieldAt(obj, []string{"spec", "template", "spec"})
+		c := spec.(map[string]interface{})["containers"].([]interface{})[0]
+		return c.(map[string]interface{})["resources"].(map[string]interface{})
+	}
+
+	annotated := annotateQuantities(live, config)
+	resources := containerResources(annotated)
+	for _, test := range []struct {
+		path     []string
+		expected interface{}
+	}{
+		{[]string{"limits", "memory"}, "384Mi (-128Mi memory, -25%)"},
+		{[]string{"limits", "cpu"}, "750m (+250m CPU, +50%)"},
+		// Unchanged, despite a different representation
+		{[]string{"requests", "cpu"}, "1000m"},
+		// Added
+		{[]string{"requests", "memory"}, "1Gi"},
+	} {
+		if v, _ := fieldAt(resources, test.path); !jsonEqual(v, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.path, test.expected, v)
+		}
+	}
+	// Not a quantity
+	if v, _ := fieldAt(annotated, []string{"spec", "replicas"}); !jsonEqual(v, 2) {
+		t.Errorf("Non-quantity field was annotated: %v", v)
+	}
+
+	if v, _ := fieldAt(containerResources(config), []string{"limits", "memory"}); v != "384Mi" {
+		t.Errorf("Annotating modified the config object: %v", v)
+	}
+
+	var buf bytes.Buffer
+	if err := (DiffCmd{}).renderDiff(&buf, "Deployment", live, config); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"memory": "384Mi (-128Mi memory, -25%)"`) {
+		t.Errorf("Rendered diff is missing memory delta:\n%s", buf.String())
+	}
+}
+
+func TestDiffServerDryRun(t *testing.T) {
+	patched := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/apis/tests/v1alpha1/namespaces/default/tests/existing":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default","uid":"1","resourceVersion":"10","generation":3},"spec":{"replicas":1,"strategy":"Rolling"},"status":{"ready":1}}`)
+		case r.Method == "PATCH" && r.URL.Path == "/apis/tests/v1alpha1/namespaces/default/tests/existing":
+			if r.URL.Query().Get("dryRun") != "All" {
+				t.Errorf("Patch was not a dry-run: %s", r.URL)
+			}
+			patched = true
+			// Simulated conversion webhook changes the version, and
+			// defaulting adds a field
+			fmt.Fprint(w, `{"apiVersion":"tests/v1","kind":"Test","metadata":{"name":"existing","namespace":"default","uid":"1","resourceVersion":"10","generation":4},"spec":{"replicas":2,"strategy":"Rolling","paused":false},"status":{"ready":1}}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.DryRunPool = dynamic.NewDynamicClientPool(&rest.Config{
+		Host:          srv.URL,
+		WrapTransport: utils.NewDryRunTransport,
+	})
+	c.OutChanges = "json"
+
+	var buf bytes.Buffer
+	if err := c.Run(diffTestObjs()[:1], &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	if !patched {
+		t.Fatalf("Object was not submitted as a dry-run")
+	}
+
+	var records []ChangeRecord
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("Failed to parse %s: %v", buf.String(), err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %v", records)
+	}
+	// resourceVersion, generation and status are not reported, and
+	// the defaulted strategy matches the live object
+	expected := []FieldChange{
+		{Path: "apiVersion", Old: "tests/v1alpha1", New: "tests/v1"},
+		{Path: "spec.paused", New: false},
+		{Path: "spec.replicas", Old: 1.0, New: 2.0},
+	}
+	if !reflect.DeepEqual(records[0].Changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, records[0].Changes)
+	}
+}
+
+func TestDiffServerDryRunMissingNamespace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/apis/tests/v1alpha1/namespaces/newns/tests/added":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","details":{"name":"added","kind":"tests"},"code":404}`)
+		case r.Method == "POST" && r.URL.Path == "/apis/tests/v1alpha1/namespaces/newns/tests":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"namespaces \"newns\" not found","reason":"NotFound","details":{"name":"newns","kind":"namespaces"},"code":404}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.DryRunPool = dynamic.NewDynamicClientPool(&rest.Config{
+		Host:          srv.URL,
+		WrapTransport: utils.NewDryRunTransport,
+	})
+	c.OutChanges = "json"
+
+	obj := diffTestObjs()[0]
+	obj.SetName("added")
+	obj.SetNamespace("newns")
+
+	var buf bytes.Buffer
+	if err := c.Run([]*unstructured.Unstructured{obj}, &buf); err != ErrDiffFound {
+		t.Fatalf("Expected ErrDiffFound, got %v", err)
+	}
+	var records []ChangeRecord
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("Failed to parse %s: %v", buf.String(), err)
+	}
+	if len(records) != 1 || records[0].Name != "added" || records[0].Action != "create" {
+		t.Errorf("Expected the client-side diff of the added object, got %v", records)
+	}
+}
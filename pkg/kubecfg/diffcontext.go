@@ -0,0 +1,104 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"strings"
+)
+
+const (
+	// DiffContextAll shows the whole of each changed object,
+	// rather than only the lines around each change
+	DiffContextAll = -1
+
+	// omittedLines replaces each run of unchanged lines hidden by
+	// DiffCmd.ContextLines
+	omittedLines = "..."
+)
+
+// contextMask returns which lines to show, given which lines are
+// changed: each changed line, and up to n lines either side of it.
+// A negative n shows every line.
+func contextMask(changed []bool, n int) []bool {
+	show := make([]bool, len(changed))
+	if n < 0 {
+		for i := range show {
+			show[i] = true
+		}
+		return show
+	}
+	// Distance to the previous change, then to the next
+	last := -1
+	for i, c := range changed {
+		if c {
+			last = i
+		}
+		show[i] = last >= 0 && i-last <= n
+	}
+	last = -1
+	for i := len(changed) - 1; i >= 0; i-- {
+		if changed[i] {
+			last = i
+		}
+		if last >= 0 && last-i <= n {
+			show[i] = true
+		}
+	}
+	return show
+}
+
+// isChangedLine returns true if line (from gojsondiff's
+// AsciiFormatter, possibly coloured) is an addition or deletion
+func isChangedLine(line string) bool {
+	if strings.HasPrefix(line, "\x1b[") {
+		if i := strings.IndexByte(line, 'm'); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+	return strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")
+}
+
+// limitContext removes the unchanged lines of a unified diff that
+// are more than n lines from any change.  Each run of removed lines
+// is replaced by omittedLines.
+func limitContext(text string, n int) string {
+	if n < 0 {
+		return text
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	changed := make([]bool, len(lines))
+	for i, line := range lines {
+		changed[i] = isChangedLine(line)
+	}
+
+	var b strings.Builder
+	omitting := false
+	for i, show := range contextMask(changed, n) {
+		if !show {
+			if !omitting {
+				b.WriteString(omittedLines + "\n")
+			}
+			omitting = true
+			continue
+		}
+		omitting = false
+		b.WriteString(lines[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	yaml "gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// DiffFormatJSON is a list of DiffResults, in JSON
+	DiffFormatJSON = "json"
+	// DiffFormatYAML is a list of DiffResults, in YAML
+	DiffFormatYAML = "yaml"
+)
+
+// DiffAction is the change an update would make to an object
+type DiffAction int
+
+const (
+	DiffUnchanged DiffAction = iota
+	DiffModified
+	DiffAdded
+	DiffRemoved
+	// DiffNoLastApplied is reported instead of a diff against
+	// the last-applied config, for objects without one
+	DiffNoLastApplied
+)
+
+func (a DiffAction) String() string {
+	switch a {
+	case DiffModified:
+		return "update"
+	case DiffAdded:
+		return "create"
+	case DiffRemoved:
+		return "delete"
+	case DiffNoLastApplied:
+		return "no-last-applied"
+	}
+	return "unchanged"
+}
+
+// ObjectDiff is the difference between the live and config versions
+// of a single object.  Live is empty for objects that would be
+// created, and Config is empty for objects that would be garbage
+// collected.
+type ObjectDiff struct {
+	Action DiffAction
+	GVK    schema.GroupVersionKind
+	Object metav1.Object
+	// Desc is the human-readable description of Object
+	Desc   string
+	Live   map[string]interface{}
+	Config map[string]interface{}
+}
+
+// DiffFormatter writes the output of DiffCmd
+type DiffFormatter interface {
+	// Object reports the diff of a single object
+	Object(d ObjectDiff) error
+	// Close writes any buffered output
+	Close() error
+}
+
+// formatter returns the DiffFormatter selected by c's options
+func (c DiffCmd) formatter(out io.Writer) (DiffFormatter, error) {
+	switch c.OutChanges {
+	case "":
+	case "json":
+		return &changeRecordFormatter{c: c, out: out, records: []ChangeRecord{}}, nil
+	default:
+		return nil, fmt.Errorf("Unknown change output format: %s", c.OutChanges)
+	}
+
+	switch c.DiffFormat {
+	case "", DiffFormatUnified, DiffFormatSideBySide:
+		return textDiffFormatter{c: c, out: out}, nil
+	case DiffFormatJSON, DiffFormatYAML:
+		return &structuredDiffFormatter{c: c, out: out, format: c.DiffFormat, results: []DiffResult{}}, nil
+	}
+	return nil, fmt.Errorf("Unknown diff format: %s", c.DiffFormat)
+}
+
+// textDiffFormatter writes a human-readable diff of each object
+type textDiffFormatter struct {
+	c   DiffCmd
+	out io.Writer
+}
+
+func (f textDiffFormatter) Object(d ObjectDiff) error {
+	fmt.Fprintln(f.out, "---")
+	fmt.Fprintf(f.out, "- live %s\n+ config %s\n", d.Desc, d.Desc)
+	switch d.Action {
+	case DiffAdded:
+		if !f.c.OnlyAdded {
+			fmt.Fprintf(f.out, "%s doesn't exist on server\n", d.Desc)
+			return nil
+		}
+	case DiffRemoved:
+		if !f.c.OnlyRemoved {
+			fmt.Fprintf(f.out, "%s would be garbage collected\n", d.Desc)
+			return nil
+		}
+	case DiffUnchanged:
+		fmt.Fprintf(f.out, "%s unchanged\n", d.Desc)
+		return nil
+	case DiffNoLastApplied:
+		fmt.Fprintf(f.out, "%s has no recorded last-applied config\n", d.Desc)
+		return nil
+	}
+	return f.c.renderDiff(f.out, d.GVK.Kind, d.Live, d.Config)
+}
+
+func (f textDiffFormatter) Close() error {
+	return nil
+}
+
+// changeRecordFormatter writes a ChangeRecord for each changed
+// object, in JSON
+type changeRecordFormatter struct {
+	c       DiffCmd
+	out     io.Writer
+	records []ChangeRecord
+}
+
+func (f *changeRecordFormatter) Object(d ObjectDiff) error {
+	if d.Action != DiffUnchanged && d.Action != DiffNoLastApplied {
+		f.records = append(f.records, f.c.changeRecord(d.Action.String(), d.Object, d.GVK, d.Live, d.Config))
+	}
+	return nil
+}
+
+func (f *changeRecordFormatter) Close() error {
+	enc := json.NewEncoder(f.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f.records)
+}
+
+// DiffResult is the machine-readable diff of a single object
+type DiffResult struct {
+	GVK       metav1.GroupVersionKind `json:"gvk" yaml:"gvk"`
+	Namespace string                  `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Name      string                  `json:"name" yaml:"name"`
+	// Action is one of "create", "update", "delete",
+	// "unchanged" or "no-last-applied"
+	Action string `json:"action" yaml:"action"`
+	// Patch is the JSON merge patch from the live object to the
+	// config, ie: the whole config for created objects, and null
+	// for deleted objects.  Secret data and masked fields are
+	// replaced by placeholders.
+	Patch interface{} `json:"patch" yaml:"patch"`
+}
+
+// structuredDiffFormatter writes a list of DiffResults, as JSON or
+// YAML
+type structuredDiffFormatter struct {
+	c       DiffCmd
+	out     io.Writer
+	format  string
+	results []DiffResult
+}
+
+func (f *structuredDiffFormatter) Object(d ObjectDiff) error {
+	result := DiffResult{
+		GVK:       metav1.GroupVersionKind{Group: d.GVK.Group, Version: d.GVK.Version, Kind: d.GVK.Kind},
+		Namespace: d.Object.GetNamespace(),
+		Name:      d.Object.GetName(),
+		Action:    d.Action.String(),
+	}
+	if d.Action != DiffRemoved && d.Action != DiffNoLastApplied {
+		live, config := f.c.maskForOutput(d.GVK, d.Live, d.Config)
+		result.Patch = mergePatch(live, config)
+	}
+	f.results = append(f.results, result)
+	return nil
+}
+
+func (f *structuredDiffFormatter) Close() error {
+	if f.format == DiffFormatYAML {
+		buf, err := yaml.Marshal(f.results)
+		if err != nil {
+			return err
+		}
+		_, err = f.out.Write(buf)
+		return err
+	}
+	enc := json.NewEncoder(f.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f.results)
+}
+
+// mergePatch returns the JSON merge patch (RFC 7386) that transforms
+// live into config
+func mergePatch(live, config map[string]interface{}) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for k := range live {
+		if _, ok := config[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, cv := range config {
+		lv, ok := live[k]
+		if ok && jsonEqual(lv, cv) {
+			continue
+		}
+		lm, lok := lv.(map[string]interface{})
+		cm, cok := cv.(map[string]interface{})
+		if lok && cok {
+			patch[k] = mergePatch(lm, cm)
+		} else {
+			patch[k] = cv
+		}
+	}
+	return patch
+}
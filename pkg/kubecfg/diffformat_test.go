@@ -0,0 +1,180 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+)
+
+func TestDiffFormatStructured(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/tests/v1alpha1/namespaces/default/tests/existing":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default"},"spec":{"replicas":1,"image":"a"}}`)
+		case "/apis/tests/v1alpha1/namespaces/default/tests/same":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"same","namespace":"default"},"spec":{"replicas":2}}`)
+		case "/api/v1/namespaces/default/secrets/creds":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"creds","namespace":"default"},"data":{"password":"b2xk"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	objs := func() []*unstructured.Unstructured {
+		objs := diffTestObjs()
+		objs[0].Object["spec"] = map[string]interface{}{"replicas": 2}
+		same := diffTestObjs()[0]
+		same.SetName("same")
+		// As decoded by the dynamic client
+		same.Object["spec"] = map[string]interface{}{"replicas": int64(2)}
+		return append(objs, same, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": "creds", "namespace": "default"},
+				"data":       map[string]interface{}{"password": "bmV3"},
+			},
+		})
+	}
+
+	expected := []DiffResult{
+		{
+			GVK:       metav1.GroupVersionKind{Group: "tests", Version: "v1alpha1", Kind: "Test"},
+			Namespace: "default",
+			Name:      "added",
+			Action:    "create",
+			Patch: map[string]interface{}{
+				"apiVersion": "tests/v1alpha1",
+				"kind":       "Test",
+				"metadata":   map[string]interface{}{"name": "added", "namespace": "default"},
+				"spec":       map[string]interface{}{"replicas": 2.0},
+			},
+		},
+		{
+			GVK:       metav1.GroupVersionKind{Version: "v1", Kind: "Secret"},
+			Namespace: "default",
+			Name:      "creds",
+			Action:    "update",
+			Patch:     map[string]interface{}{"data": map[string]interface{}{"password": maskedChangedValue}},
+		},
+		{
+			GVK:       metav1.GroupVersionKind{Group: "tests", Version: "v1alpha1", Kind: "Test"},
+			Namespace: "default",
+			Name:      "existing",
+			Action:    "update",
+			Patch:     map[string]interface{}{"spec": map[string]interface{}{"image": nil, "replicas": 2.0}},
+		},
+		{
+			GVK:       metav1.GroupVersionKind{Group: "tests", Version: "v1alpha1", Kind: "Test"},
+			Namespace: "default",
+			Name:      "same",
+			Action:    "unchanged",
+			Patch:     map[string]interface{}{},
+		},
+	}
+
+	for _, format := range []string{DiffFormatJSON, DiffFormatYAML} {
+		c := diffTestCmd(srv.URL)
+		c.Discovery.(*fakediscovery.FakeDiscovery).Resources = append(c.Discovery.(*fakediscovery.FakeDiscovery).Resources, &metav1.APIResourceList{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "secrets", Kind: "Secret", Namespaced: true},
+			},
+		})
+		c.DiffFormat = format
+
+		var buf bytes.Buffer
+		if err := c.Run(objs(), &buf); err != ErrDiffFound {
+			t.Errorf("Expected ErrDiffFound, got %v", err)
+		}
+		t.Logf("%s output is %s", format, buf.String())
+
+		// Round-trip through JSON, for comparable types
+		var results []DiffResult
+		if format == DiffFormatYAML {
+			var v interface{}
+			if err := yaml.Unmarshal(buf.Bytes(), &v); err != nil {
+				t.Fatal(err)
+			}
+			jsonBuf, err := json.Marshal(yamlToJSON(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Reset()
+			buf.Write(jsonBuf)
+		}
+		if err := json.Unmarshal(buf.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("%s: expected %v, got %v", format, expected, results)
+		}
+	}
+}
+
+// yamlToJSON converts the map[interface{}]interface{}s decoded by
+// yaml.v2 to map[string]interface{}
+func yamlToJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		ret := map[string]interface{}{}
+		for k, val := range v {
+			ret[fmt.Sprint(k)] = yamlToJSON(val)
+		}
+		return ret
+	case []interface{}:
+		for i := range v {
+			v[i] = yamlToJSON(v[i])
+		}
+	}
+	return v
+}
+
+func TestMergePatch(t *testing.T) {
+	live := map[string]interface{}{
+		"a": 1.0,
+		"b": map[string]interface{}{"c": "x", "d": "y"},
+		"e": []interface{}{1.0, 2.0},
+	}
+	config := map[string]interface{}{
+		"b": map[string]interface{}{"c": "x", "d": "z"},
+		"e": []interface{}{1.0},
+		"f": "new",
+	}
+	expected := map[string]interface{}{
+		"a": nil,
+		"b": map[string]interface{}{"d": "z"},
+		"e": []interface{}{1.0},
+		"f": "new",
+	}
+	if got := mergePatch(live, config); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// AnnotationBundleDigest records the BundleDigest of the set of
+// objects last applied together with the object.
+const AnnotationBundleDigest = "kubecfg.ksonnet.io/bundle-digest"
+
+// BundleDigest returns a content-addressable digest of objs.  The
+// digest is independent of the order of objs, but changes if any
+// object is added, removed or modified.
+func BundleDigest(objs []*unstructured.Unstructured) (string, error) {
+	sorted := make([]*unstructured.Unstructured, len(objs))
+	copy(sorted, objs)
+	key := func(o *unstructured.Unstructured) string {
+		return fmt.Sprintf("%s/%s", o.GroupVersionKind(), utils.FqName(o))
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return key(sorted[i]) < key(sorted[j])
+	})
+
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, o := range sorted {
+		// Maps are encoded with sorted keys
+		if err := enc.Encode(o.Object); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// GcOptions modify the behaviour of GarbageCollect
+type GcOptions struct {
+	// DryRun reports the objects that would be deleted, without
+	// deleting them.
+	DryRun bool
+
+	// PropagationPolicy is used for each delete.  If empty,
+	// dependents are deleted in the foreground, as for
+	// `update --gc-tag`.
+	PropagationPolicy metav1.DeletionPropagation
+
+	// Allowlist, if non-empty, restricts garbage collection to
+	// these kinds.  Only these kinds are listed, so objects of
+	// other kinds are left alone.
+	Allowlist []schema.GroupVersionKind
+
+	// Filters keep matching objects from being deleted.  nil
+	// means DefaultGcFilters.
+	Filters []GcFilter
+
+	// KeepUids are the UIDs of objects that are never deleted:
+	// those applied by the current run.  Their digest may not have
+	// been updated yet (eg: with DryRun).
+	KeepUids sets.String
+}
+
+// gcResource is a resource type to list for garbage collection
+type gcResource struct {
+	gvk  schema.GroupVersionKind
+	rsrc metav1.APIResource
+	// ns is NamespaceAll for namespaced resources, and
+	// NamespaceNone otherwise
+	ns string
+}
+
+// gcResources returns the resource types to list: those in
+// allowlist, or every resource type that can be listed and deleted
+// if allowlist is empty.  Scope is taken from mapper where possible.
+func gcResources(mapper meta.RESTMapper, disco discovery.DiscoveryInterface, allowlist []schema.GroupVersionKind) ([]gcResource, error) {
+	var ret []gcResource
+	if len(allowlist) > 0 {
+		for _, gvk := range allowlist {
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to garbage collect %s: %v", gvk, err)
+			}
+			rsrc := metav1.APIResource{Name: mapping.Resource, Kind: gvk.Kind}
+			ns := metav1.NamespaceNone
+			if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+				rsrc.Namespaced = true
+				ns = metav1.NamespaceAll
+			}
+			ret = append(ret, gcResource{gvk: gvk, rsrc: rsrc, ns: ns})
+		}
+		return ret, nil
+	}
+
+	rsrclists, err := disco.ServerPreferredResources()
+	if err = utils.WarnOnPartialDiscovery(err); err != nil {
+		return nil, err
+	}
+	for _, rsrclist := range rsrclists {
+		gv, err := schema.ParseGroupVersion(rsrclist.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, rsrc := range rsrclist.APIResources {
+			if !stringListContains(rsrc.Verbs, "list") || !stringListContains(rsrc.Verbs, "delete") {
+				continue
+			}
+
+			gvk := gv.WithKind(rsrc.Kind)
+			ns := metav1.NamespaceNone
+			if mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+				log.Debugf("No REST mapping for %s, using discovery scope: %v", gvk, err)
+				if rsrc.Namespaced {
+					ns = metav1.NamespaceAll
+				}
+			} else if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+				ns = metav1.NamespaceAll
+			}
+			ret = append(ret, gcResource{gvk: gvk, rsrc: rsrc, ns: ns})
+		}
+	}
+	return ret, nil
+}
+
+// warnUnselected logs a warning for each object in objs that doesn't
+// match the label selector, since garbage collection by selector
+// will never delete it.  The selector is usually also added to every
+// object as a common label (eg: app.kubernetes.io/managed-by).
+func warnUnselected(objs []*unstructured.Unstructured, selector string) error {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return fmt.Errorf("Error parsing garbage collection selector %q: %v", selector, err)
+	}
+	for _, o := range objs {
+		if !sel.Matches(labels.Set(o.GetLabels())) {
+			log.Warningf("%s %s doesn't match the garbage collection selector %q, so will never be garbage collected", o.GetKind(), utils.FqName(o), selector)
+		}
+	}
+	return nil
+}
+
+// GarbageCollect deletes every object matching the label selector
+// whose AnnotationBundleDigest differs from keepDigest, ie: objects
+// that were applied as part of some other version of the bundle.
+// Objects without the annotation, with a controller, with the
+// `ignore` AnnotationGcStrategy, in opts.KeepUids, or kept by
+// opts.Filters are never deleted.  Resources are listed across all namespaces or at cluster
+// scope, according to the scope reported by the RESTMapper.  Returns
+// the objects deleted (or, with DryRun, those that would be).  ctx
+// is checked between API calls.
+func GarbageCollect(ctx context.Context, pool dynamic.ClientPool, disco discovery.DiscoveryInterface, selector, keepDigest string, opts GcOptions) ([]*unstructured.Unstructured, error) {
+	if selector == "" {
+		return nil, fmt.Errorf("Garbage collection by bundle digest requires a label selector")
+	}
+	dryRunText := ""
+	if opts.DryRun {
+		dryRunText = " (dry-run)"
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mapper, err := utils.RESTMapperFor(disco)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteOpts := metav1.DeleteOptions{}
+	if opts.PropagationPolicy == "" {
+		version, err := utils.FetchVersion(disco)
+		if err != nil {
+			return nil, err
+		}
+		deleteOpts = deleteOptions(version, true, -1)
+	}
+
+	rsrcs, err := gcResources(mapper, disco, opts.Allowlist)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Allowlist) > 0 {
+		log.Infof("Garbage collecting only %s, other kinds are left alone", gvkList(opts.Allowlist))
+	}
+
+	var deleted []*unstructured.Unstructured
+	seenUids := sets.NewString()
+	for _, r := range rsrcs {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		gvk, rsrc, ns := r.gvk, r.rsrc, r.ns
+		client, err := pool.ClientForGroupVersionKind(gvk)
+		if err != nil {
+			return deleted, err
+		}
+		rc := client.Resource(&rsrc, ns)
+
+		log.Debugf("Listing %s matching %q", gvk, selector)
+		list, err := rc.List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return deleted, err
+		}
+		err = meta.EachListItem(list, func(o runtime.Object) error {
+			u, ok := o.(*unstructured.Unstructured)
+			if !ok {
+				return fmt.Errorf("Unexpected object type %T", o)
+			}
+			if seenUids.Has(string(u.GetUID())) || opts.KeepUids.Has(string(u.GetUID())) {
+				return nil
+			}
+			seenUids.Insert(string(u.GetUID()))
+
+			digest, ok := u.GetAnnotations()[AnnotationBundleDigest]
+			if !ok || digest == keepDigest || !gcAllowed(u) {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(disco, u), utils.FqName(u))
+			if reason := gcKeptBy(u, opts.Filters); reason != "" {
+				log.Infof("Not garbage collecting %s: %s", desc, reason)
+				return nil
+			}
+			log.Info("Garbage collecting ", desc, dryRunText)
+			if !opts.DryRun {
+				uid := u.GetUID()
+				delOpts := deleteOpts
+				delOpts.Preconditions = &metav1.Preconditions{UID: &uid}
+				err := utils.DeleteWithPropagation(client.Resource(&rsrc, u.GetNamespace()), u.GetName(), delOpts, opts.PropagationPolicy)
+				if err != nil && (errors.IsNotFound(err) || errors.IsConflict(err)) {
+					// We lost a race with something else changing the object
+					log.Debugf("Ignoring error while deleting %s: %s", desc, err)
+					err = nil
+				}
+				if err != nil {
+					return fmt.Errorf("Error deleting %s: %s", desc, err)
+				}
+			}
+			deleted = append(deleted, u)
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// gvkList formats gvks for human messages
+func gvkList(gvks []schema.GroupVersionKind) string {
+	ret := make([]string, len(gvks))
+	for i, gvk := range gvks {
+		ret[i] = gvk.String()
+	}
+	return strings.Join(ret, "; ")
+}
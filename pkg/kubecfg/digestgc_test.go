@@ -0,0 +1,255 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestBundleDigest(t *testing.T) {
+	objs := diffTestObjs()
+	d1, err := BundleDigest(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := BundleDigest([]*unstructured.Unstructured{objs[1], objs[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d1 != d2 {
+		t.Errorf("Digest depends on object order: %s != %s", d1, d2)
+	}
+
+	objs[0].Object["spec"] = map[string]interface{}{"replicas": 3}
+	d3, err := BundleDigest(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d1 == d3 {
+		t.Errorf("Digest unchanged after modifying an object")
+	}
+	if d4, _ := BundleDigest(objs[:1]); d4 == d3 {
+		t.Errorf("Digest unchanged after removing an object")
+	}
+}
+
+func TestWarnUnselected(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	objs := diffTestObjs()
+	objs[0].SetLabels(map[string]string{"app.kubernetes.io/managed-by": "kubecfg"})
+	objs[1].SetLabels(map[string]string{"app.kubernetes.io/managed-by": "someone-else"})
+	if err := warnUnselected(objs, "app.kubernetes.io/managed-by=kubecfg"); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(logs.String(), "will never be garbage collected"); n != 1 {
+		t.Errorf("Expected 1 warning, got %d: %s", n, logs.String())
+	}
+	if !strings.Contains(logs.String(), objs[1].GetName()) {
+		t.Errorf("Warning does not name the unselected object: %s", logs.String())
+	}
+
+	if err := warnUnselected(objs, "!!bogus"); err == nil {
+		t.Errorf("Invalid selector was accepted")
+	}
+}
+
+func TestGarbageCollect(t *testing.T) {
+	const item = `{"apiVersion":"v1","kind":"%s","metadata":{"name":"%s",%s"uid":"%s","annotations":{%s}}}`
+	digest := func(d string) string {
+		return fmt.Sprintf(`"kubecfg.ksonnet.io/bundle-digest":"%s"`, d)
+	}
+
+	var mu sync.Mutex
+	var deleted []string
+	var policies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "DELETE" {
+			var opts metav1.DeleteOptions
+			json.NewDecoder(r.Body).Decode(&opts)
+			mu.Lock()
+			deleted = append(deleted, r.URL.Path)
+			if opts.PropagationPolicy != nil {
+				policies = append(policies, string(*opts.PropagationPolicy))
+			}
+			mu.Unlock()
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[]}`)
+		case "/version":
+			fmt.Fprint(w, `{"major":"1","minor":"9"}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+				{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["list","delete"]},
+				{"name":"namespaces","kind":"Namespace","namespaced":false,"verbs":["list","delete"]},
+				{"name":"componentstatuses","kind":"ComponentStatus","namespaced":false,"verbs":["list"]}]}`)
+		case "/api/v1/configmaps":
+			if r.URL.Query().Get("labelSelector") != "app=myapp" {
+				t.Errorf("Unexpected selector in %s", r.URL)
+			}
+			fmt.Fprintf(w, `{"apiVersion":"v1","kind":"ConfigMapList","items":[%s,%s,%s,%s,%s]}`,
+				fmt.Sprintf(item, "ConfigMap", "current", `"namespace":"default",`, "1", digest("new")),
+				fmt.Sprintf(item, "ConfigMap", "stale", `"namespace":"default",`, "2", digest("old")),
+				fmt.Sprintf(item, "ConfigMap", "unmanaged", `"namespace":"default",`, "3", ""),
+				fmt.Sprintf(item, "ConfigMap", "ignored", `"namespace":"default",`, "4", digest("old")+`,"kubecfg.ksonnet.io/garbage-collect-strategy":"ignore"`),
+				fmt.Sprintf(item, "ConfigMap", "protected", `"namespace":"default",`, "6", digest("old")+`,"kubecfg.io/gc-policy":"disabled"`))
+		case "/api/v1/namespaces":
+			fmt.Fprintf(w, `{"apiVersion":"v1","kind":"NamespaceList","items":[%s]}`,
+				fmt.Sprintf(item, "Namespace", "oldns", "", "5", digest("old")))
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := &rest.Config{Host: srv.URL}
+	pool := dynamic.NewDynamicClientPool(config)
+	disco, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(objs []*unstructured.Unstructured) []string {
+		ret := []string{}
+		for _, o := range objs {
+			ret = append(ret, o.GetName())
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	objs, err := GarbageCollect(context.Background(), pool, disco, "app=myapp", "new", GcOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(objs); fmt.Sprint(got) != "[oldns stale]" {
+		t.Errorf("Unexpected dry-run gc: %v", got)
+	}
+	if !strings.Contains(logs.String(), `Not garbage collecting configmaps default.protected: kubecfg.io/gc-policy is "disabled"`) {
+		t.Errorf("Skipped object was not logged:\n%s", logs.String())
+	}
+
+	keepNamespaces := func(obj metav1.Object) string {
+		if obj.GetNamespace() == "" {
+			return "cluster-scoped"
+		}
+		return ""
+	}
+	objs, err = GarbageCollect(context.Background(), pool, disco, "app=myapp", "new", GcOptions{DryRun: true, Filters: []GcFilter{keepNamespaces}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(objs); fmt.Sprint(got) != "[protected stale]" {
+		t.Errorf("Unexpected dry-run gc with custom filters: %v", got)
+	}
+
+	// Objects applied by this run still have the old digest in a
+	// dry-run
+	objs, err = GarbageCollect(context.Background(), pool, disco, "app=myapp", "new", GcOptions{DryRun: true, KeepUids: sets.NewString("2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(objs); fmt.Sprint(got) != "[oldns]" {
+		t.Errorf("Unexpected dry-run gc keeping applied objects: %v", got)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("Dry-run deleted %v", deleted)
+	}
+
+	objs, err = GarbageCollect(context.Background(), pool, disco, "app=myapp", "new", GcOptions{PropagationPolicy: metav1.DeletePropagationBackground})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(objs); fmt.Sprint(got) != "[oldns stale]" {
+		t.Errorf("Unexpected gc: %v", got)
+	}
+	sort.Strings(deleted)
+	if fmt.Sprint(deleted) != "[/api/v1/namespaces/default/configmaps/stale /api/v1/namespaces/oldns]" {
+		t.Errorf("Unexpected deletes: %v", deleted)
+	}
+	if fmt.Sprint(policies) != "[Background Background]" {
+		t.Errorf("Unexpected propagation policies: %v", policies)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GarbageCollect(ctx, pool, disco, "app=myapp", "new", GcOptions{}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := GarbageCollect(context.Background(), pool, disco, "app=myapp", "new", GcOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(policies[2:]) != "[Foreground Foreground]" {
+		t.Errorf("Unexpected default propagation policies: %v", policies[2:])
+	}
+
+	if _, err := GarbageCollect(context.Background(), pool, disco, "", "new", GcOptions{}); err == nil {
+		t.Errorf("Expected error without a selector")
+	}
+
+	mu.Lock()
+	deleted = nil
+	mu.Unlock()
+	allow := []schema.GroupVersionKind{{Version: "v1", Kind: "ConfigMap"}}
+	objs, err = GarbageCollect(context.Background(), pool, disco, "app=myapp", "new", GcOptions{Allowlist: allow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(objs); fmt.Sprint(got) != "[stale]" {
+		t.Errorf("Unexpected allowlisted gc: %v", got)
+	}
+	if fmt.Sprint(deleted) != "[/api/v1/namespaces/default/configmaps/stale]" {
+		t.Errorf("Unexpected allowlisted deletes: %v", deleted)
+	}
+
+	allow = []schema.GroupVersionKind{{Group: "example.com", Version: "v1", Kind: "Widget"}}
+	if _, err := GarbageCollect(context.Background(), pool, disco, "app=myapp", "new", GcOptions{Allowlist: allow}); err == nil {
+		t.Errorf("Expected error for unknown allowlisted kind")
+	}
+}
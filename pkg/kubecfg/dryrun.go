@@ -0,0 +1,136 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// Outcomes of a server-side dry-run
+const (
+	DryRunCreate    = "would create"
+	DryRunUnchanged = "would update (no change)"
+	DryRunChanged   = "would update (changed)"
+)
+
+// dryRunWrite submits obj through rc, whose writes must be
+// server-side dry-runs, as a patch (or create, if it doesn't yet
+// exist), and returns the object the server would have stored.
+func dryRunWrite(rc *dynamic.ResourceClient, obj *unstructured.Unstructured, exists bool) (*unstructured.Unstructured, error) {
+	if !exists {
+		return rc.Create(obj)
+	}
+	patch, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Patch(obj.GetName(), types.MergePatchType, patch)
+}
+
+// ServerDryRun submits obj through pool, whose writes must be
+// server-side dry-runs (see utils.NewDryRunTransport).  It returns
+// the object the server would have stored, and one of DryRunCreate,
+// DryRunUnchanged or DryRunChanged.  Rejections by validation or
+// admission webhooks are returned as errors.
+func ServerDryRun(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, obj *unstructured.Unstructured, defNs string) (*unstructured.Unstructured, string, error) {
+	rc, err := utils.ClientForResource(pool, disco, obj, defNs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		live = nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	result, err := dryRunWrite(rc, obj, live != nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case live == nil:
+		return result, DryRunCreate, nil
+	case reflect.DeepEqual(dryRunComparable(live), dryRunComparable(result)):
+		return result, DryRunUnchanged, nil
+	default:
+		return result, DryRunChanged, nil
+	}
+}
+
+// RunServerDryRun submits each object as a server-side dry-run
+// through DryRunPool, in the order Run would apply them, and writes
+// the outcome for each to out.  Nothing is changed on the server.
+// Rejected objects are reported individually, and don't prevent the
+// remaining objects being checked.
+func (c UpdateCmd) RunServerDryRun(apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	if c.DryRunPool == nil {
+		return fmt.Errorf("Server-side dry-run requires a dry-run client pool")
+	}
+
+	depOrder, err := utils.DependencyOrder(c.Discovery, apiObjects)
+	if err != nil {
+		return err
+	}
+	sort.Sort(depOrder)
+
+	rejected := 0
+	for _, obj := range apiObjects {
+		if c.GcTag != "" {
+			utils.SetMetaDataAnnotation(obj, AnnotationGcTag, c.GcTag)
+		}
+		if c.StampDeployID && c.DeployID != "" {
+			utils.SetMetaDataAnnotation(obj, AnnotationDeployID, c.DeployID)
+		}
+		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
+
+		_, action, err := ServerDryRun(c.DryRunPool, c.Discovery, obj, c.DefaultNamespace)
+		if ns := namespaceOf(obj, c.DefaultNamespace); isNamespaceNotFound(err, ns) && findNamespace(apiObjects, ns) != nil {
+			// Only a dry-run, so the namespace wasn't
+			// actually created
+			fmt.Fprintf(out, "%s %s (in namespace %s, which would also be created)\n", DryRunCreate, desc, ns)
+			continue
+		}
+		if err != nil {
+			log.Debugf("Server-side dry-run of %s failed: %v", desc, err)
+			fmt.Fprintf(out, "rejected %s: %v\n", desc, err)
+			rejected++
+			continue
+		}
+		fmt.Fprintf(out, "%s %s\n", action, desc)
+	}
+
+	if rejected > 0 {
+		return fmt.Errorf("Server-side dry-run rejected %d of %d objects", rejected, len(apiObjects))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+func TestUpdateServerDryRun(t *testing.T) {
+	const prefix = "/apis/tests/v1alpha1/namespaces/default/tests"
+	live := `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"%s","namespace":"default","uid":"1","resourceVersion":"10"},"spec":{"replicas":%d}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "GET" && r.URL.Query().Get("dryRun") != "All" {
+			t.Errorf("%s was not a dry-run: %s", r.Method, r.URL)
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == prefix+"/existing":
+			fmt.Fprintf(w, live, "existing", 2)
+		case r.Method == "PATCH" && r.URL.Path == prefix+"/existing":
+			// Only kubecfg's own annotations differ
+			fmt.Fprintf(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default","uid":"1","resourceVersion":"11","annotations":{"kubecfg.io/deploy-id":"abc"}},"spec":{"replicas":2}}`)
+		case r.Method == "POST" && r.URL.Path == "/api/v1/namespaces":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"newns"}}`)
+		case r.Method == "POST" && r.URL.Path == "/apis/tests/v1alpha1/namespaces/newns/tests":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"namespaces \"newns\" not found","reason":"NotFound","details":{"name":"newns","kind":"namespaces"},"code":404}`)
+		case r.Method == "GET" && r.URL.Path == prefix+"/changed":
+			fmt.Fprintf(w, live, "changed", 1)
+		case r.Method == "PATCH" && r.URL.Path == prefix+"/changed":
+			fmt.Fprintf(w, live, "changed", 2)
+		case r.Method == "POST" && r.URL.Path == prefix:
+			var buf bytes.Buffer
+			buf.ReadFrom(r.Body)
+			if strings.Contains(buf.String(), `"denied"`) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"admission webhook \"deny.example.com\" denied the request","reason":"BadRequest","code":400}`)
+				return
+			}
+			fmt.Fprintf(w, live, "added", 2)
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	d := diffTestCmd(srv.URL)
+	disco := d.Discovery.(*fakediscovery.FakeDiscovery)
+	disco.Resources = append(disco.Resources, &metav1.APIResourceList{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "namespaces", Kind: "Namespace"}},
+	})
+	c := UpdateCmd{
+		ClientPool:       d.ClientPool,
+		Discovery:        disco,
+		DefaultNamespace: "default",
+		DryRunPool: dynamic.NewDynamicClientPool(&rest.Config{
+			Host:          srv.URL,
+			WrapTransport: utils.NewDryRunTransport,
+		}),
+	}
+
+	c.DeployID, c.StampDeployID = "abc", true
+
+	objs := diffTestObjs()
+	for _, name := range []string{"changed", "denied"} {
+		o := diffTestObjs()[0]
+		o.SetName(name)
+		objs = append(objs, o)
+	}
+	// In a namespace that is also in the config
+	o := diffTestObjs()[0]
+	o.SetName("innewns")
+	o.SetNamespace("newns")
+	objs = append(objs, o)
+	objs = append(objs, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "newns"},
+	}})
+
+	var buf bytes.Buffer
+	err := c.RunServerDryRun(objs, &buf)
+	if err == nil || !strings.Contains(err.Error(), "rejected 1 of 6 objects") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	out := buf.String()
+	for _, line := range []string{
+		"would update (no change) tests default.existing",
+		"would create tests default.added",
+		"would update (changed) tests default.changed",
+		`rejected tests default.denied: admission webhook "deny.example.com" denied the request`,
+		"would create tests newns.innewns (in namespace newns, which would also be created)",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Output missing %q", line)
+		}
+	}
+}
+
+func TestRunServerDryRunNoPool(t *testing.T) {
+	c := UpdateCmd{}
+	if err := c.RunServerDryRun([]*unstructured.Unstructured{}, &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected error without a dry-run client pool")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// Reasons used for Events emitted by kubecfg
+const (
+	EventReasonCreated = "KubecfgCreated"
+	EventReasonUpdated = "KubecfgUpdated"
+	EventReasonDeleted = "KubecfgDeleted"
+)
+
+// Limits on the rate of Event creation, to avoid flooding the
+// server during large updates
+const (
+	eventQPS   = 1
+	eventBurst = 25
+)
+
+// Like the core event aggregator, similar events (same namespace,
+// kind and reason) beyond eventAggregateThreshold within
+// eventAggregateWindow are combined into a single Event.
+const (
+	eventAggregateThreshold = 10
+	eventAggregateWindow    = 10 * time.Minute
+)
+
+// eventKey identifies similar events
+type eventKey struct {
+	namespace, kind, reason string
+}
+
+// eventGroup tracks similar events within an aggregation window
+type eventGroup struct {
+	start time.Time
+	seen  int
+
+	// combined counts the events folded into the aggregate, and
+	// last is the most recent of them
+	combined int
+	last     *unstructured.Unstructured
+}
+
+// eventRecorder creates a Kubernetes Event for each object kubecfg
+// changes.  A nil *eventRecorder is valid, and records nothing.
+type eventRecorder struct {
+	pool    dynamic.ClientPool
+	disco   discovery.DiscoveryInterface
+	limiter flowcontrol.RateLimiter
+
+	suppressed int
+	groups     map[eventKey]*eventGroup
+	order      []eventKey
+}
+
+// newEventRecorder returns an eventRecorder, or nil if enabled is
+// false
+func newEventRecorder(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, enabled bool) *eventRecorder {
+	if !enabled {
+		return nil
+	}
+	return &eventRecorder{
+		pool:    pool,
+		disco:   disco,
+		limiter: flowcontrol.NewTokenBucketRateLimiter(eventQPS, eventBurst),
+	}
+}
+
+// Record emits an Event referencing obj.  Failures are logged, but
+// otherwise ignored.
+func (r *eventRecorder) Record(obj metav1.Object, gvk schema.GroupVersionKind, reason, message string) {
+	if r == nil {
+		return
+	}
+
+	now := time.Now()
+	event := newEvent(obj, gvk, reason, message, now)
+
+	if r.groups == nil {
+		r.groups = map[eventKey]*eventGroup{}
+	}
+	key := eventKey{namespace: event.GetNamespace(), kind: gvk.Kind, reason: reason}
+	g := r.groups[key]
+	if g == nil || now.Sub(g.start) > eventAggregateWindow {
+		if g != nil {
+			r.emitCombined(key, g)
+		} else {
+			r.order = append(r.order, key)
+		}
+		g = &eventGroup{start: now}
+		r.groups[key] = g
+	}
+	g.seen++
+	if g.seen > eventAggregateThreshold {
+		g.combined++
+		g.last = event
+		return
+	}
+
+	r.emit(event, utils.FqName(obj))
+}
+
+// emit creates event about desc, subject to rate limiting
+func (r *eventRecorder) emit(event *unstructured.Unstructured, desc string) {
+	if !r.limiter.TryAccept() {
+		r.suppressed++
+		return
+	}
+
+	rc, err := utils.ClientForResource(r.pool, r.disco, event, event.GetNamespace())
+	if err == nil {
+		_, err = rc.Create(event)
+	}
+	if err != nil {
+		log.Warnf("Failed to record %s event for %s: %v", event.Object["reason"], desc, err)
+	}
+}
+
+// emitCombined creates a single Event for the similar events
+// aggregated in g
+func (r *eventRecorder) emitCombined(key eventKey, g *eventGroup) {
+	if g.combined == 0 {
+		return
+	}
+	event := g.last
+	event.Object["message"] = fmt.Sprintf("(combined from similar events): %s, and %d other %s objects",
+		event.Object["message"], g.combined-1, key.kind)
+	event.Object["count"] = g.combined
+	event.Object["firstTimestamp"] = g.start.UTC().Format(time.RFC3339)
+	r.emit(event, fmt.Sprintf("%d %s objects", g.combined, key.kind))
+	g.combined, g.last = 0, nil
+}
+
+// Flush emits any aggregated events, and reports any events that
+// were suppressed by rate limiting
+func (r *eventRecorder) Flush() {
+	if r == nil {
+		return
+	}
+
+	for _, k := range r.order {
+		r.emitCombined(k, r.groups[k])
+	}
+
+	if r.suppressed == 0 {
+		return
+	}
+	log.Infof("Skipped %d events due to rate limiting", r.suppressed)
+	r.suppressed = 0
+}
+
+func newEvent(obj metav1.Object, gvk schema.GroupVersionKind, reason, message string, now time.Time) *unstructured.Unstructured {
+	ns := obj.GetNamespace()
+	if ns == "" {
+		// Events for cluster-scoped objects go in the default namespace
+		ns = metav1.NamespaceDefault
+	}
+
+	involved := map[string]interface{}{
+		"apiVersion": gvk.GroupVersion().String(),
+		"kind":       gvk.Kind,
+		"name":       obj.GetName(),
+	}
+	if obj.GetNamespace() != "" {
+		involved["namespace"] = obj.GetNamespace()
+	}
+	if uid := obj.GetUID(); uid != "" {
+		involved["uid"] = string(uid)
+	}
+	if rv := obj.GetResourceVersion(); rv != "" {
+		involved["resourceVersion"] = rv
+	}
+
+	timestamp := now.UTC().Format(time.RFC3339)
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Event",
+			"metadata": map[string]interface{}{
+				"generateName": obj.GetName() + ".",
+				"namespace":    ns,
+			},
+			"involvedObject": involved,
+			"reason":         reason,
+			"message":        message,
+			"type":           "Normal",
+			"source": map[string]interface{}{
+				"component": "kubecfg",
+			},
+			"firstTimestamp": timestamp,
+			"lastTimestamp":  timestamp,
+			"count":          1,
+		},
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestUpdateEmitEvents(t *testing.T) {
+	events := []map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v1/namespaces/default/events":
+			var event map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				t.Error(err)
+			}
+			events = append(events, event)
+			json.NewEncoder(w).Encode(event)
+		case r.Method == "PATCH" && r.URL.Path == "/apis/tests/v1alpha1/namespaces/default/tests/myobj":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"myobj","namespace":"default","uid":"1234","resourceVersion":"42"}}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "events", Kind: "Event", Namespaced: true},
+					},
+				},
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		EmitEvents:       true,
+	}
+
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "tests/v1alpha1",
+			"kind":       "Test",
+			"metadata": map[string]interface{}{
+				"name": "myobj",
+			},
+		},
+	}
+	if err := c.Run([]*unstructured.Unstructured{obj}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %v", events)
+	}
+	e := events[0]
+	if e["reason"] != EventReasonUpdated {
+		t.Errorf("Unexpected reason %v", e["reason"])
+	}
+	involved := e["involvedObject"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"apiVersion":      "tests/v1alpha1",
+		"kind":            "Test",
+		"name":            "myobj",
+		"namespace":       "default",
+		"uid":             "1234",
+		"resourceVersion": "42",
+	}
+	for k, v := range expected {
+		if involved[k] != v {
+			t.Errorf("Expected involvedObject.%s %q, got %q", k, v, involved[k])
+		}
+	}
+}
+
+func TestEventRateLimit(t *testing.T) {
+	if r := newEventRecorder(nil, nil, false); r != nil {
+		t.Errorf("Disabled recorder was not nil")
+	}
+
+	obj := &unstructured.Unstructured{}
+	r := &eventRecorder{limiter: flowcontrol.NewFakeNeverRateLimiter()}
+	r.Record(obj, obj.GroupVersionKind(), EventReasonUpdated, "")
+	if r.suppressed != 1 {
+		t.Errorf("Expected event to be suppressed")
+	}
+}
+
+func TestEventAggregation(t *testing.T) {
+	events := []map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" || r.URL.Path != "/api/v1/namespaces/default/events" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+		}
+		var event map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		events = append(events, event)
+		json.NewEncoder(w).Encode(event)
+	}))
+	defer srv.Close()
+
+	r := &eventRecorder{
+		pool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		disco: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "events", Kind: "Event", Namespaced: true},
+					},
+				},
+			},
+		}},
+		limiter: flowcontrol.NewFakeAlwaysRateLimiter(),
+	}
+
+	for i := 0; i < 100; i++ {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace("default")
+		obj.SetName(fmt.Sprintf("config-%d", i))
+		r.Record(obj, obj.GroupVersionKind(), EventReasonUpdated, "Updated by kubecfg")
+	}
+	if len(events) != eventAggregateThreshold {
+		t.Errorf("Expected %d individual events before Flush, got %d", eventAggregateThreshold, len(events))
+	}
+
+	r.Flush()
+	if len(events) != eventAggregateThreshold+1 {
+		t.Fatalf("Expected one aggregated event, got %d events in total", len(events))
+	}
+	combined := events[len(events)-1]
+	if count := combined["count"]; count != float64(100-eventAggregateThreshold) {
+		t.Errorf("Unexpected aggregated count %v", count)
+	}
+	if msg := combined["message"]; msg != "(combined from similar events): Updated by kubecfg, and 89 other ConfigMap objects" {
+		t.Errorf("Unexpected aggregated message %q", msg)
+	}
+	if involved := combined["involvedObject"].(map[string]interface{}); involved["name"] != "config-99" {
+		t.Errorf("Aggregated event should refer to the latest object, got %v", involved["name"])
+	}
+
+	// Already flushed
+	r.Flush()
+	if len(events) != eventAggregateThreshold+1 {
+		t.Errorf("Aggregated event was emitted twice")
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// ExportAll is the ExportCmd kind that exports every namespaced
+// kind
+const ExportAll = "all"
+
+// exportedAnnotations are removed from exported objects, since they
+// are maintained by other tools (or by kubecfg itself)
+var exportedAnnotations = append([]string{
+	AnnotationLastApplied,
+	"deployment.kubernetes.io/revision",
+}, kubecfgAnnotations...)
+
+// ExportCmd represents the export subcommand
+type ExportCmd struct {
+	ClientPool       dynamic.ClientPool
+	Discovery        discovery.DiscoveryInterface
+	DefaultNamespace string
+
+	// Format is "yaml", "json" or "jsonnet"
+	Format string
+
+	// Selector, if set, restricts the objects exported when no
+	// name is given
+	Selector string
+}
+
+// Run writes the live object of the given kind (a kubectl-style
+// resource name, see utils.ResolveResource) and name to out, without
+// the fields maintained by the server.  If name is empty, every
+// object of that kind in DefaultNamespace that matches Selector is
+// written.  The kind ExportAll exports every namespaced kind, except
+// objects with a controller (eg: the Pods of a ReplicaSet).
+func (c ExportCmd) Run(kind, name string, out io.Writer) error {
+	var rsrcs []gvkResource
+	if kind == ExportAll {
+		if name != "" {
+			return fmt.Errorf("Can't export %q objects by name", ExportAll)
+		}
+		lists, err := c.Discovery.ServerPreferredNamespacedResources()
+		if err = utils.WarnOnPartialDiscovery(err); err != nil {
+			return err
+		}
+		for _, list := range lists {
+			gv, err := schema.ParseGroupVersion(list.GroupVersion)
+			if err != nil {
+				return err
+			}
+			for _, r := range list.APIResources {
+				if strings.Contains(r.Name, "/") || !stringListContains(r.Verbs, "list") {
+					continue
+				}
+				rsrcs = append(rsrcs, gvkResource{gv.WithKind(r.Kind), r})
+			}
+		}
+	} else {
+		r, err := c.resolve(kind)
+		if err != nil {
+			return err
+		}
+		rsrcs = append(rsrcs, r)
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, r := range rsrcs {
+		stub := &unstructured.Unstructured{}
+		stub.SetGroupVersionKind(r.gvk)
+		rc, err := utils.ClientForResource(c.ClientPool, c.Discovery, stub, c.DefaultNamespace)
+		if err != nil {
+			return err
+		}
+
+		if name != "" {
+			obj, err := rc.Get(name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("Error fetching %s %s: %v", r.rsrc.Name, name, err)
+			}
+			obj.SetGroupVersionKind(r.gvk)
+			objs = append(objs, obj)
+			continue
+		}
+
+		log.Debugf("Listing %s matching %q", r.gvk, c.Selector)
+		list, err := rc.List(metav1.ListOptions{LabelSelector: c.Selector})
+		if err != nil {
+			return fmt.Errorf("Error listing %s: %v", r.rsrc.Name, err)
+		}
+		ulist, ok := list.(*unstructured.UnstructuredList)
+		if !ok {
+			return fmt.Errorf("Unexpected list type %T", list)
+		}
+		for i := range ulist.Items {
+			obj := &ulist.Items[i]
+			if kind == ExportAll && hasController(obj) {
+				log.Debugf("Not exporting %s %s, which has a controller", r.rsrc.Name, utils.FqName(obj))
+				continue
+			}
+			obj.SetGroupVersionKind(r.gvk)
+			objs = append(objs, obj)
+		}
+	}
+
+	for _, obj := range objs {
+		StripForExport(obj)
+	}
+	sort.Sort(utils.AlphabeticalOrder(objs))
+
+	if c.Format == "jsonnet" {
+		return writeJsonnet(out, objs)
+	}
+	return writeObjects(out, c.Format, objs)
+}
+
+// gvkResource is a resource, and the kind it serves
+type gvkResource struct {
+	gvk  schema.GroupVersionKind
+	rsrc metav1.APIResource
+}
+
+// resolve returns the resource the server prefers for kind
+func (c ExportCmd) resolve(kind string) (gvkResource, error) {
+	gvr, err := utils.ResolveResource(c.Discovery, kind)
+	if err != nil {
+		return gvkResource{}, err
+	}
+	list, err := c.Discovery.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		return gvkResource{}, err
+	}
+	for _, r := range list.APIResources {
+		if r.Name == gvr.Resource {
+			return gvkResource{gvr.GroupVersion().WithKind(r.Kind), r}, nil
+		}
+	}
+	return gvkResource{}, fmt.Errorf("Server does not have a resource type %q", kind)
+}
+
+// StripForExport removes the fields of a live object that are
+// maintained by the server or other tools, so that it can be
+// checked into config (see utils.StripServerManagedFields).
+// Metadata left empty is removed entirely, so the result is stable
+// across repeated exports.
+func StripForExport(obj *unstructured.Unstructured) {
+	utils.StripServerManagedFields(obj)
+	metadata, ok := obj.Object["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(metadata, "selfLink")
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		for _, a := range exportedAnnotations {
+			delete(annotations, a)
+		}
+	}
+	for _, f := range []string{"annotations", "labels"} {
+		if m, ok := metadata[f].(map[string]interface{}); ok && len(m) == 0 {
+			delete(metadata, f)
+		}
+	}
+}
+
+// writeJsonnet writes objs as a jsonnet object, with a field named
+// after each object's kind and name
+func writeJsonnet(out io.Writer, objs []*unstructured.Unstructured) error {
+	fields := make(map[string]interface{}, len(objs))
+	for _, obj := range objs {
+		key := strings.ToLower(obj.GetKind()) + "_" + obj.GetName()
+		if ns := obj.GetNamespace(); ns != "" {
+			key = ns + "_" + key
+		}
+		fields[key] = obj.Object
+	}
+	// Valid JSON is valid jsonnet
+	buf, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", buf)
+	return err
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestExport(t *testing.T) {
+	const live = `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"%s","namespace":"myns","uid":"1234","resourceVersion":"42","selfLink":"/api/v1/namespaces/myns/configmaps/%s","creationTimestamp":"2017-01-01T00:00:00Z","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}","kubecfg.io/deploy-id":"1","kubecfg.ksonnet.io/garbage-collect-tag":"mytag","kubecfg.ksonnet.io/bundle-digest":"abc"}%s},"data":{"key":"value"}}`
+	var selectors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+				{"name":"configmaps","singularName":"configmap","kind":"ConfigMap","namespaced":true,"shortNames":["cm"],"verbs":["get","list"]},
+				{"name":"pods","singularName":"pod","kind":"Pod","namespaced":true,"verbs":["get","list"]}]}`)
+		case "/api/v1/namespaces/myns/configmaps/web":
+			fmt.Fprintf(w, live, "web", "web", "")
+		case "/api/v1/namespaces/myns/configmaps":
+			selectors = append(selectors, r.URL.Query().Get("labelSelector"))
+			fmt.Fprintf(w, `{"apiVersion":"v1","kind":"ConfigMapList","items":[%s,%s]}`,
+				fmt.Sprintf(live, "web", "web", ""),
+				fmt.Sprintf(live, "api", "api", `,"labels":{"app":"api"}`))
+		case "/api/v1/namespaces/myns/pods":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"PodList","items":[
+				{"metadata":{"name":"web-1234","namespace":"myns","ownerReferences":[{"kind":"ReplicaSet","name":"web","controller":true}]}},
+				{"metadata":{"name":"standalone","namespace":"myns"},"status":{"phase":"Running"}}]}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := &rest.Config{Host: srv.URL}
+	disco, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ExportCmd{
+		ClientPool:       dynamic.NewDynamicClientPool(config),
+		Discovery:        disco,
+		DefaultNamespace: "myns",
+		Format:           "yaml",
+	}
+
+	var buf bytes.Buffer
+	if err := c.Run("cm", "web", &buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `---
+apiVersion: v1
+data:
+  key: value
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: myns
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	c.Format = "jsonnet"
+	c.Selector = "app"
+	if err := c.Run("configmaps", "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(selectors) != "[app]" {
+		t.Errorf("Unexpected selectors %v", selectors)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `"myns_configmap_api": {`) || !strings.Contains(out, `"myns_configmap_web": {`) {
+		t.Errorf("Unexpected jsonnet output:\n%s", out)
+	}
+	if strings.Contains(out, "resourceVersion") || strings.Contains(out, "annotations") {
+		t.Errorf("Server-managed fields were exported:\n%s", out)
+	}
+
+	buf.Reset()
+	c.Format = "json"
+	c.Selector = ""
+	if err := c.Run(ExportAll, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	for _, name := range []string{`"api"`, `"web"`, `"standalone"`} {
+		if !strings.Contains(out, name) {
+			t.Errorf("Missing %s in output:\n%s", name, out)
+		}
+	}
+	if strings.Contains(out, "web-1234") || strings.Contains(out, "Running") {
+		t.Errorf("Unexpected controlled pod or status in output:\n%s", out)
+	}
+
+	if err := c.Run("bogus", "", &buf); err == nil || !strings.Contains(err.Error(), `"bogus"`) {
+		t.Errorf("Unexpected error for unknown kind: %v", err)
+	}
+	if err := c.Run(ExportAll, "web", &buf); err == nil {
+		t.Errorf("Expected error exporting %s by name", ExportAll)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ApplyHook is called by UpdateCmd before or after applying a single
+// object, with the client for that object's resource.  Post-apply
+// hooks are passed the object returned by the server.  An error
+// aborts the update.
+type ApplyHook func(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error
+
+// ApplyHooks holds the hooks to run for each kind.  The zero value
+// (and nil) has no hooks.
+type ApplyHooks struct {
+	pre  map[schema.GroupVersionKind][]ApplyHook
+	post map[schema.GroupVersionKind][]ApplyHook
+}
+
+// DefaultApplyHooks returns the built-in hooks: after applying a
+// CustomResourceDefinition, wait for it to be established so custom
+// resources that follow it can be applied.
+func DefaultApplyHooks() *ApplyHooks {
+	h := &ApplyHooks{}
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"},
+		{Group: "apiextensions.k8s.io", Version: "v1beta1", Kind: "CustomResourceDefinition"},
+	} {
+		h.PostApply(gvk, waitForEstablished)
+	}
+	return h
+}
+
+// PreApply registers hook to run before applying objects of kind
+// gvk.  Hooks run in the order they were registered.
+func (h *ApplyHooks) PreApply(gvk schema.GroupVersionKind, hook ApplyHook) {
+	if h.pre == nil {
+		h.pre = map[schema.GroupVersionKind][]ApplyHook{}
+	}
+	h.pre[gvk] = append(h.pre[gvk], hook)
+}
+
+// PostApply registers hook to run after successfully applying
+// objects of kind gvk.  Hooks run in the order they were registered.
+func (h *ApplyHooks) PostApply(gvk schema.GroupVersionKind, hook ApplyHook) {
+	if h.post == nil {
+		h.post = map[schema.GroupVersionKind][]ApplyHook{}
+	}
+	h.post[gvk] = append(h.post[gvk], hook)
+}
+
+func (h *ApplyHooks) runPre(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error {
+	if h == nil {
+		return nil
+	}
+	return runHooks(h.pre[obj.GroupVersionKind()], rc, obj)
+}
+
+func (h *ApplyHooks) runPost(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error {
+	if h == nil {
+		return nil
+	}
+	return runHooks(h.post[obj.GroupVersionKind()], rc, obj)
+}
+
+func runHooks(hooks []ApplyHook, rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error {
+	for _, hook := range hooks {
+		if err := hook(rc, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForEstablished polls a CustomResourceDefinition until its
+// Established condition is true
+func waitForEstablished(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error {
+	for i := 0; ; i++ {
+		live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if conditionTrue(live, "Established") {
+			return nil
+		}
+		if i >= crdEstablishRetries {
+			return fmt.Errorf("CustomResourceDefinition %s was not established after %s", obj.GetName(), time.Duration(crdEstablishRetries)*crdEstablishDelay)
+		}
+		log.Debugf("Waiting for CustomResourceDefinition %s to be established", obj.GetName())
+		time.Sleep(crdEstablishDelay)
+	}
+}
+
+// conditionTrue returns true if obj has a status condition of type
+// condType with status "True"
+func conditionTrue(obj *unstructured.Unstructured, condType string) bool {
+	conds, _ := fieldAt(obj.Object, []string{"status", "conditions"})
+	list, _ := conds.([]interface{})
+	for _, c := range list {
+		cond, ok := c.(map[string]interface{})
+		if ok && cond["type"] == condType && cond["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestApplyHooks(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		var obj map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(obj)
+	}))
+	defer srv.Close()
+
+	testGvk := schema.GroupVersionKind{Group: "tests", Version: "v1alpha1", Kind: "Test"}
+	hooks := &ApplyHooks{}
+	hooks.PreApply(testGvk, func(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error {
+		requests = append(requests, "pre "+obj.GetName())
+		return nil
+	})
+	hooks.PostApply(testGvk, func(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error {
+		requests = append(requests, "post "+obj.GetName())
+		if obj.GetName() == "three" {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	})
+	hooks.PostApply(schema.GroupVersionKind{Group: "other", Version: "v1", Kind: "Test"}, func(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) error {
+		t.Errorf("Hook for another kind was run for %s", obj.GetName())
+		return nil
+	})
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		Hooks:            hooks,
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, name := range []string{"one", "three", "two"} {
+		objs = append(objs, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "tests/v1alpha1",
+				"kind":       "Test",
+				"metadata":   map[string]interface{}{"name": name},
+			},
+		})
+	}
+
+	err := c.Run(objs)
+	if err == nil || !strings.Contains(err.Error(), "Error in post-apply hook for tests three: boom") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := "[pre one PATCH /apis/tests/v1alpha1/namespaces/default/tests/one post one pre three PATCH /apis/tests/v1alpha1/namespaces/default/tests/three post three]"
+	if fmt.Sprint(requests) != expected {
+		t.Errorf("Expected %s, got %s", expected, requests)
+	}
+}
+
+func TestWaitForEstablished(t *testing.T) {
+	gets := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "GET" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+		}
+		gets++
+		status := "False"
+		if gets > 1 {
+			status = "True"
+		}
+		fmt.Fprintf(w, `{"apiVersion":"apiextensions.k8s.io/v1beta1","kind":"CustomResourceDefinition","metadata":{"name":"tests.example.com"},
+			"status":{"conditions":[{"type":"NamesAccepted","status":"True"},{"type":"Established","status":"%s"}]}}`, status)
+	}))
+	defer srv.Close()
+
+	gvk := schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1beta1", Kind: "CustomResourceDefinition"}
+	client, err := dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}).ClientForGroupVersionKind(gvk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := client.Resource(&metav1.APIResource{Name: "customresourcedefinitions", Kind: gvk.Kind}, metav1.NamespaceNone)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName("tests.example.com")
+	if err := DefaultApplyHooks().runPost(rc, obj); err != nil {
+		t.Fatal(err)
+	}
+	if gets != 2 {
+		t.Errorf("Expected to poll until established, got %d GETs", gets)
+	}
+
+	var nilHooks *ApplyHooks
+	if err := nilHooks.runPost(rc, obj); err != nil || gets != 2 {
+		t.Errorf("nil hooks did something: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// ImageItem is a container image used by the config, for
+// vulnerability scanners
+type ImageItem struct {
+	// Image is the image as written in the config
+	Image string `json:"image"`
+	// Digest is only present if images were resolved
+	Digest string `json:"digest,omitempty"`
+}
+
+// containerFields are the pod spec fields that hold containers
+var containerFields = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// imagesOf returns the sorted, deduplicated container images used by
+// objs
+func imagesOf(objs []*unstructured.Unstructured) []string {
+	images := sets.NewString()
+	for _, o := range objs {
+		spec := podSpecOf(o)
+		if spec == nil {
+			continue
+		}
+		for _, field := range containerFields {
+			containers, _ := spec[field].([]interface{})
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if image, ok := container["image"].(string); ok && image != "" {
+					images.Insert(image)
+				}
+			}
+		}
+	}
+	return images.List()
+}
+
+func (c ShowCmd) runImages(apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	items := []ImageItem{}
+	for _, image := range imagesOf(apiObjects) {
+		item := ImageItem{Image: image}
+		if c.Resolver != nil {
+			name, err := utils.ParseImageName(image)
+			if err != nil {
+				return err
+			}
+			if err := c.Resolver.Resolve(&name); err != nil {
+				return err
+			}
+			item.Digest = name.Digest
+		}
+		items = append(items, item)
+	}
+	return writeList(out, c.Format, items)
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// DiffStrategyLastApplied compares only the fields managed by the
+// config, in the same way as `kubectl apply`: those set by the
+// config, and those set by the previously applied config recorded in
+// AnnotationLastApplied (which apply would remove).  Other fields
+// are assumed to be owned by the server or other clients.  Objects
+// without the annotation are compared in full.
+const DiffStrategyLastApplied = "last-applied"
+
+// Values of DiffCmd.DiffAgainst
+const (
+	// DiffAgainstLive compares config with the live objects
+	DiffAgainstLive = "live"
+	// DiffAgainstLastApplied compares config with the config
+	// last applied to each object, as recorded in
+	// AnnotationLastApplied, ignoring changes made by the server
+	// and other clients since.
+	DiffAgainstLastApplied = "last-applied"
+)
+
+// lastAppliedConfig returns the config recorded in live's
+// AnnotationLastApplied, or false if live has no (valid) annotation.
+func lastAppliedConfig(live *unstructured.Unstructured) (map[string]interface{}, bool) {
+	data, ok := live.GetAnnotations()[AnnotationLastApplied]
+	if !ok {
+		return nil, false
+	}
+	var lastApplied map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &lastApplied); err != nil {
+		log.Debugf("Ignoring unparseable %s annotation: %v", AnnotationLastApplied, err)
+		return nil, false
+	}
+	return lastApplied, true
+}
+
+// lastAppliedFields returns the union of the fields of config and
+// of live's AnnotationLastApplied, or false if live has no (valid)
+// annotation.
+func lastAppliedFields(config map[string]interface{}, live *unstructured.Unstructured) (map[string]interface{}, bool) {
+	lastApplied, ok := lastAppliedConfig(live)
+	if !ok {
+		return nil, false
+	}
+	return unionFields(config, lastApplied).(map[string]interface{}), true
+}
+
+// unionFields returns a value containing every field present in
+// either a or b.  Only the shape of the result is meaningful: where
+// both contain a leaf field, the value is taken from a.
+func unionFields(a, b interface{}) interface{} {
+	switch at := a.(type) {
+	case map[string]interface{}:
+		bt, ok := b.(map[string]interface{})
+		if !ok {
+			return a
+		}
+		ret := make(map[string]interface{}, len(at))
+		for k, v := range bt {
+			ret[k] = v
+		}
+		for k, v := range at {
+			ret[k] = unionFields(v, bt[k])
+		}
+		return ret
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		if !ok {
+			return a
+		}
+		ret := make([]interface{}, 0, len(at))
+		for i := 0; i < len(at) || i < len(bt); i++ {
+			switch {
+			case i >= len(at):
+				ret = append(ret, bt[i])
+			case i >= len(bt):
+				ret = append(ret, at[i])
+			default:
+				ret = append(ret, unionFields(at[i], bt[i]))
+			}
+		}
+		return ret
+	case nil:
+		return b
+	default:
+		return a
+	}
+}
+
+// renderPathDiff writes the differences between live and config to
+// out as a unified diff with one hunk per changed field, keyed by
+// JSONPath.
+func renderPathDiff(out io.Writer, live, config map[string]interface{}) error {
+	var err error
+	walkChanges(nil, live, config, func(path []string, old, new interface{}) {
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(out, "@@ %s @@\n", utils.JSONPath(path))
+		for _, l := range []struct {
+			prefix string
+			v      interface{}
+		}{{"-", old}, {"+", new}} {
+			if l.v == nil {
+				continue
+			}
+			var text []byte
+			text, err = json.Marshal(l.v)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(out, "%s%s\n", l.prefix, text)
+		}
+	})
+	return err
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiffLastApplied(t *testing.T) {
+	const lastApplied = `{\"apiVersion\":\"tests/v1alpha1\",\"kind\":\"Test\",\"metadata\":{\"name\":\"%s\",\"namespace\":\"default\"},\"spec\":{\"replicas\":1,\"paused\":true}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/tests/v1alpha1/namespaces/default/tests/existing":
+			// replicas and paused are managed, clusterIP and
+			// status are owned by the server
+			fmt.Fprintf(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default","uid":"1","resourceVersion":"10","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"`+lastApplied+`"}},"spec":{"replicas":1,"paused":true,"clusterIP":"10.0.0.1"},"status":{"ready":1}}`, "existing")
+		case "/apis/tests/v1alpha1/namespaces/default/tests/added":
+			// No annotation: compared in full
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"added","namespace":"default"},"spec":{"replicas":2,"clusterIP":"10.0.0.2"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.DiffStrategy = DiffStrategyLastApplied
+
+	var buf bytes.Buffer
+	if err := c.Run(diffTestObjs(), &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	out := buf.String()
+
+	for _, s := range []string{
+		"@@ .spec.replicas @@\n-1\n+2\n",
+		"@@ .spec.paused @@\n-true\n",
+		"@@ .spec.clusterIP @@\n-\"10.0.0.2\"\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Output missing %q:\n%s", s, out)
+		}
+	}
+	for _, s := range []string{"10.0.0.1", "status", "resourceVersion", "last-applied-configuration"} {
+		if strings.Contains(out, s) {
+			t.Errorf("Output unexpectedly contains %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestDiffAgainstLastApplied(t *testing.T) {
+	const lastApplied = `{\"apiVersion\":\"tests/v1alpha1\",\"kind\":\"Test\",\"metadata\":{\"name\":\"existing\",\"namespace\":\"default\"},\"spec\":{\"replicas\":1,\"paused\":true}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/tests/v1alpha1/namespaces/default/tests/existing":
+			// replicas has since been changed by an autoscaler
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"existing","namespace":"default","uid":"1","resourceVersion":"10","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"`+lastApplied+`"}},"spec":{"replicas":7,"paused":true,"clusterIP":"10.0.0.1"}}`)
+		case "/apis/tests/v1alpha1/namespaces/default/tests/added":
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"added","namespace":"default"},"spec":{"replicas":2}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := diffTestCmd(srv.URL)
+	c.DiffAgainst = DiffAgainstLastApplied
+
+	var buf bytes.Buffer
+	if err := c.Run(diffTestObjs(), &buf); err != ErrDiffFound {
+		t.Errorf("Expected ErrDiffFound, got %v", err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		`-    "replicas": 1`,
+		`+    "replicas": 2`,
+		`-    "paused": true`,
+		"tests default.added has no recorded last-applied config\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Output missing %q:\n%s", s, out)
+		}
+	}
+	for _, s := range []string{"7", "10.0.0.1"} {
+		if strings.Contains(out, s) {
+			t.Errorf("Output unexpectedly contains %q:\n%s", s, out)
+		}
+	}
+
+	c.DiffFormat = DiffFormatJSON
+	buf.Reset()
+	c.Run(diffTestObjs(), &buf)
+	if !strings.Contains(buf.String(), `"action": "no-last-applied"`) {
+		t.Errorf("Missing no-last-applied result:\n%s", buf.String())
+	}
+
+	c.DiffAgainst = "yesterday"
+	if err := c.Run(diffTestObjs(), &buf); err == nil {
+		t.Errorf("Expected error for unknown diff base")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// How long to wait for a terminating namespace to disappear
+var (
+	nsTerminatingTimeout = 2 * time.Minute
+	nsPollInterval       = time.Second
+)
+
+// namespaceTerminatingCause is the StatusCause type reported when
+// creating objects in a terminating namespace
+const namespaceTerminatingCause = "NamespaceTerminating"
+
+// isNamespaceTerminating returns true if err was caused by writing
+// to a namespace that is being deleted.
+func isNamespaceTerminating(err error) bool {
+	if !errors.IsForbidden(err) {
+		return false
+	}
+	status := err.(errors.APIStatus).Status()
+	if status.Details != nil {
+		for _, cause := range status.Details.Causes {
+			if cause.Type == namespaceTerminatingCause {
+				return true
+			}
+		}
+	}
+	// Older servers only report the cause in the message
+	return strings.Contains(status.Message, "because it is being terminated")
+}
+
+// isNamespaceNotFound returns true if err was caused by writing to
+// the namespace ns, which doesn't exist
+func isNamespaceNotFound(err error, ns string) bool {
+	if !errors.IsNotFound(err) {
+		return false
+	}
+	details := err.(errors.APIStatus).Status().Details
+	return details != nil && details.Kind == "namespaces" && details.Name == ns
+}
+
+// namespaceOf returns the namespace obj will be created in
+func namespaceOf(obj *unstructured.Unstructured, defNs string) string {
+	if ns := obj.GetNamespace(); ns != "" {
+		return ns
+	}
+	return defNs
+}
+
+// findNamespace returns the Namespace object named ns from objs, or
+// nil if there is none.
+func findNamespace(objs []*unstructured.Unstructured, ns string) *unstructured.Unstructured {
+	for _, o := range objs {
+		gvk := o.GroupVersionKind()
+		if gvk.Group == "" && gvk.Kind == "Namespace" && o.GetName() == ns {
+			return o
+		}
+	}
+	return nil
+}
+
+// recreateNamespace waits for the terminating namespace nsObj to be
+// deleted, and then creates it afresh.
+func recreateNamespace(logger log.FieldLogger, pool dynamic.ClientPool, disco discovery.DiscoveryInterface, nsObj *unstructured.Unstructured) (metav1.Object, error) {
+	rc, err := clientForResource(pool, disco, nsObj, "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = waitForGone(rc, nsObj.GetName(), nsPollInterval, nsTerminatingTimeout)
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("Timed out waiting for namespace %s to terminate", nsObj.GetName())
+	} else if err != nil {
+		return nil, err
+	}
+
+	logger.Info(" Recreating namespace ", nsObj.GetName())
+	return rc.Create(nsObj)
+}
+
+// namespaceCreator creates missing namespaces before namespaced
+// objects are applied into them.  A nil *namespaceCreator does
+// nothing.
+type namespaceCreator struct {
+	pool   dynamic.ClientPool
+	disco  discovery.DiscoveryInterface
+	mapper meta.RESTMapper
+	dryRun bool
+	// seen is the namespaces already checked (or created)
+	seen sets.String
+}
+
+func newNamespaceCreator(enabled bool, pool dynamic.ClientPool, disco discovery.DiscoveryInterface, dryRun bool) (*namespaceCreator, error) {
+	if !enabled {
+		return nil, nil
+	}
+	mapper, err := utils.RESTMapperFor(disco)
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceCreator{
+		pool:   pool,
+		disco:  disco,
+		mapper: mapper,
+		dryRun: dryRun,
+		seen:   sets.NewString(),
+	}, nil
+}
+
+// Ensure creates the namespace obj will be applied into, if obj is
+// namespaced and the namespace does not exist.  Each namespace is
+// only checked once.  The default namespace is assumed to exist.
+// Created namespaces are recorded in rollback.
+func (n *namespaceCreator) Ensure(obj *unstructured.Unstructured, defNs string, rollback *rollbackLog) error {
+	if n == nil {
+		return nil
+	}
+
+	gvk := obj.GroupVersionKind()
+	mapping, err := n.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		// Leave the error for the update itself to report
+		log.Debugf("No REST mapping for %s, not creating its namespace: %v", gvk, err)
+		return nil
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return nil
+	}
+
+	ns := namespaceOf(obj, defNs)
+	if ns == "" || ns == metav1.NamespaceDefault || n.seen.Has(ns) {
+		return nil
+	}
+	n.seen.Insert(ns)
+
+	nsObj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata": map[string]interface{}{
+				"name": ns,
+			},
+		},
+	}
+	rc, err := clientForResource(n.pool, n.disco, nsObj, "", false)
+	if err != nil {
+		return err
+	}
+	_, err = rc.Get(ns, metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	dryRunText := ""
+	if n.dryRun {
+		dryRunText = " (dry-run)"
+	}
+	log.Info("Creating namespace ", ns, dryRunText)
+	if n.dryRun {
+		return nil
+	}
+	_, err = rc.Create(nsObj)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rollback.Record(&rollbackEntry{rc: rc, desc: "namespaces " + ns, name: ns})
+	return nil
+}
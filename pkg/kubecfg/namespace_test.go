@@ -0,0 +1,276 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestUpdateNamespaceTerminating(t *testing.T) {
+	const (
+		terminating = iota
+		gone
+		recreated
+	)
+	state := terminating
+	polls := 0
+
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		notFound := func() {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/myns" && r.Method == "PATCH":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"myns","uid":"old"},"status":{"phase":"Terminating"}}`)
+		case r.URL.Path == "/api/v1/namespaces/myns" && r.Method == "GET":
+			polls++
+			if polls > 2 {
+				state = gone
+			}
+			if state == gone {
+				notFound()
+				return
+			}
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"myns","uid":"old"},"status":{"phase":"Terminating"}}`)
+		case r.URL.Path == "/api/v1/namespaces" && r.Method == "POST":
+			if state != gone {
+				t.Errorf("Namespace recreated before it was gone")
+			}
+			state = recreated
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"myns","uid":"new"}}`)
+		case r.URL.Path == "/api/v1/namespaces/myns/configmaps/config" && r.Method == "PATCH":
+			notFound()
+		case r.URL.Path == "/api/v1/namespaces/myns/configmaps" && r.Method == "POST":
+			if state != recreated {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403,
+  "message":"configmaps \"config\" is forbidden: unable to create new content in namespace myns because it is being terminated",
+  "details":{"name":"config","kind":"configmaps","causes":[{"reason":"NamespaceTerminating","message":"namespace myns is being terminated","field":"metadata.namespace"}]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config","namespace":"myns","uid":"cm"}}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			notFound()
+		}
+	}))
+	defer srv.Close()
+
+	defer func(d time.Duration) { nsPollInterval = d }(nsPollInterval)
+	nsPollInterval = time.Millisecond
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "namespaces", Kind: "Namespace", Namespaced: false},
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		Create:           true,
+	}
+
+	ns := &unstructured.Unstructured{}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName("myns")
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetNamespace("myns")
+	cm.SetName("config")
+
+	if err := c.Run([]*unstructured.Unstructured{ns, cm}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"PATCH /api/v1/namespaces/myns",
+		"PATCH /api/v1/namespaces/myns/configmaps/config",
+		"POST /api/v1/namespaces/myns/configmaps",
+		// terminating -> gone
+		"GET /api/v1/namespaces/myns",
+		"GET /api/v1/namespaces/myns",
+		"GET /api/v1/namespaces/myns",
+		// -> recreated
+		"POST /api/v1/namespaces",
+		// -> applied
+		"PATCH /api/v1/namespaces/myns/configmaps/config",
+		"POST /api/v1/namespaces/myns/configmaps",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+
+	// Without the namespace in config, the error is returned
+	state, polls, requests = terminating, 0, nil
+	if err := c.Run([]*unstructured.Unstructured{cm}); err == nil {
+		t.Errorf("Expected namespace terminating error, got %v", err)
+	}
+}
+
+func TestUpdateCreateNamespace(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+			return
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[]}`)
+			return
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+				{"name":"namespaces","kind":"Namespace","namespaced":false},
+				{"name":"configmaps","kind":"ConfigMap","namespaced":true},
+				{"name":"persistentvolumes","kind":"PersistentVolume","namespaced":false}]}`)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/swagger") || strings.HasPrefix(r.URL.Path, "/openapi") {
+			http.NotFound(w, r)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/newns" && r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		case r.URL.Path == "/api/v1/namespaces/oldns" && r.Method == "GET":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"oldns"}}`)
+		case r.URL.Path == "/api/v1/namespaces" && r.Method == "POST":
+			body, _ := ioutil.ReadAll(r.Body)
+			if strings.TrimSpace(string(body)) != `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"newns"}}` {
+				t.Errorf("Unexpected namespace %s", body)
+			}
+			w.Write(body)
+		case r.URL.Path == "/api/v1/namespaces/newns/configmaps/fail" && r.Method == "PATCH":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Invalid","code":422}`)
+		case r.URL.Path == "/api/v1/namespaces/newns" && r.Method == "DELETE":
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		case r.Method == "PATCH" || (r.Method == "GET" && strings.Contains(r.URL.Path, "/configmaps/")):
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config"}}`)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := &rest.Config{Host: srv.URL}
+	disco, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := UpdateCmd{
+		ClientPool:       dynamic.NewDynamicClientPool(config),
+		Discovery:        disco,
+		DefaultNamespace: "default",
+		CreateNamespace:  true,
+	}
+
+	obj := func(kind, ns, name string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetAPIVersion("v1")
+		o.SetKind(kind)
+		o.SetNamespace(ns)
+		o.SetName(name)
+		return o
+	}
+	objs := []*unstructured.Unstructured{
+		obj("ConfigMap", "newns", "a"),
+		obj("ConfigMap", "newns", "b"),
+		obj("ConfigMap", "oldns", "c"),
+		obj("ConfigMap", "", "d"),
+		obj("PersistentVolume", "", "e"),
+	}
+
+	if err := c.Run(objs); err != nil {
+		t.Fatal(err)
+	}
+	// Namespaces are created before the wave of objects that
+	// needs them is applied
+	expected := []string{
+		"PATCH /api/v1/persistentvolumes/e",
+		"GET /api/v1/namespaces/newns",
+		"POST /api/v1/namespaces",
+		"GET /api/v1/namespaces/oldns",
+		"PATCH /api/v1/namespaces/default/configmaps/d",
+		"PATCH /api/v1/namespaces/newns/configmaps/a",
+		"PATCH /api/v1/namespaces/newns/configmaps/b",
+		"PATCH /api/v1/namespaces/oldns/configmaps/c",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+
+	requests = nil
+	c.DryRun = true
+	if err := c.Run([]*unstructured.Unstructured{obj("ConfigMap", "newns", "a")}); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"GET /api/v1/namespaces/newns",
+		"GET /api/v1/namespaces/newns/configmaps/a",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Unexpected dry-run requests %v", requests)
+	}
+	// --atomic deletes the namespace it created
+	requests = nil
+	c.DryRun = false
+	c.Atomic = true
+	err = c.Run([]*unstructured.Unstructured{obj("ConfigMap", "newns", "fail")})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Expected rolled back error, got %v", err)
+	}
+	expected = []string{
+		"GET /api/v1/namespaces/newns",
+		"POST /api/v1/namespaces",
+		"GET /api/v1/namespaces/newns/configmaps/fail",
+		"PATCH /api/v1/namespaces/newns/configmaps/fail",
+		"DELETE /api/v1/namespaces/newns",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Unexpected atomic requests %v", requests)
+	}
+}
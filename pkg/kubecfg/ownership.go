@@ -0,0 +1,218 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+const (
+	// FieldManager is the field manager name recorded by the server
+	// for kubecfg requests (derived from the User-Agent).
+	FieldManager = "kubecfg"
+
+	// AnnotationLastApplied is the annotation used by `kubectl
+	// apply` to record the last applied configuration.
+	AnnotationLastApplied = "kubectl.kubernetes.io/last-applied-configuration"
+
+	// lastAppliedManager is the pseudo-manager reported for fields
+	// recorded in AnnotationLastApplied.
+	lastAppliedManager = "kubectl (last-applied-configuration)"
+)
+
+// Fields that identify an object, rather than configure it
+var identityFields = [][]string{
+	{"apiVersion"},
+	{"kind"},
+	{"metadata", "name"},
+	{"metadata", "namespace"},
+}
+
+// checkOwnership fetches the live version of each existing object,
+// and warns about any fields in the object that are currently owned
+// by another field manager.  Returns an error if any conflicts were
+// found and strict is true.
+func checkOwnership(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, objs []*unstructured.Unstructured, defNs string, strict bool) error {
+	conflicts := 0
+	for _, obj := range objs {
+		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(disco, obj), utils.FqName(obj))
+
+		rc, err := utils.ClientForResource(pool, disco, obj, defNs)
+		if err != nil {
+			// Probably a kind that doesn't exist yet
+			log.Debugf("Skipping ownership check for %s: %v", desc, err)
+			continue
+		}
+
+		live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error fetching %s: %v", desc, err)
+		}
+
+		if managers := conflictingManagers(live, obj); len(managers) > 0 {
+			conflicts++
+			log.Warnf("%s has fields owned by other managers: %s", desc, strings.Join(managers, ", "))
+		}
+	}
+
+	if strict && conflicts > 0 {
+		return fmt.Errorf("Found %d object(s) with fields owned by other managers", conflicts)
+	}
+	return nil
+}
+
+// conflictingManagers returns the (sorted) names of managers other
+// than kubecfg that own fields of live that obj would set.
+func conflictingManagers(live, obj *unstructured.Unstructured) []string {
+	wanted := leafPaths(nil, obj.Object, nil)
+
+	owners := map[string][][]string{}
+	for _, mf := range managedFields(live) {
+		if mf.manager == FieldManager || strings.HasPrefix(mf.manager, FieldManager+"/") {
+			continue
+		}
+		owners[mf.manager] = append(owners[mf.manager], mf.paths...)
+	}
+
+	if data, ok := live.GetAnnotations()[AnnotationLastApplied]; ok {
+		var lastApplied map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &lastApplied); err != nil {
+			log.Debugf("Ignoring unparseable %s annotation: %v", AnnotationLastApplied, err)
+		} else {
+			owners[lastAppliedManager] = leafPaths(nil, lastApplied, nil)
+		}
+	}
+
+	ret := sets.NewString()
+	for manager, paths := range owners {
+		if pathsOverlap(wanted, paths) {
+			ret.Insert(manager)
+		}
+	}
+	return ret.List()
+}
+
+type managedFieldsEntry struct {
+	manager string
+	paths   [][]string
+}
+
+// managedFields decodes the FieldsV1 managedFields of obj
+func managedFields(obj *unstructured.Unstructured) []managedFieldsEntry {
+	metadata, _ := obj.Object["metadata"].(map[string]interface{})
+	entries, _ := metadata["managedFields"].([]interface{})
+
+	ret := []managedFieldsEntry{}
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		manager, _ := entry["manager"].(string)
+		fields, _ := entry["fieldsV1"].(map[string]interface{})
+		ret = append(ret, managedFieldsEntry{
+			manager: manager,
+			paths:   fieldsV1Paths(nil, fields, nil),
+		})
+	}
+	return ret
+}
+
+// fieldsV1Paths flattens a FieldsV1 set into a list of paths.
+// Associative list items (`k:`, `v:`, `i:` keys) are treated as
+// ownership of the entire list.
+func fieldsV1Paths(prefix []string, fields map[string]interface{}, ret [][]string) [][]string {
+	leaf := true
+	for k, v := range fields {
+		if !strings.HasPrefix(k, "f:") {
+			continue
+		}
+		leaf = false
+		path := append(append([]string{}, prefix...), strings.TrimPrefix(k, "f:"))
+		child, _ := v.(map[string]interface{})
+		ret = fieldsV1Paths(path, child, ret)
+	}
+	if leaf && len(prefix) > 0 {
+		ret = append(ret, prefix)
+	}
+	return ret
+}
+
+// leafPaths returns the paths of all non-object values within obj,
+// excluding identifying fields and managedFields.
+func leafPaths(prefix []string, obj map[string]interface{}, ret [][]string) [][]string {
+	for k, v := range obj {
+		path := append(append([]string{}, prefix...), k)
+		if isIdentityField(path) || (len(path) == 2 && path[0] == "metadata" && path[1] == "managedFields") {
+			continue
+		}
+		if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+			ret = leafPaths(path, child, ret)
+		} else {
+			ret = append(ret, path)
+		}
+	}
+	return ret
+}
+
+func isIdentityField(path []string) bool {
+	for _, f := range identityFields {
+		if pathHasPrefix(path, f) && len(path) == len(f) {
+			return true
+		}
+	}
+	return false
+}
+
+func pathHasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pathsOverlap returns true if any path in a is equal to, or
+// contains/is contained by, any path in b.
+func pathsOverlap(a, b [][]string) bool {
+	for _, p := range a {
+		for _, q := range b {
+			if pathHasPrefix(p, q) || pathHasPrefix(q, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
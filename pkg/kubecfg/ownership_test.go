@@ -0,0 +1,127 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func mustUnstructured(t *testing.T, data string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(data), &obj.Object); err != nil {
+		t.Fatalf("Failed to parse %s: %v", data, err)
+	}
+	return obj
+}
+
+func TestConflictingManagers(t *testing.T) {
+	obj := mustUnstructured(t, `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {"name": "myapp", "namespace": "default", "labels": {"app": "myapp"}},
+  "spec": {"replicas": 2}
+}`)
+
+	kubectlOwned := mustUnstructured(t, `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {
+    "name": "myapp",
+    "namespace": "default",
+    "annotations": {
+      "kubectl.kubernetes.io/last-applied-configuration": "{\"apiVersion\":\"apps/v1\",\"kind\":\"Deployment\",\"metadata\":{\"name\":\"myapp\"},\"spec\":{\"replicas\":3}}"
+    },
+    "managedFields": [
+      {
+        "manager": "kubectl-client-side-apply",
+        "operation": "Update",
+        "fieldsType": "FieldsV1",
+        "fieldsV1": {"f:spec": {"f:replicas": {}}}
+      },
+      {
+        "manager": "kube-controller-manager",
+        "operation": "Update",
+        "fieldsType": "FieldsV1",
+        "fieldsV1": {"f:status": {"f:replicas": {}}}
+      }
+    ]
+  },
+  "spec": {"replicas": 3}
+}`)
+
+	managers := conflictingManagers(kubectlOwned, obj)
+	expected := []string{"kubectl (last-applied-configuration)", "kubectl-client-side-apply"}
+	if !reflect.DeepEqual(managers, expected) {
+		t.Errorf("Expected %v, got %v", expected, managers)
+	}
+
+	kubecfgOwned := mustUnstructured(t, `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {
+    "name": "myapp",
+    "namespace": "default",
+    "labels": {"app": "myapp"},
+    "managedFields": [
+      {
+        "manager": "kubecfg",
+        "operation": "Update",
+        "fieldsType": "FieldsV1",
+        "fieldsV1": {
+          "f:metadata": {"f:labels": {".": {}, "f:app": {}}},
+          "f:spec": {"f:replicas": {}}
+        }
+      },
+      {
+        "manager": "kube-controller-manager",
+        "operation": "Update",
+        "fieldsType": "FieldsV1",
+        "fieldsV1": {"f:status": {"f:replicas": {}}}
+      }
+    ]
+  },
+  "spec": {"replicas": 2}
+}`)
+
+	if managers := conflictingManagers(kubecfgOwned, obj); len(managers) != 0 {
+		t.Errorf("Expected no conflicts, got %v", managers)
+	}
+}
+
+func TestFieldsV1Paths(t *testing.T) {
+	fields := map[string]interface{}{
+		"f:spec": map[string]interface{}{
+			"f:template": map[string]interface{}{
+				"f:spec": map[string]interface{}{
+					"f:containers": map[string]interface{}{
+						`k:{"name":"app"}`: map[string]interface{}{
+							"f:image": map[string]interface{}{},
+						},
+					},
+				},
+			},
+		},
+	}
+	paths := fieldsV1Paths(nil, fields, nil)
+	expected := [][]string{{"spec", "template", "spec", "containers"}}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-openapi/spec"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// patchTypeChooser selects the patch type used to update each
+// object: a strategic merge patch for built-in kinds whose OpenAPI
+// schema has patch strategy metadata, so lists with merge keys such as
+// containers are merged, and a JSON merge patch otherwise (eg: custom
+// resources).  The schema is only fetched when first needed.  A nil
+// *patchTypeChooser always chooses a JSON merge patch.
+type patchTypeChooser struct {
+	disco discovery.OpenAPISchemaInterface
+
+	once sync.Once
+	doc  *spec.Swagger
+	mu   sync.Mutex
+	// keys holds the result of utils.PatchMergeKeys for each
+	// kind.  nil means the kind gets a JSON merge patch.
+	keys map[schema.GroupVersionKind]map[string]string
+}
+
+func newPatchTypeChooser(disco discovery.OpenAPISchemaInterface) *patchTypeChooser {
+	return &patchTypeChooser{
+		disco: disco,
+		keys:  map[schema.GroupVersionKind]map[string]string{},
+	}
+}
+
+// mergeKeys returns the merged lists of kind gvk (see
+// utils.PatchMergeKeys), or nil if gvk doesn't support strategic
+// merge patches
+func (p *patchTypeChooser) mergeKeys(gvk schema.GroupVersionKind) map[string]string {
+	if p == nil {
+		return nil
+	}
+
+	p.once.Do(func() {
+		doc, err := p.disco.OpenAPISchema()
+		if err != nil {
+			log.Debugf("Unable to fetch OpenAPI schema, using merge patches: %v", err)
+			return
+		}
+		p.doc = doc
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys, ok := p.keys[gvk]
+	if !ok {
+		keys = utils.PatchMergeKeys(p.doc, gvk)
+		pt := types.MergePatchType
+		if keys != nil {
+			pt = types.StrategicMergePatchType
+		}
+		log.Debugf("Using %s patches for %s", pt, gvk)
+		p.keys[gvk] = keys
+	}
+	return keys
+}
+
+// disable makes gvk get JSON merge patches, after the server
+// rejected a strategic merge patch
+func (p *patchTypeChooser) disable(gvk schema.GroupVersionKind) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	log.Debugf("Server does not support %s patches for %s, using %s patches", types.StrategicMergePatchType, gvk, types.MergePatchType)
+	p.keys[gvk] = nil
+}
+
+// For returns the patch type to use to update live (nil if unknown)
+// to config.
+//
+// A strategic merge patch of the whole config doesn't remove items
+// that were deleted from a merged list, so a JSON merge patch (which
+// replaces lists) is used if any merged list in live has items that
+// config doesn't.  Items that are missing from the config last
+// applied by kubectl (see AnnotationLastApplied) were added by
+// someone else, eg: an injected sidecar container, and are kept.
+func (p *patchTypeChooser) For(config, live *unstructured.Unstructured) types.PatchType {
+	keys := p.mergeKeys(config.GroupVersionKind())
+	if keys == nil || live == nil {
+		return types.MergePatchType
+	}
+	lastApplied, haveApplied := lastAppliedConfig(live)
+	removed := map[string]bool{}
+	removedListItems(keys, nil, config.Object, live.Object, lastApplied, haveApplied, removed)
+	if len(removed) > 0 {
+		paths := make([]string, 0, len(removed))
+		for p := range removed {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		log.Debugf("Using %s patch for %s, since items may have been removed from %s", types.MergePatchType, config.GetName(), strings.Join(paths, ", "))
+		return types.MergePatchType
+	}
+	return types.StrategicMergePatchType
+}
+
+// listItemKey returns the merge key value that identifies item, or
+// item itself for lists of primitives
+func listItemKey(item interface{}, mergeKey string) string {
+	if mergeKey == "" {
+		return fmt.Sprint(item)
+	}
+	m, _ := item.(map[string]interface{})
+	return fmt.Sprint(m[mergeKey])
+}
+
+// listItems indexes the items of list (if it is a list) by their
+// merge key
+func listItems(list interface{}, mergeKey string) map[string]interface{} {
+	ret := map[string]interface{}{}
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		ret[listItemKey(item, mergeKey)] = item
+	}
+	return ret
+}
+
+// removedListItems adds to ret the dotted paths of the merged lists
+// (see utils.PatchMergeKeys) in live, at or below path, that have
+// items that config doesn't and that applied (the last applied
+// config) did.  If haveApplied is false, the last applied config is
+// unknown and any such item counts.
+func removedListItems(keys map[string]string, path []string, config, live, applied interface{}, haveApplied bool, ret map[string]bool) {
+	switch c := config.(type) {
+	case map[string]interface{}:
+		l, _ := live.(map[string]interface{})
+		a, _ := applied.(map[string]interface{})
+		for k, v := range c {
+			removedListItems(keys, append(path[:len(path):len(path)], k), v, l[k], a[k], haveApplied, ret)
+		}
+	case []interface{}:
+		mergeKey, ok := keys[strings.Join(path, ".")]
+		if !ok {
+			// Replaced by the patch
+			return
+		}
+		configItems := listItems(c, mergeKey)
+		appliedItems := listItems(applied, mergeKey)
+		liveItems := listItems(live, mergeKey)
+		for k := range liveItems {
+			if _, ok := configItems[k]; ok {
+				continue
+			}
+			if _, ok := appliedItems[k]; ok || !haveApplied {
+				ret[strings.Join(path, ".")] = true
+			}
+		}
+		for k, item := range configItems {
+			removedListItems(keys, path, item, liveItems[k], appliedItems[k], haveApplied, ret)
+		}
+	}
+}
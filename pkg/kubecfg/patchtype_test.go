@@ -0,0 +1,275 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// The relevant parts of the OpenAPI schema of a server with a
+// CronTab custom resource
+const patchTypeOpenAPI = `{
+  "swagger": "2.0",
+  "definitions": {
+    "io.k8s.api.apps.v1.Deployment": {
+      "properties": {"spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DeploymentSpec"}},
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "Deployment", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.DeploymentSpec": {
+      "properties": {"template": {"$ref": "#/definitions/io.k8s.api.core.v1.PodTemplateSpec"}}
+    },
+    "io.k8s.api.core.v1.PodTemplateSpec": {
+      "properties": {"spec": {"$ref": "#/definitions/io.k8s.api.core.v1.PodSpec"}}
+    },
+    "io.k8s.api.core.v1.PodSpec": {
+      "properties": {
+        "containers": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Container"},
+          "x-kubernetes-patch-merge-key": "name",
+          "x-kubernetes-patch-strategy": "merge"
+        }
+      }
+    },
+    "io.k8s.api.core.v1.Container": {
+      "properties": {"name": {"type": "string"}, "image": {"type": "string"}}
+    },
+    "com.example.stable.v1.CronTab": {
+      "properties": {"spec": {"type": "object", "properties": {"containers": {"type": "array"}}}},
+      "x-kubernetes-group-version-kind": [{"group": "stable.example.com", "kind": "CronTab", "version": "v1"}]
+    }
+  }
+}`
+
+// openAPIDiscovery is a FakeDiscovery that serves an OpenAPI schema
+type openAPIDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	doc *spec.Swagger
+}
+
+func (d openAPIDiscovery) OpenAPISchema() (*spec.Swagger, error) {
+	return d.doc, nil
+}
+
+func patchTestObject(apiVersion, kind, name string, containers ...string) *unstructured.Unstructured {
+	list := []interface{}{}
+	for _, c := range containers {
+		list = append(list, map[string]interface{}{"name": c, "image": c + ":v2"})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"containers": list},
+			},
+		},
+	}}
+}
+
+// withLastApplied records obj's last applied config (by kubectl)
+// as a config with the given containers
+func withLastApplied(obj *unstructured.Unstructured, containers ...string) *unstructured.Unstructured {
+	applied := patchTestObject(obj.GetAPIVersion(), obj.GetKind(), obj.GetName(), containers...)
+	data, err := json.Marshal(applied.Object)
+	if err != nil {
+		panic(err)
+	}
+	obj.SetAnnotations(map[string]string{AnnotationLastApplied: string(data)})
+	return obj
+}
+
+// applyPatch is a minimal server-side patch implementation, that
+// only understands the merge key of containers
+func applyPatch(live, patch map[string]interface{}, pt types.PatchType) {
+	liveSpec, _ := fieldAt(live, []string{"spec", "template", "spec"})
+	patchContainers, _ := fieldAt(patch, []string{"spec", "template", "spec", "containers"})
+	patched := patchContainers.([]interface{})
+	if pt == types.StrategicMergePatchType {
+		liveContainers, _ := fieldAt(live, []string{"spec", "template", "spec", "containers"})
+		patched = []interface{}{}
+		names := map[string]bool{}
+		for _, c := range patchContainers.([]interface{}) {
+			names[c.(map[string]interface{})["name"].(string)] = true
+		}
+		for _, c := range liveContainers.([]interface{}) {
+			if !names[c.(map[string]interface{})["name"].(string)] {
+				patched = append(patched, c)
+			}
+		}
+		patched = append(patched, patchContainers.([]interface{})...)
+	}
+	liveSpec.(map[string]interface{})["containers"] = patched
+}
+
+// patchTestServer serves GETs and PATCHes of the live objects, and
+// records the type of each patch.  Strategic merge patches of the
+// paths in reject fail with 415 Unsupported Media Type.
+func patchTestServer(t *testing.T, live map[string]*unstructured.Unstructured, patchTypes map[string]string, reject map[string]bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		obj, ok := live[r.URL.Path]
+		if !ok || (r.Method != "PATCH" && r.Method != "GET") {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "PATCH" {
+			pt := r.Header.Get("Content-Type")
+			if pt == string(types.StrategicMergePatchType) && reject[r.URL.Path] {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				fmt.Fprintf(w, `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "UnsupportedMediaType", "code": %d}`, http.StatusUnsupportedMediaType)
+				return
+			}
+			patchTypes[r.URL.Path] = pt
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var patch map[string]interface{}
+			if err := json.Unmarshal(data, &patch); err != nil {
+				t.Fatal(err)
+			}
+			applyPatch(obj.Object, patch, types.PatchType(pt))
+		}
+		buf, _ := json.Marshal(obj.Object)
+		fmt.Fprint(w, string(buf))
+	}))
+}
+
+func patchTestCmd(t *testing.T, srv *httptest.Server) UpdateCmd {
+	var doc spec.Swagger
+	if err := json.Unmarshal([]byte(patchTypeOpenAPI), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: openAPIDiscovery{
+			FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+				Resources: []*metav1.APIResourceList{
+					{
+						GroupVersion: "apps/v1",
+						APIResources: []metav1.APIResource{{Name: "deployments", Kind: "Deployment", Namespaced: true}},
+					},
+					{
+						GroupVersion: "stable.example.com/v1",
+						APIResources: []metav1.APIResource{{Name: "crontabs", Kind: "CronTab", Namespaced: true}},
+					},
+				},
+			}},
+			doc: &doc,
+		},
+		DefaultNamespace: "default",
+	}
+}
+
+func liveContainers(t *testing.T, obj *unstructured.Unstructured) []string {
+	containers, _ := fieldAt(obj.Object, []string{"spec", "template", "spec", "containers"})
+	var ret []string
+	for _, c := range containers.([]interface{}) {
+		ret = append(ret, c.(map[string]interface{})["name"].(string))
+	}
+	return ret
+}
+
+func TestUpdateStrategicMergePatch(t *testing.T) {
+	const (
+		injected = "/apis/apps/v1/namespaces/default/deployments/injected"
+		removed  = "/apis/apps/v1/namespaces/default/deployments/removed"
+		unknown  = "/apis/apps/v1/namespaces/default/deployments/unknown"
+		crontab  = "/apis/stable.example.com/v1/namespaces/default/crontabs/cron"
+	)
+	live := map[string]*unstructured.Unstructured{
+		// istio-proxy was injected, not applied
+		injected: withLastApplied(patchTestObject("apps/v1", "Deployment", "injected", "app", "istio-proxy"), "app"),
+		// old was applied, and has since been removed from config
+		removed: withLastApplied(patchTestObject("apps/v1", "Deployment", "removed", "app", "old"), "app", "old"),
+		// No record of what was applied
+		unknown: patchTestObject("apps/v1", "Deployment", "unknown", "app", "istio-proxy"),
+		crontab: patchTestObject("stable.example.com/v1", "CronTab", "cron", "app", "istio-proxy"),
+	}
+	patchTypes := map[string]string{}
+	srv := patchTestServer(t, live, patchTypes, nil)
+	defer srv.Close()
+
+	// The config adds a container, and doesn't mention the
+	// istio-proxy or old containers
+	err := patchTestCmd(t, srv).Run([]*unstructured.Unstructured{
+		patchTestObject("apps/v1", "Deployment", "injected", "app", "logger"),
+		patchTestObject("apps/v1", "Deployment", "removed", "app", "logger"),
+		patchTestObject("apps/v1", "Deployment", "unknown", "app", "logger"),
+		patchTestObject("stable.example.com/v1", "CronTab", "cron", "app", "logger"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]struct {
+		patchType  types.PatchType
+		containers []string
+	}{
+		injected: {types.StrategicMergePatchType, []string{"istio-proxy", "app", "logger"}},
+		removed:  {types.MergePatchType, []string{"app", "logger"}},
+		unknown:  {types.MergePatchType, []string{"app", "logger"}},
+		crontab:  {types.MergePatchType, []string{"app", "logger"}},
+	} {
+		if pt := patchTypes[path]; pt != string(expected.patchType) {
+			t.Errorf("%s: expected %s patch, got %s", path, expected.patchType, pt)
+		}
+		if got := liveContainers(t, live[path]); fmt.Sprint(got) != fmt.Sprint(expected.containers) {
+			t.Errorf("%s: expected containers %v, got %v", path, expected.containers, got)
+		}
+	}
+}
+
+func TestUpdateStrategicMergePatchUnsupported(t *testing.T) {
+	const path = "/apis/apps/v1/namespaces/default/deployments/myapp"
+	live := map[string]*unstructured.Unstructured{
+		path: withLastApplied(patchTestObject("apps/v1", "Deployment", "myapp", "app", "istio-proxy"), "app"),
+	}
+	patchTypes := map[string]string{}
+	srv := patchTestServer(t, live, patchTypes, map[string]bool{path: true})
+	defer srv.Close()
+
+	c := patchTestCmd(t, srv)
+	err := c.Run([]*unstructured.Unstructured{
+		patchTestObject("apps/v1", "Deployment", "myapp", "app", "logger"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt := patchTypes[path]; pt != string(types.MergePatchType) {
+		t.Errorf("Expected fallback to %s patch, got %s", types.MergePatchType, pt)
+	}
+	if got := liveContainers(t, live[path]); fmt.Sprint(got) != "[app logger]" {
+		t.Errorf("Unexpected containers %v", got)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+var pdbPollInterval = 2 * time.Second
+
+var pdbGVK = schema.GroupVersionKind{Group: "policy", Version: "v1beta1", Kind: "PodDisruptionBudget"}
+
+// pdbStatus is the disruption budget state of a single PDB
+type pdbStatus struct {
+	name           string
+	currentHealthy int64
+	desiredHealthy int64
+}
+
+func (s pdbStatus) violated() bool {
+	return s.currentHealthy < s.desiredHealthy
+}
+
+// rolloutWorkloads are the kinds whose rollouts are gated on
+// PodDisruptionBudgets
+var rolloutWorkloads = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"ReplicaSet":  true,
+}
+
+// matchingPDBs returns the names of the PodDisruptionBudgets in
+// namespace ns that select pods with the given labels
+func matchingPDBs(pool dynamic.ClientPool, ns string, podLabels map[string]string) ([]string, error) {
+	client, err := pool.ClientForGroupVersionKind(pdbGVK)
+	if err != nil {
+		return nil, err
+	}
+	rsrc := metav1.APIResource{Name: "poddisruptionbudgets", Kind: pdbGVK.Kind, Namespaced: true}
+	list, err := client.Resource(&rsrc, ns).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing PodDisruptionBudgets in %s: %v", ns, err)
+	}
+	ulist, ok := list.(*unstructured.UnstructuredList)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected PodDisruptionBudget list type %T", list)
+	}
+
+	var names []string
+	for _, pdb := range ulist.Items {
+		sel, ok := fieldAt(pdb.Object, []string{"spec", "selector"})
+		if !ok {
+			continue
+		}
+		selector, err := parseLabelSelector(sel)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing selector of PodDisruptionBudget %s: %v", pdb.GetName(), err)
+		}
+		if !selector.Empty() && selector.Matches(labels.Set(podLabels)) {
+			names = append(names, pdb.GetName())
+		}
+	}
+	return names, nil
+}
+
+func parseLabelSelector(v interface{}) (labels.Selector, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var ls metav1.LabelSelector
+	if err := json.Unmarshal(buf, &ls); err != nil {
+		return nil, err
+	}
+	return metav1.LabelSelectorAsSelector(&ls)
+}
+
+func fetchPDBStatus(rc *dynamic.ResourceClient, name string) (pdbStatus, error) {
+	pdb, err := rc.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return pdbStatus{}, err
+	}
+	s := pdbStatus{name: name}
+	s.currentHealthy, _ = intField(pdb.Object, []string{"status", "currentHealthy"})
+	s.desiredHealthy, _ = intField(pdb.Object, []string{"status", "desiredHealthy"})
+	return s, nil
+}
+
+// rolloutComplete returns true once the controller has observed the
+// latest spec of workload, and all of its pods are updated and
+// available
+func rolloutComplete(workload *unstructured.Unstructured) bool {
+	obj := workload.Object
+	generation, _ := intField(obj, []string{"metadata", "generation"})
+	if observed, ok := intField(obj, []string{"status", "observedGeneration"}); !ok || observed < generation {
+		return false
+	}
+
+	status := func(field string) int64 {
+		n, _ := intField(obj, []string{"status", field})
+		return n
+	}
+	if workload.GetKind() == "DaemonSet" {
+		desired := status("desiredNumberScheduled")
+		return status("updatedNumberScheduled") >= desired && status("numberAvailable") >= desired
+	}
+
+	replicas, ok := intField(obj, []string{"spec", "replicas"})
+	if !ok {
+		replicas = 1
+	}
+	available := status("availableReplicas")
+	if workload.GetKind() == "StatefulSet" {
+		// availableReplicas is only reported by newer servers
+		available = status("readyReplicas")
+	}
+	return status("updatedReplicas") >= replicas && available >= replicas
+}
+
+// waitForPDBs waits for the rollout of workload to complete, warning
+// whenever a PodDisruptionBudget covering its pods has fewer healthy
+// pods than it requires.  An error is returned if the rollout does not
+// complete within timeout; the error names any PDB still violated.
+func waitForPDBs(logger log.FieldLogger, pool dynamic.ClientPool, rc *dynamic.ResourceClient, workload *unstructured.Unstructured, desc string, timeout time.Duration) error {
+	podLabels := map[string]string{}
+	if l, ok := fieldAt(workload.Object, []string{"spec", "template", "metadata", "labels"}); ok {
+		m, _ := l.(map[string]interface{})
+		for k, v := range m {
+			podLabels[k] = fmt.Sprint(v)
+		}
+	}
+	ns := workload.GetNamespace()
+	names, err := matchingPDBs(pool, ns, podLabels)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		logger.Debugf("No PodDisruptionBudgets cover %s", desc)
+		return nil
+	}
+
+	client, err := pool.ClientForGroupVersionKind(pdbGVK)
+	if err != nil {
+		return err
+	}
+	pdbs := client.Resource(&metav1.APIResource{Name: "poddisruptionbudgets", Kind: pdbGVK.Kind, Namespaced: true}, ns)
+
+	logger.Infof(" Waiting for rollout of %s, gated on PodDisruptionBudgets %v", desc, names)
+	warned := map[string]bool{}
+	var violations []pdbStatus
+	err = wait.PollImmediate(pdbPollInterval, timeout, func() (bool, error) {
+		violations = nil
+		for _, name := range names {
+			s, err := fetchPDBStatus(pdbs, name)
+			if err != nil {
+				return false, err
+			}
+			if s.violated() {
+				violations = append(violations, s)
+				if !warned[name] {
+					logger.Warningf("Rollout of %s is violating PodDisruptionBudget %s: %d healthy pods, %d required", desc, name, s.currentHealthy, s.desiredHealthy)
+					warned[name] = true
+				}
+			} else if warned[name] {
+				logger.Infof(" PodDisruptionBudget %s is satisfied again", name)
+				warned[name] = false
+			}
+		}
+
+		live, err := rc.Get(workload.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return len(violations) == 0 && rolloutComplete(live), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		if len(violations) > 0 {
+			s := violations[0]
+			return fmt.Errorf("Rollout of %s violates PodDisruptionBudget %s: %d healthy pods, %d required", desc, s.name, s.currentHealthy, s.desiredHealthy)
+		}
+		return fmt.Errorf("Timed out waiting for rollout of %s", desc)
+	} else if err != nil {
+		return fmt.Errorf("Error waiting for rollout of %s: %v", desc, err)
+	}
+	return nil
+}
+
+// gateOnPDBs is waitForPDBs for any object, which is a no-op for
+// objects that are not workloads
+func gateOnPDBs(logger log.FieldLogger, pool dynamic.ClientPool, rc *dynamic.ResourceClient, obj metav1.Object, desc string, timeout time.Duration) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || !rolloutWorkloads[u.GetKind()] {
+		return nil
+	}
+	return waitForPDBs(logger, pool, rc, u, desc, timeout)
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestUpdatePDBGate(t *testing.T) {
+	defer func(d time.Duration) { pdbPollInterval = d }(pdbPollInterval)
+	pdbPollInterval = time.Millisecond
+
+	const deployment = `{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"web","namespace":"default","generation":2},
+  "spec":{"replicas":3,"template":{"metadata":{"labels":{"app":"web"}}}},
+  "status":{"observedGeneration":2,"replicas":3,"updatedReplicas":3,"availableReplicas":%d}}`
+	const pdb = `{"apiVersion":"policy/v1beta1","kind":"PodDisruptionBudget","metadata":{"name":"%s","namespace":"default"},
+  "spec":{"selector":{"matchLabels":{"app":"%s"}}},
+  "status":{"currentHealthy":%d,"desiredHealthy":2}}`
+
+	healthy := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/apps/v1beta1/namespaces/default/deployments/web":
+			fmt.Fprintf(w, deployment, healthy)
+		case "/apis/policy/v1beta1/namespaces/default/poddisruptionbudgets":
+			fmt.Fprintf(w, `{"apiVersion":"policy/v1beta1","kind":"PodDisruptionBudgetList","items":[%s,%s]}`,
+				fmt.Sprintf(pdb, "web-pdb", "web", healthy), fmt.Sprintf(pdb, "other-pdb", "other", 0))
+		case "/apis/policy/v1beta1/namespaces/default/poddisruptionbudgets/web-pdb":
+			fmt.Fprintf(w, pdb, "web-pdb", "web", healthy)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "apps/v1beta1",
+					APIResources: []metav1.APIResource{
+						{Name: "deployments", Kind: "Deployment", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		PDBGate:          true,
+		PDBGateTimeout:   20 * time.Millisecond,
+	}
+	objs := func() []*unstructured.Unstructured {
+		return []*unstructured.Unstructured{mustUnstructured(t, `{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"web"},
+  "spec":{"replicas":3,"template":{"metadata":{"labels":{"app":"web"}}}}}`)}
+	}
+
+	err := c.Run(objs())
+	if err == nil || !strings.Contains(err.Error(), "violates PodDisruptionBudget web-pdb: 1 healthy pods, 2 required") {
+		t.Errorf("Expected PDB violation error, got %v", err)
+	}
+
+	healthy = 3
+	if err := c.Run(objs()); err != nil {
+		t.Errorf("Rollout within disruption budget failed: %v", err)
+	}
+}
+
+func TestRolloutComplete(t *testing.T) {
+	for _, test := range []struct {
+		obj      string
+		complete bool
+	}{
+		{`{"kind":"Deployment","metadata":{"generation":2},"spec":{"replicas":2},"status":{"observedGeneration":1,"updatedReplicas":2,"availableReplicas":2}}`, false},
+		{`{"kind":"Deployment","metadata":{"generation":2},"spec":{"replicas":2},"status":{"observedGeneration":2,"updatedReplicas":1,"availableReplicas":2}}`, false},
+		{`{"kind":"Deployment","metadata":{"generation":2},"spec":{"replicas":2},"status":{"observedGeneration":2,"updatedReplicas":2,"availableReplicas":2}}`, true},
+		{`{"kind":"StatefulSet","metadata":{"generation":1},"status":{"observedGeneration":1,"updatedReplicas":1,"readyReplicas":1}}`, true},
+		{`{"kind":"DaemonSet","metadata":{"generation":1},"status":{"observedGeneration":1,"desiredNumberScheduled":3,"updatedNumberScheduled":3,"numberAvailable":2}}`, false},
+	} {
+		if got := rolloutComplete(mustUnstructured(t, test.obj)); got != test.complete {
+			t.Errorf("rolloutComplete(%s) = %v", test.obj, got)
+		}
+	}
+}
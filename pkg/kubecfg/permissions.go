@@ -0,0 +1,75 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// checkPermissions checks that the current user may perform each
+// of verbs on the resources of objs, in their namespaces, and
+// returns an error listing everything they lack.  Each resource and
+// namespace is only checked once.
+func checkPermissions(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, objs []*unstructured.Unstructured, defNs string, verbs []string) error {
+	mapper, err := utils.RESTMapperFor(disco)
+	if err != nil {
+		return err
+	}
+
+	seen := sets.NewString()
+	var lacking []string
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		ns := ""
+		if mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			// Leave the error for the update itself to report
+			log.Debugf("No REST mapping for %s, not checking permissions: %v", gvk, err)
+			continue
+		} else if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			ns = namespaceOf(obj, defNs)
+		}
+
+		for _, verb := range verbs {
+			key := fmt.Sprintf("%s %s %s", gvk.GroupKind(), ns, verb)
+			if seen.Has(key) {
+				continue
+			}
+			seen.Insert(key)
+
+			err := utils.CanIAccess(pool, disco, gvk, ns, verb)
+			if utils.IsPermissionError(err) {
+				lacking = append(lacking, err.Error())
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(lacking) > 0 {
+		return fmt.Errorf("Insufficient permissions for update: %s", strings.Join(lacking, "; "))
+	}
+	return nil
+}
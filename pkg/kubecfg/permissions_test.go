@@ -0,0 +1,95 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestUpdateCheckPermissions(t *testing.T) {
+	reviews := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+				{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["create","get","patch"]},
+				{"name":"persistentvolumes","kind":"PersistentVolume","namespaced":false,"verbs":["create","get","patch"]}]}`)
+		case "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews":
+			reviews++
+			var review map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				t.Error(err)
+			}
+			attrs := review["spec"].(map[string]interface{})["resourceAttributes"].(map[string]interface{})
+			allowed := attrs["namespace"] != "locked" && !(attrs["resource"] == "persistentvolumes" && attrs["verb"] == "create")
+			review["status"] = map[string]interface{}{"allowed": allowed}
+			json.NewEncoder(w).Encode(review)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := &rest.Config{Host: srv.URL}
+	disco, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := UpdateCmd{
+		ClientPool:       dynamic.NewDynamicClientPool(config),
+		Discovery:        disco,
+		DefaultNamespace: "default",
+		Create:           true,
+		CheckPermissions: true,
+	}
+
+	obj := func(kind, ns, name string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetAPIVersion("v1")
+		o.SetKind(kind)
+		o.SetNamespace(ns)
+		o.SetName(name)
+		return o
+	}
+	err = c.Run([]*unstructured.Unstructured{
+		obj("ConfigMap", "", "a"),
+		obj("ConfigMap", "", "b"),
+		obj("ConfigMap", "locked", "c"),
+		obj("PersistentVolume", "", "d"),
+	})
+	expected := "Insufficient permissions for update: you lack create on persistentvolumes; you lack patch on configmaps in namespace locked; you lack create on configmaps in namespace locked"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+	if reviews != 6 {
+		t.Errorf("Expected each resource, namespace and verb to be reviewed once, got %d reviews", reviews)
+	}
+}
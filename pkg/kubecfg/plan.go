@@ -0,0 +1,256 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yudai/gojsondiff"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// Actions in an update plan
+const (
+	PlanCreate    = "create"
+	PlanUpdate    = "update"
+	PlanDelete    = "delete"
+	PlanUnchanged = "unchanged"
+)
+
+// planColors are the DOT fill colors for each action
+var planColors = map[string]string{
+	PlanCreate:    "green",
+	PlanUpdate:    "yellow",
+	PlanDelete:    "red",
+	PlanUnchanged: "gray",
+}
+
+// PlanCmd represents the plan subcommand
+type PlanCmd struct {
+	ClientPool       dynamic.ClientPool
+	Discovery        discovery.DiscoveryInterface
+	DefaultNamespace string
+
+	DiffStrategy string
+
+	// GcTag, if set, also plans the deletion of live objects
+	// that would be garbage collected by `update --gc-tag`.
+	GcTag string
+
+	// GcFilters are as for UpdateCmd
+	GcFilters []GcFilter
+
+	// KeepStatus is as for DiffCmd
+	KeepStatus bool
+
+	// Graph is the output format: empty for a plain list, or
+	// "dot" for a Graphviz dependency graph.
+	Graph string
+}
+
+// planStep is the action an update would take for a single object
+type planStep struct {
+	desc   string
+	ref    objectRef
+	obj    *unstructured.Unstructured
+	action string
+	// wave is the apply order: objects in a wave depend only on
+	// objects in earlier waves.  Deletes happen last.
+	wave int
+	// deps are the indexes of steps this step depends on
+	deps []int
+}
+
+// planRef identifies o within the plan.  Kinds are not qualified by
+// group, matching the references found in pod specs.
+func planRef(o *unstructured.Unstructured, defNs string) objectRef {
+	ns := ""
+	if o.GetKind() != "Namespace" {
+		ns = namespaceOf(o, defNs)
+	}
+	return objectRef{Kind: o.GetKind(), Namespace: ns, Name: o.GetName()}
+}
+
+// planDeps returns the references from o to objects it should be
+// applied after: its Namespace, and anything its pod spec uses.
+func planDeps(o *unstructured.Unstructured, defNs string) []objectRef {
+	var deps []objectRef
+	if ns := o.GetNamespace(); ns != "" {
+		deps = append(deps, objectRef{Kind: "Namespace", Name: ns})
+	}
+	for _, ref := range podSpecRefs(o) {
+		if ref.Namespace == "" {
+			ref.Namespace = defNs
+		}
+		deps = append(deps, ref)
+	}
+	return deps
+}
+
+// liveAction returns the action an update would take for obj
+func (c PlanCmd) liveAction(obj *unstructured.Unstructured, desc string) (string, error) {
+	client, err := utils.ClientForResource(c.ClientPool, c.Discovery, obj, c.DefaultNamespace)
+	if err != nil {
+		return "", err
+	}
+	liveObj, err := client.Get(obj.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return PlanCreate, nil
+	} else if err != nil {
+		return "", fmt.Errorf("Error fetching %s: %v", desc, err)
+	}
+
+	live := stripServerManaged(liveObj.Object, c.KeepStatus)
+	config := stripServerManaged(obj.Object, c.KeepStatus)
+	switch c.DiffStrategy {
+	case "subset":
+		live = removeMapFields(config, live)
+	case DiffStrategyLastApplied:
+		if managed, ok := lastAppliedFields(config, liveObj); ok {
+			live = removeMapFields(managed, live)
+		}
+	}
+	if gojsondiff.New().CompareObjects(live, config).Modified() {
+		return PlanUpdate, nil
+	}
+	return PlanUnchanged, nil
+}
+
+// plan returns the steps an update of apiObjects would take, in the
+// waves that update would apply them in (see utils.DependencyWaves).
+func (c PlanCmd) plan(apiObjects []*unstructured.Unstructured) ([]planStep, error) {
+	waves, err := utils.DependencyWaves(c.Discovery, apiObjects)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]planStep, 0, len(apiObjects))
+	index := map[objectRef]int{}
+	for wave, objs := range waves {
+		sort.Sort(utils.AlphabeticalOrder(objs))
+		for _, obj := range objs {
+			desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
+			log.Debug("Fetching ", desc)
+			action, err := c.liveAction(obj, desc)
+			if err != nil {
+				return nil, err
+			}
+			ref := planRef(obj, c.DefaultNamespace)
+			index[ref] = len(steps)
+			steps = append(steps, planStep{desc: desc, ref: ref, obj: obj, action: action, wave: wave})
+		}
+	}
+
+	// Only dependencies that the wave order already satisfies are
+	// shown, since update applies each wave in turn and follows no
+	// other ordering.
+	for i := range steps {
+		seen := map[int]bool{}
+		for _, ref := range planDeps(steps[i].obj, c.DefaultNamespace) {
+			if j, ok := index[ref]; ok && steps[j].wave < steps[i].wave && !seen[j] {
+				seen[j] = true
+				steps[i].deps = append(steps[i].deps, j)
+			}
+		}
+	}
+	lastWave := len(waves) - 1
+
+	if c.GcTag != "" {
+		seenUids := sets.NewString()
+		err := walkObjects(c.ClientPool, c.Discovery, metav1.ListOptions{}, func(o runtime.Object) error {
+			meta, err := meta.Accessor(o)
+			if err != nil {
+				return err
+			}
+			u, ok := o.(*unstructured.Unstructured)
+			if !ok {
+				return fmt.Errorf("Unexpected object type %T", o)
+			}
+			if !eligibleForGc(meta, c.GcTag) || seenUids.Has(string(meta.GetUID())) {
+				return nil
+			}
+			if gcKeptBy(meta, c.GcFilters) != "" {
+				return nil
+			}
+			seenUids.Insert(string(meta.GetUID()))
+			// Objects in config are updated, not deleted
+			if _, ok := index[planRef(u, c.DefaultNamespace)]; ok {
+				return nil
+			}
+			desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, o), utils.FqName(meta))
+			steps = append(steps, planStep{desc: desc, ref: planRef(u, c.DefaultNamespace), obj: u, action: PlanDelete, wave: lastWave + 1})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return steps, nil
+}
+
+func (c PlanCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	switch c.Graph {
+	case "", "dot":
+	default:
+		return fmt.Errorf("Unknown graph format: %s", c.Graph)
+	}
+
+	steps, err := c.plan(apiObjects)
+	if err != nil {
+		return err
+	}
+
+	if c.Graph == "dot" {
+		writeDotPlan(out, steps)
+		return nil
+	}
+
+	for _, s := range steps {
+		fmt.Fprintf(out, "wave %d: %s %s\n", s.wave, s.action, s.desc)
+	}
+	return nil
+}
+
+// writeDotPlan writes steps as a Graphviz DOT graph.  Edges point
+// from each object to the objects that depend on it, ie: in apply
+// order.
+func writeDotPlan(out io.Writer, steps []planStep) {
+	fmt.Fprintln(out, "digraph plan {")
+	fmt.Fprintln(out, "  node [shape=box, style=filled];")
+	for i, s := range steps {
+		label := fmt.Sprintf("%s\n%s (wave %d)", s.desc, s.action, s.wave)
+		fmt.Fprintf(out, "  n%d [label=%q, fillcolor=%s];\n", i, label, planColors[s.action])
+	}
+	for i, s := range steps {
+		for _, j := range s.deps {
+			fmt.Fprintf(out, "  n%d -> n%d;\n", j, i)
+		}
+	}
+	fmt.Fprintln(out, "}")
+}
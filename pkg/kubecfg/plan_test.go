@@ -0,0 +1,107 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestPlanGraph(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/namespaces/app":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"app","uid":"1"},"status":{"phase":"Active"}}`)
+		case "/api/v1/namespaces/app/configmaps/cfg":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cfg","namespace":"app","uid":"2"},"data":{"key":"old"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := PlanCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "namespaces", Kind: "Namespace"},
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+				{
+					GroupVersion: "apps/v1beta1",
+					APIResources: []metav1.APIResource{
+						{Name: "deployments", Kind: "Deployment", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		DiffStrategy:     "subset",
+		Graph:            "dot",
+	}
+
+	objs := []*unstructured.Unstructured{
+		mustUnstructured(t, `{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"web","namespace":"app"},
+  "spec":{"template":{"spec":{"containers":[{"name":"web"}],"volumes":[{"name":"cfg","configMap":{"name":"cfg"}}]}}}}`),
+		mustUnstructured(t, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cfg","namespace":"app"},"data":{"key":"new"}}`),
+		mustUnstructured(t, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"app"}}`),
+	}
+
+	var buf bytes.Buffer
+	if err := c.Run(objs, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	// Steps are in the waves update applies them in
+	for _, expected := range []string{
+		`n0 [label="namespaces app\nunchanged (wave 0)", fillcolor=gray];`,
+		`n1 [label="configmaps app.cfg\nupdate (wave 1)", fillcolor=yellow];`,
+		`n2 [label="deployments app.web\ncreate (wave 2)", fillcolor=green];`,
+		"n0 -> n1;",
+		"n0 -> n2;",
+		"n1 -> n2;",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Missing %q in output", expected)
+		}
+	}
+	if !strings.HasPrefix(out, "digraph plan {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Errorf("Output is not a DOT graph")
+	}
+
+	c.Graph = "svg"
+	if err := c.Run(objs, &buf); err == nil {
+		t.Errorf("Unknown graph format was accepted")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How often a long update reports its progress
+var progressInterval = 10 * time.Second
+
+// progressReporter periodically logs how many objects have been
+// applied.  Progress is logged (rather than written directly to
+// stderr) so it is never interleaved with other log lines.  A nil
+// *progressReporter reports nothing.
+type progressReporter struct {
+	total int
+	now   func() time.Time
+	last  time.Time
+}
+
+func newProgressReporter(total int, quiet bool) *progressReporter {
+	if quiet {
+		return nil
+	}
+	return &progressReporter{total: total, now: time.Now, last: time.Now()}
+}
+
+// Report logs that done objects have been applied, if it has been
+// at least progressInterval since the last report.
+func (p *progressReporter) Report(done int) {
+	if p == nil || done == 0 || done >= p.total {
+		return
+	}
+	if now := p.now(); now.Sub(p.last) >= progressInterval {
+		log.Infof("Applied %d/%d objects", done, p.total)
+		p.last = now
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestProgressReporter(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newProgressReporter(450, false)
+	p.now = func() time.Time { return now }
+	p.last = now
+
+	for i := 0; i < 450; i++ {
+		p.Report(i)
+		now = now.Add(time.Second)
+	}
+	reports := strings.Count(logs.String(), "Applied ")
+	if reports != 44 {
+		t.Errorf("Expected a report every %s, got %d reports:\n%s", progressInterval, reports, logs.String())
+	}
+	if !strings.Contains(logs.String(), "Applied 120/450 objects") {
+		t.Errorf("Missing progress report in:\n%s", logs.String())
+	}
+
+	logs.Reset()
+	p = newProgressReporter(450, true)
+	for i := 0; i < 450; i++ {
+		p.Report(i)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Quiet reporter logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// quantityParents are the fields whose members are resource
+// quantities, eg: `resources.limits.memory` or ResourceQuota
+// `spec.hard.pods`.
+var quantityParents = map[string]bool{
+	"requests":    true,
+	"limits":      true,
+	"hard":        true,
+	"capacity":    true,
+	"allocatable": true,
+}
+
+// quantityFields are individual fields that are resource quantities
+var quantityFields = map[string]bool{
+	"sizeLimit": true,
+}
+
+// annotateQuantities returns a display copy of config with each
+// changed resource quantity followed by its delta from live, eg:
+// "750m (+250m CPU, +50%)".
+func annotateQuantities(live, config map[string]interface{}) map[string]interface{} {
+	if len(live) == 0 || len(config) == 0 {
+		return config
+	}
+	ret := deepCopyJSON(config).(map[string]interface{})
+	annotateQuantityMap("", live, ret)
+	return ret
+}
+
+func annotateQuantityMap(parent string, live, config map[string]interface{}) {
+	for k, v := range config {
+		old, ok := live[k]
+		if !ok {
+			continue
+		}
+		if quantityParents[parent] || quantityFields[k] {
+			if s, ok := quantityDelta(k, old, v); ok {
+				config[k] = s
+				continue
+			}
+		}
+		annotateQuantityValue(k, old, v)
+	}
+}
+
+func annotateQuantityValue(key string, live, config interface{}) {
+	switch c := config.(type) {
+	case map[string]interface{}:
+		if l, ok := live.(map[string]interface{}); ok {
+			annotateQuantityMap(key, l, c)
+		}
+	case []interface{}:
+		if l, ok := live.([]interface{}); ok {
+			for i := 0; i < len(c) && i < len(l); i++ {
+				if m, ok := c[i].(map[string]interface{}); ok {
+					if lm, ok := l[i].(map[string]interface{}); ok {
+						annotateQuantityMap(key, lm, m)
+					}
+				}
+			}
+		}
+	}
+}
+
+// quantityDelta describes the change from old to new, if both are
+// quantities and they differ.
+func quantityDelta(name string, old, new interface{}) (string, bool) {
+	oldQ, ok := parseQuantity(old)
+	if !ok {
+		return "", false
+	}
+	newQ, ok := parseQuantity(new)
+	if !ok || oldQ.Cmp(newQ) == 0 {
+		return "", false
+	}
+
+	delta := newQ.DeepCopy()
+	delta.Sub(oldQ)
+	sign := ""
+	if delta.Sign() > 0 {
+		sign = "+"
+	}
+	label := name
+	if name == "cpu" {
+		label = "CPU"
+	}
+	text := fmt.Sprintf("%s (%s%s %s", quantityString(new), sign, delta.String(), label)
+
+	oldF, _ := strconv.ParseFloat(oldQ.AsDec().String(), 64)
+	deltaF, _ := strconv.ParseFloat(delta.AsDec().String(), 64)
+	if oldF != 0 {
+		text += fmt.Sprintf(", %+.0f%%", deltaF/oldF*100)
+	}
+	return text + ")", true
+}
+
+func parseQuantity(v interface{}) (resource.Quantity, bool) {
+	s := quantityString(v)
+	if s == "" {
+		return resource.Quantity{}, false
+	}
+	q, err := resource.ParseQuantity(s)
+	return q, err == nil
+}
+
+func quantityString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case int, int64, float64:
+		return fmt.Sprint(t)
+	}
+	return ""
+}
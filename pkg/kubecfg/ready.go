@@ -0,0 +1,113 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// How often to poll objects with WaitForReady
+var readyPollInterval = 2 * time.Second
+
+// readinessChecks are the kinds with a known readiness heuristic.
+// Each returns true once the live object is ready.
+var readinessChecks = map[string]func(*unstructured.Unstructured) bool{
+	"Deployment":  rolloutComplete,
+	"StatefulSet": rolloutComplete,
+	"DaemonSet":   rolloutComplete,
+	"ReplicaSet":  rolloutComplete,
+}
+
+// pendingObject is an object that WaitForReady is waiting for
+type pendingObject struct {
+	rc    *dynamic.ResourceClient
+	name  string
+	desc  string
+	ready func(*unstructured.Unstructured) bool
+}
+
+// WaitForReady polls the live version of each of objs until they are
+// all ready (eg: the rollout of a Deployment is complete), similar to
+// `kubectl rollout status`.  Kinds without a known readiness
+// heuristic are assumed to be ready immediately.  An error naming
+// the objects that are still not ready is returned if timeout passes
+// first.
+func WaitForReady(ctx context.Context, pool dynamic.ClientPool, disco discovery.DiscoveryInterface, objs []*unstructured.Unstructured, defNs string, timeout time.Duration) error {
+	var pending []pendingObject
+	for _, obj := range objs {
+		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(disco, obj), utils.FqName(obj))
+		ready, ok := readinessChecks[obj.GetKind()]
+		if !ok {
+			log.Infof("No readiness check for %s, assuming it is ready", desc)
+			continue
+		}
+		rc, err := utils.ClientForResourceContext(ctx, pool, disco, obj, defNs)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, pendingObject{rc: rc, name: obj.GetName(), desc: desc, ready: ready})
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Infof("Waiting for %d objects to become ready", len(pending))
+	for {
+		var notReady []pendingObject
+		for _, o := range pending {
+			live, err := o.rc.Get(o.name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("Error waiting for %s to become ready: %v", o.desc, err)
+			}
+			if o.ready(live) {
+				log.Info(" Ready ", o.desc)
+			} else {
+				notReady = append(notReady, o)
+			}
+		}
+		pending = notReady
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				return ctx.Err()
+			}
+			descs := make([]string, len(pending))
+			for i, o := range pending {
+				descs[i] = o.desc
+			}
+			return fmt.Errorf("Timed out waiting for %d objects to become ready: %s", len(pending), strings.Join(descs, ", "))
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestWaitForReady(t *testing.T) {
+	defer func(d time.Duration) { readyPollInterval = d }(readyPollInterval)
+	readyPollInterval = time.Millisecond
+
+	const deployment = `{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"%s","namespace":"default","generation":2},
+  "spec":{"replicas":3},
+  "status":{"observedGeneration":2,"replicas":3,"updatedReplicas":%d,"availableReplicas":3}}`
+
+	polls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/apps/v1beta1/namespaces/default/deployments/web":
+			// Rollout completes on the third poll
+			polls["web"]++
+			updated := 1
+			if polls["web"] >= 3 {
+				updated = 3
+			}
+			fmt.Fprintf(w, deployment, "web", updated)
+		case "/apis/apps/v1beta1/namespaces/default/deployments/stuck":
+			polls["stuck"]++
+			fmt.Fprintf(w, deployment, "stuck", 1)
+		default:
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	pool := dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL})
+	disco := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "apps/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "deployments", Kind: "Deployment", Namespaced: true},
+				},
+			},
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+				},
+			},
+		},
+	}}
+	obj := func(kind, name string) *unstructured.Unstructured {
+		apiVersion := "v1"
+		if kind == "Deployment" {
+			apiVersion = "apps/v1beta1"
+		}
+		return mustUnstructured(t, fmt.Sprintf(`{"apiVersion":%q,"kind":%q,"metadata":{"name":%q}}`, apiVersion, kind, name))
+	}
+
+	// ConfigMaps have no readiness check, and are never fetched
+	objs := []*unstructured.Unstructured{obj("ConfigMap", "config"), obj("Deployment", "web")}
+	if err := WaitForReady(context.Background(), pool, disco, objs, "default", time.Minute); err != nil {
+		t.Errorf("WaitForReady failed: %v", err)
+	}
+	if polls["web"] != 3 {
+		t.Errorf("Expected 3 polls, got %d", polls["web"])
+	}
+
+	objs = append(objs, obj("Deployment", "stuck"))
+	err := WaitForReady(context.Background(), pool, disco, objs, "default", 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "Timed out waiting for 1 objects to become ready: deployments stuck") {
+		t.Errorf("Expected timeout naming the stuck deployment, got %v", err)
+	}
+	if polls["stuck"] < 2 {
+		t.Errorf("Expected stuck deployment to be polled repeatedly, got %d polls", polls["stuck"])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WaitForReady(ctx, pool, disco, objs, "default", time.Minute); err != context.Canceled {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+}
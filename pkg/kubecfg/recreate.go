@@ -0,0 +1,105 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// How long to wait for an object deleted by RecreateOnImmutable to
+// disappear (eg: for its finalizers to run)
+var (
+	recreateTimeout      = 2 * time.Minute
+	recreatePollInterval = time.Second
+)
+
+// immutableMessages are fragments of the messages returned by the
+// server when an update changes a field that can't be changed
+var immutableMessages = []string{
+	"field is immutable",
+	"field can not be less than previous value",
+	"updates to statefulset spec for fields other than",
+}
+
+// isImmutableFieldError returns true if err is the server rejecting
+// an update because it changes an immutable field (eg: a Job's
+// selector, or a Service's clusterIP)
+func isImmutableFieldError(err error) bool {
+	if !errors.IsInvalid(err) {
+		return false
+	}
+	msg := err.Error()
+	for _, m := range immutableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForGone polls until the named object no longer exists, or
+// timeout has passed (in which case wait.ErrWaitTimeout is returned)
+func waitForGone(rc *dynamic.ResourceClient, name string, interval, timeout time.Duration) error {
+	return wait.PollImmediate(interval, timeout, func() (bool, error) {
+		_, err := rc.Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+// recreate deletes the live version of obj (and, in the foreground,
+// its dependents), waits for it to disappear, and then creates obj
+// afresh.  cause is the immutable field error that made this
+// necessary.
+func (c UpdateCmd) recreate(logger log.FieldLogger, rc *dynamic.ResourceClient, obj *unstructured.Unstructured, desc string, cause error) (metav1.Object, string, string, error) {
+	if c.DryRun {
+		logger.Warningf(" %s changes immutable fields, and would be DELETED and recreated (dry-run)", desc)
+		return obj, EventReasonUpdated, "", nil
+	}
+	logger.Warningf(" %s changes immutable fields, DELETING and recreating it: %v", desc, cause)
+
+	err := utils.DeleteWithPropagation(rc, obj.GetName(), metav1.DeleteOptions{}, metav1.DeletePropagationForeground)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, "", "", fmt.Errorf("Error deleting for recreate: %v", err)
+	}
+	err = waitForGone(rc, obj.GetName(), recreatePollInterval, recreateTimeout)
+	if err == wait.ErrWaitTimeout {
+		return nil, "", "", fmt.Errorf("Timed out waiting for deleted object to disappear before recreating it")
+	} else if err != nil {
+		return nil, "", "", err
+	}
+
+	newobj := &unstructured.Unstructured{Object: deepCopyJSON(obj.Object).(map[string]interface{})}
+	newobj.SetResourceVersion("")
+	created, err := rc.Create(newobj)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Error recreating after delete: %v", err)
+	}
+	return created, EventReasonCreated, "Recreated by kubecfg, to change immutable fields", nil
+}
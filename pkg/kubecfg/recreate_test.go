@@ -0,0 +1,142 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestIsImmutableFieldError(t *testing.T) {
+	gk := schema.GroupKind{Group: "batch", Kind: "Job"}
+	immutable := errors.NewInvalid(gk, "myjob", field.ErrorList{
+		field.Invalid(field.NewPath("spec", "selector"), "x", "field is immutable"),
+	})
+	if !isImmutableFieldError(immutable) {
+		t.Errorf("Immutable field error not detected: %v", immutable)
+	}
+
+	other := errors.NewInvalid(gk, "myjob", field.ErrorList{
+		field.Required(field.NewPath("spec", "template"), ""),
+	})
+	for _, err := range []error{nil, other, errors.NewBadRequest("field is immutable")} {
+		if isImmutableFieldError(err) {
+			t.Errorf("Unexpected immutable field error: %v", err)
+		}
+	}
+}
+
+func TestUpdateRecreateOnImmutable(t *testing.T) {
+	defer func(d time.Duration) { recreatePollInterval = d }(recreatePollInterval)
+	recreatePollInterval = time.Millisecond
+
+	var requests []string
+	exists := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+				return
+			}
+			fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"myobj","namespace":"default"},"spec":{"selector":"old"}}`)
+		case "PATCH":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Invalid","code":422,"message":"Test.tests \"myobj\" is invalid: spec.selector: Invalid value: \"new\": field is immutable"}`)
+		case "DELETE":
+			exists = false
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		case "POST":
+			exists = true
+			io.Copy(w, r.Body)
+		default:
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+	}
+	obj := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "tests/v1alpha1",
+				"kind":       "Test",
+				"metadata":   map[string]interface{}{"name": "myobj"},
+				"spec":       map[string]interface{}{"selector": "new"},
+			},
+		}
+	}
+
+	err := c.Run([]*unstructured.Unstructured{obj()})
+	if err == nil || !strings.Contains(err.Error(), "field is immutable") {
+		t.Errorf("Expected immutable field error without RecreateOnImmutable, got %v", err)
+	}
+	if !exists {
+		t.Errorf("Object was deleted without RecreateOnImmutable")
+	}
+
+	requests = nil
+	c.RecreateOnImmutable = true
+	if err := c.Run([]*unstructured.Unstructured{obj()}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"PATCH", "DELETE", "GET", "POST"}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests)
+	}
+
+	requests = nil
+	c.DryRun = true
+	if err := c.Run([]*unstructured.Unstructured{obj()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range requests {
+		if r != "GET" {
+			t.Errorf("Unexpected %s request in dry-run", r)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApplyConflict is a field whose value a server-side apply would
+// change, but which is owned by another field manager
+type ApplyConflict struct {
+	Manager string
+	// Field is the path of the contested field, as reported by
+	// the server (eg: `.spec.replicas`)
+	Field string
+}
+
+// ApplyConflictError is returned when a server-side apply fails
+// because of conflicts with other field managers
+type ApplyConflictError struct {
+	Desc      string
+	Conflicts []ApplyConflict
+}
+
+func (e *ApplyConflictError) Error() string {
+	lines := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		lines = append(lines, fmt.Sprintf("  %s is owned by %q", c.Field, c.Manager))
+	}
+	return fmt.Sprintf("Server-side apply of %s conflicts with other field managers (use --force-conflicts to take ownership):\n%s", e.Desc, strings.Join(lines, "\n"))
+}
+
+// IsApplyConflict returns true if err is an ApplyConflictError
+func IsApplyConflict(err error) bool {
+	_, ok := err.(*ApplyConflictError)
+	return ok
+}
+
+// managerConflictRe matches the cause message returned by the server
+// for each conflict, eg: `conflict with "kubectl" using apps/v1`
+var managerConflictRe = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// applyConflictError converts a 409 response to a server-side apply
+// into an ApplyConflictError, or returns err unchanged.
+func applyConflictError(desc string, err error) error {
+	if !errors.IsConflict(err) {
+		return err
+	}
+	status, ok := err.(errors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return err
+	}
+
+	var conflicts []ApplyConflict
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseType("FieldManagerConflict") {
+			continue
+		}
+		c := ApplyConflict{Field: cause.Field, Manager: "unknown"}
+		if m := managerConflictRe.FindStringSubmatch(cause.Message); m != nil {
+			c.Manager = m[1]
+		}
+		conflicts = append(conflicts, c)
+	}
+	if len(conflicts) == 0 {
+		return err
+	}
+	return &ApplyConflictError{Desc: desc, Conflicts: conflicts}
+}
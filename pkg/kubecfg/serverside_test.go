@@ -0,0 +1,131 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+func TestUpdateServerSide(t *testing.T) {
+	var applied []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "PATCH" || r.Header.Get("Content-Type") != "application/apply-patch+yaml" {
+			t.Errorf("Unexpected %s %s (%s)", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if q := r.URL.Query(); q.Get("fieldManager") != "mytool" || q.Get("force") != "" {
+			t.Errorf("Unexpected query %s", r.URL.RawQuery)
+		}
+		if strings.HasSuffix(r.URL.Path, "/two") {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Conflict","code":409,
+  "message":"Apply failed with 2 conflicts: conflict with \"kubectl\" using tests/v1alpha1: .spec.replicas",
+  "details":{"causes":[
+    {"reason":"FieldManagerConflict","message":"conflict with \"kubectl\" using tests/v1alpha1","field":".spec.replicas"},
+    {"reason":"FieldManagerConflict","message":"conflict with \"hpa-controller\"","field":".spec.paused"}]}}`)
+			return
+		}
+		var obj map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			t.Error(err)
+		}
+		applied = append(applied, r.URL.Path)
+		json.NewEncoder(w).Encode(obj)
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{
+			Host: srv.URL,
+			WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+				return utils.NewServerSideApplyTransport("mytool", false, rt)
+			},
+		}),
+		Discovery:        diffTestCmd(srv.URL).Discovery,
+		DefaultNamespace: "default",
+		ServerSide:       true,
+	}
+
+	newObj := func(name string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "tests/v1alpha1",
+			"kind":       "Test",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec":       map[string]interface{}{"replicas": 2},
+		}}
+	}
+
+	if err := c.Run([]*unstructured.Unstructured{newObj("one")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0] != "/apis/tests/v1alpha1/namespaces/default/tests/one" {
+		t.Errorf("Unexpected applies: %v", applied)
+	}
+
+	err := c.Run([]*unstructured.Unstructured{newObj("two")})
+	if err == nil {
+		t.Fatal("Expected conflict error")
+	}
+	for _, s := range []string{`.spec.replicas is owned by "kubectl"`, `.spec.paused is owned by "hpa-controller"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("Error %q doesn't contain %q", err, s)
+		}
+	}
+
+	c.Overwrite = true
+	if err := c.Run(nil); err == nil {
+		t.Errorf("Expected error combining server-side apply and overwrite")
+	}
+}
+
+func TestApplyConflictError(t *testing.T) {
+	plain := fmt.Errorf("boom")
+	if applyConflictError("x", plain) != plain {
+		t.Errorf("Unrelated error was changed")
+	}
+
+	status := metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusConflict,
+		Reason: metav1.StatusReasonConflict,
+		Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{
+			{Type: "FieldManagerConflict", Message: `conflict with "kubectl"`, Field: ".spec.replicas"},
+			{Type: "FieldManagerConflict", Message: "something else", Field: ".spec.paused"},
+		}},
+	}
+	err := applyConflictError("tests default.x", &errors.StatusError{ErrStatus: status})
+	if !IsApplyConflict(err) {
+		t.Fatalf("Expected ApplyConflictError, got %v", err)
+	}
+	conflicts := err.(*ApplyConflictError).Conflicts
+	expected := []ApplyConflict{{Manager: "kubectl", Field: ".spec.replicas"}, {Manager: "unknown", Field: ".spec.paused"}}
+	if fmt.Sprint(conflicts) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, conflicts)
+	}
+}
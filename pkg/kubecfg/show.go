@@ -16,21 +16,86 @@
 package kubecfg
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/kubecfg/utils"
 )
 
 // ShowCmd represents the show subcommand
 type ShowCmd struct {
 	Format string
+
+	// Inventory emits a compact InventoryItem per object, rather
+	// than the full objects
+	Inventory bool
+
+	// GcTag is reported as the apply-set of each object in the
+	// inventory.  If empty, the object's existing gc tag
+	// annotation (if any) is used.
+	GcTag string
+
+	// OutputDir, if set, writes each object to a separate file
+	// in this directory rather than to the output stream.
+	OutputDir string
+
+	// GroupByLabel places files in OutputDir into subdirectories
+	// named after the value of this label.  Objects without the
+	// label are placed in DefaultGroup.
+	GroupByLabel string
+
+	// Images emits the deduplicated list of container images used
+	// by the objects, rather than the objects themselves.
+	Images bool
+
+	// Resolver, if set, resolves --images to digests
+	Resolver utils.Resolver
+}
+
+// DefaultGroup is the --group-by-label subdirectory used for objects
+// that lack the label
+const DefaultGroup = "_default"
+
+// InventoryItem summarises a single object for external asset
+// tracking systems
+type InventoryItem struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	ApplySet   string `json:"applySet,omitempty"`
+	// Hash is the sha256 of the object content, and changes
+	// whenever the object does
+	Hash string `json:"hash"`
 }
 
 func (c ShowCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) error {
-	switch c.Format {
+	if c.Inventory {
+		return c.runInventory(apiObjects, out)
+	}
+
+	if c.Images {
+		return c.runImages(apiObjects, out)
+	}
+
+	if c.OutputDir != "" {
+		return c.runOutputDir(apiObjects)
+	}
+
+	return writeObjects(out, c.Format, apiObjects)
+}
+
+func writeObjects(out io.Writer, format string, apiObjects []*unstructured.Unstructured) error {
+	switch format {
 	case "yaml":
 		for _, obj := range apiObjects {
 			fmt.Fprintln(out, "---")
@@ -64,8 +129,146 @@ func (c ShowCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) err
 			}
 		}
 	default:
+		return fmt.Errorf("Unknown --format: %s", format)
+	}
+
+	return nil
+}
+
+// runOutputDir writes each object to its own file under OutputDir
+func (c ShowCmd) runOutputDir(apiObjects []*unstructured.Unstructured) error {
+	if c.Format != "yaml" && c.Format != "json" {
 		return fmt.Errorf("Unknown --format: %s", c.Format)
 	}
 
+	written := map[string]string{}
+	for _, obj := range apiObjects {
+		dir := c.OutputDir
+		if c.GroupByLabel != "" {
+			group := obj.GetLabels()[c.GroupByLabel]
+			if group == "" {
+				group = DefaultGroup
+			}
+			dir = filepath.Join(dir, pathComponent(group))
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, outputFileName(obj)+"."+c.Format)
+		desc := utils.FqName(obj)
+		if prev, ok := written[path]; ok {
+			return fmt.Errorf("Both %s and %s would be written to %s", prev, desc, path)
+		}
+		written[path] = desc
+
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = writeObjects(f, c.Format, []*unstructured.Unstructured{obj})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("Error writing %s: %v", path, err)
+		}
+	}
 	return nil
 }
+
+// outputFileName returns the --output-dir file name (without
+// extension) for obj: its namespace (if any), kind qualified by
+// group (if any) and name, separated by underscores, which are not
+// valid in any of them.
+func outputFileName(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	kind := strings.ToLower(gvk.Kind)
+	if gvk.Group != "" {
+		kind += "." + gvk.Group
+	}
+	parts := []string{kind, obj.GetName()}
+	if ns := obj.GetNamespace(); ns != "" {
+		parts = append([]string{ns}, parts...)
+	}
+	return pathComponent(strings.Join(parts, "_"))
+}
+
+// pathComponent makes s safe to use as a single file or directory
+// name: path separators are replaced, and "." or ".." escaped.
+func pathComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" || s == "." || s == ".." {
+		s = "_" + s
+	}
+	return s
+}
+
+func (c ShowCmd) runInventory(apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	items := make([]InventoryItem, 0, len(apiObjects))
+	for _, obj := range apiObjects {
+		hash, err := contentHash(obj)
+		if err != nil {
+			return err
+		}
+		applySet := c.GcTag
+		if applySet == "" {
+			applySet = obj.GetAnnotations()[AnnotationGcTag]
+		}
+		items = append(items, InventoryItem{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			ApplySet:   applySet,
+			Hash:       hash,
+		})
+	}
+
+	return writeList(out, c.Format, items)
+}
+
+// writeList writes items, which must be a slice, as a single
+// yaml or json list
+func writeList(out io.Writer, format string, items interface{}) error {
+	switch format {
+	case "yaml":
+		buf, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		var o []interface{}
+		if err := json.Unmarshal(buf, &o); err != nil {
+			return err
+		}
+		buf, err = yaml.Marshal(o)
+		if err != nil {
+			return err
+		}
+		out.Write(buf)
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	default:
+		return fmt.Errorf("Unknown --format: %s", format)
+	}
+
+	return nil
+}
+
+// contentHash returns a stable hash of obj.  encoding/json sorts
+// map keys, so equal objects always produce the same hash.
+func contentHash(obj *unstructured.Unstructured) (string, error) {
+	buf, err := json.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
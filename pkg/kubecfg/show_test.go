@@ -0,0 +1,192 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+func TestShowGroupByLabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "show-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const label = "app.kubernetes.io/component"
+	newObj := func(name, component string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace("myns")
+		obj.SetName(name)
+		if component != "" {
+			obj.SetLabels(map[string]string{label: component})
+		}
+		return obj
+	}
+
+	c := ShowCmd{Format: "yaml", OutputDir: dir, GroupByLabel: label}
+	objs := []*unstructured.Unstructured{
+		newObj("web-config", "frontend"),
+		newObj("db-config", "database"),
+		newObj("other", ""),
+		newObj("escape", ".."),
+	}
+	other := newObj("other", "")
+	other.SetNamespace("otherns")
+	objs = append(objs, other)
+	other = newObj("other", "")
+	other.SetNamespace("otherns")
+	other.SetAPIVersion("apps/v1")
+	other.SetKind("Deployment")
+	objs = append(objs, other)
+	if err := c.Run(objs, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	expected := []string{
+		"_../myns_configmap_escape.yaml",
+		"_default/myns_configmap_other.yaml",
+		"_default/otherns_configmap_other.yaml",
+		"_default/otherns_deployment.apps_other.yaml",
+		"database/myns_configmap_db-config.yaml",
+		"frontend/myns_configmap_web-config.yaml",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected files %v, got %v", expected, files)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "frontend", "myns_configmap_web-config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedData := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    app.kubernetes.io/component: frontend
+  name: web-config
+  namespace: myns
+`
+	if string(data) != expectedData {
+		t.Errorf("Unexpected file content:\n%s", data)
+	}
+
+	// Names that would escape OutputDir
+	bad := newObj("../../evil", "")
+	bad.SetNamespace("..")
+	if name := outputFileName(bad); strings.Contains(name, "/") {
+		t.Errorf("Unsafe file name %q", name)
+	}
+
+	// Duplicates are an error, rather than overwritten
+	if err := c.Run([]*unstructured.Unstructured{newObj("dup", ""), newObj("dup", "")}, ioutil.Discard); err == nil {
+		t.Errorf("Duplicate objects were written to the same file")
+	}
+}
+
+type fakeDigestResolver struct{}
+
+func (fakeDigestResolver) Resolve(image *utils.ImageName) error {
+	image.Digest = "sha256:" + image.Name
+	return nil
+}
+
+func TestShowImages(t *testing.T) {
+	objs := []*unstructured.Unstructured{
+		mustUnstructured(t, `{
+  "apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web"},
+  "spec": {"template": {"spec": {
+    "initContainers": [{"name": "init", "image": "busybox:1.27"}],
+    "containers": [{"name": "web", "image": "nginx:1.13"}, {"name": "sidecar", "image": "envoy:1.5"}]
+  }}}
+}`),
+		mustUnstructured(t, `{
+  "apiVersion": "apps/v1", "kind": "DaemonSet", "metadata": {"name": "agent"},
+  "spec": {"template": {"spec": {
+    "containers": [{"name": "agent", "image": "agent:2.0"}, {"name": "sidecar", "image": "envoy:1.5"}],
+    "ephemeralContainers": [{"name": "debug", "image": "debugger:latest"}]
+  }}}
+}`),
+		mustUnstructured(t, `{
+  "apiVersion": "batch/v1beta1", "kind": "CronJob", "metadata": {"name": "backup"},
+  "spec": {"jobTemplate": {"spec": {"template": {"spec": {
+    "containers": [{"name": "backup", "image": "busybox:1.27"}]
+  }}}}}
+}`),
+		mustUnstructured(t, `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config"}, "data": {"image": "notanimage"}}`),
+	}
+
+	c := ShowCmd{Format: "json", Images: true}
+	var buf bytes.Buffer
+	if err := c.Run(objs, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var items []ImageItem
+	if err := json.Unmarshal(buf.Bytes(), &items); err != nil {
+		t.Fatalf("Failed to parse %s: %v", buf.String(), err)
+	}
+	expected := []ImageItem{
+		{Image: "agent:2.0"},
+		{Image: "busybox:1.27"},
+		{Image: "debugger:latest"},
+		{Image: "envoy:1.5"},
+		{Image: "nginx:1.13"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected %v, got %v", expected, items)
+	}
+
+	c.Resolver = fakeDigestResolver{}
+	buf.Reset()
+	if err := c.Run(objs[:1], &buf); err != nil {
+		t.Fatal(err)
+	}
+	items = nil
+	if err := json.Unmarshal(buf.Bytes(), &items); err != nil {
+		t.Fatalf("Failed to parse %s: %v", buf.String(), err)
+	}
+	if len(items) != 3 || items[2] != (ImageItem{Image: "nginx:1.13", Digest: "sha256:nginx"}) {
+		t.Errorf("Unexpected resolved images %v", items)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DiffFormatUnified is the default, gojsondiff-based, diff format
+	DiffFormatUnified = "unified"
+	// DiffFormatSideBySide renders live and desired fields in two
+	// columns
+	DiffFormatSideBySide = "side-by-side"
+
+	// minSideBySideWidth is the narrowest terminal that can
+	// usefully show two columns.  Narrower terminals fall back to
+	// the unified format.
+	minSideBySideWidth = 60
+
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// sideBySideRow is a single field, as it appears in the live and
+// desired objects.  A missing side is "".
+type sideBySideRow struct {
+	left, right string
+}
+
+// marker returns the sdiff-style character shown between columns
+func (r sideBySideRow) marker() string {
+	switch {
+	case r.left == r.right:
+		return " "
+	case r.left == "":
+		return ">"
+	case r.right == "":
+		return "<"
+	default:
+		return "|"
+	}
+}
+
+// sideBySideRows flattens live and config into one row per leaf
+// field, ordered by path.
+func sideBySideRows(live, config map[string]interface{}) []sideBySideRow {
+	l, r := map[string]string{}, map[string]string{}
+	flattenFields(nil, live, l)
+	flattenFields(nil, config, r)
+
+	paths := make([]string, 0, len(l)+len(r))
+	for p := range l {
+		paths = append(paths, p)
+	}
+	for p := range r {
+		if _, ok := l[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	rows := make([]sideBySideRow, len(paths))
+	for i, p := range paths {
+		rows[i] = sideBySideRow{left: l[p], right: r[p]}
+	}
+	return rows
+}
+
+// flattenFields records each leaf of v in ret, as "path: value"
+// keyed by path
+func flattenFields(path []string, v interface{}, ret map[string]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) > 0 {
+			for k, v2 := range t {
+				flattenFields(append(path[:len(path):len(path)], k), v2, ret)
+			}
+			return
+		}
+	case []interface{}:
+		if len(t) > 0 {
+			for i, v2 := range t {
+				flattenFields(append(path[:len(path):len(path)], strconv.Itoa(i)), v2, ret)
+			}
+			return
+		}
+	}
+	if len(path) == 0 {
+		return
+	}
+	p := strings.Join(path, ".")
+	text, err := json.Marshal(v)
+	if err != nil {
+		text = []byte(fmt.Sprintf("%v", v))
+	}
+	ret[p] = fmt.Sprintf("%s: %s", p, text)
+}
+
+// wrapText splits s into lines of at most width runes
+func wrapText(s string, width int) []string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return []string{""}
+	}
+	var lines []string
+	for len(runes) > width {
+		lines = append(lines, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(lines, string(runes))
+}
+
+// renderSideBySide writes live and config to out in two columns,
+// filling width characters.  Long fields are wrapped within their
+// column.  Only context unchanged fields are shown either side of
+// each change (see contextMask).
+func renderSideBySide(out io.Writer, live, config map[string]interface{}, width, context int, color bool) error {
+	colWidth := (width - 3) / 2
+	rows := sideBySideRows(live, config)
+	changed := make([]bool, len(rows))
+	for i, row := range rows {
+		changed[i] = row.marker() != " "
+	}
+	show := contextMask(changed, context)
+	omitting := false
+	for j, row := range rows {
+		if !show[j] {
+			if !omitting {
+				if _, err := fmt.Fprintln(out, omittedLines); err != nil {
+					return err
+				}
+			}
+			omitting = true
+			continue
+		}
+		omitting = false
+
+		marker := row.marker()
+		left := wrapText(row.left, colWidth)
+		right := wrapText(row.right, colWidth)
+		for len(left) < len(right) {
+			left = append(left, "")
+		}
+		for len(right) < len(left) {
+			right = append(right, "")
+		}
+
+		for i := range left {
+			l := left[i] + strings.Repeat(" ", colWidth-len([]rune(left[i])))
+			r := right[i]
+			if color && marker != " " {
+				l = colorRed + l + colorReset
+				r = colorGreen + r + colorReset
+			}
+			m := marker
+			if i > 0 {
+				m = " "
+			}
+			line := fmt.Sprintf("%s %s %s", l, m, r)
+			if _, err := fmt.Fprintln(out, strings.TrimRight(line, " ")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// A custom resource with a status subresource, as stored by the
+// server
+const liveCronTab = `{"apiVersion":"stable.example.com/v1","kind":"CronTab","metadata":{"name":"cron","namespace":"default","uid":"1","resourceVersion":"42","generation":2,"creationTimestamp":"2017-01-01T00:00:00Z","managedFields":[{"manager":"kubecfg"}]},"spec":{"schedule":"* * * * */5"},"status":{"lastScheduleTime":"2017-01-01T00:05:00Z"}}`
+
+func statusTestServer(t *testing.T, patched *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/apis/stable.example.com/v1/namespaces/default/crontabs/cron" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "PATCH" {
+			var obj map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+				t.Error(err)
+			}
+			*patched = append(*patched, obj)
+		}
+		fmt.Fprint(w, liveCronTab)
+	}))
+}
+
+func statusTestDiscovery() *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "stable.example.com/v1",
+				APIResources: []metav1.APIResource{
+					{Name: "crontabs", Kind: "CronTab", Namespaced: true},
+					{Name: "crontabs/status", Kind: "CronTab", Namespaced: true},
+				},
+			},
+		},
+	}}
+}
+
+// configCronTab is the config for liveCronTab, with a (meaningless)
+// status copied from an earlier `kubectl get -o yaml`
+func configCronTab() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "stable.example.com/v1",
+			"kind":       "CronTab",
+			"metadata": map[string]interface{}{
+				"name":            "cron",
+				"namespace":       "default",
+				"resourceVersion": "1",
+			},
+			"spec":   map[string]interface{}{"schedule": "* * * * */5"},
+			"status": map[string]interface{}{"lastScheduleTime": "2016-01-01T00:00:00Z"},
+		},
+	}
+}
+
+func TestDiffStripsServerManagedFields(t *testing.T) {
+	srv := statusTestServer(t, nil)
+	defer srv.Close()
+
+	c := DiffCmd{
+		ClientPool:       dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery:        statusTestDiscovery(),
+		DefaultNamespace: "default",
+		DiffStrategy:     "all",
+	}
+
+	var buf bytes.Buffer
+	if err := c.Run([]*unstructured.Unstructured{configCronTab()}, &buf); err != nil {
+		t.Errorf("Unexpected diff (%v):\n%s", err, buf.String())
+	}
+
+	c.KeepStatus = true
+	buf.Reset()
+	if err := c.Run([]*unstructured.Unstructured{configCronTab()}, &buf); err != ErrDiffFound {
+		t.Errorf("Expected status diff with KeepStatus, got %v:\n%s", err, buf.String())
+	}
+}
+
+func TestUpdateStripsServerManagedFields(t *testing.T) {
+	var patched []map[string]interface{}
+	srv := statusTestServer(t, &patched)
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool:       dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery:        statusTestDiscovery(),
+		DefaultNamespace: "default",
+	}
+	if err := c.Run([]*unstructured.Unstructured{configCronTab()}); err != nil {
+		t.Fatal(err)
+	}
+	c.KeepStatus = true
+	if err := c.Run([]*unstructured.Unstructured{configCronTab()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patched) != 2 {
+		t.Fatalf("Expected 2 patches, got %v", patched)
+	}
+	if _, ok := patched[0]["status"]; ok {
+		t.Errorf("Status was applied: %v", patched[0])
+	}
+	if rv := patched[0]["metadata"].(map[string]interface{})["resourceVersion"]; rv != nil {
+		t.Errorf("resourceVersion was applied: %v", patched[0])
+	}
+	if _, ok := patched[1]["status"]; !ok {
+		t.Errorf("Status was not applied with KeepStatus: %v", patched[1])
+	}
+}
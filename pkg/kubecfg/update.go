@@ -1,9 +1,16 @@
 package kubecfg
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -14,6 +21,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/diff"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
@@ -36,8 +44,52 @@ const (
 	GcStrategyAuto = "auto"
 	// GcStrategyIgnore means this object should be ignored by garbage collection
 	GcStrategyIgnore = "ignore"
+
+	// AnnotationGcPolicy set to GcPolicyDisabled on a live object
+	// keeps it from being garbage collected (see GcPolicyFilter),
+	// eg: a PersistentVolumeClaim holding data.
+	AnnotationGcPolicy = "kubecfg.io/gc-policy"
+	// GcPolicyDisabled is the AnnotationGcPolicy value that
+	// disables garbage collection
+	GcPolicyDisabled = "disabled"
+
+	// AnnotationDeployID records the deploy ID of the kubecfg
+	// update that last applied the object.
+	AnnotationDeployID = "kubecfg.io/deploy-id"
 )
 
+// kubecfgAnnotations are the annotations kubecfg adds to applied
+// objects, which describe the update rather than the config
+var kubecfgAnnotations = []string{AnnotationGcTag, AnnotationDeployID, AnnotationBundleDigest}
+
+// stripKubecfgAnnotations removes kubecfgAnnotations from obj
+func stripKubecfgAnnotations(obj *unstructured.Unstructured) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		return
+	}
+	for _, a := range kubecfgAnnotations {
+		delete(annotations, a)
+	}
+	if len(annotations) > 0 {
+		obj.SetAnnotations(annotations)
+	} else if metadata, ok := obj.Object["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "annotations")
+	}
+}
+
+// NewDeployID returns a random (version 4) UUID, for use as
+// UpdateCmd.DeployID
+func NewDeployID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
 // UpdateCmd represents the update subcommand
 type UpdateCmd struct {
 	ClientPool       dynamic.ClientPool
@@ -48,79 +100,337 @@ type UpdateCmd struct {
 	GcTag  string
 	SkipGc bool
 	DryRun bool
+
+	// GcClientPool lists objects for garbage collection, which
+	// only reads their metadata, so it may use a smaller but
+	// lossy encoding (see utils.NewProtobufTransport).  nil means
+	// ClientPool.
+	GcClientPool dynamic.ClientPool
+
+	// CreateNamespace creates the namespace of each namespaced
+	// object first, if it does not already exist.
+	CreateNamespace bool
+
+	// WaitForCRDs retries objects whose kind is not yet served
+	// but is declared by a CustomResourceDefinition.  Checking
+	// requires permission to list CRDs.
+	WaitForCRDs bool
+
+	// MetadataOnly restricts updates to labels and annotations of
+	// existing objects.  Missing objects are skipped.
+	MetadataOnly bool
+
+	// Overwrite replaces existing objects with the desired fields
+	// merged over the live object, resetting any drift in fields
+	// kubecfg specifies.
+	Overwrite bool
+
+	// ServerSide sends each object as a server-side apply patch,
+	// rather than a merge patch.  ClientPool must add the field
+	// manager (and force option) to apply requests, see
+	// utils.NewServerSideApplyTransport.
+	ServerSide bool
+
+	// Tracer records timing spans, if non-nil
+	Tracer *utils.Tracer
+
+	// Budget limits the total run time, if non-nil
+	Budget *utils.Budget
+
+	// EmitEvents records a Kubernetes Event for each object
+	// created, updated or garbage collected.
+	EmitEvents bool
+
+	// CheckOwnership warns about existing objects with fields
+	// owned by other managers (eg: kubectl).  With Strict, any
+	// such conflict aborts the update.
+	CheckOwnership bool
+	Strict         bool
+
+	// CheckPermissions asks the server whether the current user
+	// may patch (and, with Create, create) each object before
+	// anything is updated, and aborts the update if not.
+	CheckPermissions bool
+
+	// CheckConflicts records each applied object as its
+	// AnnotationLastApplied, and warns about fields that both
+	// config and another client have changed since the previous
+	// update (see threeWayConflicts).  Any such conflict aborts
+	// the update, unless ForceConflicts is set.
+	CheckConflicts bool
+	ForceConflicts bool
+
+	// ApplyConcurrency is the number of objects applied at once.
+	// Only objects in the same dependency wave (see
+	// utils.DependencyWaves) are applied concurrently.  Values
+	// less than 1 are treated as 1.
+	ApplyConcurrency int
+
+	// Atomic rolls back the changes already made by this run if
+	// any object fails to apply.
+	Atomic bool
+
+	// RecreateOnImmutable deletes and recreates objects whose
+	// update the server rejects for changing an immutable field
+	// (eg: a Job's selector).  Deleting the object also deletes
+	// its dependents, and anything else lost with it (eg: a
+	// Service's allocated clusterIP).
+	RecreateOnImmutable bool
+
+	// DeployID identifies this run.  If set, it is included in
+	// logs, Events and trace spans.
+	DeployID string
+
+	// StampDeployID also records DeployID on each applied object,
+	// as AnnotationDeployID.  This should only be set for an ID
+	// chosen by the user (eg: to correlate with CI): a new ID on
+	// every run would modify every object on every update.
+	StampDeployID bool
+
+	// PDBGate waits for the rollout of each updated workload
+	// before continuing, and reports any PodDisruptionBudget
+	// covering its pods that is violated meanwhile.  The update
+	// fails if the rollout is not complete after PDBGateTimeout.
+	PDBGate        bool
+	PDBGateTimeout time.Duration
+
+	// KeepStatus applies status from config, which is otherwise
+	// stripped (along with utils.ServerManagedMetadata) before
+	// applying.
+	KeepStatus bool
+
+	// Quiet suppresses the periodic progress reports of long
+	// updates
+	Quiet bool
+
+	// Wait blocks after applying, until each applied object is
+	// ready (see WaitForReady), or WaitTimeout has passed.
+	Wait        bool
+	WaitTimeout time.Duration
+
+	// GcSelector, if set, stamps each applied object with the
+	// bundle's AnnotationBundleDigest, and then garbage collects
+	// objects matching this label selector that were applied with
+	// a different digest.  GcPropagation is the propagation policy
+	// used for these deletes (default foreground).
+	GcSelector    string
+	GcPropagation metav1.DeletionPropagation
+
+	// GcAllowlist, if non-empty, restricts both forms of garbage
+	// collection to these kinds.  Objects of other kinds are
+	// never deleted.
+	GcAllowlist []schema.GroupVersionKind
+
+	// GcFilters keep matching objects from both forms of garbage
+	// collection.  nil means DefaultGcFilters.
+	GcFilters []GcFilter
+
+	// Hooks are run before and after applying each object.
+	// Hooks are not run with DryRun.
+	Hooks *ApplyHooks
+
+	// DryRunPool is a client pool whose writes are server-side
+	// dry-runs (see utils.NewDryRunTransport).  It is only used
+	// by RunServerDryRun.
+	DryRunPool dynamic.ClientPool
+
+	// patchTypes is set by run
+	patchTypes *patchTypeChooser
 }
 
 func (c UpdateCmd) Run(apiObjects []*unstructured.Unstructured) error {
+	if c.DeployID == "" {
+		return c.run(apiObjects)
+	}
+
+	log.Infof("Starting deploy %s", c.DeployID)
+	if err := c.run(apiObjects); err != nil {
+		log.Infof("Deploy %s failed", c.DeployID)
+		return err
+	}
+	log.Infof("Deploy %s complete: %d objects applied", c.DeployID, len(apiObjects))
+	return nil
+}
+
+// eventMessage adds the deploy ID, if any, to an Event message
+func (c UpdateCmd) eventMessage(message string) string {
+	if c.DeployID == "" {
+		return message
+	}
+	return fmt.Sprintf("%s (deploy %s)", message, c.DeployID)
+}
+
+func (c UpdateCmd) run(apiObjects []*unstructured.Unstructured) error {
 	dryRunText := ""
 	if c.DryRun {
 		dryRunText = " (dry-run)"
 	}
 
+	if c.Overwrite && c.MetadataOnly {
+		return fmt.Errorf("Overwrite and metadata-only updates are mutually exclusive")
+	}
+	if c.ServerSide && (c.Overwrite || c.MetadataOnly) {
+		return fmt.Errorf("Server-side apply can't be combined with overwrite or metadata-only updates")
+	}
+	if c.ServerSide && c.CheckConflicts {
+		return fmt.Errorf("Server-side apply detects conflicts itself, and can't be combined with client-side conflict checks")
+	}
+
+	for _, obj := range apiObjects {
+		if c.KeepStatus {
+			utils.StripServerManagedMetadata(obj)
+		} else {
+			utils.StripServerManagedFields(obj)
+		}
+	}
+
 	log.Infof("Fetching schemas for %d resources", len(apiObjects))
-	depOrder, err := utils.DependencyOrder(c.Discovery, apiObjects)
+	if err := c.Budget.StartDiscovery(); err != nil {
+		return err
+	}
+	span := c.Tracer.Start(nil, "discovery")
+	waves, err := utils.DependencyWaves(c.Discovery, apiObjects)
+	span.End()
 	if err != nil {
 		return err
 	}
-	sort.Sort(depOrder)
 
-	seenUids := sets.NewString()
-
-	for _, obj := range apiObjects {
-		if c.GcTag != "" {
-			utils.SetMetaDataAnnotation(obj, AnnotationGcTag, c.GcTag)
+	if c.CheckOwnership {
+		if err := checkOwnership(c.ClientPool, c.Discovery, apiObjects, c.DefaultNamespace, c.Strict); err != nil {
+			return err
 		}
+	}
 
-		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
-		log.Info("Updating ", desc, dryRunText)
+	if c.CheckPermissions {
+		verbs := []string{"patch"}
+		if c.Create && !c.ServerSide {
+			verbs = append(verbs, "create")
+		}
+		if err := checkPermissions(c.ClientPool, c.Discovery, apiObjects, c.DefaultNamespace, verbs); err != nil {
+			return err
+		}
+	}
 
-		rc, err := utils.ClientForResource(c.ClientPool, c.Discovery, obj, c.DefaultNamespace)
-		if err != nil {
+	if c.CheckConflicts {
+		if err := checkConflicts(c.ClientPool, c.Discovery, apiObjects, c.DefaultNamespace, c.ForceConflicts); err != nil {
 			return err
 		}
+	}
 
-		asPatch, err := json.Marshal(obj)
-		if err != nil {
+	digest := ""
+	if c.GcSelector != "" {
+		if digest, err = BundleDigest(apiObjects); err != nil {
 			return err
 		}
-		var newobj metav1.Object
-		if !c.DryRun {
-			newobj, err = rc.Patch(obj.GetName(), types.MergePatchType, asPatch)
-			log.Debugf("Patch(%s) returned (%v, %v)", obj.GetName(), newobj, err)
-		} else {
-			newobj, err = rc.Get(obj.GetName(), metav1.GetOptions{})
+		log.Debugf("Bundle digest is %s", digest)
+		if err := warnUnselected(apiObjects, c.GcSelector); err != nil {
+			return err
 		}
-		if c.Create && errors.IsNotFound(err) {
-			log.Info(" Creating non-existent ", desc, dryRunText)
-			if !c.DryRun {
-				newobj, err = rc.Create(obj)
-				log.Debugf("Create(%s) returned (%v, %v)", obj.GetName(), newobj, err)
-			} else {
-				newobj = obj
-				err = nil
+	}
+
+	c.patchTypes = newPatchTypeChooser(c.Discovery)
+
+	seenUids := sets.NewString()
+	events := newEventRecorder(c.ClientPool, c.Discovery, c.EmitEvents && !c.DryRun)
+	defer events.Flush()
+
+	namespaces, err := newNamespaceCreator(c.CreateNamespace, c.ClientPool, c.Discovery, c.DryRun)
+	if err != nil {
+		return err
+	}
+
+	rollback := newRollbackLog(c.Atomic && !c.DryRun)
+	var applied []*unstructured.Unstructured
+	progress := newProgressReporter(len(apiObjects), c.Quiet)
+
+	applySpan := c.Tracer.Start(nil, "apply")
+	if c.DeployID != "" {
+		applySpan.SetAttribute("kubecfg.deploy_id", c.DeployID)
+	}
+	state := &applyState{all: apiObjects, rollback: rollback, span: applySpan}
+	done := 0
+	for _, wave := range waves {
+		items := make([]applyItem, len(wave))
+		for i, obj := range wave {
+			// Recorded before kubecfg's own annotations, which
+			// change on every update
+			if c.CheckConflicts {
+				if err := setLastApplied(obj); err != nil {
+					return rollback.Rollback(err)
+				}
+			}
+			if c.GcTag != "" {
+				utils.SetMetaDataAnnotation(obj, AnnotationGcTag, c.GcTag)
+			}
+			if c.StampDeployID && c.DeployID != "" {
+				utils.SetMetaDataAnnotation(obj, AnnotationDeployID, c.DeployID)
+			}
+			if digest != "" {
+				utils.SetMetaDataAnnotation(obj, AnnotationBundleDigest, digest)
 			}
+
+			desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
+			if err := namespaces.Ensure(obj, c.DefaultNamespace, rollback); err != nil {
+				return rollback.Rollback(fmt.Errorf("Error creating namespace for %s: %v", desc, err))
+			}
+			items[i] = applyItem{obj: obj, desc: desc, remaining: len(apiObjects) - done - i}
 		}
+
+		var errs []error
+		for i, res := range c.applyWave(items, state) {
+			obj := wave[i]
+			// Printed in order, so the output is the same
+			// regardless of ApplyConcurrency
+			log.StandardLogger().Out.Write(res.output)
+			seenUids.Insert(res.uids...)
+			if res.newobj != nil {
+				log.Debug("Updated object: ", diff.ObjectDiff(obj, res.newobj))
+				rollback.Record(res.snapshot)
+				applied = append(applied, obj)
+				events.Record(res.newobj, obj.GroupVersionKind(), res.reason, c.eventMessage(res.message))
+			}
+			if res.err != nil {
+				errs = append(errs, res.err)
+			}
+		}
+		if len(errs) > 0 {
+			return rollback.Rollback(utilerrors.NewAggregate(errs))
+		}
+		done += len(wave)
+		progress.Report(done)
+	}
+	applySpan.End()
+
+	if c.Wait && !c.DryRun {
+		span := c.Tracer.Start(nil, "wait")
+		err := WaitForReady(context.Background(), c.ClientPool, c.Discovery, applied, c.DefaultNamespace, c.WaitTimeout)
+		span.End()
 		if err != nil {
-			// TODO: retry
-			return fmt.Errorf("Error updating %s: %s", desc, err)
+			return err
 		}
+	}
 
-		log.Debug("Updated object: ", diff.ObjectDiff(obj, newobj))
+	if c.GcTag != "" && c.MetadataOnly {
+		log.Info("Skipping garbage collection for metadata-only update")
+	} else if c.GcTag != "" && !c.SkipGc {
+		span := c.Tracer.Start(nil, "gc")
+		defer span.End()
 
-		// Some objects appear under multiple kinds
-		// (eg: Deployment is both extensions/v1beta1
-		// and apps/v1beta1).  UID is the only stable
-		// identifier that links these two views of
-		// the same object.
-		seenUids.Insert(string(newobj.GetUID()))
-	}
+		_, done, err := c.Budget.StartOperation(1, 1)
+		if err != nil {
+			return err
+		}
+		defer done()
 
-	if c.GcTag != "" && !c.SkipGc {
 		version, err := utils.FetchVersion(c.Discovery)
 		if err != nil {
 			return err
 		}
 
-		err = walkObjects(c.ClientPool, c.Discovery, metav1.ListOptions{}, func(o runtime.Object) error {
+		// Used to preview the impact of a dry-run gc
+		var pruned, live []*unstructured.Unstructured
+
+		err = walkObjects(c.gcClientPool(), c.Discovery, metav1.ListOptions{}, func(o runtime.Object) error {
 			meta, err := meta.Accessor(o)
 			if err != nil {
 				return err
@@ -128,13 +438,29 @@ func (c UpdateCmd) Run(apiObjects []*unstructured.Unstructured) error {
 			gvk := o.GetObjectKind().GroupVersionKind()
 			desc := fmt.Sprintf("%s %s (%s)", utils.ResourceNameFor(c.Discovery, o), utils.FqName(meta), gvk.GroupVersion())
 			log.Debugf("Considering %v for gc", desc)
+			u, isUnstructured := o.(*unstructured.Unstructured)
+			if c.DryRun && isUnstructured {
+				live = append(live, u)
+			}
 			if eligibleForGc(meta, c.GcTag) && !seenUids.Has(string(meta.GetUID())) {
+				if !gvkAllowed(c.GcAllowlist, gvk) {
+					log.Infof("Not garbage collecting %s: kind is not in the prune whitelist", desc)
+					return nil
+				}
+				if reason := gcKeptBy(meta, c.GcFilters); reason != "" {
+					log.Infof("Not garbage collecting %s: %s", desc, reason)
+					return nil
+				}
 				log.Info("Garbage collecting ", desc, dryRunText)
+				if c.DryRun && isUnstructured {
+					pruned = append(pruned, u)
+				}
 				if !c.DryRun {
 					err := gcDelete(c.ClientPool, c.Discovery, &version, o)
 					if err != nil {
 						return err
 					}
+					events.Record(meta, gvk, EventReasonDeleted, c.eventMessage("Garbage collected by kubecfg"))
 				}
 			}
 			return nil
@@ -142,11 +468,490 @@ func (c UpdateCmd) Run(apiObjects []*unstructured.Unstructured) error {
 		if err != nil {
 			return err
 		}
+
+		for _, w := range blastRadius(pruned, live) {
+			log.Warning(w)
+		}
+	}
+
+	if c.GcSelector != "" && c.MetadataOnly {
+		log.Info("Skipping garbage collection for metadata-only update")
+	} else if c.GcSelector != "" && !c.SkipGc {
+		span := c.Tracer.Start(nil, "gc-digest")
+		defer span.End()
+
+		_, done, err := c.Budget.StartOperation(1, 1)
+		if err != nil {
+			return err
+		}
+		defer done()
+		opts := GcOptions{DryRun: c.DryRun, PropagationPolicy: c.GcPropagation, Allowlist: c.GcAllowlist, Filters: c.GcFilters, KeepUids: seenUids}
+		deleted, err := GarbageCollect(context.Background(), c.gcClientPool(), c.Discovery, c.GcSelector, digest, opts)
+		if err != nil {
+			return err
+		}
+		if !c.DryRun {
+			for _, o := range deleted {
+				events.Record(o, o.GroupVersionKind(), EventReasonDeleted, c.eventMessage("Garbage collected by kubecfg"))
+			}
+		}
 	}
 
 	return nil
 }
 
+// apply patches (or creates) a single object.  Returns a nil object
+// if the object was skipped, and the event reason and message to
+// record otherwise.
+func (c UpdateCmd) apply(logger log.FieldLogger, rc *dynamic.ResourceClient, obj *unstructured.Unstructured, desc string, asPatch []byte) (metav1.Object, string, string, error) {
+	dryRunText := ""
+	if c.DryRun {
+		dryRunText = " (dry-run)"
+	}
+
+	var newobj metav1.Object
+	var err error
+	if c.Overwrite {
+		newobj, err = overwrite(rc, obj, c.DryRun)
+		logger.Debugf("overwrite(%s) returned (%v, %v)", obj.GetName(), newobj, err)
+	} else if c.ServerSide && !c.DryRun {
+		// Server-side apply creates missing objects itself
+		newobj, err = rc.Patch(obj.GetName(), utils.ApplyPatchType, asPatch)
+		logger.Debugf("Apply(%s) returned (%v, %v)", obj.GetName(), newobj, err)
+		if err != nil {
+			return nil, "", "", applyConflictError(desc, err)
+		}
+		return newobj, EventReasonUpdated, "Applied by kubecfg", nil
+	} else if !c.DryRun {
+		pt := c.patchType(rc, obj)
+		newobj, err = rc.Patch(obj.GetName(), pt, asPatch)
+		logger.Debugf("Patch(%s) returned (%v, %v)", obj.GetName(), newobj, err)
+		if pt == types.StrategicMergePatchType && isUnsupportedMediaType(err) {
+			c.patchTypes.disable(obj.GroupVersionKind())
+			newobj, err = rc.Patch(obj.GetName(), types.MergePatchType, asPatch)
+			logger.Debugf("Patch(%s) returned (%v, %v)", obj.GetName(), newobj, err)
+		}
+	} else {
+		newobj, err = rc.Get(obj.GetName(), metav1.GetOptions{})
+	}
+	if c.MetadataOnly && errors.IsNotFound(err) {
+		logger.Info(" Skipping non-existent ", desc)
+		return nil, "", "", nil
+	}
+	reason, message := EventReasonUpdated, "Updated by kubecfg"
+	if c.Create && errors.IsNotFound(err) {
+		logger.Info(" Creating non-existent ", desc, dryRunText)
+		reason, message = EventReasonCreated, "Created by kubecfg"
+		if !c.DryRun {
+			newobj, err = rc.Create(obj)
+			logger.Debugf("Create(%s) returned (%v, %v)", obj.GetName(), newobj, err)
+		} else {
+			newobj = obj
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	return newobj, reason, message, nil
+}
+
+// patchType returns the type of patch to use to update obj.  A
+// strategic merge patch depends on the live object (see
+// patchTypeChooser.For), which is only fetched for kinds that support
+// them.
+func (c UpdateCmd) patchType(rc *dynamic.ResourceClient, obj *unstructured.Unstructured) types.PatchType {
+	if c.patchTypes.mergeKeys(obj.GroupVersionKind()) == nil {
+		return types.MergePatchType
+	}
+	live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		// Most likely NotFound, and the patch will fail too
+		return types.MergePatchType
+	}
+	return c.patchTypes.For(obj, live)
+}
+
+// isUnsupportedMediaType returns true if err is the server rejecting
+// a patch type, eg: a strategic merge patch of a custom resource
+func isUnsupportedMediaType(err error) bool {
+	status, ok := err.(errors.APIStatus)
+	return ok && status.Status().Code == http.StatusUnsupportedMediaType
+}
+
+// How often to retry an update that loses a race with another
+// client, and the initial backoff between attempts (doubled after
+// each conflict).  Vars for tests.
+var (
+	conflictRetries    = 4
+	conflictRetryDelay = 100 * time.Millisecond
+)
+
+// applyState is shared by the objects applied concurrently by
+// applyWave
+type applyState struct {
+	// all is every object in the update
+	all      []*unstructured.Unstructured
+	rollback *rollbackLog
+	span     *utils.Span
+
+	// nsLock serialises recreating terminating namespaces
+	nsLock sync.Mutex
+}
+
+// applyItem is a single object to apply
+type applyItem struct {
+	obj  *unstructured.Unstructured
+	desc string
+	// remaining is the number of objects still to be applied,
+	// including this one
+	remaining int
+}
+
+// applyResult is the outcome of applying an applyItem.  newobj is
+// set if the object was changed, even if a later step (eg: a
+// post-apply hook) failed.
+type applyResult struct {
+	newobj          metav1.Object
+	reason, message string
+	snapshot        *rollbackEntry
+	// uids are the UIDs of objects created or updated
+	uids []string
+	err  error
+	// output is the messages logged while applying the object
+	output []byte
+}
+
+// applyWave applies the objects in items, using up to
+// ApplyConcurrency concurrent workers.  Once any object fails, the
+// objects not yet started are skipped.  The results are in the same
+// order as items.
+func (c UpdateCmd) applyWave(items []applyItem, state *applyState) []applyResult {
+	results := make([]applyResult, len(items))
+
+	workers := c.ApplyConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	work := make(chan int)
+	var mu sync.Mutex
+	failed := false
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				mu.Lock()
+				skip := failed
+				mu.Unlock()
+				if skip {
+					continue
+				}
+				results[j] = c.applyObject(items[j], state)
+				if results[j].err != nil {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for j := range items {
+		work <- j
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+// applyObject creates or updates a single object, running its
+// hooks and PDB gate.  It must be safe to call concurrently, so
+// messages are collected in res.output rather than logged directly.
+func (c UpdateCmd) applyObject(item applyItem, state *applyState) (res applyResult) {
+	obj, desc := item.obj, item.desc
+
+	var output bytes.Buffer
+	logger := bufferedLogger(&output)
+	defer func() { res.output = output.Bytes() }()
+
+	dryRunText := ""
+	if c.DryRun {
+		dryRunText = " (dry-run)"
+	}
+	logger.Info("Updating ", desc, dryRunText)
+
+	_, done, err := c.Budget.StartOperation(item.remaining, c.ApplyConcurrency)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer done()
+
+	span := c.Tracer.Start(state.span, "update "+desc)
+	span.SetObjectAttributes(obj)
+	defer func() {
+		if res.err != nil {
+			span.SetError(res.err)
+		}
+		span.End()
+	}()
+
+	rc, err := clientForResource(c.ClientPool, c.Discovery, obj, c.DefaultNamespace, c.WaitForCRDs)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	var patch interface{} = obj
+	if c.MetadataOnly {
+		patch = metadataOnly(obj)
+	}
+	asPatch, err := json.Marshal(patch)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	res.snapshot, err = state.rollback.Snapshot(rc, desc, obj)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	if !c.DryRun {
+		if err := c.Hooks.runPre(rc, obj); err != nil {
+			res.err = fmt.Errorf("Error in pre-apply hook for %s: %v", desc, err)
+			return res
+		}
+	}
+	newobj, reason, message, err := c.applyWithRetry(logger, rc, obj, desc, asPatch)
+	if isNamespaceTerminating(err) && !c.DryRun {
+		if nsObj := findNamespace(state.all, namespaceOf(obj, c.DefaultNamespace)); nsObj != nil {
+			state.nsLock.Lock()
+			logger.Info(" Namespace is terminating, waiting to recreate it")
+			var ns metav1.Object
+			ns, err = recreateNamespace(logger, c.ClientPool, c.Discovery, nsObj)
+			state.nsLock.Unlock()
+			if err == nil {
+				res.uids = append(res.uids, string(ns.GetUID()))
+				newobj, reason, message, err = c.applyWithRetry(logger, rc, obj, desc, asPatch)
+			}
+		}
+	}
+	if c.RecreateOnImmutable && isImmutableFieldError(err) {
+		newobj, reason, message, err = c.recreate(logger, rc, obj, desc, err)
+		if err == nil && res.snapshot != nil {
+			res.snapshot.recreated = true
+		}
+	}
+	utils.Metrics().Apply(obj.GroupVersionKind(), err)
+	if err != nil {
+		res.err = fmt.Errorf("Error updating %s: %s", desc, err)
+		return res
+	}
+	if newobj == nil {
+		// Skipped
+		return res
+	}
+	res.newobj, res.reason, res.message = newobj, reason, message
+
+	if u, ok := newobj.(*unstructured.Unstructured); ok && !c.DryRun {
+		if err := c.Hooks.runPost(rc, u); err != nil {
+			res.err = fmt.Errorf("Error in post-apply hook for %s: %v", desc, err)
+			return res
+		}
+	}
+
+	if c.PDBGate && !c.DryRun {
+		if err := gateOnPDBs(logger, c.ClientPool, rc, newobj, desc, c.PDBGateTimeout); err != nil {
+			res.err = err
+			return res
+		}
+	}
+
+	// Some objects appear under multiple kinds
+	// (eg: Deployment is both extensions/v1beta1
+	// and apps/v1beta1).  UID is the only stable
+	// identifier that links these two views of
+	// the same object.
+	res.uids = append(res.uids, string(newobj.GetUID()))
+	return res
+}
+
+// gcClientPool returns the client pool to list objects for garbage
+// collection with.  A dry-run also previews the blast radius from the
+// full live objects, so always uses ClientPool.
+func (c UpdateCmd) gcClientPool() dynamic.ClientPool {
+	if c.GcClientPool == nil || c.DryRun {
+		return c.ClientPool
+	}
+	return c.GcClientPool
+}
+
+// bufferedLogger returns a logger with the same format and level as
+// the standard logger, that writes to buf
+func bufferedLogger(buf *bytes.Buffer) *log.Logger {
+	std := log.StandardLogger()
+	return &log.Logger{
+		Out:       buf,
+		Formatter: std.Formatter,
+		Hooks:     std.Hooks,
+		Level:     log.GetLevel(),
+	}
+}
+
+// applyWithRetry is apply, except it retries (with exponential
+// backoff) when the update conflicts with a concurrent change.  Each
+// attempt starts over from the live object.  The caller annotates
+// the final error with desc.  Server-side apply conflicts are
+// between field managers, and are not retried.
+func (c UpdateCmd) applyWithRetry(logger log.FieldLogger, rc *dynamic.ResourceClient, obj *unstructured.Unstructured, desc string, asPatch []byte) (metav1.Object, string, string, error) {
+	delay := conflictRetryDelay
+	for i := 0; ; i++ {
+		newobj, reason, message, err := c.apply(logger, rc, obj, desc, asPatch)
+		if !errors.IsConflict(err) || c.ServerSide {
+			return newobj, reason, message, err
+		}
+		if i >= conflictRetries {
+			return nil, "", "", fmt.Errorf("Gave up after %d conflicting updates: %v", i+1, err)
+		}
+		logger.Debugf("Conflict updating %s, retrying in %s: %v", desc, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// How long to wait for newly created CRDs to be served
+const (
+	crdEstablishRetries = 10
+	crdEstablishDelay   = time.Second
+)
+
+// clientForResource is utils.ClientForResource, except with
+// waitForCRDs it waits for custom resource kinds whose CRD has only
+// just been created.
+func clientForResource(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, obj runtime.Object, defNs string, waitForCRDs bool) (*dynamic.ResourceClient, error) {
+	if !waitForCRDs {
+		return utils.ClientForResource(pool, disco, obj, defNs)
+	}
+	for i := 0; ; i++ {
+		rc, err := utils.ClientForResourceEstablished(pool, disco, obj, defNs)
+		if !utils.IsResourceNotEstablished(err) || i >= crdEstablishRetries {
+			return rc, err
+		}
+		log.Debugf("%v, retrying", err)
+		if cached, ok := disco.(utils.SelectiveInvalidator); ok {
+			cached.InvalidateResources()
+		} else if cached, ok := disco.(discovery.CachedDiscoveryInterface); ok {
+			cached.Invalidate()
+		}
+		time.Sleep(crdEstablishDelay)
+	}
+}
+
+// overwrite replaces the live version of obj with the fields of obj
+// merged over it.  Fields not present in obj are left untouched.
+func overwrite(rc *dynamic.ResourceClient, obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	live, err := rc.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	drifted := driftedPaths(live.Object, obj.Object)
+	for _, p := range drifted {
+		log.Info(" Resetting drifted field ", strings.Join(p, "."))
+	}
+	if len(drifted) == 0 || dryRun {
+		return live, nil
+	}
+
+	// NB: live resourceVersion is retained, so this fails with a
+	// conflict if the object changes in the meantime.
+	overlay(live.Object, obj.Object)
+	return rc.Update(live)
+}
+
+// overlay recursively merges src into dst.  Values in src replace
+// those in dst, except where both are objects.
+func overlay(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcChild, srcOk := v.(map[string]interface{})
+		dstChild, dstOk := dst[k].(map[string]interface{})
+		if srcOk && dstOk {
+			overlay(dstChild, srcChild)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+// driftedPaths returns the paths of fields in desired whose live
+// value differs.
+func driftedPaths(live, desired map[string]interface{}) [][]string {
+	ret := [][]string{}
+	for _, p := range leafPaths(nil, desired, nil) {
+		want, _ := fieldAt(desired, p)
+		if m, ok := want.(map[string]interface{}); ok && len(m) == 0 {
+			// Empty objects don't specify anything
+			continue
+		}
+		got, found := fieldAt(live, p)
+		if !found || !jsonEqual(want, got) {
+			ret = append(ret, p)
+		}
+	}
+	sort.Sort(pathList(ret))
+	return ret
+}
+
+func fieldAt(obj map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = obj
+	for _, f := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = m[f]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// jsonEqual compares a and b, ignoring differences in Go numeric types
+func jsonEqual(a, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(ja) == string(jb)
+}
+
+type pathList [][]string
+
+func (l pathList) Len() int      { return len(l) }
+func (l pathList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l pathList) Less(i, j int) bool {
+	return strings.Join(l[i], ".") < strings.Join(l[j], ".")
+}
+
+// metadataOnly returns a merge patch containing only the labels and
+// annotations of obj.
+func metadataOnly(obj *unstructured.Unstructured) map[string]interface{} {
+	meta := map[string]interface{}{}
+	if labels := obj.GetLabels(); labels != nil {
+		meta["labels"] = labels
+	}
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		meta["annotations"] = annotations
+	}
+	return map[string]interface{}{"metadata": meta}
+}
+
 func stringListContains(list []string, value string) bool {
 	for _, item := range list {
 		if item == value {
@@ -165,18 +970,8 @@ func gcDelete(clientpool dynamic.ClientPool, disco discovery.DiscoveryInterface,
 	uid := obj.GetUID()
 	desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(disco, o), utils.FqName(obj))
 
-	deleteOpts := metav1.DeleteOptions{
-		Preconditions: &metav1.Preconditions{UID: &uid},
-	}
-	if version.Compare(1, 6) < 0 {
-		// 1.5.x option
-		boolFalse := false
-		deleteOpts.OrphanDependents = &boolFalse
-	} else {
-		// 1.6.x option (NB: Background is broken)
-		fg := metav1.DeletePropagationForeground
-		deleteOpts.PropagationPolicy = &fg
-	}
+	deleteOpts := deleteOptions(*version, true, -1)
+	deleteOpts.Preconditions = &metav1.Preconditions{UID: &uid}
 
 	c, err := utils.ClientForResource(clientpool, disco, o, metav1.NamespaceNone)
 	if err != nil {
@@ -197,8 +992,10 @@ func gcDelete(clientpool dynamic.ClientPool, disco discovery.DiscoveryInterface,
 }
 
 func walkObjects(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, listopts metav1.ListOptions, callback func(runtime.Object) error) error {
+	// Objects of undiscoverable kinds are not visited, and so
+	// are never garbage collected
 	rsrclists, err := disco.ServerResources()
-	if err != nil {
+	if err = utils.WarnOnPartialDiscovery(err); err != nil {
 		return err
 	}
 	for _, rsrclist := range rsrclists {
@@ -240,6 +1037,56 @@ func walkObjects(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, li
 }
 
 func eligibleForGc(obj metav1.Object, gcTag string) bool {
+	return obj.GetAnnotations()[AnnotationGcTag] == gcTag && gcAllowed(obj)
+}
+
+// gvkAllowed returns true if gvk is in allowlist, or allowlist is
+// empty
+func gvkAllowed(allowlist []schema.GroupVersionKind, gvk schema.GroupVersionKind) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	for _, a := range allowlist {
+		if a == gvk {
+			return true
+		}
+	}
+	return false
+}
+
+// GcFilter returns the reason obj, a garbage collection candidate,
+// must be kept, or "" if it may be deleted
+type GcFilter func(obj metav1.Object) string
+
+// DefaultGcFilters are used when no GcFilters are given
+var DefaultGcFilters = []GcFilter{GcPolicyFilter}
+
+// GcPolicyFilter keeps objects that opt out of garbage collection
+// with AnnotationGcPolicy
+func GcPolicyFilter(obj metav1.Object) string {
+	if obj.GetAnnotations()[AnnotationGcPolicy] == GcPolicyDisabled {
+		return fmt.Sprintf("%s is %q", AnnotationGcPolicy, GcPolicyDisabled)
+	}
+	return ""
+}
+
+// gcKeptBy returns the reason given by the first of filters (or
+// DefaultGcFilters, if nil) to keep obj, or "" if none do
+func gcKeptBy(obj metav1.Object, filters []GcFilter) string {
+	if filters == nil {
+		filters = DefaultGcFilters
+	}
+	for _, f := range filters {
+		if reason := f(obj); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// gcAllowed returns false if obj has a controller, or opts out of
+// garbage collection with AnnotationGcStrategy
+func gcAllowed(obj metav1.Object) bool {
 	for _, ref := range obj.GetOwnerReferences() {
 		if ref.Controller != nil && *ref.Controller {
 			// Has a controller ref
@@ -247,13 +1094,9 @@ func eligibleForGc(obj metav1.Object, gcTag string) bool {
 		}
 	}
 
-	a := obj.GetAnnotations()
-
-	strategy, ok := a[AnnotationGcStrategy]
+	strategy, ok := obj.GetAnnotations()[AnnotationGcStrategy]
 	if !ok {
 		strategy = GcStrategyAuto
 	}
-
-	return a[AnnotationGcTag] == gcTag &&
-		strategy == GcStrategyAuto
+	return strategy == GcStrategyAuto
 }
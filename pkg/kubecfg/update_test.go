@@ -1,10 +1,26 @@
 package kubecfg
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
 
 	"github.com/ksonnet/kubecfg/utils"
 )
@@ -79,3 +95,487 @@ func TestEligibleForGc(t *testing.T) {
 		t.Errorf("%v should not be eligible (controller ownerref)", o)
 	}
 }
+
+func TestGcKeptBy(t *testing.T) {
+	o := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if reason := gcKeptBy(o, nil); reason != "" {
+		t.Errorf("Unexpected reason %q to keep %v", reason, o)
+	}
+
+	utils.SetMetaDataAnnotation(o, AnnotationGcPolicy, GcPolicyDisabled)
+	if reason := gcKeptBy(o, nil); reason == "" {
+		t.Errorf("%v should be kept by the default filters", o)
+	}
+	if reason := gcKeptBy(o, []GcFilter{}); reason != "" {
+		t.Errorf("%v should not be kept without filters, got %q", o, reason)
+	}
+}
+
+func TestGcClientPool(t *testing.T) {
+	pool := dynamic.NewDynamicClientPool(&rest.Config{Host: "https://pool.example.com"})
+	gcPool := dynamic.NewDynamicClientPool(&rest.Config{Host: "https://gc.example.com"})
+
+	c := UpdateCmd{ClientPool: pool}
+	if c.gcClientPool() != pool {
+		t.Errorf("Expected ClientPool without a GcClientPool")
+	}
+	c.GcClientPool = gcPool
+	if c.gcClientPool() != gcPool {
+		t.Errorf("Expected GcClientPool")
+	}
+	c.DryRun = true
+	if c.gcClientPool() != pool {
+		t.Errorf("Expected ClientPool for a dry-run")
+	}
+}
+
+func TestMetadataOnly(t *testing.T) {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      "myname",
+				"namespace": "myns",
+				"labels": map[string]interface{}{
+					"team": "foo",
+				},
+				"annotations": map[string]interface{}{
+					"owner": "bar",
+				},
+			},
+			"data": map[string]interface{}{
+				"key": "value",
+			},
+		},
+	}
+
+	expected := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]string{"team": "foo"},
+			"annotations": map[string]string{"owner": "bar"},
+		},
+	}
+	if patch := metadataOnly(obj); !reflect.DeepEqual(patch, expected) {
+		t.Errorf("Expected %v, got %v", expected, patch)
+	}
+
+	delete(obj.Object, "metadata")
+	expected = map[string]interface{}{
+		"metadata": map[string]interface{}{},
+	}
+	if patch := metadataOnly(obj); !reflect.DeepEqual(patch, expected) {
+		t.Errorf("Expected %v, got %v", expected, patch)
+	}
+}
+
+func TestUpdateTracing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"myobj","namespace":"default","uid":"1234"}}`)
+	}))
+	defer srv.Close()
+
+	exporter := &utils.InMemoryExporter{}
+	tracer := utils.NewTracer(exporter)
+	tracer.Root = tracer.Start(nil, "root")
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		DryRun:           true,
+		Tracer:           tracer,
+	}
+
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "tests/v1alpha1",
+			"kind":       "Test",
+			"metadata": map[string]interface{}{
+				"name": "myobj",
+			},
+		},
+	}
+	if err := c.Run([]*unstructured.Unstructured{obj}); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Flush()
+
+	spans := map[string]*utils.Span{}
+	for _, s := range exporter.Spans {
+		spans[s.Name] = s
+	}
+	disco, apply, update := spans["discovery"], spans["apply"], spans["update tests myobj"]
+	if disco == nil || disco.ParentSpanID != tracer.Root.SpanID {
+		t.Errorf("Missing or unexpected discovery span: %#v", disco)
+	}
+	if apply == nil || apply.ParentSpanID != tracer.Root.SpanID {
+		t.Fatalf("Missing or unexpected apply span: %#v", apply)
+	}
+	if update == nil || update.ParentSpanID != apply.SpanID {
+		t.Fatalf("Missing or unexpected update span: %v", exporter.Spans)
+	}
+	if update.Attributes["k8s.kind"] != "Test" || update.Attributes["k8s.name"] != "myobj" || update.Attributes["k8s.group"] != "tests" {
+		t.Errorf("Unexpected attributes %v", update.Attributes)
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	var updated map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{
+  "apiVersion": "tests/v1alpha1",
+  "kind": "Test",
+  "metadata": {"name": "myobj", "namespace": "default", "resourceVersion": "42", "labels": {"app": "myobj", "extra": "live"}},
+  "spec": {"replicas": 5, "paused": true}
+}`)
+		case "PUT":
+			if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+				t.Error(err)
+			}
+			json.NewEncoder(w).Encode(updated)
+		default:
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		Overwrite:        true,
+	}
+
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "tests/v1alpha1",
+			"kind":       "Test",
+			"metadata": map[string]interface{}{
+				"name":   "myobj",
+				"labels": map[string]interface{}{"app": "myobj"},
+			},
+			"spec": map[string]interface{}{
+				"replicas": 2,
+			},
+		},
+	}
+	if err := c.Run([]*unstructured.Unstructured{obj}); err != nil {
+		t.Fatal(err)
+	}
+
+	if updated == nil {
+		t.Fatalf("Object was not updated")
+	}
+	spec := updated["spec"].(map[string]interface{})
+	if spec["replicas"] != 2.0 {
+		t.Errorf("Drifted field was not reset: %v", spec)
+	}
+	if spec["paused"] != true {
+		t.Errorf("Unspecified field was not preserved: %v", spec)
+	}
+	metadata := updated["metadata"].(map[string]interface{})
+	if metadata["resourceVersion"] != "42" {
+		t.Errorf("Live resourceVersion was not retained: %v", metadata)
+	}
+	if labels := metadata["labels"].(map[string]interface{}); labels["extra"] != "live" {
+		t.Errorf("Unspecified label was not preserved: %v", labels)
+	}
+}
+
+func TestUpdateConflictRetry(t *testing.T) {
+	defer func(d time.Duration) { conflictRetryDelay = d }(conflictRetryDelay)
+	conflictRetryDelay = time.Millisecond
+
+	conflicts, gets, puts := 0, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			gets++
+			fmt.Fprintf(w, `{"apiVersion":"tests/v1alpha1","kind":"Test","metadata":{"name":"myobj","namespace":"default","resourceVersion":"%d"},"spec":{"replicas":5}}`, gets)
+		case "PUT":
+			puts++
+			if puts <= conflicts {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Conflict","code":409,"message":"the object has been modified"}`)
+				return
+			}
+			io.Copy(w, r.Body)
+		default:
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		Overwrite:        true,
+	}
+	obj := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "tests/v1alpha1",
+				"kind":       "Test",
+				"metadata":   map[string]interface{}{"name": "myobj"},
+				"spec":       map[string]interface{}{"replicas": 2},
+			},
+		}
+	}
+
+	conflicts = 1
+	if err := c.Run([]*unstructured.Unstructured{obj()}); err != nil {
+		t.Fatal(err)
+	}
+	if gets != 2 || puts != 2 {
+		t.Errorf("Expected the live object to be re-fetched after a conflict, got %d GETs and %d PUTs", gets, puts)
+	}
+
+	gets, puts, conflicts = 0, 0, 100
+	err := c.Run([]*unstructured.Unstructured{obj()})
+	if err == nil || !strings.Contains(err.Error(), "tests myobj") || !strings.Contains(err.Error(), "5 conflicting updates") {
+		t.Errorf("Unexpected error after exhausting retries: %v", err)
+	}
+	if puts != conflictRetries+1 {
+		t.Errorf("Expected %d attempts, got %d", conflictRetries+1, puts)
+	}
+}
+
+func TestDriftedPaths(t *testing.T) {
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo", "labels": map[string]interface{}{"a": "1"}},
+		"spec":     map[string]interface{}{"replicas": int64(3), "paused": true},
+	}
+	desired := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo", "annotations": map[string]interface{}{}},
+		"spec":     map[string]interface{}{"replicas": 3.0, "image": "bar"},
+	}
+	expected := [][]string{{"spec", "image"}}
+	if paths := driftedPaths(live, desired); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+}
+
+func TestUpdateDeployID(t *testing.T) {
+	var patched []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "PATCH" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+		}
+		var obj map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			t.Error(err)
+		}
+		patched = append(patched, obj)
+		json.NewEncoder(w).Encode(obj)
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		DeployID:         NewDeployID(),
+	}
+	if len(c.DeployID) != 36 || c.DeployID == NewDeployID() {
+		t.Errorf("Unexpected deploy ID %q", c.DeployID)
+	}
+
+	newObjs := func() []*unstructured.Unstructured {
+		var objs []*unstructured.Unstructured
+		for _, name := range []string{"one", "two"} {
+			objs = append(objs, &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"apiVersion": "tests/v1alpha1",
+					"kind":       "Test",
+					"metadata":   map[string]interface{}{"name": name},
+				},
+			})
+		}
+		return objs
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	for _, stamp := range []bool{true, false} {
+		patched = nil
+		logs.Reset()
+		c.StampDeployID = stamp
+		if err := c.Run(newObjs()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(patched) != 2 {
+			t.Fatalf("Expected 2 patches, got %d", len(patched))
+		}
+		expected := ""
+		if stamp {
+			expected = c.DeployID
+		}
+		for _, obj := range patched {
+			u := unstructured.Unstructured{Object: obj}
+			if id := u.GetAnnotations()[AnnotationDeployID]; id != expected {
+				t.Errorf("Object %s has deploy ID %q, expected %q", u.GetName(), id, expected)
+			}
+		}
+		if summary := fmt.Sprintf("Deploy %s complete: 2 objects applied", c.DeployID); !strings.Contains(logs.String(), summary) {
+			t.Errorf("Missing outcome summary %q in logs:\n%s", summary, logs.String())
+		}
+	}
+}
+
+func TestUpdateConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/broken") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","code":500,"message":"server exploded"}`)
+			return
+		}
+		if r.Method == "PATCH" && (strings.HasSuffix(r.URL.Path, "/b") || strings.HasSuffix(r.URL.Path, "/e")) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer srv.Close()
+
+	c := UpdateCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "tests/v1alpha1",
+					APIResources: []metav1.APIResource{
+						{Name: "tests", Kind: "Test", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+		ApplyConcurrency: 3,
+		Create:           true,
+	}
+	objs := func(names ...string) []*unstructured.Unstructured {
+		var ret []*unstructured.Unstructured
+		for _, name := range names {
+			ret = append(ret, &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"apiVersion": "tests/v1alpha1",
+					"kind":       "Test",
+					"metadata":   map[string]interface{}{"name": name},
+				},
+			})
+		}
+		return ret
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	if err := c.Run(objs("f", "e", "d", "c", "b", "a")); err != nil {
+		t.Fatal(err)
+	}
+	if maxInFlight < 2 || maxInFlight > c.ApplyConcurrency {
+		t.Errorf("Expected between 2 and %d concurrent requests, got %d", c.ApplyConcurrency, maxInFlight)
+	}
+	// Each object's messages are together, and in order
+	var lines []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if i := strings.Index(line, "msg="); i >= 0 && strings.Contains(line, " tests ") {
+			lines = append(lines, strings.TrimSpace(line[i:]))
+		}
+	}
+	expected := []string{
+		`msg="Updating tests a"`,
+		`msg="Updating tests b"`,
+		`msg=" Creating non-existent tests b"`,
+		`msg="Updating tests c"`,
+		`msg="Updating tests d"`,
+		`msg="Updating tests e"`,
+		`msg=" Creating non-existent tests e"`,
+		`msg="Updating tests f"`,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected objects to be logged in order, got:\n%s", logs.String())
+	}
+
+	// Concurrent operations share a --timeout budget without
+	// cutting each other short
+	c.Budget = utils.NewBudget(2*time.Second, 100)
+	c.ClientPool = dynamic.NewDynamicClientPool(&rest.Config{
+		Host: srv.URL,
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return utils.NewBudgetTransport(c.Budget, rt)
+		},
+	})
+	if err := c.Run(objs("f", "e", "d", "c", "b", "a")); err != nil {
+		t.Errorf("Update with a budget failed: %v", err)
+	}
+	c.Budget = nil
+
+	err := c.Run(objs("a", "broken", "c"))
+	if err == nil || !strings.Contains(err.Error(), "Error updating tests broken") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -18,9 +18,12 @@ package kubecfg
 import (
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/go-openapi/spec"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 
 	"github.com/ksonnet/kubecfg/utils"
@@ -28,24 +31,63 @@ import (
 
 // ValidateCmd represents the validate subcommand
 type ValidateCmd struct {
+	// Discovery is used to fetch the server schema.  If nil,
+	// server validation is skipped.
 	Discovery discovery.DiscoveryInterface
+
+	// JSONSchemas are additional user-supplied schemas
+	JSONSchemas []*utils.JSONSchemaRule
 }
 
 func (c ValidateCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer) error {
 	hasError := false
 
+	// Prefer the OpenAPI v3 schema of each group version, then
+	// the OpenAPI v2 schema, falling back to the older swagger
+	// 1.2 schema for servers that don't publish one
+	v3 := openAPIV3Schemas{}
+	var openapi *spec.Swagger
+	if c.Discovery != nil {
+		doc, err := c.Discovery.OpenAPISchema()
+		if err != nil {
+			log.Debugf("Unable to fetch OpenAPI schema, using swagger schema: %v", err)
+		} else if len(doc.Definitions) > 0 {
+			openapi = doc
+		}
+	}
+
 	for _, obj := range apiObjects {
-		desc := fmt.Sprintf("%s %s", utils.ResourceNameFor(c.Discovery, obj), utils.FqName(obj))
+		rsrc := strings.ToLower(obj.GetKind())
+		if c.Discovery != nil {
+			rsrc = utils.ResourceNameFor(c.Discovery, obj)
+		}
+		desc := fmt.Sprintf("%s %s", rsrc, utils.FqName(obj))
 		log.Info("Validating ", desc)
 
 		var allErrs []error
 
-		schema, err := utils.NewSwaggerSchemaFor(c.Discovery, obj.GroupVersionKind().GroupVersion())
-		if err != nil {
-			allErrs = append(allErrs, fmt.Errorf("Unable to fetch schema: %v", err))
-		} else {
-			// Validate obj
-			allErrs = append(allErrs, schema.Validate(obj)...)
+		if doc := v3.get(c.Discovery, obj.GroupVersionKind().GroupVersion()); utils.SchemaHasKind(doc, obj.GroupVersionKind()) {
+			allErrs = append(allErrs, utils.ValidateOpenAPI(obj, doc)...)
+		} else if openapi != nil {
+			if utils.SchemaHasKind(openapi, obj.GroupVersionKind()) {
+				allErrs = append(allErrs, utils.ValidateOpenAPI(obj, openapi)...)
+			} else {
+				log.Warningf("Skipping schema validation of %s: %s not found in server schema", desc, obj.GroupVersionKind())
+			}
+		} else if c.Discovery != nil {
+			schema, err := utils.NewSwaggerSchemaFor(c.Discovery, obj.GroupVersionKind().GroupVersion())
+			if err != nil {
+				allErrs = append(allErrs, fmt.Errorf("Unable to fetch schema: %v", err))
+			} else {
+				// Validate obj
+				allErrs = append(allErrs, schema.Validate(obj)...)
+			}
+		}
+
+		for _, rule := range c.JSONSchemas {
+			if rule.Matches(obj.GroupVersionKind()) {
+				allErrs = append(allErrs, rule.Validate(obj)...)
+			}
 		}
 
 		for _, err := range allErrs {
@@ -60,3 +102,26 @@ func (c ValidateCmd) Run(apiObjects []*unstructured.Unstructured, out io.Writer)
 
 	return nil
 }
+
+// openAPIV3Schemas holds the OpenAPI v3 schema of each group version
+// (nil if unavailable), so failures are only reported once
+type openAPIV3Schemas map[schema.GroupVersion]*spec.Swagger
+
+// get returns the OpenAPI v3 schema of gv, or nil if disco can't
+// fetch it (eg: Kubernetes < 1.24)
+func (s openAPIV3Schemas) get(disco discovery.DiscoveryInterface, gv schema.GroupVersion) *spec.Swagger {
+	if doc, ok := s[gv]; ok {
+		return doc
+	}
+	v3, ok := disco.(utils.OpenAPIV3SchemaInterface)
+	if !ok {
+		return nil
+	}
+	doc, err := v3.OpenAPIV3Schema(gv)
+	if err != nil {
+		log.Debugf("Unable to fetch OpenAPI v3 schema for %s, using older schema: %v", gv, err)
+		doc = nil
+	}
+	s[gv] = doc
+	return doc
+}
@@ -0,0 +1,286 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/utils"
+)
+
+// WatchCmd represents the watch subcommand
+type WatchCmd struct {
+	ClientPool       dynamic.ClientPool
+	Discovery        discovery.DiscoveryInterface
+	DefaultNamespace string
+}
+
+// watchTarget is a single watch, covering the objects of one kind
+// in one namespace
+type watchTarget struct {
+	gvk   schema.GroupVersionKind
+	rc    *dynamic.ResourceClient
+	names sets.String
+	// seen is the last resourceVersion seen for each watched
+	// object, by namespace/name
+	seen map[string]string
+}
+
+// watchEvent is an event for one of the watched objects
+type watchEvent struct {
+	gvk  schema.GroupVersionKind
+	typ  watch.EventType
+	name string
+}
+
+func (e watchEvent) String() string {
+	return fmt.Sprintf("%s %s/%s %s", e.typ, e.gvk.GroupVersion(), e.gvk.Kind, e.name)
+}
+
+// Run streams the events for apiObjects to out, one line per event,
+// until ctx is done.  Each line gives the event type, group/version/
+// kind and namespace/name of the object.  The initial state of each
+// existing object is reported as an ADDED event.
+func (c WatchCmd) Run(ctx context.Context, apiObjects []*unstructured.Unstructured, out io.Writer) error {
+	targets := map[string]*watchTarget{}
+	var keys []string
+	for _, obj := range apiObjects {
+		gvk := obj.GroupVersionKind()
+		key := fmt.Sprintf("%s %s", gvk, namespaceOf(obj, c.DefaultNamespace))
+		if t, ok := targets[key]; ok {
+			t.names.Insert(obj.GetName())
+			continue
+		}
+		rc, err := utils.ClientForResource(c.ClientPool, c.Discovery, obj, c.DefaultNamespace)
+		if err != nil {
+			return err
+		}
+		targets[key] = &watchTarget{gvk: gvk, rc: rc, names: sets.NewString(obj.GetName()), seen: map[string]string{}}
+		keys = append(keys, key)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan watchEvent)
+	errs := make(chan error, len(targets))
+	for _, key := range keys {
+		go func(t *watchTarget) {
+			errs <- t.watch(ctx, events)
+		}(targets[key])
+	}
+
+	for running := len(targets); running > 0; {
+		select {
+		case ev := <-events:
+			fmt.Fprintln(out, ev)
+		case err := <-errs:
+			running--
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// watch sends the events for t's objects to events until ctx is
+// done.  Watches that end are restarted from the last resourceVersion
+// seen, or from a fresh list if that resourceVersion has expired.
+// Changes missed while relisting are reported as synthetic events.
+func (t *watchTarget) watch(ctx context.Context, events chan<- watchEvent) error {
+	opts := metav1.ListOptions{}
+	if t.names.Len() == 1 {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", t.names.List()[0]).String()
+	}
+
+	for ctx.Err() == nil {
+		w, err := t.rc.Watch(opts)
+		expired := isExpired(err)
+		if err != nil && !expired {
+			return fmt.Errorf("Error watching %s: %v", t.gvk.Kind, err)
+		}
+		if err == nil {
+			expired, err = t.stream(ctx, w, &opts, events)
+			w.Stop()
+			if err != nil {
+				return err
+			}
+		}
+		if !expired {
+			log.Debugf("Watch of %s ended, restarting from resourceVersion %q", t.gvk.Kind, opts.ResourceVersion)
+			continue
+		}
+
+		log.Debugf("resourceVersion %q of %s expired, relisting", opts.ResourceVersion, t.gvk.Kind)
+		listOpts := opts
+		listOpts.ResourceVersion = ""
+		list, err := t.rc.List(listOpts)
+		if err != nil {
+			return fmt.Errorf("Error listing %s: %v", t.gvk.Kind, err)
+		}
+		listMeta, err := meta.ListAccessor(list)
+		if err != nil {
+			return err
+		}
+		if !t.resync(ctx, list, events) {
+			return nil
+		}
+		opts.ResourceVersion = listMeta.GetResourceVersion()
+	}
+	return nil
+}
+
+// stream sends the events from w to events, recording the latest
+// resourceVersion in opts.  Returns true if the watch failed because
+// its resourceVersion has expired.
+func (t *watchTarget) stream(ctx context.Context, w watch.Interface, opts *metav1.ListOptions, events chan<- watchEvent) (bool, error) {
+	for {
+		var ev watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case ev, ok = <-w.ResultChan():
+			if !ok {
+				return false, nil
+			}
+		}
+
+		if ev.Type == watch.Error {
+			err := errors.FromObject(ev.Object)
+			if isExpired(err) {
+				return true, nil
+			}
+			return false, fmt.Errorf("Error watching %s: %v", t.gvk.Kind, err)
+		}
+
+		obj, ok := ev.Object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		opts.ResourceVersion = obj.GetResourceVersion()
+		if !t.names.Has(obj.GetName()) {
+			continue
+		}
+
+		name := qualifiedName(obj)
+		if ev.Type == watch.Deleted {
+			delete(t.seen, name)
+		} else {
+			t.seen[name] = obj.GetResourceVersion()
+		}
+		if !t.send(ctx, events, ev.Type, name) {
+			return false, nil
+		}
+	}
+}
+
+// resync compares a fresh list of t's objects against the last
+// resourceVersions seen, and sends ADDED, MODIFIED or DELETED events
+// for any changes missed while the watch was down.  Returns false if
+// ctx is done.
+func (t *watchTarget) resync(ctx context.Context, list runtime.Object, events chan<- watchEvent) bool {
+	current := map[string]string{}
+	err := meta.EachListItem(list, func(o runtime.Object) error {
+		obj, err := meta.Accessor(o)
+		if err != nil {
+			return err
+		}
+		if t.names.Has(obj.GetName()) {
+			current[qualifiedName(obj)] = obj.GetResourceVersion()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Debugf("Unable to compare relisted %s: %v", t.gvk.Kind, err)
+		return true
+	}
+
+	for _, name := range sets.StringKeySet(current).List() {
+		typ := watch.Modified
+		rv, ok := t.seen[name]
+		if !ok {
+			typ = watch.Added
+		} else if rv == current[name] {
+			continue
+		}
+		t.seen[name] = current[name]
+		if !t.send(ctx, events, typ, name) {
+			return false
+		}
+	}
+	for _, name := range sets.StringKeySet(t.seen).List() {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		delete(t.seen, name)
+		if !t.send(ctx, events, watch.Deleted, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// send sends an event for the named object.  Returns false if ctx
+// is done first.
+func (t *watchTarget) send(ctx context.Context, events chan<- watchEvent, typ watch.EventType, name string) bool {
+	select {
+	case events <- watchEvent{gvk: t.gvk, typ: typ, name: name}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// qualifiedName returns the namespace/name of obj, or just the name
+// if it is not namespaced
+func qualifiedName(obj metav1.Object) string {
+	if ns := obj.GetNamespace(); ns != "" {
+		return ns + "/" + obj.GetName()
+	}
+	return obj.GetName()
+}
+
+// isExpired returns true if err reports an expired resourceVersion
+// (410 Gone)
+func isExpired(err error) bool {
+	status, ok := err.(errors.APIStatus)
+	if !ok {
+		return false
+	}
+	s := status.Status()
+	return s.Code == http.StatusGone || s.Reason == metav1.StatusReasonExpired || s.Reason == metav1.StatusReasonGone
+}
@@ -0,0 +1,237 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// lineWriter calls done after n lines have been written
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	n    int
+	done func()
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	if strings.Count(w.buf.String(), "\n") >= w.n {
+		w.done()
+	}
+	return len(p), nil
+}
+
+func TestWatch(t *testing.T) {
+	watchEvent := func(typ, kind, name, rv string) string {
+		return fmt.Sprintf(`{"type":%q,"object":{"apiVersion":"v1","kind":%q,"metadata":{"name":%q,"namespace":"default","resourceVersion":%q}}}`+"\n", typ, kind, name, rv)
+	}
+
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		mu.Lock()
+		requests = append(requests, fmt.Sprintf("%s watch=%s rv=%s fieldSelector=%s", r.URL.Path, q.Get("watch"), q.Get("resourceVersion"), q.Get("fieldSelector")))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/default/pods" && q.Get("watch") != "true":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"PodList","metadata":{"resourceVersion":"100"},"items":[{"apiVersion":"v1","kind":"Pod","metadata":{"name":"a","namespace":"default","resourceVersion":"1"}}]}`)
+			return
+		case r.URL.Path == "/api/v1/namespaces/default/pods" && q.Get("resourceVersion") == "":
+			fmt.Fprint(w, watchEvent("ADDED", "Pod", "a", "1"))
+			fmt.Fprint(w, watchEvent("ADDED", "Pod", "other", "2"))
+			fmt.Fprint(w, `{"type":"ERROR","object":{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"Expired","code":410,"message":"too old resource version"}}`+"\n")
+			return
+		case r.URL.Path == "/api/v1/namespaces/default/pods" && q.Get("resourceVersion") == "100":
+			fmt.Fprint(w, watchEvent("MODIFIED", "Pod", "b", "101"))
+			fmt.Fprint(w, watchEvent("DELETED", "Pod", "a", "102"))
+		case r.URL.Path == "/api/v1/namespaces/default/configmaps" && q.Get("resourceVersion") == "":
+			fmt.Fprint(w, watchEvent("ADDED", "ConfigMap", "cfg", "5"))
+			return
+		case r.URL.Path == "/api/v1/namespaces/default/configmaps" && q.Get("resourceVersion") == "5":
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		// Leave the watch open until the client goes away
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := WatchCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "pods", Kind: "Pod", Namespaced: true},
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+	}
+
+	objs := []*unstructured.Unstructured{}
+	for _, o := range []struct{ kind, name string }{{"Pod", "a"}, {"Pod", "b"}, {"ConfigMap", "cfg"}} {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind(o.kind)
+		obj.SetName(o.name)
+		objs = append(objs, obj)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &lineWriter{n: 4, done: cancel}
+	if err := c.Run(ctx, objs, out); err != context.Canceled {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.buf.String()), "\n")
+	sort.Strings(lines)
+	expected := []string{
+		"ADDED v1/ConfigMap default/cfg",
+		"ADDED v1/Pod default/a",
+		"DELETED v1/Pod default/a",
+		"MODIFIED v1/Pod default/b",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected events:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// The ConfigMap watch may or may not have been restarted (from
+	// resourceVersion 5) before cancellation
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range []string{
+		"/api/v1/namespaces/default/configmaps watch=true rv= fieldSelector=metadata.name=cfg",
+		"/api/v1/namespaces/default/pods watch=true rv= fieldSelector=",
+		"/api/v1/namespaces/default/pods watch= rv= fieldSelector=",
+		"/api/v1/namespaces/default/pods watch=true rv=100 fieldSelector=",
+	} {
+		if !stringListContains(requests, r) {
+			t.Errorf("Missing request %q in:\n%s", r, strings.Join(requests, "\n"))
+		}
+	}
+}
+
+func TestWatchRelist(t *testing.T) {
+	watchEvent := func(typ, name, rv string) string {
+		return fmt.Sprintf(`{"type":%q,"object":{"apiVersion":"v1","kind":"Pod","metadata":{"name":%q,"namespace":"default","resourceVersion":%q}}}`+"\n", typ, name, rv)
+	}
+	pod := func(name, rv string) string {
+		return fmt.Sprintf(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":%q,"namespace":"default","resourceVersion":%q}}`, name, rv)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path != "/api/v1/namespaces/default/pods":
+			t.Errorf("Unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		case q.Get("watch") != "true":
+			// While the watch was down: b changed, c was deleted,
+			// d was created, and an unwatched object appeared
+			items := []string{pod("a", "1"), pod("b", "50"), pod("d", "60"), pod("other", "70")}
+			fmt.Fprintf(w, `{"apiVersion":"v1","kind":"PodList","metadata":{"resourceVersion":"100"},"items":[%s]}`, strings.Join(items, ","))
+			return
+		case q.Get("resourceVersion") == "":
+			fmt.Fprint(w, watchEvent("ADDED", "a", "1"))
+			fmt.Fprint(w, watchEvent("ADDED", "b", "2"))
+			fmt.Fprint(w, watchEvent("ADDED", "c", "3"))
+			fmt.Fprint(w, `{"type":"ERROR","object":{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"Expired","code":410,"message":"too old resource version"}}`+"\n")
+			return
+		case q.Get("resourceVersion") == "100":
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := WatchCmd{
+		ClientPool: dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}),
+		Discovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "pods", Kind: "Pod", Namespaced: true},
+					},
+				},
+			},
+		}},
+		DefaultNamespace: "default",
+	}
+
+	objs := []*unstructured.Unstructured{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Pod")
+		obj.SetName(name)
+		objs = append(objs, obj)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &lineWriter{n: 6, done: cancel}
+	if err := c.Run(ctx, objs, out); err != context.Canceled {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+
+	expected := []string{
+		"ADDED v1/Pod default/a",
+		"ADDED v1/Pod default/b",
+		"ADDED v1/Pod default/c",
+		"MODIFIED v1/Pod default/b",
+		"ADDED v1/Pod default/d",
+		"DELETED v1/Pod default/c",
+	}
+	if actual := strings.TrimSpace(out.buf.String()); actual != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected events:\n%s", actual)
+	}
+}
@@ -17,20 +17,69 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
-	jsonnet "github.com/strickyak/jsonnet_cgo"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+// Input formats for ReadFormat
+const (
+	FormatJsonnet = "jsonnet"
+	FormatJSON    = "json"
+	FormatYAML    = "yaml"
 )
 
+// SniffFormat guesses the format of data, which has no file
+// extension to go by.  Valid JSON is JSON, a document that starts
+// like a YAML mapping or stream is YAML, and anything else is
+// assumed to be jsonnet.
+func SniffFormat(data []byte) string {
+	if json.Valid(data) {
+		return FormatJSON
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "---" || yamlKeyRegexp.MatchString(line) {
+			return FormatYAML
+		}
+		break
+	}
+	return FormatJsonnet
+}
+
+// yamlKeyRegexp matches a line starting a YAML mapping, but not
+// the start of a jsonnet local, import or object
+var yamlKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*:(\s|$)`)
+
+// ReadFormat decodes JSON or YAML K8s objects from r.  jsonnet
+// needs a VM, see Evaluator.EvaluateReader.
+func ReadFormat(r io.Reader, format string) ([]runtime.Object, error) {
+	switch format {
+	case FormatJSON:
+		return jsonReader(r)
+	case FormatYAML:
+		return yamlReader(ioutil.NopCloser(r))
+	}
+	return nil, fmt.Errorf("Unknown input format %q", format)
+}
+
 // Read fetches and decodes K8s objects by path.
 // TODO: Replace this with something supporting more sophisticated
 // content negotiation.
@@ -130,11 +179,16 @@ func jsonnetReader(vm *jsonnet.VM, path string) ([]runtime.Object, error) {
 	if err != nil {
 		return nil, err
 	}
+	return jsonnetObjects(jsonstr)
+}
 
+// jsonnetObjects decodes the objects in the output of a jsonnet
+// evaluation
+func jsonnetObjects(jsonstr string) ([]runtime.Object, error) {
 	log.Debugf("jsonnet result is: %s", jsonstr)
 
 	var top interface{}
-	if err = json.Unmarshal([]byte(jsonstr), &top); err != nil {
+	if err := json.Unmarshal([]byte(jsonstr), &top); err != nil {
 		return nil, err
 	}
 
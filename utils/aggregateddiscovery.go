@@ -0,0 +1,240 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// aggregatedDiscoveryAccept asks servers that support it (1.26+) for
+// the aggregated discovery document, which lists every resource of
+// every group version in a single response.  Older servers ignore
+// the unknown media types and return the plain APIVersions or
+// APIGroupList.
+const aggregatedDiscoveryAccept = "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList," +
+	"application/json;g=apidiscovery.k8s.io;v=v2beta1;as=APIGroupDiscoveryList," +
+	"application/json"
+
+// The subset of apidiscovery.k8s.io/v2 that we use.  The vendored
+// client-go predates aggregated discovery, so these are declared
+// here.
+type apiGroupDiscoveryList struct {
+	Kind  string              `json:"kind"`
+	Items []apiGroupDiscovery `json:"items"`
+}
+
+type apiGroupDiscovery struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	// Versions are in order of preference
+	Versions []apiVersionDiscovery `json:"versions"`
+}
+
+type apiVersionDiscovery struct {
+	Version   string                 `json:"version"`
+	Resources []apiResourceDiscovery `json:"resources"`
+	// Freshness is discoveryStale if the resources could not be
+	// refreshed, eg: because the APIService serving them is
+	// unavailable
+	Freshness string `json:"freshness"`
+}
+
+const discoveryStale = "Stale"
+
+type apiResourceDiscovery struct {
+	Resource         string                    `json:"resource"`
+	ResponseKind     *metav1.GroupVersionKind  `json:"responseKind"`
+	Scope            string                    `json:"scope"`
+	SingularResource string                    `json:"singularResource"`
+	Verbs            []string                  `json:"verbs"`
+	ShortNames       []string                  `json:"shortNames"`
+	Categories       []string                  `json:"categories"`
+	Subresources     []apiSubresourceDiscovery `json:"subresources"`
+}
+
+type apiSubresourceDiscovery struct {
+	Subresource  string                   `json:"subresource"`
+	ResponseKind *metav1.GroupVersionKind `json:"responseKind"`
+	Verbs        []string                 `json:"verbs"`
+}
+
+// aggregatedResources is the resources of each group version listed
+// by aggregated discovery.  Group versions whose resources are stale
+// are in failed instead.
+type aggregatedResources struct {
+	resources map[string]*metav1.APIResourceList
+	failed    map[string]error
+}
+
+// fetchDiscoveryRoots fetches the groups served under the /api and
+// /apis roots, asking for aggregated discovery.  Roots that return
+// the aggregated document also provide the resources of each of their
+// group versions; older servers return the plain APIVersions or
+// APIGroupList, and their resources must be fetched separately.
+func fetchDiscoveryRoots(client rest.Interface) (*metav1.APIGroupList, *aggregatedResources, error) {
+	if client == nil {
+		return nil, nil, fmt.Errorf("No REST client for discovery")
+	}
+	resources := &aggregatedResources{
+		resources: map[string]*metav1.APIResourceList{},
+		failed:    map[string]error{},
+	}
+	var core, groups metav1.APIGroupList
+	for _, root := range []struct {
+		path   string
+		groups *metav1.APIGroupList
+	}{{"/api", &core}, {"/apis", &groups}} {
+		body, err := client.Get().AbsPath(root.path).SetHeader("Accept", aggregatedDiscoveryAccept).Do().Raw()
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			// As for DiscoveryClient.ServerGroups, to be
+			// compatible with old servers
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if err := decodeDiscoveryRoot(body, root.groups, resources); err != nil {
+			return nil, nil, fmt.Errorf("Error decoding %s: %v", root.path, err)
+		}
+	}
+	// The legacy group is listed last, as by DiscoveryClient
+	groups.Groups = append(groups.Groups, core.Groups...)
+	return &groups, resources, nil
+}
+
+// decodeDiscoveryRoot decodes the response from a discovery root,
+// whichever form the server chose to return, and adds the result to
+// groups and resources.
+func decodeDiscoveryRoot(body []byte, groups *metav1.APIGroupList, resources *aggregatedResources) error {
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(body, &typeMeta); err != nil {
+		return err
+	}
+	switch typeMeta.Kind {
+	case "APIGroupDiscoveryList":
+		var list apiGroupDiscoveryList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return err
+		}
+		addAggregated(&list, groups, resources)
+	case "APIVersions":
+		var versions metav1.APIVersions
+		if err := json.Unmarshal(body, &versions); err != nil {
+			return err
+		}
+		if len(versions.Versions) == 0 {
+			return nil
+		}
+		group := metav1.APIGroup{}
+		for _, v := range versions.Versions {
+			group.Versions = append(group.Versions, metav1.GroupVersionForDiscovery{GroupVersion: v, Version: v})
+		}
+		group.PreferredVersion = group.Versions[0]
+		groups.Groups = append(groups.Groups, group)
+	case "APIGroupList":
+		var list metav1.APIGroupList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return err
+		}
+		groups.Groups = append(groups.Groups, list.Groups...)
+	default:
+		return fmt.Errorf("Unexpected kind %q", typeMeta.Kind)
+	}
+	return nil
+}
+
+// addAggregated converts list to the equivalent (non-aggregated)
+// discovery results, and adds them to groups and resources.
+func addAggregated(list *apiGroupDiscoveryList, groups *metav1.APIGroupList, resources *aggregatedResources) {
+	for _, item := range list.Items {
+		group := metav1.APIGroup{Name: item.Metadata.Name}
+		for _, v := range item.Versions {
+			gv := schema.GroupVersion{Group: group.Name, Version: v.Version}.String()
+			group.Versions = append(group.Versions, metav1.GroupVersionForDiscovery{
+				GroupVersion: gv,
+				Version:      v.Version,
+			})
+			if v.Freshness == discoveryStale {
+				resources.failed[gv] = fmt.Errorf("Discovery information for %s is stale, its APIService may be unavailable", gv)
+				continue
+			}
+			list := &metav1.APIResourceList{GroupVersion: gv}
+			for _, r := range v.Resources {
+				list.APIResources = append(list.APIResources, aggregatedResource(r))
+				for _, sub := range r.Subresources {
+					subresource := metav1.APIResource{
+						Name:       strings.Join([]string{r.Resource, sub.Subresource}, "/"),
+						Namespaced: r.Scope == "Namespaced",
+						Verbs:      sub.Verbs,
+					}
+					if sub.ResponseKind != nil {
+						subresource.Kind = sub.ResponseKind.Kind
+					}
+					list.APIResources = append(list.APIResources, subresource)
+				}
+			}
+			resources.resources[gv] = list
+		}
+		if len(group.Versions) == 0 {
+			continue
+		}
+		group.PreferredVersion = group.Versions[0]
+		groups.Groups = append(groups.Groups, group)
+	}
+}
+
+func aggregatedResource(r apiResourceDiscovery) metav1.APIResource {
+	ret := metav1.APIResource{
+		Name:         r.Resource,
+		SingularName: r.SingularResource,
+		Namespaced:   r.Scope == "Namespaced",
+		Verbs:        r.Verbs,
+		ShortNames:   r.ShortNames,
+		Categories:   r.Categories,
+	}
+	if r.ResponseKind != nil {
+		ret.Kind = r.ResponseKind.Kind
+	}
+	return ret
+}
+
+// storeAggregated fills the resources cache from the result of
+// fetchDiscoveryRoots, exactly as if each group version had been fetched
+// by ServerResourcesForGroupVersion.  In-flight fetches are left
+// alone.  Stale group versions are not cached, but fail (as though
+// fetching them failed) until the groups are fetched again.  c.lock
+// must be held.
+func (c *memcachedDiscoveryClient) storeAggregated(resources *aggregatedResources) {
+	c.stale = resources.failed
+	now := c.now()
+	for gv, list := range resources.resources {
+		if e, ok := c.serverresources[gv]; ok && !e.completed() {
+			continue
+		}
+		e := &resourcesEntry{done: make(chan struct{}), resources: list, fetched: now}
+		close(e.done)
+		c.serverresources[gv] = e
+		c.disk.write(filepath.Join("resources", gv+".json"), list)
+	}
+}
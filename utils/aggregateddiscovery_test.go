@@ -0,0 +1,221 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+const aggregatedCore = `{"kind":"APIGroupDiscoveryList","apiVersion":"apidiscovery.k8s.io/v2","items":[
+  {"metadata":{"name":""},"versions":[{"version":"v1","resources":[
+    {"resource":"pods","responseKind":{"group":"","version":"v1","kind":"Pod"},"scope":"Namespaced","singularResource":"pod","verbs":["get","list"],"shortNames":["po"],
+     "subresources":[{"subresource":"status","responseKind":{"group":"","version":"v1","kind":"Pod"},"verbs":["get","patch"]}]},
+    {"resource":"namespaces","responseKind":{"group":"","version":"v1","kind":"Namespace"},"scope":"Cluster","singularResource":"namespace","verbs":["get"]}]}]}]}`
+
+const aggregatedGroups = `{"kind":"APIGroupDiscoveryList","apiVersion":"apidiscovery.k8s.io/v2","items":[
+  {"metadata":{"name":"apps"},"versions":[
+    {"version":"v1","resources":[{"resource":"deployments","responseKind":{"group":"apps","version":"v1","kind":"Deployment"},"scope":"Namespaced","singularResource":"deployment","verbs":["get"]}]},
+    {"version":"v1beta1","resources":[{"resource":"deployments","responseKind":{"group":"apps","version":"v1beta1","kind":"Deployment"},"scope":"Namespaced","singularResource":"deployment","verbs":["get"]}]}]}]}`
+
+func TestAggregatedDiscovery(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if !strings.Contains(r.Header.Get("Accept"), "as=APIGroupDiscoveryList") {
+			t.Errorf("Unexpected request for %s with Accept %q", r.URL.Path, r.Header.Get("Accept"))
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList")
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, aggregatedCore)
+		case "/apis":
+			fmt.Fprint(w, aggregatedGroups)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tmpdir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(c discovery.CachedDiscoveryInterface) {
+		groups, err := c.ServerGroups()
+		if err != nil || len(groups.Groups) != 2 {
+			t.Fatalf("Unexpected groups %v: %v", groups, err)
+		}
+		for _, g := range groups.Groups {
+			if g.Name == "apps" && g.PreferredVersion.GroupVersion != "apps/v1" {
+				t.Errorf("Unexpected preferred version %v", g.PreferredVersion)
+			}
+		}
+
+		rsrcs, err := c.ServerResourcesForGroupVersion("v1")
+		if err != nil || len(rsrcs.APIResources) != 3 {
+			t.Fatalf("Unexpected resources %v: %v", rsrcs, err)
+		}
+		pods, status, ns := rsrcs.APIResources[0], rsrcs.APIResources[1], rsrcs.APIResources[2]
+		if pods.Name != "pods" || pods.Kind != "Pod" || !pods.Namespaced || pods.SingularName != "pod" || len(pods.ShortNames) != 1 {
+			t.Errorf("Unexpected pods resource %#v", pods)
+		}
+		if status.Name != "pods/status" || status.Kind != "Pod" || !status.Namespaced || len(status.Verbs) != 2 {
+			t.Errorf("Unexpected pods/status resource %#v", status)
+		}
+		if ns.Name != "namespaces" || ns.Namespaced {
+			t.Errorf("Unexpected namespaces resource %#v", ns)
+		}
+
+		preferred, err := c.ServerPreferredResources()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, list := range preferred {
+			if list.GroupVersion == "apps/v1beta1" && len(list.APIResources) != 0 {
+				t.Errorf("Unexpected preferred resources %v", list)
+			}
+		}
+	}
+
+	c := NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	check(c)
+	if len(requests) != 2 || requests["/api"] != 1 || requests["/apis"] != 1 {
+		t.Errorf("Expected one request per root, got %v", requests)
+	}
+
+	// The disk cache is filled as though each group version
+	// was fetched separately
+	c = NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	check(c)
+	if requests["/api"] != 1 || requests["/apis"] != 1 {
+		t.Errorf("Cached results were fetched again: %v", requests)
+	}
+}
+
+func TestAggregatedDiscoveryStale(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.Header().Set("Content-Type", "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList")
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, aggregatedCore)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupDiscoveryList","apiVersion":"apidiscovery.k8s.io/v2","items":[
+  {"metadata":{"name":"metrics.k8s.io"},"versions":[
+    {"version":"v1beta1","freshness":"Stale","resources":[{"resource":"pods","responseKind":{"group":"metrics.k8s.io","version":"v1beta1","kind":"PodMetrics"},"scope":"Namespaced","verbs":["get"]}]}]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tmpdir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	rsrcs, err := c.ServerResources()
+	if len(rsrcs) != 1 || rsrcs[0].GroupVersion != "v1" {
+		t.Errorf("Unexpected resources %v", rsrcs)
+	}
+	failed, ok := err.(*discovery.ErrGroupDiscoveryFailed)
+	if !ok || len(failed.Groups) != 1 || failed.Groups[schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}] == nil {
+		t.Errorf("Expected stale group version to fail, got %v", err)
+	}
+	if c.Fresh() {
+		t.Errorf("Stale results were reported as fresh")
+	}
+	if len(requests) != 2 {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+
+	files, err := filepath.Glob(filepath.Join(tmpdir, "*", "resources", "*", "*.json"))
+	if err != nil || len(files) != 0 {
+		t.Errorf("Stale resources were cached on disk: %v (%v)", files, err)
+	}
+}
+
+func TestAggregatedDiscoveryFallback(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[{"name":"apps","versions":[{"groupVersion":"apps/v1","version":"v1"}],"preferredVersion":{"groupVersion":"apps/v1","version":"v1"}}]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"pods","kind":"Pod","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis/apps/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"apps/v1","resources":[{"name":"deployments","kind":"Deployment","namespaced":true,"verbs":["get"]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewMemcachedDiscoveryClient(disco)
+	rsrcs, err := c.ServerResources()
+	if err != nil || len(rsrcs) != 2 {
+		t.Fatalf("Unexpected resources %v: %v", rsrcs, err)
+	}
+	for path, n := range requests {
+		if n != 1 {
+			t.Errorf("%s requested %d times, expected 1", path, n)
+		}
+	}
+	if len(requests) != 4 {
+		t.Errorf("Expected per-group requests, got %v", requests)
+	}
+	if _, err := c.ServerResourcesForGroupVersion("apps/v1"); err != nil || requests["/apis/apps/v1"] != 1 {
+		t.Errorf("Resources were fetched again: %v (%v)", requests, err)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Budget divides an overall time limit between the discovery and
+// apply phases of a run.  Discovery may use up to its share of the
+// total; whatever remains is divided evenly between the remaining
+// apply operations, allowing for those that run concurrently.  A nil
+// *Budget is valid, and imposes no limit.
+type Budget struct {
+	// Total is the overall time limit
+	Total time.Duration
+	// ApplyPercent is the percentage of Total reserved for apply
+	// operations
+	ApplyPercent int
+
+	// now is replaced in tests
+	now func() time.Time
+
+	mu       sync.Mutex
+	start    time.Time
+	deadline time.Time
+	// Deadline of the current phase
+	opDeadline time.Time
+	// Deadlines of the apply operations in progress, by id
+	ops    map[int]time.Time
+	nextOp int
+}
+
+// ErrBudgetExceeded is returned once the overall time limit has
+// passed
+type ErrBudgetExceeded struct {
+	Total time.Duration
+}
+
+func (e *ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf("Timeout budget of %s exceeded", e.Total)
+}
+
+// NewBudget starts a new Budget
+func NewBudget(total time.Duration, applyPercent int) *Budget {
+	return newBudget(total, applyPercent, time.Now)
+}
+
+func newBudget(total time.Duration, applyPercent int, now func() time.Time) *Budget {
+	b := &Budget{
+		Total:        total,
+		ApplyPercent: applyPercent,
+		now:          now,
+		ops:          make(map[int]time.Time),
+	}
+	b.start = b.now()
+	b.deadline = b.start.Add(total)
+	b.opDeadline = b.deadline
+	return b
+}
+
+// Remaining returns the time left before the overall deadline
+func (b *Budget) Remaining() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.deadline.Sub(b.now())
+}
+
+func (b *Budget) check() error {
+	if !b.now().Before(b.deadline) {
+		return &ErrBudgetExceeded{Total: b.Total}
+	}
+	return nil
+}
+
+// StartDiscovery begins the discovery phase, which may use the
+// portion of the budget not reserved for apply.
+func (b *Budget) StartDiscovery() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(); err != nil {
+		return err
+	}
+	share := b.Total * time.Duration(100-b.ApplyPercent) / 100
+	b.opDeadline = b.start.Add(share)
+	if b.opDeadline.After(b.deadline) {
+		b.opDeadline = b.deadline
+	}
+	return nil
+}
+
+// StartOperation begins the next of `remaining` apply operations,
+// up to `concurrent` of which run at once, and returns the time
+// allotted to it.  The caller must call done when the operation
+// finishes.
+func (b *Budget) StartOperation(remaining, concurrent int) (timeout time.Duration, done func(), err error) {
+	if b == nil {
+		return 0, func() {}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(); err != nil {
+		return 0, nil, err
+	}
+	if remaining < 1 {
+		remaining = 1
+	}
+	if concurrent < 1 {
+		concurrent = 1
+	} else if concurrent > remaining {
+		concurrent = remaining
+	}
+	now := b.now()
+	timeout = b.deadline.Sub(now) * time.Duration(concurrent) / time.Duration(remaining)
+	// Requests made outside an operation are only limited by
+	// the overall deadline from now on
+	b.opDeadline = b.deadline
+
+	id := b.nextOp
+	b.nextOp++
+	b.ops[id] = now.Add(timeout)
+	done = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.ops, id)
+	}
+	return timeout, done, nil
+}
+
+// Deadline returns the deadline for requests made now.  Requests
+// can't be attributed to a particular operation, so while operations
+// are in progress this is the latest of their deadlines.
+func (b *Budget) Deadline() (time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(); err != nil {
+		return time.Time{}, err
+	}
+	d := b.opDeadline
+	if len(b.ops) > 0 {
+		d = time.Time{}
+		for _, op := range b.ops {
+			if op.After(d) {
+				d = op
+			}
+		}
+	}
+	if !b.now().Before(d) {
+		// The current phase/operation has overrun its share.
+		// Allow it to continue, up to the overall deadline.
+		d = b.deadline
+	}
+	return d, nil
+}
+
+// NewBudgetTransport returns a RoundTripper that limits each
+// request to the current deadline of budget.
+func NewBudgetTransport(budget *Budget, rt http.RoundTripper) http.RoundTripper {
+	return &budgetTransport{Transport: rt, Budget: budget}
+}
+
+type budgetTransport struct {
+	Transport http.RoundTripper
+	Budget    *Budget
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	deadline, err := t.Budget.Deadline()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithDeadline(req.Context(), deadline)
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Deadline continues to apply while reading the body
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBudget(total time.Duration, applyPercent int) (*Budget, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return newBudget(total, applyPercent, clock.Now), clock
+}
+
+func TestBudget(t *testing.T) {
+	b, clock := newTestBudget(100*time.Second, 50)
+
+	if err := b.StartDiscovery(); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := b.Deadline(); !d.Equal(clock.Now().Add(50 * time.Second)) {
+		t.Errorf("Unexpected discovery deadline %v", d)
+	}
+
+	// Discovery overruns its share
+	clock.Advance(60 * time.Second)
+	if d, _ := b.Deadline(); !d.Equal(b.deadline) {
+		t.Errorf("Overrunning discovery was not allowed to continue: %v", d)
+	}
+
+	// Remaining 40s is divided between 4 operations
+	timeout, done, err := b.StartOperation(4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout != 10*time.Second {
+		t.Errorf("Expected 10s, got %v", timeout)
+	}
+
+	// First operation is quick, so the next get more
+	clock.Advance(1 * time.Second)
+	done()
+	timeout, done, _ = b.StartOperation(3, 1)
+	if timeout != 13*time.Second {
+		t.Errorf("Expected 13s, got %v", timeout)
+	}
+	if d, _ := b.Deadline(); !d.Equal(clock.Now().Add(13 * time.Second)) {
+		t.Errorf("Unexpected operation deadline %v", d)
+	}
+	done()
+
+	clock.Advance(39 * time.Second)
+	if _, _, err := b.StartOperation(2, 1); err == nil {
+		t.Errorf("Operation started after budget was exhausted")
+	} else if _, ok := err.(*ErrBudgetExceeded); !ok {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := b.Deadline(); err == nil {
+		t.Errorf("Request allowed after budget was exhausted")
+	}
+}
+
+func TestNilBudget(t *testing.T) {
+	var b *Budget
+	if err := b.StartDiscovery(); err != nil {
+		t.Error(err)
+	}
+	_, done, err := b.StartOperation(1, 1)
+	if err != nil {
+		t.Error(err)
+	}
+	done()
+}
+
+func TestBudgetConcurrent(t *testing.T) {
+	b, clock := newTestBudget(100*time.Second, 100)
+
+	// 8 operations, 4 at a time: each gets a quarter of the
+	// remaining time, not an eighth
+	var dones []func()
+	for n := 8; n > 4; n-- {
+		timeout, done, err := b.StartOperation(n, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 8 && timeout != 50*time.Second {
+			t.Errorf("Expected 50s, got %v", timeout)
+		}
+		dones = append(dones, done)
+		clock.Advance(time.Second)
+	}
+
+	// Requests get the latest deadline of the operations in
+	// progress, so later operations never cut short earlier ones
+	if d, _ := b.Deadline(); !d.Equal(b.start.Add(3*time.Second + 97*time.Second*4/5)) {
+		t.Errorf("Unexpected deadline %v", d)
+	}
+	for _, done := range dones {
+		done()
+	}
+	if len(b.ops) != 0 {
+		t.Errorf("Finished operations were not forgotten: %v", b.ops)
+	}
+	if d, _ := b.Deadline(); !d.Equal(b.deadline) {
+		t.Errorf("Requests outside an operation were limited: %v", d)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestBudgetTransport(t *testing.T) {
+	b, clock := newTestBudget(100*time.Second, 20)
+	b.StartDiscovery()
+
+	var deadline time.Time
+	rt := NewBudgetTransport(b, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		deadline, _ = req.Context().Deadline()
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}))
+
+	req, _ := http.NewRequest("GET", "http://example.com/api", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !deadline.Equal(clock.Now().Add(80 * time.Second)) {
+		t.Errorf("Unexpected request deadline %v", deadline)
+	}
+
+	clock.Advance(100 * time.Second)
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Errorf("Request allowed after budget was exhausted")
+	}
+}
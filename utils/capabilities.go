@@ -0,0 +1,82 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+)
+
+// Capabilities describes the features of a cluster, for templates
+// that adapt to their target.  Modelled on helm's `.Capabilities`.
+type Capabilities struct {
+	// KubeVersion is nil if capabilities were not discovered
+	KubeVersion *KubeVersion `json:"kubeVersion"`
+	// APIVersions lists each served group/version (eg
+	// "apps/v1"), and each group/version/kind (eg
+	// "apps/v1/Deployment").
+	APIVersions []string `json:"apiVersions"`
+}
+
+// KubeVersion is the version of the Kubernetes API server
+type KubeVersion struct {
+	Major      int    `json:"major"`
+	Minor      int    `json:"minor"`
+	GitVersion string `json:"gitVersion"`
+}
+
+// EmptyCapabilities is used when the cluster is not consulted
+func EmptyCapabilities() *Capabilities {
+	return &Capabilities{APIVersions: []string{}}
+}
+
+// FetchCapabilities populates Capabilities from discovery
+func FetchCapabilities(disco discovery.DiscoveryInterface) (*Capabilities, error) {
+	info, err := disco.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	version, err := ParseVersion(info)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := disco.ServerResources()
+	if err = WarnOnPartialDiscovery(err); err != nil {
+		return nil, err
+	}
+
+	apiVersions := sets.NewString()
+	for _, rl := range resources {
+		apiVersions.Insert(rl.GroupVersion)
+		for _, r := range rl.APIResources {
+			apiVersions.Insert(rl.GroupVersion + "/" + r.Kind)
+		}
+	}
+	list := apiVersions.List()
+	sort.Strings(list)
+
+	return &Capabilities{
+		KubeVersion: &KubeVersion{
+			Major:      version.Major,
+			Minor:      version.Minor,
+			GitVersion: info.GitVersion,
+		},
+		APIVersions: list,
+	}, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+type fakeVersionDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	version version.Info
+}
+
+func (d *fakeVersionDiscovery) ServerVersion() (*version.Info, error) {
+	return &d.version, nil
+}
+
+func TestFetchCapabilities(t *testing.T) {
+	disco := &fakeVersionDiscovery{
+		FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+				{
+					GroupVersion: "networking.k8s.io/v1",
+					APIResources: []metav1.APIResource{
+						{Name: "networkpolicies", Kind: "NetworkPolicy", Namespaced: true},
+					},
+				},
+			},
+		}},
+		version: version.Info{Major: "1", Minor: "8+", GitVersion: "v1.8.2-gke.0"},
+	}
+
+	caps, err := FetchCapabilities(disco)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Capabilities{
+		KubeVersion: &KubeVersion{Major: 1, Minor: 8, GitVersion: "v1.8.2-gke.0"},
+		APIVersions: []string{
+			"networking.k8s.io/v1",
+			"networking.k8s.io/v1/NetworkPolicy",
+			"v1",
+			"v1/ConfigMap",
+		},
+	}
+	if !reflect.DeepEqual(caps, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, caps)
+	}
+}
@@ -16,14 +16,25 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/emicklei/go-restful-swagger12"
 	"github.com/go-openapi/spec"
 	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/version"
@@ -36,30 +47,241 @@ type memcachedDiscoveryClient struct {
 	cl              discovery.DiscoveryInterface
 	lock            sync.RWMutex
 	servergroups    *metav1.APIGroupList
-	serverresources map[string]*metav1.APIResourceList
+	serverresources map[string]*resourcesEntry
 	schemas         map[string]*swagger.ApiDeclaration
 	schema          *spec.Swagger
+
+	// stale is the group versions that aggregated discovery
+	// reported as stale, and the error to return for them
+	stale map[string]error
+
+	// ttl is how long results remain valid, or zero for ever.
+	// The *At fields record when each result was fetched.
+	ttl            time.Duration
+	now            func() time.Time
+	servergroupsAt time.Time
+	schemasAt      map[string]time.Time
+	schemaAt       time.Time
+	version        *version.Info
+	versionAt      time.Time
+
+	// OpenAPI v3 schemas, by group version, and the index of
+	// their URLs.  v3index is nil until fetched.
+	schemasV3   map[string]*spec.Swagger
+	schemasV3At map[string]time.Time
+	v3index     map[string]string
+	v3indexAt   time.Time
+
+	// disk is nil unless results are also cached on disk
+	disk *diskCache
+
+	// preferredVersions overrides the server's preferred version
+	// of some groups (group name -> version)
+	preferredVersions map[string]string
+
+	// mapper is built from the cached results on demand.
+	// mapperLock must be acquired before lock.
+	mapperLock sync.Mutex
+	mapper     meta.RESTMapper
+	mapperAt   time.Time
+}
+
+// resourcesEntry is a cached, or in-flight, result of
+// ServerResourcesForGroupVersion.  resources and err are valid once
+// done is closed.
+type resourcesEntry struct {
+	done      chan struct{}
+	resources *metav1.APIResourceList
+	err       error
+	fetched   time.Time
 }
 
+// completed returns true if e is no longer in-flight
+func (e *resourcesEntry) completed() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// DiscoveryParallelism limits the number of concurrent requests made
+// by ServerResources and ServerPreferredResources
+var DiscoveryParallelism = 8
+
 // NewMemcachedDiscoveryClient creates a new DiscoveryClient that
 // caches results in memory
 func NewMemcachedDiscoveryClient(cl discovery.DiscoveryInterface) discovery.CachedDiscoveryInterface {
-	c := &memcachedDiscoveryClient{cl: cl}
+	return NewMemcachedDiscoveryClientWithTTL(cl, 0)
+}
+
+// NewMemcachedDiscoveryClientWithTTL creates a new DiscoveryClient
+// that caches results in memory for ttl, so long-running processes
+// notice changes (eg: new CRDs).  A ttl of zero caches results until
+// Invalidate is called.
+func NewMemcachedDiscoveryClientWithTTL(cl discovery.DiscoveryInterface, ttl time.Duration) discovery.CachedDiscoveryInterface {
+	c := &memcachedDiscoveryClient{cl: cl, ttl: ttl, now: time.Now}
+	c.Invalidate()
+	return c
+}
+
+// NewMemcachedDiscoveryClientWithPreferredVersions is
+// NewMemcachedDiscoveryClientWithTTL, except ServerPreferredResources
+// selects the given version (group name -> version) of each group in
+// versions, rather than the server's preferred version.  It is an
+// error if the server does not serve a selected version.
+func NewMemcachedDiscoveryClientWithPreferredVersions(cl discovery.DiscoveryInterface, ttl time.Duration, versions map[string]string) discovery.CachedDiscoveryInterface {
+	c := &memcachedDiscoveryClient{cl: cl, ttl: ttl, now: time.Now, preferredVersions: versions}
 	c.Invalidate()
 	return c
 }
 
+// NewCachedDiscoveryClient creates a new DiscoveryClient that
+// caches results in memory, and also on disk under cacheDir for ttl.
+// The disk cache is keyed by the server's host and user (see
+// CacheUser), and survives between invocations.
+func NewCachedDiscoveryClient(cl discovery.DiscoveryInterface, cacheDir, user string, ttl time.Duration) discovery.CachedDiscoveryInterface {
+	c := &memcachedDiscoveryClient{
+		cl:              cl,
+		now:             time.Now,
+		serverresources: make(map[string]*resourcesEntry),
+		schemas:         make(map[string]*swagger.ApiDeclaration),
+		schemasAt:       make(map[string]time.Time),
+		schemasV3:       make(map[string]*spec.Swagger),
+		schemasV3At:     make(map[string]time.Time),
+		disk:            newDiskCache(filepath.Join(cacheDir, serverCacheKey(cl, user)), ttl),
+	}
+	return c
+}
+
+// expired returns true if a result fetched at t is older than the
+// ttl
+func (c *memcachedDiscoveryClient) expired(t time.Time) bool {
+	return c.ttl > 0 && c.now().Sub(t) > c.ttl
+}
+
+// Fresh returns false if any result was read from the disk cache, or
+// has expired, so callers that fail to find a resource know to
+// Invalidate and retry.
 func (c *memcachedDiscoveryClient) Fresh() bool {
-	return true
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.disk.used() || len(c.stale) > 0 {
+		return false
+	}
+	if c.ttl == 0 {
+		return true
+	}
+	if c.servergroups != nil && c.expired(c.servergroupsAt) {
+		return false
+	}
+	for _, e := range c.serverresources {
+		if e.completed() && c.expired(e.fetched) {
+			return false
+		}
+	}
+	for _, t := range c.schemasAt {
+		if c.expired(t) {
+			return false
+		}
+	}
+	for _, t := range c.schemasV3At {
+		if c.expired(t) {
+			return false
+		}
+	}
+	if c.version != nil && c.expired(c.versionAt) {
+		return false
+	}
+	return c.schema == nil || !c.expired(c.schemaAt)
+}
+
+// SelectiveInvalidator is implemented by the CachedDiscoveryInterface
+// clients returned by this package.  Each method discards only part
+// of the cache, so long-running callers can refresh the parts that
+// change often (eg: resources, as CRDs are installed) without
+// refetching the rest.  Invalidate discards everything.
+type SelectiveInvalidator interface {
+	// InvalidateResources discards the server groups and
+	// resources (and the RESTMapper built from them)
+	InvalidateResources()
+	// InvalidateSchema discards the Swagger and OpenAPI (v2 and
+	// v3) schemas
+	InvalidateSchema()
+	// InvalidateVersion discards the server version
+	InvalidateVersion()
 }
 
+var _ SelectiveInvalidator = &memcachedDiscoveryClient{}
+
+// Invalidate discards all cached results.  Concurrent readers see
+// either the old or the new (empty) cache, never a mixture.
 func (c *memcachedDiscoveryClient) Invalidate() {
+	c.mapperLock.Lock()
+	defer c.mapperLock.Unlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.invalidateResources()
+	c.invalidateSchema()
+	c.invalidateVersion()
+	c.disk.clear()
+}
+
+// InvalidateResources is part of SelectiveInvalidator
+func (c *memcachedDiscoveryClient) InvalidateResources() {
+	c.mapperLock.Lock()
+	defer c.mapperLock.Unlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.invalidateResources()
+	c.disk.remove("servergroups.json", "resources")
+}
+
+// InvalidateSchema is part of SelectiveInvalidator
+func (c *memcachedDiscoveryClient) InvalidateSchema() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.invalidateSchema()
+	c.disk.remove(openAPICacheFile)
+}
+
+// InvalidateVersion is part of SelectiveInvalidator
+func (c *memcachedDiscoveryClient) InvalidateVersion() {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	c.invalidateVersion()
+}
+
+// invalidateResources discards the cached groups and resources.
+// c.mapperLock and c.lock must be held.
+func (c *memcachedDiscoveryClient) invalidateResources() {
+	c.mapper = nil
 	c.servergroups = nil
-	c.serverresources = make(map[string]*metav1.APIResourceList)
+	c.serverresources = make(map[string]*resourcesEntry)
+	c.stale = nil
+}
+
+// invalidateSchema discards the cached schemas.  c.lock must be
+// held.
+func (c *memcachedDiscoveryClient) invalidateSchema() {
 	c.schemas = make(map[string]*swagger.ApiDeclaration)
+	c.schemasAt = make(map[string]time.Time)
+	c.schemasV3 = make(map[string]*spec.Swagger)
+	c.schemasV3At = make(map[string]time.Time)
+	c.v3index = nil
+	c.schema = nil
+}
+
+// invalidateVersion discards the cached server version.  c.lock
+// must be held.
+func (c *memcachedDiscoveryClient) invalidateVersion() {
+	c.version = nil
 }
 
 func (c *memcachedDiscoveryClient) RESTClient() rest.Interface {
@@ -70,40 +292,300 @@ func (c *memcachedDiscoveryClient) ServerGroups() (*metav1.APIGroupList, error)
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	var err error
-	if c.servergroups != nil {
+	if c.servergroups != nil && !c.expired(c.servergroupsAt) {
+		Metrics().CacheHit("ServerGroups")
 		return c.servergroups, nil
 	}
-	c.servergroups, err = c.cl.ServerGroups()
-	return c.servergroups, err
+	c.servergroupsAt = c.now()
+	groups := &metav1.APIGroupList{}
+	if c.disk.read("servergroups.json", groups) {
+		Metrics().CacheHit("ServerGroups")
+		c.servergroups = groups
+		return groups, nil
+	}
+	Metrics().CacheMiss("ServerGroups")
+	defer timeDiscoveryFetch("ServerGroups", time.Now())
+	groups, err := c.fetchGroups()
+	if err != nil {
+		return groups, err
+	}
+	c.disk.write("servergroups.json", groups)
+	c.servergroups = groups
+	return groups, nil
+}
+
+// fetchGroups fetches the server groups, along with the resources of
+// every group version if the server supports aggregated discovery.
+// Merged clients span several servers, so are always queried through
+// c.cl, as are servers that fail the first attempt (so any retry
+// policy applies).  c.lock must be held.
+func (c *memcachedDiscoveryClient) fetchGroups() (*metav1.APIGroupList, error) {
+	if _, merged := c.cl.(*mergedDiscoveryClient); !merged {
+		groups, resources, err := fetchDiscoveryRoots(c.cl.RESTClient())
+		if err == nil {
+			c.storeAggregated(resources)
+			return groups, nil
+		}
+		log.Debugf("Falling back to per-group discovery: %v", err)
+	}
+	return c.cl.ServerGroups()
 }
 
+// ServerResourcesForGroupVersion only holds the client lock while
+// looking up the cache, so a slow fetch of one group version does
+// not block others.  Concurrent requests for the same group version
+// share a single fetch.
 func (c *memcachedDiscoveryClient) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	if err, ok := c.stale[groupVersion]; ok {
+		c.lock.Unlock()
+		return nil, err
+	}
+	e, ok := c.serverresources[groupVersion]
+	if ok && e.completed() && c.expired(e.fetched) {
+		ok = false
+	}
+	if !ok {
+		e = &resourcesEntry{done: make(chan struct{}), fetched: c.now()}
+		c.serverresources[groupVersion] = e
+	}
+	c.lock.Unlock()
+
+	if ok {
+		Metrics().CacheHit("ServerResourcesForGroupVersion")
+		<-e.done
+		return e.resources, e.err
+	}
+
+	e.resources, e.err = c.fetchResources(groupVersion)
+	if e.err != nil {
+		// Don't cache failures
+		c.lock.Lock()
+		if c.serverresources[groupVersion] == e {
+			delete(c.serverresources, groupVersion)
+		}
+		c.lock.Unlock()
+	}
+	close(e.done)
+	return e.resources, e.err
+}
+
+func (c *memcachedDiscoveryClient) fetchResources(groupVersion string) (*metav1.APIResourceList, error) {
+	file := filepath.Join("resources", groupVersion+".json")
+	resources := &metav1.APIResourceList{}
+	if c.disk.read(file, resources) {
+		Metrics().CacheHit("ServerResourcesForGroupVersion")
+		return resources, nil
+	}
+	Metrics().CacheMiss("ServerResourcesForGroupVersion")
+	defer timeDiscoveryFetch("ServerResourcesForGroupVersion", time.Now())
+	resources, err := c.cl.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return nil, err
+	}
+	c.disk.write(file, resources)
+	return resources, nil
+}
+
+// allServerResources fetches the resources of every group version
+// in groups, using up to DiscoveryParallelism concurrent requests.
+// Group versions that fail are returned in failed.
+func (c *memcachedDiscoveryClient) allServerResources(groups *metav1.APIGroupList) (resources map[string]*metav1.APIResourceList, failed map[schema.GroupVersion]error) {
+	resources = map[string]*metav1.APIResourceList{}
+	failed = map[schema.GroupVersion]error{}
+
+	workers := DiscoveryParallelism
+	if workers < 1 {
+		workers = 1
+	}
+	work := make(chan metav1.GroupVersionForDiscovery)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range work {
+				list, err := c.ServerResourcesForGroupVersion(v.GroupVersion)
+				mu.Lock()
+				if err != nil {
+					gv, _ := schema.ParseGroupVersion(v.GroupVersion)
+					failed[gv] = err
+				} else {
+					resources[v.GroupVersion] = list
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, group := range groups.Groups {
+		for _, v := range group.Versions {
+			work <- v
+		}
+	}
+	close(work)
+	wg.Wait()
+	return resources, failed
+}
 
-	var err error
-	if v := c.serverresources[groupVersion]; v != nil {
-		return v, nil
+func groupDiscoveryError(failed map[schema.GroupVersion]error) error {
+	if len(failed) == 0 {
+		return nil
 	}
-	c.serverresources[groupVersion], err = c.cl.ServerResourcesForGroupVersion(groupVersion)
-	return c.serverresources[groupVersion], err
+	return &discovery.ErrGroupDiscoveryFailed{Groups: failed}
 }
 
+// WarnOnPartialDiscovery logs a warning and returns nil if err is a
+// discovery.ErrGroupDiscoveryFailed, ie: the results of discovery
+// are usable, but omit some (eg: broken aggregated) API groups.
+// Other errors are returned unchanged.
+func WarnOnPartialDiscovery(err error) error {
+	if !discovery.IsGroupDiscoveryFailedError(err) {
+		return err
+	}
+	log.Warningf("Continuing without some API groups: %v", err)
+	return nil
+}
+
+// ServerResources returns the resources of every group version.  If
+// some group versions fail, the others are still returned, along
+// with a discovery.ErrGroupDiscoveryFailed.
 func (c *memcachedDiscoveryClient) ServerResources() ([]*metav1.APIResourceList, error) {
-	return c.cl.ServerResources()
+	groups, err := c.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	resources, failed := c.allServerResources(groups)
+
+	result := []*metav1.APIResourceList{}
+	for _, group := range groups.Groups {
+		for _, v := range group.Versions {
+			if list, ok := resources[v.GroupVersion]; ok {
+				result = append(result, list)
+			}
+		}
+	}
+	return result, groupDiscoveryError(failed)
+}
+
+// preferredVersion returns the version of group to prefer, which
+// is the server's preferred version unless overridden
+func (c *memcachedDiscoveryClient) preferredVersion(group metav1.APIGroup) string {
+	if v, ok := c.preferredVersions[group.Name]; ok {
+		return v
+	}
+	return group.PreferredVersion.Version
+}
+
+// checkPreferredVersions returns an error if any overridden
+// preferred version is not served
+func (c *memcachedDiscoveryClient) checkPreferredVersions(groups *metav1.APIGroupList) error {
+	served := map[string][]string{}
+	for _, group := range groups.Groups {
+		for _, v := range group.Versions {
+			served[group.Name] = append(served[group.Name], v.Version)
+		}
+	}
+	for name, version := range c.preferredVersions {
+		versions, ok := served[name]
+		if !ok {
+			return fmt.Errorf("Can't select version %s of API group %q: group is not served", version, name)
+		}
+		found := false
+		for _, v := range versions {
+			found = found || v == version
+		}
+		if !found {
+			return fmt.Errorf("Can't select version %s of API group %q: served versions are %s", version, name, strings.Join(versions, ", "))
+		}
+	}
+	return nil
 }
 
+// ServerPreferredResources returns each resource in the server's
+// preferred version of its group (or the version selected by
+// NewMemcachedDiscoveryClientWithPreferredVersions), or the first
+// version found if the preferred version doesn't have it.
 func (c *memcachedDiscoveryClient) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
-	return c.cl.ServerPreferredResources()
+	groups, err := c.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkPreferredVersions(groups); err != nil {
+		return nil, err
+	}
+	resources, failed := c.allServerResources(groups)
+
+	result := []*metav1.APIResourceList{}
+	for _, group := range groups.Groups {
+		preferredVersion := c.preferredVersion(group)
+		selected := map[string]int{} // resource name -> index into result
+		for _, v := range group.Versions {
+			list, ok := resources[v.GroupVersion]
+			if !ok {
+				continue
+			}
+			preferred := &metav1.APIResourceList{GroupVersion: v.GroupVersion}
+			result = append(result, preferred)
+			for _, r := range list.APIResources {
+				if strings.Contains(r.Name, "/") {
+					// Subresource
+					continue
+				}
+				if i, ok := selected[r.Name]; ok {
+					if v.Version != preferredVersion {
+						continue
+					}
+					removeResource(result[i], r.Name)
+				}
+				selected[r.Name] = len(result) - 1
+				preferred.APIResources = append(preferred.APIResources, r)
+			}
+		}
+	}
+	return result, groupDiscoveryError(failed)
+}
+
+func removeResource(list *metav1.APIResourceList, name string) {
+	kept := list.APIResources[:0]
+	for _, r := range list.APIResources {
+		if r.Name != name {
+			kept = append(kept, r)
+		}
+	}
+	list.APIResources = kept
 }
 
 func (c *memcachedDiscoveryClient) ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error) {
-	return c.cl.ServerPreferredNamespacedResources()
+	all, err := c.ServerPreferredResources()
+	return discovery.FilteredBy(discovery.ResourcePredicateFunc(func(groupVersion string, r *metav1.APIResource) bool {
+		return r.Namespaced
+	}), all), err
 }
 
 func (c *memcachedDiscoveryClient) ServerVersion() (*version.Info, error) {
-	return c.cl.ServerVersion()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.serverVersion()
+}
+
+// serverVersion returns the (cached) server version.  c.lock must
+// be held.
+func (c *memcachedDiscoveryClient) serverVersion() (*version.Info, error) {
+	if c.version != nil && !c.expired(c.versionAt) {
+		Metrics().CacheHit("ServerVersion")
+		return c.version, nil
+	}
+	Metrics().CacheMiss("ServerVersion")
+	defer timeDiscoveryFetch("ServerVersion", time.Now())
+	fetched := c.now()
+	info, err := c.cl.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	c.version, c.versionAt = info, fetched
+	return info, nil
 }
 
 func (c *memcachedDiscoveryClient) SwaggerSchema(version schema.GroupVersion) (*swagger.ApiDeclaration, error) {
@@ -112,16 +594,21 @@ func (c *memcachedDiscoveryClient) SwaggerSchema(version schema.GroupVersion) (*
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	if c.schemas[key] != nil {
+	if c.schemas[key] != nil && !c.expired(c.schemasAt[key]) {
+		Metrics().CacheHit("SwaggerSchema")
 		return c.schemas[key], nil
 	}
 
+	Metrics().CacheMiss("SwaggerSchema")
+	defer timeDiscoveryFetch("SwaggerSchema", time.Now())
+	fetched := c.now()
 	schema, err := c.cl.SwaggerSchema(version)
 	if err != nil {
 		return nil, err
 	}
 
 	c.schemas[key] = schema
+	c.schemasAt[key] = fetched
 	return schema, nil
 }
 
@@ -129,31 +616,133 @@ func (c *memcachedDiscoveryClient) OpenAPISchema() (*spec.Swagger, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	if c.schema != nil {
+	if c.schema != nil && !c.expired(c.schemaAt) {
+		Metrics().CacheHit("OpenAPISchema")
 		return c.schema, nil
 	}
 
+	c.schemaAt = c.now()
+	gitVersion := ""
+	if c.disk != nil {
+		info, err := c.serverVersion()
+		if err != nil {
+			log.Debugf("Not caching OpenAPI schema: %v", err)
+		} else {
+			gitVersion = info.GitVersion
+		}
+	}
+	if schema := c.readCachedSchema(gitVersion); schema != nil {
+		Metrics().CacheHit("OpenAPISchema")
+		c.schema = schema
+		return schema, nil
+	}
+
+	Metrics().CacheMiss("OpenAPISchema")
+	start := time.Now()
 	schema, err := c.cl.OpenAPISchema()
+	timeDiscoveryFetch("OpenAPISchema", start)
 	if err != nil {
 		return nil, err
 	}
 
+	c.writeCachedSchema(gitVersion, schema)
 	c.schema = schema
 	return schema, nil
 }
 
+// openAPICacheFile is the disk cache file holding the OpenAPI
+// schema.  Unlike other discovery results, it is reused for as long
+// as the server version is unchanged.
+const openAPICacheFile = "openapi.json"
+
+// cachedSchema is the on-disk form of the OpenAPI schema
+type cachedSchema struct {
+	// GitVersion is the server version the schema was fetched
+	// from
+	GitVersion string `json:"gitVersion"`
+	// Checksum is the hex-encoded SHA-256 of Schema
+	Checksum string          `json:"checksum"`
+	Schema   json.RawMessage `json:"schema"`
+}
+
+// readCachedSchema returns the OpenAPI schema cached on disk, or nil
+// if there is none for gitVersion (of any age), or it is corrupt.
+func (c *memcachedDiscoveryClient) readCachedSchema(gitVersion string) *spec.Swagger {
+	if gitVersion == "" {
+		return nil
+	}
+	var cached cachedSchema
+	if !c.disk.readAnyAge(openAPICacheFile, &cached) {
+		return nil
+	}
+	if cached.GitVersion != gitVersion {
+		log.Debugf("Cached OpenAPI schema is for server %s, not %s", cached.GitVersion, gitVersion)
+		return nil
+	}
+	sum := sha256.Sum256(cached.Schema)
+	if hex.EncodeToString(sum[:]) != cached.Checksum {
+		log.Debugf("Ignoring cached OpenAPI schema with bad checksum")
+		return nil
+	}
+	schema := &spec.Swagger{}
+	if err := json.Unmarshal(cached.Schema, schema); err != nil {
+		log.Debugf("Ignoring corrupt cached OpenAPI schema: %v", err)
+		return nil
+	}
+	return schema
+}
+
+// writeCachedSchema stores schema on disk, for servers reporting
+// gitVersion
+func (c *memcachedDiscoveryClient) writeCachedSchema(gitVersion string, schema *spec.Swagger) {
+	if c.disk == nil || gitVersion == "" {
+		return
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		log.Debugf("Error encoding OpenAPI schema: %v", err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	c.disk.write(openAPICacheFile, cachedSchema{
+		GitVersion: gitVersion,
+		Checksum:   hex.EncodeToString(sum[:]),
+		Schema:     data,
+	})
+}
+
 var _ discovery.CachedDiscoveryInterface = &memcachedDiscoveryClient{}
 
-// ClientForResource returns the ResourceClient for a given object
+// ClientForResource returns the ResourceClient for a given object.
+// Namespaced objects without a namespace are placed in defNs.  Any
+// namespace is ignored for cluster-scoped objects.
 func ClientForResource(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, obj runtime.Object, defNs string) (*dynamic.ResourceClient, error) {
+	return clientForResource(pool, disco, obj, defNs, false)
+}
+
+// ClientForResourceEstablished is ClientForResource, except that
+// when obj's kind is not served it also looks for a
+// CustomResourceDefinition declaring it, and returns
+// ErrResourceNotEstablished if there is one.  This costs a LIST of
+// CRDs (and permission to make it) on every discovery miss.
+func ClientForResourceEstablished(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, obj runtime.Object, defNs string) (*dynamic.ResourceClient, error) {
+	return clientForResource(pool, disco, obj, defNs, true)
+}
+
+func clientForResource(pool dynamic.ClientPool, disco discovery.DiscoveryInterface, obj runtime.Object, defNs string, checkCRDs bool) (*dynamic.ResourceClient, error) {
 	gvk := obj.GetObjectKind().GroupVersionKind()
 
-	client, err := pool.ClientForGroupVersionKind(gvk)
+	resource, err := serverResourceForGroupVersionKind(disco, gvk)
 	if err != nil {
+		if checkCRDs {
+			if crdErr := checkResourceEstablished(pool, disco, gvk); crdErr != nil {
+				return nil, crdErr
+			}
+		}
 		return nil, err
 	}
 
-	resource, err := serverResourceForGroupVersionKind(disco, gvk)
+	client, err := pool.ClientForGroupVersionKind(gvk)
 	if err != nil {
 		return nil, err
 	}
@@ -162,9 +751,14 @@ func ClientForResource(pool dynamic.ClientPool, disco discovery.DiscoveryInterfa
 	if err != nil {
 		return nil, err
 	}
-	namespace := meta.GetNamespace()
-	if namespace == "" {
-		namespace = defNs
+	namespace := ""
+	if resource.Namespaced {
+		namespace = meta.GetNamespace()
+		if namespace == "" {
+			namespace = defNs
+		}
+	} else if ns := meta.GetNamespace(); ns != "" {
+		log.Debugf("Ignoring namespace %s of cluster-scoped %s", ns, resource.Name)
 	}
 
 	log.Debugf("Fetching client for %s namespace=%s", resource, namespace)
@@ -172,18 +766,309 @@ func ClientForResource(pool dynamic.ClientPool, disco discovery.DiscoveryInterfa
 	return rc, nil
 }
 
+// ClientForResourceContext is ClientForResource, but returns
+// ctx.Err() as soon as ctx is done.  Requests already in flight are
+// abandoned rather than aborted, unless pool and disco were built
+// with a NewContextTransport for ctx, which also bounds requests
+// made with the returned client.
+func ClientForResourceContext(ctx context.Context, pool dynamic.ClientPool, disco discovery.DiscoveryInterface, obj runtime.Object, defNs string) (*dynamic.ResourceClient, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		rc  *dynamic.ResourceClient
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		rc, err := ClientForResource(pool, disco, obj, defNs)
+		ch <- result{rc, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.rc, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// serverResourceForGroupVersionKind returns the resource that serves
+// gvk.  Subresources (eg: deployments/scale) are only returned if no
+// top-level resource has that kind.
 func serverResourceForGroupVersionKind(disco discovery.ServerResourcesInterface, gvk schema.GroupVersionKind) (*metav1.APIResource, error) {
+	resources, err := disco.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		// The whole group version is missing
+		return nil, &NoMatchError{GroupVersionKind: gvk}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to fetch resource description for %s: %v", gvk.GroupVersion(), err)
+	}
+
+	var subresource *metav1.APIResource
+	for i, r := range resources.APIResources {
+		if r.Kind != gvk.Kind {
+			continue
+		}
+		if strings.Contains(r.Name, "/") {
+			if subresource == nil {
+				subresource = &resources.APIResources[i]
+			}
+			continue
+		}
+		log.Debugf("Using resource '%s' for %s", r.Name, gvk)
+		return &r, nil
+	}
+	if subresource != nil {
+		log.Debugf("Using subresource '%s' for %s", subresource.Name, gvk)
+		r := *subresource
+		return &r, nil
+	}
+
+	return nil, &NoMatchError{GroupVersionKind: gvk}
+}
+
+// ErrResourceNotFound is wrapped by errors returned when the server
+// does not serve a kind at all, as opposed to a failure to discover
+// it.  Test for it with IsResourceNotFound or errors.Is.
+var ErrResourceNotFound = errors.New("resource not found")
+
+// NoMatchError is returned when the server has no resource for a
+// GroupVersionKind.  It wraps ErrResourceNotFound.
+type NoMatchError struct {
+	GroupVersionKind schema.GroupVersionKind
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("Server is unable to handle %s", e.GroupVersionKind)
+}
+
+// Unwrap returns ErrResourceNotFound
+func (e *NoMatchError) Unwrap() error {
+	return ErrResourceNotFound
+}
+
+// IsResourceNotFound returns true if err is (or wraps) a NoMatchError
+func IsResourceNotFound(err error) bool {
+	return errors.Is(err, ErrResourceNotFound)
+}
+
+// ServerResourceForSubresource returns the named subresource (eg:
+// "scale" or "log") of the resource that serves gvk.
+func ServerResourceForSubresource(disco discovery.ServerResourcesInterface, gvk schema.GroupVersionKind, subresource string) (*metav1.APIResource, error) {
+	parent, err := serverResourceForGroupVersionKind(disco, gvk)
+	if err != nil {
+		return nil, err
+	}
 	resources, err := disco.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
 	if err != nil {
 		return nil, fmt.Errorf("unable to fetch resource description for %s: %v", gvk.GroupVersion(), err)
 	}
 
+	name := parent.Name + "/" + subresource
 	for _, r := range resources.APIResources {
-		if r.Kind == gvk.Kind {
-			log.Debugf("Using resource '%s' for %s", r.Name, gvk)
+		if r.Name == name {
 			return &r, nil
 		}
 	}
+	return nil, fmt.Errorf("Server does not support subresource %s of %s", subresource, gvk)
+}
+
+// ResolveResource turns a kubectl-style resource argument (eg: "po",
+// "deployment" or "deployments.apps") into the resource the server
+// prefers for it.  Input is matched against each resource's name,
+// singular name, kind and short names, in that order of precedence.
+// An optional group suffix restricts the match to that API group.
+// Like kubectl, a match in the core group is preferred over matches
+// in other groups (eg: "events" is core "events", not
+// "events.events.k8s.io"); otherwise matches in more than one group
+// are an error listing the candidates.
+func ResolveResource(disco discovery.ServerResourcesInterface, input string) (schema.GroupVersionResource, error) {
+	name, group := strings.ToLower(input), ""
+	if i := strings.Index(name, "."); i >= 0 {
+		name, group = name[:i], name[i+1:]
+	}
+
+	lists, err := disco.ServerPreferredResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return schema.GroupVersionResource{}, err
+		}
+		log.Debugf("Resolving %q with partial discovery: %v", input, err)
+	}
+
+	// Lower ranks take precedence
+	const (
+		rankName = iota
+		rankSingular
+		rankKind
+		rankShort
+		rankNone
+	)
+	rank := func(r *metav1.APIResource) int {
+		switch {
+		case r.Name == name:
+			return rankName
+		case r.SingularName == name:
+			return rankSingular
+		case strings.ToLower(r.Kind) == name:
+			return rankKind
+		}
+		for _, short := range r.ShortNames {
+			if short == name {
+				return rankShort
+			}
+		}
+		return rankNone
+	}
 
-	return nil, fmt.Errorf("Server is unable to handle %s", gvk)
+	best := rankNone
+	var matches []schema.GroupVersionResource
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		if group != "" && gv.Group != group {
+			continue
+		}
+		for i := range list.APIResources {
+			r := &list.APIResources[i]
+			if strings.Contains(r.Name, "/") {
+				// Subresource
+				continue
+			}
+			n := rank(r)
+			if n == rankNone || n > best {
+				continue
+			}
+			if n < best {
+				best = n
+				matches = nil
+			}
+			matches = append(matches, gv.WithResource(r.Name))
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return schema.GroupVersionResource{}, fmt.Errorf("Server does not have a resource type %q", input)
+	case 1:
+		log.Debugf("Resolved %q to %s", input, matches[0])
+		return matches[0], nil
+	}
+
+	candidates := make([]string, len(matches))
+	for i, m := range matches {
+		gr := m.GroupResource()
+		candidates[i] = gr.String()
+	}
+	sort.Strings(candidates)
+	var core []schema.GroupVersionResource
+	for _, m := range matches {
+		if m.Group == "" {
+			core = append(core, m)
+		}
+	}
+	if len(core) == 1 {
+		log.Debugf("Resolved %q to core %s, ignoring %s", input, core[0], strings.Join(candidates, ", "))
+		return core[0], nil
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("Resource type %q is ambiguous, specify one of: %s", input, strings.Join(candidates, ", "))
+}
+
+// ErrResourceNotEstablished is returned when the server does not
+// (yet) serve a kind, but a CustomResourceDefinition for that kind
+// exists.  This is usually transient immediately after the CRD is
+// created, and callers may Invalidate discovery and retry.
+type ErrResourceNotEstablished struct {
+	GroupVersionKind schema.GroupVersionKind
+	CRDName          string
+}
+
+func (e *ErrResourceNotEstablished) Error() string {
+	return fmt.Sprintf("%s is defined by CustomResourceDefinition %s, but is not yet served", e.GroupVersionKind, e.CRDName)
+}
+
+// IsResourceNotEstablished returns true if err is an ErrResourceNotEstablished
+func IsResourceNotEstablished(err error) bool {
+	_, ok := err.(*ErrResourceNotEstablished)
+	return ok
+}
+
+var crdGroupVersionKinds = []schema.GroupVersionKind{
+	{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"},
+	{Group: "apiextensions.k8s.io", Version: "v1beta1", Kind: "CustomResourceDefinition"},
+}
+
+// checkResourceEstablished is used after gvk could not be found in
+// discovery.  It returns ErrResourceNotEstablished if a
+// CustomResourceDefinition declares gvk, and nil otherwise
+// (including when CRDs can't be listed at all).
+func checkResourceEstablished(pool dynamic.ClientPool, disco discovery.ServerResourcesInterface, gvk schema.GroupVersionKind) error {
+	for _, crdGvk := range crdGroupVersionKinds {
+		rsrc, err := serverResourceForGroupVersionKind(disco, crdGvk)
+		if err != nil {
+			continue
+		}
+		client, err := pool.ClientForGroupVersionKind(crdGvk)
+		if err != nil {
+			log.Debugf("Unable to create client for %s: %v", crdGvk, err)
+			return nil
+		}
+		list, err := client.Resource(rsrc, metav1.NamespaceNone).List(metav1.ListOptions{})
+		if err != nil {
+			log.Debugf("Unable to list %s: %v", rsrc.Name, err)
+			return nil
+		}
+		var found *unstructured.Unstructured
+		err = meta.EachListItem(list, func(o runtime.Object) error {
+			if crd, ok := o.(*unstructured.Unstructured); ok && crdDefinesKind(crd, gvk) {
+				found = crd
+			}
+			return nil
+		})
+		if err != nil {
+			return nil
+		}
+		if found != nil {
+			return &ErrResourceNotEstablished{GroupVersionKind: gvk, CRDName: found.GetName()}
+		}
+		return nil
+	}
+	return nil
+}
+
+// crdDefinesKind returns true if the CustomResourceDefinition object
+// declares a served gvk.
+func crdDefinesKind(crd *unstructured.Unstructured, gvk schema.GroupVersionKind) bool {
+	spec, ok := crd.Object["spec"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if group, _ := spec["group"].(string); group != gvk.Group {
+		return false
+	}
+	names, _ := spec["names"].(map[string]interface{})
+	if kind, _ := names["kind"].(string); kind != gvk.Kind {
+		return false
+	}
+
+	// apiextensions/v1beta1 single version
+	if version, _ := spec["version"].(string); version == gvk.Version {
+		return true
+	}
+	versions, _ := spec["versions"].([]interface{})
+	for _, v := range versions {
+		v, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		served, ok := v["served"].(bool)
+		if name, _ := v["name"].(string); name == gvk.Version && (served || !ok) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,1007 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+const crdListJSON = `{
+  "apiVersion": "apiextensions.k8s.io/v1",
+  "kind": "CustomResourceDefinitionList",
+  "items": [
+    {
+      "apiVersion": "apiextensions.k8s.io/v1",
+      "kind": "CustomResourceDefinition",
+      "metadata": {"name": "widgets.example.com"},
+      "spec": {
+        "group": "example.com",
+        "names": {"kind": "Widget", "plural": "widgets"},
+        "versions": [{"name": "v1", "served": true}]
+      }
+    }
+  ]
+}`
+
+func TestClientForResourceEstablished(t *testing.T) {
+	lists := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lists++
+		if r.URL.Path != "/apis/apiextensions.k8s.io/v1/customresourcedefinitions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, crdListJSON)
+	}))
+	defer srv.Close()
+
+	pool := dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL})
+	disco := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "apiextensions.k8s.io/v1",
+				APIResources: []metav1.APIResource{
+					{Name: "customresourcedefinitions", Kind: "CustomResourceDefinition"},
+				},
+			},
+			{
+				GroupVersion: "tests/v1alpha1",
+				APIResources: []metav1.APIResource{
+					{Name: "tests", Kind: "Test", Namespaced: true},
+				},
+			},
+		},
+	}}
+
+	newObj := func(apiVersion, kind string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": apiVersion,
+				"kind":       kind,
+				"metadata": map[string]interface{}{
+					"name": "myobj",
+				},
+			},
+		}
+	}
+
+	// Served
+	if _, err := ClientForResourceEstablished(pool, disco, newObj("tests/v1alpha1", "Test"), "default"); err != nil {
+		t.Errorf("served kind returned error: %v", err)
+	}
+
+	// Plain ClientForResource doesn't look for CRDs
+	_, err := ClientForResource(pool, disco, newObj("example.com/v1", "Widget"), "default")
+	if err == nil || IsResourceNotEstablished(err) {
+		t.Errorf("Expected unknown kind error, got %v", err)
+	}
+	if lists != 0 {
+		t.Errorf("ClientForResource made %d requests, expected none", lists)
+	}
+
+	// Registered, but not yet served
+	_, err = ClientForResourceEstablished(pool, disco, newObj("example.com/v1", "Widget"), "default")
+	if !IsResourceNotEstablished(err) {
+		t.Errorf("Expected ErrResourceNotEstablished, got %v", err)
+	} else if name := err.(*ErrResourceNotEstablished).CRDName; name != "widgets.example.com" {
+		t.Errorf("Unexpected CRD name %q", name)
+	}
+
+	// Genuinely unknown
+	for _, o := range []*unstructured.Unstructured{
+		newObj("example.com/v1", "Gadget"),
+		newObj("example.com/v2", "Widget"),
+		newObj("bogus/v1", "Unknown"),
+	} {
+		_, err = ClientForResourceEstablished(pool, disco, o, "default")
+		if err == nil || IsResourceNotEstablished(err) {
+			t.Errorf("Expected unknown kind error for %s, got %v", o.GroupVersionKind(), err)
+		}
+	}
+}
+
+func TestClientForResourceScope(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"myobj"}}`)
+	}))
+	defer srv.Close()
+
+	pool := dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL})
+	disco := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					{Name: "persistentvolumes", Kind: "PersistentVolume"},
+				},
+			},
+		},
+	}}
+
+	newObj := func(kind, namespace string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       kind,
+				"metadata": map[string]interface{}{
+					"name": "myobj",
+				},
+			},
+		}
+		if namespace != "" {
+			o.SetNamespace(namespace)
+		}
+		return o
+	}
+
+	for _, o := range []*unstructured.Unstructured{
+		newObj("ConfigMap", ""),
+		newObj("ConfigMap", "myns"),
+		newObj("PersistentVolume", ""),
+		newObj("PersistentVolume", "myns"),
+	} {
+		rc, err := ClientForResource(pool, disco, o, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rc.Get(o.GetName(), metav1.GetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"/api/v1/namespaces/default/configmaps/myobj",
+		"/api/v1/namespaces/myns/configmaps/myobj",
+		"/api/v1/persistentvolumes/myobj",
+		"/api/v1/persistentvolumes/myobj",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Unexpected request paths %v, expected %v", paths, expected)
+	}
+}
+
+func TestClientPathPrefix(t *testing.T) {
+	const prefix = "/clusters/abc"
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case prefix + "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case prefix + "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[]}`)
+		case prefix + "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["get"]}]}`)
+		case prefix + "/api/v1/namespaces/default/configmaps/myobj":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"myobj","namespace":"default"}}`)
+		case prefix + "/swagger.json":
+			fmt.Fprint(w, `{"swagger":"2.0","info":{"title":"Kubernetes","version":"v1.7.0"},"paths":{}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	conf := &rest.Config{Host: srv.URL + prefix}
+	disco, err := discovery.NewDiscoveryClientForConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discoCache := NewMemcachedDiscoveryClient(disco)
+	mapper := discovery.NewDeferredDiscoveryRESTMapper(discoCache, dynamic.VersionInterfaces)
+	pool := dynamic.NewClientPool(conf, mapper, dynamic.LegacyAPIPathResolverFunc)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetName("myobj")
+
+	rc, err := ClientForResource(pool, discoCache, obj, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.Get("myobj", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.Patch("myobj", types.MergePatchType, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := discoCache.OpenAPISchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) == 0 {
+		t.Fatalf("No requests made")
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, prefix+"/") {
+			t.Errorf("Request to %s is missing the %s prefix", p, prefix)
+		}
+	}
+}
+
+func TestCachedDiscoveryClient(t *testing.T) {
+	requests := map[string]int{}
+	gitVersion := "v1.7.0"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/version":
+			fmt.Fprintf(w, `{"major":"1","minor":"7","gitVersion":%q}`, gitVersion)
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[{"name":"apps","versions":[{"groupVersion":"apps/v1beta1","version":"v1beta1"}]}]}`)
+		case "/apis/apps/v1beta1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"apps/v1beta1","resources":[{"name":"deployments","kind":"Deployment","namespaced":true,"verbs":["get"]}]}`)
+		case "/swagger.json":
+			fmt.Fprint(w, `{"swagger":"2.0","info":{"title":"Kubernetes","version":"v1.7.0"},"paths":{}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tmpdir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchAll := func(c discovery.CachedDiscoveryInterface) {
+		groups, err := c.ServerGroups()
+		if err != nil || len(groups.Groups) != 2 {
+			t.Errorf("Unexpected groups %v: %v", groups, err)
+		}
+		rsrcs, err := c.ServerResourcesForGroupVersion("apps/v1beta1")
+		if err != nil || len(rsrcs.APIResources) != 1 || rsrcs.APIResources[0].Kind != "Deployment" {
+			t.Errorf("Unexpected resources %v: %v", rsrcs, err)
+		}
+		schema, err := c.OpenAPISchema()
+		if err != nil || schema.Info.Version != "v1.7.0" {
+			t.Errorf("Unexpected schema %v: %v", schema, err)
+		}
+	}
+	// The server version is checked whenever the schema is read
+	// from disk, so isn't counted
+	total := func() int {
+		n := 0
+		for p, v := range requests {
+			if p != "/version" {
+				n += v
+			}
+		}
+		return n
+	}
+
+	c := NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	fetchAll(c)
+	fetchAll(c)
+	if !c.Fresh() {
+		t.Errorf("Live results were not fresh")
+	}
+	live := total()
+	if live == 0 || requests["/swagger.json"] != 1 {
+		t.Fatalf("Unexpected requests %v", requests)
+	}
+
+	// A new client (ie: the next invocation) reads from disk
+	c = NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	fetchAll(c)
+	if total() != live {
+		t.Errorf("Cached results were fetched again: %v", requests)
+	}
+	if c.Fresh() {
+		t.Errorf("Cached results were reported as fresh")
+	}
+
+	// Corrupt files are ignored
+	dirs, err := filepath.Glob(filepath.Join(tmpdir, "*"))
+	if err != nil || len(dirs) != 1 || !strings.HasPrefix(filepath.Base(dirs[0]), "127.0.0.1_") {
+		t.Fatalf("Unexpected cache directories %v", dirs)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dirs[0], "openapi.json"), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	fetchAll(c)
+	if requests["/swagger.json"] != 2 || total() != live+1 {
+		t.Errorf("Corrupt cache was not refetched: %v", requests)
+	}
+
+	// Stale files are ignored, except the schema for an unchanged
+	// server version
+	c = NewCachedDiscoveryClient(disco, tmpdir, "", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	fetchAll(c)
+	if requests["/swagger.json"] != 2 || total() != 2*live {
+		t.Errorf("Stale cache was not refetched: %v", requests)
+	}
+
+	// The schema is refetched when its checksum doesn't match
+	data, err := ioutil.ReadFile(filepath.Join(dirs[0], "openapi.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(data), "v1.7.0", "v1.6.0", 1)
+	if tampered == string(data) {
+		t.Fatalf("Unexpected schema cache %s", data)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dirs[0], "openapi.json"), []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	if _, err := c.OpenAPISchema(); err != nil {
+		t.Fatal(err)
+	}
+	if requests["/swagger.json"] != 3 {
+		t.Errorf("Schema with bad checksum was not refetched: %v", requests)
+	}
+
+	// ... and when the server is upgraded
+	gitVersion = "v1.7.1"
+	c = NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+	if _, err := c.OpenAPISchema(); err != nil {
+		t.Fatal(err)
+	}
+	if requests["/swagger.json"] != 4 {
+		t.Errorf("Schema for old server version was not refetched: %v", requests)
+	}
+
+	// A different user doesn't share the cache
+	other := NewCachedDiscoveryClient(disco, tmpdir, "other", time.Hour)
+	if _, err := other.OpenAPISchema(); err != nil {
+		t.Fatal(err)
+	}
+	if requests["/swagger.json"] != 5 {
+		t.Errorf("Schema cached for another user was reused: %v", requests)
+	}
+
+	c.Invalidate()
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("Invalidate did not remove cache directory: %v", err)
+	}
+}
+
+func TestCacheUser(t *testing.T) {
+	base := rest.Config{Username: "alice"}
+	keys := map[string]bool{}
+	for _, conf := range []rest.Config{
+		base,
+		{Username: "bob"},
+		{BearerToken: "token"},
+		{Username: "alice", Impersonate: rest.ImpersonationConfig{UserName: "bob"}},
+		{Username: "alice", Impersonate: rest.ImpersonationConfig{Groups: []string{"admins"}}},
+	} {
+		key := CacheUser(&conf)
+		if keys[key] {
+			t.Errorf("Duplicate cache key %q for %+v", key, conf)
+		}
+		keys[key] = true
+	}
+
+	same := base
+	if CacheUser(&same) != CacheUser(&base) {
+		t.Errorf("Cache key is not stable")
+	}
+	if key := CacheUser(&rest.Config{BearerToken: "token"}); strings.Contains(key, "token") {
+		t.Errorf("Cache key %q leaks credentials", key)
+	}
+}
+
+func TestParallelServerResources(t *testing.T) {
+	defer func(n int) { DiscoveryParallelism = n }(DiscoveryParallelism)
+	DiscoveryParallelism = 4
+
+	const ngroups = 20
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case r.URL.Path == "/apis":
+			var groups []string
+			for i := 0; i < ngroups; i++ {
+				groups = append(groups, fmt.Sprintf(`{"name":"g%d.example.com","versions":[{"groupVersion":"g%d.example.com/v1","version":"v1"}],"preferredVersion":{"groupVersion":"g%d.example.com/v1","version":"v1"}}`, i, i, i))
+			}
+			fmt.Fprintf(w, `{"kind":"APIGroupList","groups":[%s]}`, strings.Join(groups, ","))
+		case r.URL.Path == "/apis/g3.example.com/v1":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			gv := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/apis/"), "/api/")
+			fmt.Fprintf(w, `{"kind":"APIResourceList","groupVersion":%q,"resources":[{"name":"things","kind":"Thing","namespaced":true,"verbs":["get"]}]}`, gv)
+		}
+	}))
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMemcachedDiscoveryClient(disco)
+
+	lists, err := c.ServerResources()
+	if !discovery.IsGroupDiscoveryFailedError(err) {
+		t.Fatalf("Expected group discovery error, got %v", err)
+	}
+	if failed := err.(*discovery.ErrGroupDiscoveryFailed).Groups; len(failed) != 1 {
+		t.Errorf("Unexpected failed groups %v", failed)
+	}
+	// core v1, and all but the broken group
+	if len(lists) != ngroups {
+		t.Errorf("Expected %d resource lists, got %d", ngroups, len(lists))
+	}
+	if maxInFlight < 2 || maxInFlight > DiscoveryParallelism {
+		t.Errorf("Expected between 2 and %d concurrent requests, got %d", DiscoveryParallelism, maxInFlight)
+	}
+
+	// Successful results are cached, failures are retried
+	preferred, err := c.ServerPreferredNamespacedResources()
+	if !discovery.IsGroupDiscoveryFailedError(err) || len(preferred) != ngroups {
+		t.Errorf("Unexpected preferred resources (%d lists): %v", len(preferred), err)
+	}
+	for path, n := range requests {
+		expected := 1
+		if path == "/apis/g3.example.com/v1" {
+			expected = 2
+		}
+		if n != expected {
+			t.Errorf("%s requested %d times, expected %d", path, n, expected)
+		}
+	}
+}
+
+func TestMemcachedDiscoveryTTL(t *testing.T) {
+	fake := &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{{Name: "configmaps", Kind: "ConfigMap"}},
+			},
+		},
+	}
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewMemcachedDiscoveryClientWithTTL(&fakediscovery.FakeDiscovery{Fake: fake}, time.Minute).(*memcachedDiscoveryClient)
+	c.now = func() time.Time { return now }
+
+	fetch := func() {
+		if _, err := c.ServerResourcesForGroupVersion("v1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.SwaggerSchema(schema.GroupVersion{Version: "v1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetch()
+	now = now.Add(30 * time.Second)
+	fetch()
+	if n := len(fake.Actions()); n != 2 {
+		t.Errorf("Expected 2 requests before expiry, got %d", n)
+	}
+	if !c.Fresh() {
+		t.Errorf("Unexpired cache is not fresh")
+	}
+
+	now = now.Add(time.Minute)
+	if c.Fresh() {
+		t.Errorf("Expired cache is still fresh")
+	}
+	fetch()
+	if n := len(fake.Actions()); n != 4 {
+		t.Errorf("Expected expired results to be refetched, got %d requests", n)
+	}
+	if !c.Fresh() {
+		t.Errorf("Refetched cache is not fresh")
+	}
+
+	// Zero ttl never expires
+	fake.ClearActions()
+	c = NewMemcachedDiscoveryClient(&fakediscovery.FakeDiscovery{Fake: fake}).(*memcachedDiscoveryClient)
+	fetch()
+	c.now = func() time.Time { return now.Add(24 * time.Hour) }
+	fetch()
+	if n := len(fake.Actions()); n != 2 || !c.Fresh() {
+		t.Errorf("Cache without ttl expired (%d requests)", n)
+	}
+}
+
+func TestSelectiveInvalidate(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/version":
+			fmt.Fprint(w, `{"major":"1","minor":"7","gitVersion":"v1.7.0"}`)
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[]}`)
+		case "/swagger.json":
+			fmt.Fprint(w, `{"swagger":"2.0","info":{"title":"Kubernetes","version":"v1.7.0"},"paths":{}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tmpdir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCachedDiscoveryClient(disco, tmpdir, "", time.Hour)
+
+	fetchAll := func() {
+		if _, err := c.ServerResources(); err != nil {
+			t.Error(err)
+		}
+		if _, err := c.OpenAPISchema(); err != nil {
+			t.Error(err)
+		}
+		if _, err := c.ServerVersion(); err != nil {
+			t.Error(err)
+		}
+	}
+	check := func(desc string, expected map[string]int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(requests, expected) {
+			t.Errorf("%s: expected requests %v, got %v", desc, expected, requests)
+		}
+		requests = map[string]int{}
+	}
+
+	fetchAll()
+	fetchAll()
+	check("initial fetch", map[string]int{"/version": 1, "/api": 1, "/api/v1": 1, "/apis": 1, "/swagger.json": 1})
+
+	invalidator := c.(SelectiveInvalidator)
+	invalidator.InvalidateResources()
+	fetchAll()
+	check("InvalidateResources", map[string]int{"/api": 1, "/api/v1": 1, "/apis": 1})
+
+	invalidator.InvalidateVersion()
+	fetchAll()
+	check("InvalidateVersion", map[string]int{"/version": 1})
+
+	// The on-disk schema is removed too, so isn't reused for
+	// the (unchanged) server version
+	invalidator.InvalidateSchema()
+	fetchAll()
+	check("InvalidateSchema", map[string]int{"/swagger.json": 1})
+
+	c.Invalidate()
+	fetchAll()
+	check("Invalidate", map[string]int{"/version": 1, "/api": 1, "/api/v1": 1, "/apis": 1, "/swagger.json": 1})
+
+	// Readers see consistent results while parts are invalidated
+	// (run with -race)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			fetchAll()
+		}()
+		go func() {
+			defer wg.Done()
+			invalidator.InvalidateResources()
+			invalidator.InvalidateSchema()
+			invalidator.InvalidateVersion()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPreferredVersionOverride(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[{"name":"networking.k8s.io","versions":[{"groupVersion":"networking.k8s.io/v1","version":"v1"},{"groupVersion":"networking.k8s.io/v1beta1","version":"v1beta1"}],"preferredVersion":{"groupVersion":"networking.k8s.io/v1","version":"v1"}}]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis/networking.k8s.io/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"networking.k8s.io/v1","resources":[{"name":"ingresses","kind":"Ingress","namespaced":true,"verbs":["get"]},{"name":"networkpolicies","kind":"NetworkPolicy","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis/networking.k8s.io/v1beta1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"networking.k8s.io/v1beta1","resources":[{"name":"ingresses","kind":"Ingress","namespaced":true,"verbs":["get"]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resource name -> selected group version
+	selected := func(c discovery.DiscoveryInterface) (map[string]string, error) {
+		lists, err := c.ServerPreferredResources()
+		if err != nil {
+			return nil, err
+		}
+		ret := map[string]string{}
+		for _, l := range lists {
+			for _, r := range l.APIResources {
+				ret[r.Name] = l.GroupVersion
+			}
+		}
+		return ret, nil
+	}
+
+	got, err := selected(NewMemcachedDiscoveryClient(disco))
+	if err != nil || got["ingresses"] != "networking.k8s.io/v1" {
+		t.Errorf("Unexpected default selection %v: %v", got, err)
+	}
+
+	c := NewMemcachedDiscoveryClientWithPreferredVersions(disco, 0, map[string]string{"networking.k8s.io": "v1beta1"})
+	got, err = selected(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"configmaps":      "v1",
+		"ingresses":       "networking.k8s.io/v1beta1",
+		"networkpolicies": "networking.k8s.io/v1",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	for _, versions := range []map[string]string{
+		{"networking.k8s.io": "v2"},
+		{"example.com": "v1"},
+	} {
+		c := NewMemcachedDiscoveryClientWithPreferredVersions(disco, 0, versions)
+		if _, err := c.ServerPreferredResources(); err == nil || !strings.Contains(err.Error(), "Can't select version") {
+			t.Errorf("Expected error for %v, got %v", versions, err)
+		}
+	}
+}
+
+func TestServerResourceForGroupVersionKind(t *testing.T) {
+	disco := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "events", SingularName: "core-event", Kind: "Event", Namespaced: true},
+					// Subresources listed before their parent
+					{Name: "pods/eviction", Kind: "Pod", Namespaced: true},
+					{Name: "pods", Kind: "Pod", Namespaced: true},
+					{Name: "pods/log", Kind: "Pod", Namespaced: true},
+				},
+			},
+			{
+				GroupVersion: "events.k8s.io/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "events", Kind: "Event", Namespaced: true, SingularName: "event"},
+				},
+			},
+			{
+				GroupVersion: "apps/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "deployments/scale", Kind: "Scale", Namespaced: true},
+					{Name: "deployments", Kind: "Deployment", Namespaced: true},
+				},
+			},
+		},
+	}}
+
+	for _, test := range []struct {
+		gvk      schema.GroupVersionKind
+		expected string
+		singular string
+	}{
+		{schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, "pods", ""},
+		{schema.GroupVersionKind{Version: "v1", Kind: "Event"}, "events", "core-event"},
+		{schema.GroupVersionKind{Group: "events.k8s.io", Version: "v1beta1", Kind: "Event"}, "events", "event"},
+		{schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"}, "deployments", ""},
+		// No top-level resource serves Scale
+		{schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Scale"}, "deployments/scale", ""},
+	} {
+		r, err := serverResourceForGroupVersionKind(disco, test.gvk)
+		if err != nil {
+			t.Errorf("%s: %v", test.gvk, err)
+			continue
+		}
+		if r.Name != test.expected || r.SingularName != test.singular {
+			t.Errorf("%s: expected %s (%q), got %s (%q)", test.gvk, test.expected, test.singular, r.Name, r.SingularName)
+		}
+	}
+
+	for _, test := range []struct {
+		gvk         schema.GroupVersionKind
+		subresource string
+		expected    string
+	}{
+		{schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, "log", "pods/log"},
+		{schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"}, "scale", "deployments/scale"},
+	} {
+		r, err := ServerResourceForSubresource(disco, test.gvk, test.subresource)
+		if err != nil {
+			t.Errorf("%s %s: %v", test.gvk, test.subresource, err)
+		} else if r.Name != test.expected {
+			t.Errorf("%s %s: expected %s, got %s", test.gvk, test.subresource, test.expected, r.Name)
+		}
+	}
+
+	if _, err := ServerResourceForSubresource(disco, schema.GroupVersionKind{Version: "v1", Kind: "Event"}, "status"); err == nil {
+		t.Errorf("Missing subresource was found")
+	}
+}
+
+func TestResourceNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/apps/v1beta1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"apps/v1beta1","resources":[{"name":"deployments","kind":"Deployment","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis/broken.example.com/v1":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1beta1", Kind: "Widget"},
+		{Group: "example.com", Version: "v1", Kind: "Widget"},
+	} {
+		_, err := serverResourceForGroupVersionKind(disco, gvk)
+		if !IsResourceNotFound(err) || !errors.Is(err, ErrResourceNotFound) {
+			t.Errorf("%s: expected resource not found, got %v", gvk, err)
+		}
+		var noMatch *NoMatchError
+		if !errors.As(fmt.Errorf("Error applying: %w", err), &noMatch) || noMatch.GroupVersionKind != gvk {
+			t.Errorf("%s: expected wrapped NoMatchError, got %v", gvk, err)
+		}
+	}
+
+	// Transient failures are not "not found"
+	_, err = serverResourceForGroupVersionKind(disco, schema.GroupVersionKind{Group: "broken.example.com", Version: "v1", Kind: "Widget"})
+	if err == nil || IsResourceNotFound(err) {
+		t.Errorf("Expected discovery error, got %v", err)
+	}
+}
+
+func TestClientForResourceContext(t *testing.T) {
+	block := make(chan struct{})
+	aborted := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hang, like an unresponsive API server
+		select {
+		case <-block:
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetName("myobj")
+
+	for _, wrap := range []bool{false, true} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+
+		conf := &rest.Config{Host: srv.URL}
+		if wrap {
+			conf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+				return NewContextTransport(ctx, rt)
+			}
+		}
+		disco, err := discovery.NewDiscoveryClientForConfig(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := dynamic.NewDynamicClientPool(conf)
+
+		start := time.Now()
+		_, err = ClientForResourceContext(ctx, pool, disco, obj, "default")
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected deadline exceeded (wrap=%v), got %v", wrap, err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("Cancelled call took %v", d)
+		}
+
+		if wrap {
+			select {
+			case <-aborted:
+			case <-time.After(5 * time.Second):
+				t.Errorf("In-flight request was not aborted")
+			}
+		}
+
+		// Calls with an expired context fail immediately
+		if _, err := ClientForResourceContext(ctx, pool, disco, obj, "default"); err != context.DeadlineExceeded {
+			t.Errorf("Expected deadline exceeded for expired context, got %v", err)
+		}
+		cancel()
+	}
+}
+
+// preferredDiscovery serves Resources as the preferred resources
+type preferredDiscovery struct {
+	fakediscovery.FakeDiscovery
+}
+
+func (d *preferredDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return d.Resources, nil
+}
+
+func TestResolveResource(t *testing.T) {
+	disco := &preferredDiscovery{fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "pods", SingularName: "pod", Kind: "Pod", ShortNames: []string{"po"}},
+					{Name: "pods/log", Kind: "Pod"},
+					{Name: "services", SingularName: "service", Kind: "Service", ShortNames: []string{"svc"}},
+					{Name: "events", SingularName: "event", Kind: "Event", ShortNames: []string{"ev"}},
+				},
+			},
+			{
+				GroupVersion: "apps/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "deployments", SingularName: "deployment", Kind: "Deployment", ShortNames: []string{"deploy"}},
+				},
+			},
+			{
+				GroupVersion: "extensions/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "deployments", SingularName: "deployment", Kind: "Deployment", ShortNames: []string{"deploy"}},
+					// Old servers don't report singular names
+					{Name: "ingresses", Kind: "Ingress", ShortNames: []string{"ing"}},
+				},
+			},
+			{
+				GroupVersion: "events.k8s.io/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "events", SingularName: "event", Kind: "Event", ShortNames: []string{"ev"}},
+				},
+			},
+			{
+				GroupVersion: "example.com/v1",
+				APIResources: []metav1.APIResource{
+					// Short name clashes with a real resource name
+					{Name: "widgets", SingularName: "widget", Kind: "Widget", ShortNames: []string{"pods"}},
+				},
+			},
+		},
+	}}}
+
+	for _, test := range []struct {
+		input    string
+		expected schema.GroupVersionResource
+	}{
+		{"po", schema.GroupVersionResource{Version: "v1", Resource: "pods"}},
+		{"pod", schema.GroupVersionResource{Version: "v1", Resource: "pods"}},
+		{"pods", schema.GroupVersionResource{Version: "v1", Resource: "pods"}},
+		{"Pod", schema.GroupVersionResource{Version: "v1", Resource: "pods"}},
+		{"svc", schema.GroupVersionResource{Version: "v1", Resource: "services"}},
+		{"ingress", schema.GroupVersionResource{Group: "extensions", Version: "v1beta1", Resource: "ingresses"}},
+		{"deploy.apps", schema.GroupVersionResource{Group: "apps", Version: "v1beta1", Resource: "deployments"}},
+		{"deployments.extensions", schema.GroupVersionResource{Group: "extensions", Version: "v1beta1", Resource: "deployments"}},
+		// Core group is preferred over other groups
+		{"ev", schema.GroupVersionResource{Version: "v1", Resource: "events"}},
+		{"event", schema.GroupVersionResource{Version: "v1", Resource: "events"}},
+		{"events", schema.GroupVersionResource{Version: "v1", Resource: "events"}},
+		{"events.events.k8s.io", schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1beta1", Resource: "events"}},
+		{"widgets.example.com", schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}},
+	} {
+		gvr, err := ResolveResource(disco, test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		if gvr != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, gvr)
+		}
+	}
+
+	for _, test := range []struct {
+		input    string
+		expected string
+	}{
+		{"deploy", `Resource type "deploy" is ambiguous, specify one of: deployments.apps, deployments.extensions`},
+		{"log", `Server does not have a resource type "log"`},
+		{"pods.apps", `Server does not have a resource type "pods.apps"`},
+	} {
+		_, err := ResolveResource(disco, test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected error %q, got %v", test.input, test.expected, err)
+		}
+	}
+}
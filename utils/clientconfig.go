@@ -0,0 +1,174 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"io"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ClientConfigOptions select a kubeconfig context, and override
+// parts of it.  Empty fields are not overridden.
+type ClientConfigOptions struct {
+	// Kubeconfigs are the paths of explicit kubeconfig files,
+	// merged as for a $KUBECONFIG path list.  By default,
+	// $KUBECONFIG or ~/.kube/config is used, falling back to
+	// in-cluster configuration.
+	Kubeconfigs []string
+
+	// Context is the kubeconfig context to use, instead of the
+	// current context
+	Context string
+
+	// Server is the URL of the API server
+	Server string
+
+	// Namespace is the default namespace for objects that don't
+	// specify one
+	Namespace string
+
+	// Token, or ClientCertificate and ClientKey (paths), are the
+	// credentials used to authenticate to the server
+	Token             string
+	ClientCertificate string
+	ClientKey         string
+
+	// CertificateAuthority is the path of a CA bundle used to
+	// verify the server's certificate
+	CertificateAuthority string
+}
+
+// ClientConfig loads client configuration from kubeconfig files, as
+// kubectl does, and applies any overrides.
+type ClientConfig struct {
+	config    clientcmd.ClientConfig
+	overrides *clientcmd.ConfigOverrides
+}
+
+// NewClientConfig creates a (non-interactive) ClientConfig with the
+// given options
+func NewClientConfig(opts ClientConfigOptions) *ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.DefaultClientConfig = &clientcmd.DefaultClientConfig
+	SetKubeconfigs(rules, opts.Kubeconfigs)
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.Context}
+	overrides.ClusterInfo.Server = opts.Server
+	overrides.ClusterInfo.CertificateAuthority = opts.CertificateAuthority
+	overrides.Context.Namespace = opts.Namespace
+	overrides.AuthInfo.Token = opts.Token
+	overrides.AuthInfo.ClientCertificate = opts.ClientCertificate
+	overrides.AuthInfo.ClientKey = opts.ClientKey
+
+	return NewClientConfigFromOverrides(rules, overrides, nil)
+}
+
+// SetKubeconfigs makes rules load the given kubeconfig files instead
+// of $KUBECONFIG or ~/.kube/config.  A single file must exist.
+// Several files are merged as clientcmd merges a $KUBECONFIG path
+// list: the first file to define a context, cluster or user wins, and
+// missing files are skipped.  An empty list leaves rules unchanged.
+func SetKubeconfigs(rules *clientcmd.ClientConfigLoadingRules, paths []string) {
+	switch len(paths) {
+	case 0:
+	case 1:
+		rules.ExplicitPath = paths[0]
+	default:
+		rules.ExplicitPath = ""
+		rules.Precedence = paths
+	}
+}
+
+// NewClientConfigFromOverrides creates a ClientConfig from clientcmd
+// loading rules and overrides, eg: overrides bound to command-line
+// flags with clientcmd.BindOverrideFlags.  Both are only read when
+// the configuration is first used.  If in is non-nil, it is used to
+// prompt for missing credentials.
+func NewClientConfigFromOverrides(rules *clientcmd.ClientConfigLoadingRules, overrides *clientcmd.ConfigOverrides, in io.Reader) *ClientConfig {
+	var config clientcmd.ClientConfig
+	if in != nil {
+		config = clientcmd.NewInteractiveDeferredLoadingClientConfig(rules, overrides, in)
+	} else {
+		config = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	}
+	return &ClientConfig{config: config, overrides: overrides}
+}
+
+// RESTConfig returns the configuration for REST clients
+func (c *ClientConfig) RESTConfig() (*rest.Config, error) {
+	conf, err := c.config.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	c.applyOverrides(conf)
+	return conf, nil
+}
+
+// applyOverrides reapplies overridden server and credentials to
+// conf.  As with DefaultNamespace, clientcmd only applies overrides
+// to fields that are empty in the kubeconfig.
+func (c *ClientConfig) applyOverrides(conf *rest.Config) {
+	cluster, auth := c.overrides.ClusterInfo, c.overrides.AuthInfo
+	if cluster.Server != "" {
+		conf.Host = cluster.Server
+	}
+	if cluster.CertificateAuthority != "" {
+		conf.CAFile = cluster.CertificateAuthority
+		conf.CAData = nil
+	}
+	if auth.Token != "" {
+		conf.BearerToken = auth.Token
+	}
+	if auth.ClientCertificate != "" {
+		conf.CertFile = auth.ClientCertificate
+		conf.CertData = nil
+	}
+	if auth.ClientKey != "" {
+		conf.KeyFile = auth.ClientKey
+		conf.KeyData = nil
+	}
+}
+
+// DefaultNamespace returns the namespace that ClientForResource
+// should use for objects that don't specify one: the overridden
+// namespace, or else the namespace of the selected context, or else
+// "default".
+func (c *ClientConfig) DefaultNamespace() (string, error) {
+	// clientConfig.Namespace() is broken in client-go 3.0:
+	// namespace in config erroneously overrides explicit
+	// --namespace
+	if c.overrides.Context.Namespace != "" {
+		return c.overrides.Context.Namespace, nil
+	}
+	ns, _, err := c.config.Namespace()
+	return ns, err
+}
+
+// Build returns both the REST client configuration and the default
+// namespace
+func (c *ClientConfig) Build() (*rest.Config, string, error) {
+	conf, err := c.RESTConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	ns, err := c.DefaultNamespace()
+	if err != nil {
+		return nil, "", err
+	}
+	return conf, ns, nil
+}
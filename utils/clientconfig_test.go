@@ -0,0 +1,219 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: alice
+  user:
+    token: alice-token
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: alice
+- name: prod
+  context:
+    cluster: prod
+    user: alice
+    namespace: web
+`
+
+func TestClientConfig(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		opts  ClientConfigOptions
+		host  string
+		token string
+		defNs string
+	}{
+		{
+			opts:  ClientConfigOptions{},
+			host:  "https://dev.example.com",
+			token: "alice-token",
+			defNs: "default",
+		},
+		{
+			opts:  ClientConfigOptions{Context: "prod"},
+			host:  "https://prod.example.com",
+			token: "alice-token",
+			defNs: "web",
+		},
+		{
+			// Explicit namespace wins over the context's
+			opts:  ClientConfigOptions{Context: "prod", Namespace: "other"},
+			host:  "https://prod.example.com",
+			token: "alice-token",
+			defNs: "other",
+		},
+		{
+			opts:  ClientConfigOptions{Server: "https://override.example.com", Token: "bob-token"},
+			host:  "https://override.example.com",
+			token: "bob-token",
+			defNs: "default",
+		},
+	} {
+		test.opts.Kubeconfigs = []string{path}
+		conf, ns, err := NewClientConfig(test.opts).Build()
+		if err != nil {
+			t.Errorf("%+v: %v", test.opts, err)
+			continue
+		}
+		if conf.Host != test.host || conf.BearerToken != test.token {
+			t.Errorf("%+v: unexpected host %q and token %q", test.opts, conf.Host, conf.BearerToken)
+		}
+		if ns != test.defNs {
+			t.Errorf("%+v: expected default namespace %q, got %q", test.opts, test.defNs, ns)
+		}
+	}
+
+	_, _, err = NewClientConfig(ClientConfigOptions{Kubeconfigs: []string{path}, Context: "missing"}).Build()
+	if err == nil {
+		t.Errorf("Unknown context did not fail")
+	}
+}
+
+func TestClientConfigMerge(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// Contexts in one file refer to clusters and users in the
+	// other.  The first file to define "dev" wins.
+	first := filepath.Join(tmpdir, "first")
+	if err := ioutil.WriteFile(first, []byte(`apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: alice
+    namespace: web
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(tmpdir, "second")
+	if err := ioutil.WriteFile(second, []byte(`apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+- name: dev
+  cluster:
+    server: https://shadowed.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: alice
+  user:
+    token: alice-token
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: alice
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmpdir, "missing")
+
+	for _, test := range []struct {
+		opts  ClientConfigOptions
+		host  string
+		defNs string
+	}{
+		{
+			opts:  ClientConfigOptions{},
+			host:  "https://dev.example.com",
+			defNs: "default",
+		},
+		{
+			opts:  ClientConfigOptions{Context: "prod"},
+			host:  "https://prod.example.com",
+			defNs: "web",
+		},
+	} {
+		test.opts.Kubeconfigs = []string{first, missing, second}
+		conf, ns, err := NewClientConfig(test.opts).Build()
+		if err != nil {
+			t.Errorf("%+v: %v", test.opts, err)
+			continue
+		}
+		if conf.Host != test.host || conf.BearerToken != "alice-token" {
+			t.Errorf("%+v: unexpected host %q and token %q", test.opts, conf.Host, conf.BearerToken)
+		}
+		if ns != test.defNs {
+			t.Errorf("%+v: expected default namespace %q, got %q", test.opts, test.defNs, ns)
+		}
+	}
+
+	// $KUBECONFIG path lists are merged the same way
+	oldEnv, hadEnv := os.LookupEnv("KUBECONFIG")
+	defer func() {
+		if hadEnv {
+			os.Setenv("KUBECONFIG", oldEnv)
+		} else {
+			os.Unsetenv("KUBECONFIG")
+		}
+	}()
+	os.Setenv("KUBECONFIG", strings.Join([]string{second, first}, string(filepath.ListSeparator)))
+	conf, _, err := NewClientConfig(ClientConfigOptions{}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "https://prod.example.com" {
+		t.Errorf("Unexpected host from $KUBECONFIG: %q", conf.Host)
+	}
+
+	// A single explicit file must exist
+	_, _, err = NewClientConfig(ClientConfigOptions{Kubeconfigs: []string{missing}}).Build()
+	if err == nil {
+		t.Errorf("Missing kubeconfig did not fail")
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+// ClusterConnector returns a discovery client for the target
+// cluster.  It is only called when a template first queries the
+// cluster.
+type ClusterConnector func() (discovery.DiscoveryInterface, error)
+
+// ClientPoolConnector returns a dynamic client pool for the target
+// cluster.  It is only called when a template first reads a live
+// object.
+type ClientPoolConnector func() (dynamic.ClientPool, error)
+
+// ClusterFuncs implements the native functions that query the
+// target cluster.  The server version is fetched at most once, so it
+// is constant for the lifetime of the ClusterFuncs.
+type ClusterFuncs struct {
+	// Connect is nil if the cluster must not be contacted
+	Connect ClusterConnector
+	// Clients is nil if live objects must not be read
+	Clients ClientPoolConnector
+
+	mu        sync.Mutex
+	disco     discovery.DiscoveryInterface
+	pool      dynamic.ClientPool
+	version   *version.Info
+	resources map[string]interface{}
+}
+
+// NewClusterFuncs returns a ClusterFuncs using connect
+func NewClusterFuncs(connect ClusterConnector) *ClusterFuncs {
+	return &ClusterFuncs{Connect: connect}
+}
+
+// connect returns the (cached) discovery client.  f.mu must be
+// held.
+func (f *ClusterFuncs) connect() (discovery.DiscoveryInterface, error) {
+	if f.Connect == nil {
+		return nil, fmt.Errorf("cluster access is disabled")
+	}
+	if f.disco == nil {
+		disco, err := f.Connect()
+		if err != nil {
+			return nil, err
+		}
+		f.disco = disco
+	}
+	return f.disco, nil
+}
+
+// ServerVersion returns the major, minor and gitVersion fields of
+// the server's version.Info
+func (f *ClusterFuncs) ServerVersion() (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.version == nil {
+		disco, err := f.connect()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to fetch server version: %v", err)
+		}
+		info, err := disco.ServerVersion()
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching server version: %v", err)
+		}
+		f.version = info
+	}
+	return map[string]interface{}{
+		"major":      f.version.Major,
+		"minor":      f.version.Minor,
+		"gitVersion": f.version.GitVersion,
+	}, nil
+}
+
+// ResourceExists returns true if the server serves the given kind.
+// Failures other than the kind (or group version) being unknown are
+// returned as errors.
+func (f *ClusterFuncs) ResourceExists(group, version, kind string) (bool, error) {
+	gvk := schema.GroupVersionKind{Group: group, Version: version, Kind: kind}
+
+	f.mu.Lock()
+	disco, err := f.connect()
+	f.mu.Unlock()
+	if err != nil {
+		return false, fmt.Errorf("Unable to look up %s: %v", gvk, err)
+	}
+
+	_, err = serverResourceForGroupVersionKind(disco, gvk)
+	if IsResourceNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Scope values returned by ResourceScope
+const (
+	ScopeNamespaced = "Namespaced"
+	ScopeCluster    = "Cluster"
+)
+
+// ResourceScope returns ScopeNamespaced or ScopeCluster, according
+// to whether objects of the given kind live in a namespace.  It is
+// an error if the server does not serve the kind.
+func (f *ClusterFuncs) ResourceScope(apiVersion, kind string) (string, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return "", err
+	}
+	gvk := gv.WithKind(kind)
+
+	f.mu.Lock()
+	disco, err := f.connect()
+	f.mu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("Unable to look up %s: %v", gvk, err)
+	}
+
+	rsrc, err := serverResourceForGroupVersionKind(disco, gvk)
+	if err != nil {
+		return "", fmt.Errorf("Unable to look up %s: %v", gvk, err)
+	}
+	if rsrc.Namespaced {
+		return ScopeNamespaced, nil
+	}
+	return ScopeCluster, nil
+}
+
+// Discovery returns the server's preferred resources, grouped by
+// API group ("" for the core group).  Each resource is described by
+// its `name`, `kind`, `namespaced` and `verbs`.  The result is fetched
+// at most once.  Without cluster access, the result is empty.
+func (f *ClusterFuncs) Discovery() (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Connect == nil {
+		return map[string]interface{}{}, nil
+	}
+	if f.resources != nil {
+		return f.resources, nil
+	}
+
+	disco, err := f.connect()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch API resources: %v", err)
+	}
+	rsrclists, err := disco.ServerPreferredResources()
+	if err = WarnOnPartialDiscovery(err); err != nil {
+		return nil, fmt.Errorf("Error fetching API resources: %v", err)
+	}
+
+	ret := map[string]interface{}{}
+	for _, rsrclist := range rsrclists {
+		gv, err := schema.ParseGroupVersion(rsrclist.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		group, _ := ret[gv.Group].([]interface{})
+		for _, rsrc := range rsrclist.APIResources {
+			verbs := make([]interface{}, len(rsrc.Verbs))
+			for i, v := range rsrc.Verbs {
+				verbs[i] = v
+			}
+			group = append(group, map[string]interface{}{
+				"name":       rsrc.Name,
+				"kind":       rsrc.Kind,
+				"namespaced": rsrc.Namespaced,
+				"verbs":      verbs,
+			})
+		}
+		ret[gv.Group] = group
+	}
+	f.resources = ret
+	return ret, nil
+}
+
+// Get returns the live object with the given apiVersion, kind,
+// namespace and name, or nil if it does not exist.  namespace is
+// ignored for cluster-scoped kinds.  Objects are always fetched
+// afresh: only discovery information is reused.
+func (f *ClusterFuncs) Get(apiVersion, kind, namespace, name string) (interface{}, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	desc := fmt.Sprintf("%s %s", obj.GroupVersionKind(), name)
+	if namespace != "" {
+		desc = fmt.Sprintf("%s %s/%s", obj.GroupVersionKind(), namespace, name)
+	}
+
+	f.mu.Lock()
+	disco, err := f.connect()
+	if err == nil && f.pool == nil {
+		if f.Clients == nil {
+			err = fmt.Errorf("cluster access is disabled")
+		} else {
+			f.pool, err = f.Clients()
+		}
+	}
+	pool := f.pool
+	f.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch %s: %v", desc, err)
+	}
+
+	rc, err := ClientForResource(pool, disco, obj, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch %s: %v", desc, err)
+	}
+	live, err := rc.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error fetching %s: %v", desc, err)
+	}
+
+	// The dynamic client decodes integers as int64, which jsonnet
+	// native functions can't return
+	buf, err := json.Marshal(live.Object)
+	if err != nil {
+		return nil, err
+	}
+	var ret map[string]interface{}
+	if err := json.Unmarshal(buf, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// RegisterClusterFuncs adds the native jsonnet functions that
+// describe the target cluster to the provided VM
+func RegisterClusterFuncs(vm *jsonnet.VM, funcs *ClusterFuncs) {
+	vm.NativeCallback("kubeServerVersion", []string{}, funcs.ServerVersion)
+	vm.NativeCallback("kubeResourceExists", []string{"group", "version", "kind"}, funcs.ResourceExists)
+	vm.NativeCallback("kubeResourceScope", []string{"apiVersion", "kind"}, funcs.ResourceScope)
+	vm.NativeCallback("kubeDiscovery", []string{}, funcs.Discovery)
+	vm.NativeCallback("kubeGet", []string{"apiVersion", "kind", "namespace", "name"}, funcs.Get)
+}
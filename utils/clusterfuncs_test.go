@@ -0,0 +1,334 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+func TestKubeServerVersion(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major": "1", "minor": "21+", "gitVersion": "v1.21.3-gke.1"}`)
+	}))
+	defer srv.Close()
+
+	connects := 0
+	e := &Evaluator{
+		Cluster: func() (discovery.DiscoveryInterface, error) {
+			connects++
+			return discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+		},
+	}
+
+	objs, _, err := e.EvaluateSnippet("test.jsonnet", `
+    local v = std.native("kubeServerVersion")();
+    local minor = std.parseInt(std.native("kubeServerVersion")().minor[0:2]);
+    {
+      apiVersion: if minor >= 21 then "policy/v1" else "policy/v1beta1",
+      kind: "PodDisruptionBudget",
+      metadata: {name: v.major + "." + v.minor, annotations: {git: v.gitVersion}},
+    }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := objs[0].(*unstructured.Unstructured)
+	if o.GetAPIVersion() != "policy/v1" || o.GetName() != "1.21+" || o.GetAnnotations()["git"] != "v1.21.3-gke.1" {
+		t.Errorf("Unexpected object %v", objs[0])
+	}
+	if fetches != 1 || connects != 1 {
+		t.Errorf("Expected version to be fetched once, got %d fetches (%d connects)", fetches, connects)
+	}
+
+	// Evaluating config that doesn't query the cluster doesn't connect
+	if _, _, err := e.EvaluateSnippet("test.jsonnet", `{}`); err != nil {
+		t.Fatal(err)
+	}
+	if connects != 1 {
+		t.Errorf("Unexpected connection to the cluster")
+	}
+
+	e.Cluster = func() (discovery.DiscoveryInterface, error) {
+		return nil, fmt.Errorf("no kubeconfig")
+	}
+	_, _, err = e.EvaluateSnippet("test.jsonnet", `std.native("kubeServerVersion")()`)
+	if err == nil || !strings.Contains(err.Error(), "Unable to fetch server version: no kubeconfig") {
+		t.Errorf("Unexpected error without a cluster: %v", err)
+	}
+
+	for _, e := range []*Evaluator{{}, {Offline: true, Cluster: e.Cluster}} {
+		_, _, err := e.EvaluateSnippet("test.jsonnet", `std.native("kubeServerVersion")()`)
+		if err == nil || !strings.Contains(err.Error(), "cluster access is disabled") {
+			t.Errorf("Unexpected error without cluster access: %v", err)
+		}
+	}
+}
+
+func TestKubeResourceExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/cert-manager.io/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"cert-manager.io/v1","resources":[{"name":"certificates","kind":"Certificate","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis/broken.example.com/v1":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := &Evaluator{
+		Cluster: func() (discovery.DiscoveryInterface, error) {
+			return discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+		},
+	}
+
+	x, _, err := e.EvaluateSnippet("test.jsonnet", `
+    local exists = std.native("kubeResourceExists");
+    {
+      apiVersion: "v1",
+      kind: "ConfigMap",
+      metadata: {name: "test"},
+      data: {
+        cert: std.toString(exists("cert-manager.io", "v1", "Certificate")),
+        issuer: std.toString(exists("cert-manager.io", "v1", "Widget")),
+        other: std.toString(exists("example.com", "v1", "Widget")),
+      },
+    }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := x[0].(*unstructured.Unstructured).Object["data"]
+	expected := map[string]interface{}{"cert": "true", "issuer": "false", "other": "false"}
+	if fmt.Sprint(data) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, data)
+	}
+
+	_, _, err = e.EvaluateSnippet("test.jsonnet", `std.native("kubeResourceExists")("broken.example.com", "v1", "Widget")`)
+	if err == nil || IsResourceNotFound(err) {
+		t.Errorf("Expected discovery error, got %v", err)
+	}
+
+	e.Offline = true
+	_, _, err = e.EvaluateSnippet("test.jsonnet", `std.native("kubeResourceExists")("cert-manager.io", "v1", "Certificate")`)
+	if err == nil || !strings.Contains(err.Error(), "cluster access is disabled") {
+		t.Errorf("Unexpected error without cluster access: %v", err)
+	}
+}
+
+func TestKubeResourceScope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"namespaces","kind":"Namespace","namespaced":false,"verbs":["get"]},{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["get"]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := &Evaluator{
+		Cluster: func() (discovery.DiscoveryInterface, error) {
+			return discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+		},
+	}
+
+	x, _, err := e.EvaluateSnippet("test.jsonnet", `
+    local scope = std.native("kubeResourceScope");
+    local withNs(o) = o + (if scope(o.apiVersion, o.kind) == "Namespaced" then {metadata+: {namespace: "myns"}} else {});
+    [
+      withNs({apiVersion: "v1", kind: "ConfigMap", metadata: {name: "test"}}),
+      withNs({apiVersion: "v1", kind: "Namespace", metadata: {name: "test"}}),
+    ]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := FlattenToV1(x)
+	if ns := objs[0].GetNamespace(); ns != "myns" {
+		t.Errorf("Namespaced kind was not given a namespace: %q", ns)
+	}
+	if ns := objs[1].GetNamespace(); ns != "" {
+		t.Errorf("Cluster-scoped kind was given namespace %q", ns)
+	}
+
+	_, _, err = e.EvaluateSnippet("test.jsonnet", `std.native("kubeResourceScope")("example.com/v1", "Widget")`)
+	if err == nil || !strings.Contains(err.Error(), "example.com/v1, Kind=Widget") {
+		t.Errorf("Expected an error for an unknown kind, got %v", err)
+	}
+
+	e.Offline = true
+	_, _, err = e.EvaluateSnippet("test.jsonnet", `std.native("kubeResourceScope")("v1", "ConfigMap")`)
+	if err == nil || !strings.Contains(err.Error(), "cluster access is disabled") {
+		t.Errorf("Unexpected error without cluster access: %v", err)
+	}
+}
+
+func TestKubeDiscovery(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"namespaces","kind":"Namespace","namespaced":false,"verbs":["get","list"]}]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[{"name":"apps","versions":[{"groupVersion":"apps/v1","version":"v1"}],"preferredVersion":{"groupVersion":"apps/v1","version":"v1"}}]}`)
+		case "/apis/apps/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"apps/v1","resources":[{"name":"deployments","kind":"Deployment","namespaced":true,"verbs":["create","delete"]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	RegisterClusterFuncs(vm, NewClusterFuncs(func() (discovery.DiscoveryInterface, error) {
+		return discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	}))
+
+	x, err := vm.EvaluateSnippet("test", `
+    local d = std.native("kubeDiscovery");
+    [d()[""], d().apps]`)
+	check(t, err, x, `[
+   [
+      {
+         "kind": "Namespace",
+         "name": "namespaces",
+         "namespaced": false,
+         "verbs": [
+            "get",
+            "list"
+         ]
+      }
+   ],
+   [
+      {
+         "kind": "Deployment",
+         "name": "deployments",
+         "namespaced": true,
+         "verbs": [
+            "create",
+            "delete"
+         ]
+      }
+   ]
+]
+`)
+	if requests["/apis/apps/v1"] != 1 {
+		t.Errorf("Expected resources to be fetched once, got %v", requests)
+	}
+
+	offline := jsonnet.Make()
+	defer offline.Destroy()
+	RegisterClusterFuncs(offline, NewClusterFuncs(nil))
+	x, err = offline.EvaluateSnippet("test", `std.native("kubeDiscovery")()`)
+	check(t, err, x, "{ }\n")
+}
+
+func TestKubeGet(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"configmaps","kind":"ConfigMap","namespaced":true,"verbs":["get"]},{"name":"namespaces","kind":"Namespace","namespaced":false,"verbs":["get"]}]}`)
+		case "/api/v1/namespaces/myns/configmaps/config":
+			fetches++
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config","namespace":"myns","generation":3},"data":{"greeting":"hello"}}`)
+		case "/api/v1/namespaces/myns":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"myns"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer srv.Close()
+
+	e := &Evaluator{
+		Cluster: func() (discovery.DiscoveryInterface, error) {
+			return discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+		},
+		ClientPool: func() (dynamic.ClientPool, error) {
+			return dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL}), nil
+		},
+	}
+
+	src := `
+    local get = std.native("kubeGet");
+    local cm = get("v1", "ConfigMap", "myns", "config");
+    {
+      apiVersion: "v1",
+      kind: "ConfigMap",
+      metadata: {name: "copy"},
+      data: {
+        greeting: cm.data.greeting,
+        generation: std.toString(cm.metadata.generation),
+        ns: get("v1", "Namespace", "", "myns").metadata.name,
+        missing: std.toString(get("v1", "ConfigMap", "myns", "missing")),
+      },
+    }`
+	for i := 0; i < 2; i++ {
+		x, _, err := e.EvaluateSnippet("test.jsonnet", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := x[0].(*unstructured.Unstructured).Object["data"]
+		expected := map[string]interface{}{"greeting": "hello", "generation": "3", "ns": "myns", "missing": "null"}
+		if fmt.Sprint(data) != fmt.Sprint(expected) {
+			t.Errorf("Expected %v, got %v", expected, data)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("Expected object to be fetched on every evaluation, got %d fetches", fetches)
+	}
+
+	_, _, err := e.EvaluateSnippet("test.jsonnet", `std.native("kubeGet")("example.com/v1", "Widget", "myns", "w")`)
+	if err == nil || !strings.Contains(err.Error(), "Unable to fetch example.com/v1, Kind=Widget myns/w") {
+		t.Errorf("Unexpected error for unknown kind: %v", err)
+	}
+
+	e.Offline = true
+	_, _, err = e.EvaluateSnippet("test.jsonnet", `std.native("kubeGet")("v1", "ConfigMap", "myns", "config")`)
+	if err == nil || !strings.Contains(err.Error(), "cluster access is disabled") {
+		t.Errorf("Unexpected error without cluster access: %v", err)
+	}
+}
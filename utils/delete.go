@@ -0,0 +1,55 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+)
+
+// ParsePropagationPolicy parses a deletion propagation policy name:
+// "foreground", "background" or "orphan" (in any case).  The empty
+// string returns an empty policy, which leaves the choice to the
+// server (or to DeleteOptions).
+func ParsePropagationPolicy(s string) (metav1.DeletionPropagation, error) {
+	for _, p := range []metav1.DeletionPropagation{
+		metav1.DeletePropagationForeground,
+		metav1.DeletePropagationBackground,
+		metav1.DeletePropagationOrphan,
+	} {
+		if strings.EqualFold(s, string(p)) {
+			return p, nil
+		}
+	}
+	if s == "" {
+		return "", nil
+	}
+	return "", fmt.Errorf("Unknown propagation policy %q, expected foreground, background or orphan", s)
+}
+
+// DeleteWithPropagation deletes the named object using rc.  A
+// non-empty policy overrides any propagation policy (or legacy
+// OrphanDependents option) in opts.
+func DeleteWithPropagation(rc *dynamic.ResourceClient, name string, opts metav1.DeleteOptions, policy metav1.DeletionPropagation) error {
+	if policy != "" {
+		opts.OrphanDependents = nil
+		opts.PropagationPolicy = &policy
+	}
+	return rc.Delete(name, &opts)
+}
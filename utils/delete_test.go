@@ -0,0 +1,102 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestParsePropagationPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected metav1.DeletionPropagation
+	}{
+		{"", ""},
+		{"foreground", metav1.DeletePropagationForeground},
+		{"Background", metav1.DeletePropagationBackground},
+		{"ORPHAN", metav1.DeletePropagationOrphan},
+	} {
+		p, err := ParsePropagationPolicy(tc.input)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", tc.input, err)
+		} else if p != tc.expected {
+			t.Errorf("Parsed %q as %q, expected %q", tc.input, p, tc.expected)
+		}
+	}
+
+	if _, err := ParsePropagationPolicy("cascade"); err == nil {
+		t.Errorf("Expected error for unknown policy")
+	}
+}
+
+func TestDeleteWithPropagation(t *testing.T) {
+	var got metav1.DeleteOptions
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "DELETE" || r.URL.Path != "/apis/apps/v1beta1/namespaces/default/deployments/myapp" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL)
+		}
+		got = metav1.DeleteOptions{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+	}))
+	defer srv.Close()
+
+	gv := schema.GroupVersion{Group: "apps", Version: "v1beta1"}
+	client, err := dynamic.NewClient(&rest.Config{
+		Host:    srv.URL,
+		APIPath: "/apis",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion: &gv,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := client.Resource(&metav1.APIResource{Name: "deployments", Namespaced: true}, "default")
+
+	orphan := true
+	grace := int64(10)
+	opts := metav1.DeleteOptions{OrphanDependents: &orphan, GracePeriodSeconds: &grace}
+
+	if err := DeleteWithPropagation(rc, "myapp", opts, metav1.DeletePropagationForeground); err != nil {
+		t.Fatal(err)
+	}
+	if got.OrphanDependents != nil || got.PropagationPolicy == nil || *got.PropagationPolicy != metav1.DeletePropagationForeground {
+		t.Errorf("Propagation policy not applied: %+v", got)
+	}
+	if got.GracePeriodSeconds == nil || *got.GracePeriodSeconds != 10 {
+		t.Errorf("Other delete options not preserved: %+v", got)
+	}
+
+	if err := DeleteWithPropagation(rc, "myapp", opts, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got.OrphanDependents == nil || !*got.OrphanDependents || got.PropagationPolicy != nil {
+		t.Errorf("Empty policy changed delete options: %+v", got)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// diskCache stores JSON-encoded discovery results as files under
+// dir.  A nil *diskCache caches nothing.  Errors are logged and
+// otherwise ignored: a cache miss just means a live fetch.
+type diskCache struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+
+	// hit is set once any result has been read from disk
+	mu  sync.Mutex
+	hit bool
+}
+
+func newDiskCache(dir string, ttl time.Duration) *diskCache {
+	return &diskCache{dir: dir, ttl: ttl, now: time.Now}
+}
+
+// read decodes the cached file name into v, and returns true if it
+// exists, is younger than the cache ttl, and is valid.
+func (d *diskCache) read(name string, v interface{}) bool {
+	return d.load(name, v, true)
+}
+
+// readAnyAge is read, except the file may be older than the cache
+// ttl.  The caller must check that the result is still valid.
+func (d *diskCache) readAnyAge(name string, v interface{}) bool {
+	return d.load(name, v, false)
+}
+
+func (d *diskCache) load(name string, v interface{}, checkAge bool) bool {
+	if d == nil {
+		return false
+	}
+	path := filepath.Join(d.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if checkAge && d.now().Sub(info.ModTime()) > d.ttl {
+		log.Debugf("Discovery cache %s is stale", path)
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Debugf("Error reading discovery cache %s: %v", path, err)
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Debugf("Ignoring corrupt discovery cache %s: %v", path, err)
+		return false
+	}
+	d.mu.Lock()
+	d.hit = true
+	d.mu.Unlock()
+	return true
+}
+
+// write stores v as the cached file name.  The file is written
+// atomically, so concurrent readers never see a partial result.
+func (d *diskCache) write(name string, v interface{}) {
+	if d == nil {
+		return
+	}
+	path := filepath.Join(d.dir, name)
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0750)
+	}
+	var tmp *os.File
+	if err == nil {
+		tmp, err = ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	}
+	if err == nil {
+		_, err = tmp.Write(data)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		log.Debugf("Error writing discovery cache %s: %v", path, err)
+	}
+}
+
+// clear removes all cached files
+func (d *diskCache) clear() {
+	if d == nil {
+		return
+	}
+	if err := os.RemoveAll(d.dir); err != nil {
+		log.Debugf("Error removing discovery cache %s: %v", d.dir, err)
+	}
+	d.mu.Lock()
+	d.hit = false
+	d.mu.Unlock()
+}
+
+// remove removes the named cached files (or directories)
+func (d *diskCache) remove(names ...string) {
+	if d == nil {
+		return
+	}
+	for _, name := range names {
+		path := filepath.Join(d.dir, name)
+		if err := os.RemoveAll(path); err != nil {
+			log.Debugf("Error removing discovery cache %s: %v", path, err)
+		}
+	}
+}
+
+// used returns true if any result was read from disk
+func (d *diskCache) used() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hit
+}
+
+var unsafeCacheChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// serverCacheKey returns a directory name identifying the server
+// that cl talks to, and the user (see CacheUser) it talks as
+func serverCacheKey(cl discovery.DiscoveryInterface, user string) string {
+	key := "default"
+	if rc := cl.RESTClient(); rc != nil {
+		if u := rc.Get().URL(); u != nil {
+			key = u.Host + u.Path
+		}
+	}
+	key = unsafeCacheChars.ReplaceAllString(key, "_")
+	if user != "" {
+		key += "-" + user
+	}
+	return key
+}
+
+// CacheUser returns an opaque string identifying the credentials and
+// impersonation in conf.  Discovery results depend on RBAC, so
+// different users must not share a disk cache.
+func CacheUser(conf *rest.Config) string {
+	h := sha256.New()
+	write := func(s ...string) {
+		for _, v := range s {
+			h.Write([]byte(v))
+			h.Write([]byte{0})
+		}
+	}
+	write(conf.Username, conf.BearerToken, conf.CertFile, string(conf.CertData))
+	if conf.AuthProvider != nil {
+		write(conf.AuthProvider.Name)
+		keys := make([]string, 0, len(conf.AuthProvider.Config))
+		for k := range conf.AuthProvider.Config {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			write(k, conf.AuthProvider.Config[k])
+		}
+	}
+	imp := conf.Impersonate
+	write(imp.UserName, strings.Join(imp.Groups, ","))
+	extra := make([]string, 0, len(imp.Extra))
+	for k := range imp.Extra {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+	for _, k := range extra {
+		write(k, strings.Join(imp.Extra[k], ","))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,238 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+// NativeFunc is an additional native function, made available to
+// jsonnet as std.native(Name).  Func is passed to
+// jsonnet.VM.NativeCallback.
+type NativeFunc struct {
+	Name   string
+	Params []string
+	Func   interface{}
+}
+
+// Evaluator evaluates configuration with the same jsonnet
+// environment (importer, native functions, ext and top-level vars) as
+// the kubecfg command line.  The zero value is usable.
+type Evaluator struct {
+	// SearchPaths are additional jsonnet library search paths.
+	// Later entries take precedence.
+	SearchPaths []string
+
+	// MaxImportDepth limits the jsonnet stack, and so the length
+	// of import chains.  Exceeding it reports the chain of files,
+	// which is usually an import cycle.  Zero means
+	// DefaultMaxImportDepth.
+	MaxImportDepth int
+
+	// ExtCode is set before ExtVars, so a string ext var overrides
+	// code of the same name.
+	ExtCode map[string]string
+	ExtVars map[string]string
+	TlaVars map[string]string
+
+	// Resolver implements resolveImage.  nil means images are not
+	// resolved.
+	Resolver Resolver
+
+	// Fetcher implements importManifest.  nil means a fetcher
+	// using http.DefaultClient.
+	Fetcher *ManifestFetcher
+
+	// SecretBackend implements externalSecret.  nil means
+	// externalSecret always fails.
+	SecretBackend SecretBackend
+
+	// RedactSecrets makes externalSecret return a placeholder
+	// (see RedactedSecret) rather than the fetched value.
+	RedactSecrets bool
+
+	// Cluster implements kubeServerVersion and
+	// kubeResourceExists.  nil means they always fail.
+	Cluster ClusterConnector
+
+	// ClientPool (with Cluster) implements kubeGet.  nil means
+	// kubeGet always fails.
+	ClientPool ClientPoolConnector
+
+	// NativeFuncs are registered after the built-in native
+	// functions, and may replace them.
+	NativeFuncs []NativeFunc
+
+	// Offline disables every native function that would access
+	// the network.
+	Offline bool
+
+	// AllowRemoteInputs permits LoadObjects to read http(s) URLs,
+	// using Fetcher.  Otherwise, URLs are refused.
+	AllowRemoteInputs bool
+
+	// Stdin is read by LoadObjects for the path "-", in
+	// StdinFormat (see EvaluateReader), with jsonnet imports
+	// relative to StdinBase.  nil means os.Stdin.
+	Stdin       io.Reader
+	StdinFormat string
+	StdinBase   string
+
+	// Tracer records a span for each input evaluated by
+	// LoadObjects.  nil records nothing.
+	Tracer *Tracer
+}
+
+// EvaluateFile reads the objects in path, which may be jsonnet,
+// JSON or YAML.  It also returns the files read, including path and
+// any (non built-in) jsonnet imports.
+func (e *Evaluator) EvaluateFile(path string) ([]runtime.Object, []string, error) {
+	vm, importer := e.newVM(filepath.Dir(path))
+	defer vm.Destroy()
+
+	objs, err := Read(vm, path)
+	if err != nil {
+		return nil, nil, importDepthError(err, e.maxImportDepth())
+	}
+	return objs, append([]string{path}, importer.Imported()...), nil
+}
+
+// EvaluateSnippet evaluates the jsonnet src, as if read from the
+// file name.  It also returns the jsonnet files imported.
+func (e *Evaluator) EvaluateSnippet(name, src string) ([]runtime.Object, []string, error) {
+	vm, importer := e.newVM(filepath.Dir(name))
+	defer vm.Destroy()
+
+	jsonstr, err := vm.EvaluateSnippet(name, src)
+	if err != nil {
+		return nil, nil, importDepthError(err, e.maxImportDepth())
+	}
+	objs, err := jsonnetObjects(jsonstr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return objs, importer.Imported(), nil
+}
+
+// EvaluateReader reads the objects in r, which is in the given
+// format (one of FormatJsonnet, FormatJSON or FormatYAML), or
+// guessed by SniffFormat if format is empty.  jsonnet imports are
+// resolved relative to the directory base, or the current directory
+// if base is empty.  It also returns the jsonnet files imported.
+func (e *Evaluator) EvaluateReader(r io.Reader, format, base string) ([]runtime.Object, []string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == "" {
+		format = SniffFormat(data)
+		log.Debugf("Guessed input format %s", format)
+	}
+	switch format {
+	case FormatJsonnet:
+		return e.EvaluateSnippet(filepath.Join(base, "<stdin>"), string(data))
+	case FormatJSON, FormatYAML:
+		objs, err := ReadFormat(bytes.NewReader(data), format)
+		return objs, nil, err
+	}
+	return nil, nil, fmt.Errorf("Unknown input format %q, expected one of %s, %s or %s", format, FormatJsonnet, FormatJSON, FormatYAML)
+}
+
+// fetcher returns the ManifestFetcher used for importManifest and
+// remote inputs
+func (e *Evaluator) fetcher() *ManifestFetcher {
+	fetcher := e.Fetcher
+	if fetcher == nil {
+		fetcher = NewManifestFetcher(http.DefaultClient)
+	}
+	if e.Offline {
+		offline := NewManifestFetcher(&http.Client{Transport: offlineTransport{}})
+		offline.AllowUnpinned = fetcher.AllowUnpinned
+		fetcher = offline
+	}
+	return fetcher
+}
+
+func (e *Evaluator) maxImportDepth() int {
+	if e.MaxImportDepth > 0 {
+		return e.MaxImportDepth
+	}
+	return DefaultMaxImportDepth
+}
+
+// newVM constructs a jsonnet VM for evaluating a file in dir.  The
+// caller must Destroy it.
+func (e *Evaluator) newVM(dir string) (*jsonnet.VM, *Importer) {
+	vm := jsonnet.Make()
+	vm.MaxStack(uint(e.maxImportDepth()))
+
+	importer := &Importer{SearchPaths: e.SearchPaths}
+	vm.ImportCallback(importer.Import)
+
+	for k, v := range e.ExtCode {
+		vm.ExtCode(k, v)
+	}
+	for k, v := range e.ExtVars {
+		vm.ExtVar(k, v)
+	}
+	for k, v := range e.TlaVars {
+		vm.TlaVar(k, v)
+	}
+
+	resolver, fetcher, secrets, cluster, pool := e.Resolver, e.fetcher(), e.SecretBackend, e.Cluster, e.ClientPool
+	if resolver == nil || e.Offline {
+		resolver = NewIdentityResolver()
+	}
+	if e.Offline {
+		secrets = nil
+		cluster = nil
+		pool = nil
+	}
+
+	RegisterNativeFuncs(vm, resolver)
+	RegisterRemoteFuncs(vm, fetcher)
+	secretFetcher := NewSecretFetcher(secrets)
+	secretFetcher.Redact = e.RedactSecrets
+	RegisterSecretFuncs(vm, secretFetcher)
+	clusterFuncs := NewClusterFuncs(cluster)
+	clusterFuncs.Clients = pool
+	RegisterClusterFuncs(vm, clusterFuncs)
+	// importDir may not read outside the top-level file's directory
+	RegisterDirFuncs(vm, NewDirImporter(dir))
+
+	for _, f := range e.NativeFuncs {
+		vm.NativeCallback(f.Name, f.Params, f.Func)
+	}
+
+	return vm, importer
+}
+
+// offlineTransport fails every request
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("network access to %s disabled in offline mode", req.URL.Host)
+}
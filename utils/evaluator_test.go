@@ -0,0 +1,227 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+const evaluatorTestMain = `
+local kubecfg = import "kubecfg.libsonnet";
+local lib = import "lib.libsonnet";
+function(replicas) [
+  lib.configMap(std.extVar("name")),
+  {
+    apiVersion: "v1",
+    kind: "ReplicationController",
+    metadata: {name: std.native("greet")("web")},
+    spec: {replicas: std.parseInt(replicas), image: kubecfg.resolveImage("nginx")},
+  },
+]
+`
+
+func TestEvaluator(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "evaluator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	main := filepath.Join(tmpdir, "main.jsonnet")
+	lib := filepath.Join(tmpdir, "lib.libsonnet")
+	if err := ioutil.WriteFile(main, []byte(evaluatorTestMain), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(lib, []byte(`{configMap(name):: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: name}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	greet := func(s string) (string, error) { return "hello-" + s, nil }
+
+	e := &Evaluator{
+		ExtVars:     map[string]string{"name": "myconfig"},
+		TlaVars:     map[string]string{"replicas": "3"},
+		NativeFuncs: []NativeFunc{{Name: "greet", Params: []string{"s"}, Func: greet}},
+	}
+	objs, files, err := e.EvaluateFile(main)
+	if err != nil {
+		t.Fatalf("EvaluateFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{main, lib}) {
+		t.Errorf("Unexpected files %v", files)
+	}
+
+	// Same input, using a hand-assembled VM
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	vm.ImportCallback((&Importer{}).Import)
+	vm.ExtVar("name", "myconfig")
+	vm.TlaVar("replicas", "3")
+	RegisterNativeFuncs(vm, NewIdentityResolver())
+	RegisterRemoteFuncs(vm, NewManifestFetcher(http.DefaultClient))
+	vm.NativeCallback("greet", []string{"s"}, greet)
+	expected, err := Read(vm, main)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(objs, expected) {
+		t.Errorf("Evaluator produced %v, expected %v", objs, expected)
+	}
+	if len(objs) != 2 {
+		t.Errorf("Expected 2 objects, got %d", len(objs))
+	}
+
+	snippetObjs, files, err := e.EvaluateSnippet(main, evaluatorTestMain)
+	if err != nil {
+		t.Fatalf("EvaluateSnippet failed: %v", err)
+	}
+	if !reflect.DeepEqual(snippetObjs, expected) {
+		t.Errorf("EvaluateSnippet produced %v, expected %v", snippetObjs, expected)
+	}
+	if !reflect.DeepEqual(files, []string{lib}) {
+		t.Errorf("Unexpected snippet imports %v", files)
+	}
+}
+
+func TestEvaluatorOffline(t *testing.T) {
+	e := &Evaluator{Offline: true}
+	_, _, err := e.EvaluateSnippet("test.jsonnet", `std.native("importManifest")("https://example.com/x.yaml", "0123")`)
+	if err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Errorf("Unexpected error from importManifest in offline mode: %v", err)
+	}
+}
+
+func TestEvaluateReader(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "evaluator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "lib.libsonnet"), []byte(`{name: "from-lib"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := map[string]string{
+		FormatJSON:    `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "from-lib"}}`,
+		FormatYAML:    "# comment\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: from-lib\n",
+		FormatJsonnet: `local lib = import "lib.libsonnet"; {apiVersion: "v1", kind: "ConfigMap", metadata: {name: lib.name}}`,
+	}
+
+	e := Evaluator{}
+	for format, input := range inputs {
+		if sniffed := SniffFormat([]byte(input)); sniffed != format {
+			t.Errorf("Sniffed %s input as %s", format, sniffed)
+		}
+
+		for _, f := range []string{format, ""} {
+			objs, _, err := e.EvaluateReader(strings.NewReader(input), f, tmpdir)
+			if err != nil {
+				t.Errorf("Error reading %s (format %q): %v", format, f, err)
+				continue
+			}
+			if len(objs) != 1 {
+				t.Errorf("Expected 1 object from %s, got %d", format, len(objs))
+				continue
+			}
+			if name := objs[0].(*unstructured.Unstructured).GetName(); name != "from-lib" {
+				t.Errorf("Unexpected name %q from %s", name, format)
+			}
+		}
+	}
+
+	// Imports are relative to the current directory by default
+	_, _, err = e.EvaluateReader(strings.NewReader(inputs[FormatJsonnet]), FormatJsonnet, "")
+	if err == nil {
+		t.Errorf("Import resolved without an import base")
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.EvaluateReader(strings.NewReader(inputs[FormatJsonnet]), FormatJsonnet, ""); err != nil {
+		t.Errorf("Import relative to the current directory failed: %v", err)
+	}
+
+	if _, _, err := e.EvaluateReader(strings.NewReader("{}"), "toml", ""); err == nil {
+		t.Errorf("Unknown input format was accepted")
+	}
+}
+
+func TestEvaluatorMaxImportDepth(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "evaluator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// a0 -> a1 -> ... -> a10, and c0 -> c1 -> c0
+	files := map[string]string{
+		"a10.jsonnet": `[]`,
+		"c0.jsonnet":  `import "c1.jsonnet"`,
+		"c1.jsonnet":  `{x: (import "c0.jsonnet").x}`,
+	}
+	for n := 0; n < 10; n++ {
+		files[fmt.Sprintf("a%d.jsonnet", n)] = fmt.Sprintf(`import "a%d.jsonnet"`, n+1)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(names ...string) string {
+		for i, n := range names {
+			if n != "..." {
+				names[i] = filepath.Join(tmpdir, n)
+			}
+		}
+		return strings.Join(names, " -> ")
+	}
+
+	e := Evaluator{MaxImportDepth: 20}
+	if _, _, err := e.EvaluateFile(filepath.Join(tmpdir, "a0.jsonnet")); err != nil {
+		t.Errorf("Import chain within the limit failed: %v", err)
+	}
+
+	e.MaxImportDepth = 5
+	_, _, err = e.EvaluateFile(filepath.Join(tmpdir, "a0.jsonnet"))
+	expected := "import depth exceeded (max 5), possible cycle: " + path("a0.jsonnet", "a1.jsonnet", "a2.jsonnet")
+	if err == nil || !strings.HasPrefix(err.Error(), expected+"\n") {
+		t.Errorf("Expected error naming the import chain %q, got %v", expected, err)
+	}
+
+	e.MaxImportDepth = 0
+	_, _, err = e.EvaluateFile(filepath.Join(tmpdir, "c0.jsonnet"))
+	expected = "import depth exceeded (max 500), possible cycle: " + path("c1.jsonnet", "...", "c1.jsonnet")
+	if err == nil || !strings.HasPrefix(err.Error(), expected+"\n") {
+		t.Errorf("Expected error naming the import cycle %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+//go:build ignore
+// +build ignore
+
+// Generates zz_embedded.go from ../lib/*.libsonnet.  Run via `go generate`.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+)
+
+func main() {
+	files, err := filepath.Glob(filepath.Join("..", "lib", "*.libsonnet"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString("// Code generated by gen_embedded.go. DO NOT EDIT.\n\n")
+	buf.WriteString("package utils\n\n")
+	buf.WriteString("var embeddedLib = map[string]string{\n")
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(&buf, "%s: %s,\n", strconv.Quote(filepath.Base(f)), strconv.Quote(string(data)))
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("zz_embedded.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+// DirImporter implements the importDir native function.  Globs are
+// relative to the importing file, and may not match files outside
+// Root.
+type DirImporter struct {
+	Root string
+}
+
+// NewDirImporter returns a DirImporter for the project rooted at
+// root
+func NewDirImporter(root string) *DirImporter {
+	return &DirImporter{Root: root}
+}
+
+// ImportDir parses every file matching pattern, relative to the
+// directory of the jsonnet file from (ie: its std.thisFile), as a
+// YAML (or JSON) stream, and returns the resulting objects as a
+// single array.  Files are read in lexicographic order.
+func (d *DirImporter) ImportDir(pattern, from string) ([]interface{}, error) {
+	if filepath.IsAbs(pattern) {
+		return nil, fmt.Errorf("importDir pattern %q must be relative", pattern)
+	}
+	if from == "" {
+		return nil, fmt.Errorf("importDir of %q needs the importing file, eg: std.thisFile", pattern)
+	}
+
+	root, err := filepath.EvalSymlinks(d.Root)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(from), pattern))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	ret := []interface{}{}
+	for _, m := range matches {
+		resolved, err := filepath.EvalSymlinks(m)
+		if err != nil {
+			return nil, err
+		}
+		resolved, err = filepath.Abs(resolved)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("importDir: %s is outside the project root %s", m, d.Root)
+		}
+
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		log.Debugf("importDir reading %s", m)
+		data, err := ioutil.ReadFile(resolved)
+		if err != nil {
+			return nil, err
+		}
+		docs, err := parseYAMLStream(data, m)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, docs...)
+	}
+	return ret, nil
+}
+
+// RegisterDirFuncs adds the native jsonnet functions that read local
+// directories to the provided VM
+func RegisterDirFuncs(vm *jsonnet.VM, importer *DirImporter) {
+	vm.NativeCallback("importDir", []string{"glob", "from"}, importer.ImportDir)
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+func TestImportDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "importdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	files := map[string]string{
+		"manifests/b.json": `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "b"}}`,
+		"manifests/a.yaml": `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a2
+`,
+		"outside.yaml": `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "outside"}}`,
+		"project/main.jsonnet": `
+std.native("importDir")("../manifests/*", std.thisFile)`,
+		"lib/nested.libsonnet": `
+std.native("importDir")("../manifests/a.*", std.thisFile)`,
+		"main.jsonnet": `
+std.native("importDir")("manifests/*", std.thisFile) + [
+  {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "generated"}},
+] + import "lib/nested.libsonnet"`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpdir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	RegisterDirFuncs(vm, NewDirImporter(tmpdir))
+
+	objs, err := Read(vm, filepath.Join(tmpdir, "main.jsonnet"))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	names := []string{}
+	for _, o := range objs {
+		m, err := meta.Accessor(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, m.GetName())
+	}
+	// The nested glob is relative to lib/, not the top-level file
+	expected := []string{"a1", "a2", "b", "generated", "a1", "a2"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	RegisterDirFuncs(vm, NewDirImporter(filepath.Join(tmpdir, "project")))
+	if _, err := Read(vm, filepath.Join(tmpdir, "project", "main.jsonnet")); err == nil {
+		t.Errorf("importDir outside the project root succeeded")
+	}
+}
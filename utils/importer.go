@@ -0,0 +1,147 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+//go:generate go run gen_embedded.go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// embeddedDir is the pseudo-directory reported as the location of
+// libraries built into kubecfg.
+const embeddedDir = "<kubecfg>"
+
+// Importer resolves jsonnet imports.  Imports are searched for
+// relative to the importing file, then in SearchPaths (later entries
+// take precedence, as with `jsonnet -J`), and finally in the
+// libraries built into kubecfg (see `lib/`).
+type Importer struct {
+	SearchPaths []string
+
+	// imported is the set of local files resolved so far
+	imported map[string]bool
+}
+
+// Imported returns the local files imported so far, in sorted
+// order.  Built-in libraries are not included.
+func (i *Importer) Imported() []string {
+	ret := make([]string, 0, len(i.imported))
+	for f := range i.imported {
+		ret = append(ret, f)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Import implements jsonnet.ImportCallback
+func (i *Importer) Import(base, rel string) (string, string, error) {
+	contents, foundHere, err := i.find(base, rel)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !strings.HasPrefix(foundHere, embeddedDir) {
+		if i.imported == nil {
+			i.imported = map[string]bool{}
+		}
+		i.imported[foundHere] = true
+	}
+
+	return contents, foundHere, nil
+}
+
+func (i *Importer) find(base, rel string) (string, string, error) {
+	if filepath.IsAbs(rel) {
+		return i.tryPath("", rel)
+	}
+
+	dirs := make([]string, 0, len(i.SearchPaths)+1)
+	if base != embeddedDir {
+		dirs = append(dirs, base)
+	}
+	for j := len(i.SearchPaths) - 1; j >= 0; j-- {
+		dirs = append(dirs, i.SearchPaths[j])
+	}
+
+	for _, dir := range dirs {
+		contents, foundHere, err := i.tryPath(dir, rel)
+		if os.IsNotExist(err) {
+			continue
+		}
+		return contents, foundHere, err
+	}
+
+	if contents, ok := embeddedLib[rel]; ok {
+		log.Debugf("Using builtin %s", rel)
+		return contents, path.Join(embeddedDir, rel), nil
+	}
+
+	return "", "", fmt.Errorf("couldn't open import %q: no match locally or in library search paths", rel)
+}
+
+func (i *Importer) tryPath(dir, rel string) (string, string, error) {
+	foundHere := filepath.Join(dir, rel)
+	data, err := ioutil.ReadFile(foundHere)
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), foundHere, nil
+}
+
+// DefaultMaxImportDepth is libjsonnet's default stack limit
+const DefaultMaxImportDepth = 500
+
+// traceFile matches the file name in a line of a jsonnet stack
+// trace, eg "\tfoo.jsonnet:1:1-19\tthunk <import>"
+var traceFile = regexp.MustCompile(`^\t(.+?):\(?\d+:\d+`)
+
+// importDepthError turns libjsonnet's "Max stack frames exceeded"
+// error into one naming the chain of files in its stack trace,
+// outermost first.  Each import in a chain costs a stack frame, so
+// the stack limit (max) is also the import depth limit.  Other
+// errors are returned unchanged.
+func importDepthError(err error, max int) error {
+	if err == nil || !strings.Contains(err.Error(), "Max stack frames exceeded") {
+		return err
+	}
+	lines := strings.Split(err.Error(), "\n")
+	var chain []string
+	for j := len(lines) - 1; j >= 0; j-- {
+		name := "..."
+		if strings.TrimSpace(lines[j]) != "..." {
+			m := traceFile.FindStringSubmatch(lines[j])
+			if m == nil {
+				continue
+			}
+			name = m[1]
+		}
+		if len(chain) > 0 && chain[len(chain)-1] == name {
+			continue
+		}
+		chain = append(chain, name)
+	}
+	return fmt.Errorf("import depth exceeded (max %d), possible cycle: %s\n%v", max, strings.Join(chain, " -> "), err)
+}
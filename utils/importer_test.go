@@ -0,0 +1,137 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+func TestEmbeddedLibUpToDate(t *testing.T) {
+	files, err := filepath.Glob(filepath.FromSlash("../lib/*.libsonnet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(embeddedLib) {
+		t.Errorf("Embedded library is stale, run `go generate`")
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if embeddedLib[filepath.Base(f)] != string(data) {
+			t.Errorf("Embedded %s is stale, run `go generate`", filepath.Base(f))
+		}
+	}
+}
+
+func TestEmbeddedImport(t *testing.T) {
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	RegisterNativeFuncs(vm, NewIdentityResolver())
+	importer := Importer{}
+	vm.ImportCallback(importer.Import)
+
+	x, err := vm.EvaluateSnippet("test", `
+    local kubecfg = import "kubecfg.libsonnet";
+    kubecfg.deepMerge({a: {b: 1, c: 2}}, {a: {c: 3}}).a.c`)
+	check(t, err, x, "3\n")
+
+	x, err = vm.EvaluateSnippet("test", `
+    local kubecfg = import "kubecfg.libsonnet";
+    kubecfg.labelSet("foo", version="v1")["app.kubernetes.io/version"]`)
+	check(t, err, x, "\"v1\"\n")
+
+	x, err = vm.EvaluateSnippet("test", `
+    local kubecfg = import "kubecfg.libsonnet";
+    kubecfg.regexMatch("o$", "foo")`)
+	check(t, err, x, "true\n")
+
+	_, err = vm.EvaluateSnippet("failtest", `import "nonexistent.libsonnet"`)
+	if err == nil {
+		t.Errorf("import of nonexistent file succeeded")
+	}
+}
+
+func TestEmbeddedImportShadowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubecfg.libsonnet"), []byte(`{shadowed: true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	importer := Importer{SearchPaths: []string{dir}}
+	vm.ImportCallback(importer.Import)
+
+	x, err := vm.EvaluateSnippet("test", `(import "kubecfg.libsonnet").shadowed`)
+	check(t, err, x, "true\n")
+}
+
+func TestImportMany(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// main imports 120 siblings, and a chain of 5 more
+	var imports []string
+	for n := 0; n < 120; n++ {
+		name := fmt.Sprintf("s%d.jsonnet", n)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		imports = append(imports, fmt.Sprintf("(import %q)", name))
+	}
+	for n := 0; n < 5; n++ {
+		data := fmt.Sprintf(`1 + import "a%d.jsonnet"`, n+1)
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("a%d.jsonnet", n)), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a5.jsonnet"), []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	imports = append(imports, `(import "a0.jsonnet")`)
+	main := filepath.Join(dir, "main.jsonnet")
+	if err := ioutil.WriteFile(main, []byte(strings.Join(imports, " + ")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	var importer Importer
+	vm.ImportCallback(importer.Import)
+	x, err := vm.EvaluateFile(main)
+	check(t, err, x, "125\n")
+	if n := len(importer.Imported()); n != 126 {
+		t.Errorf("Expected 126 imported files, got %d", n)
+	}
+
+}
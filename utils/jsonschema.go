@@ -0,0 +1,89 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/xeipuuv/gojsonschema"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// JSONSchemaRule validates objects against a user-supplied JSON
+// Schema
+type JSONSchemaRule struct {
+	// Group and Kind restrict the rule to matching objects.
+	// Empty values match anything.
+	Group string
+	Kind  string
+	Path  string
+
+	schema *gojsonschema.Schema
+}
+
+// ParseJSONSchemaRule parses a rule of the form
+// `[[group/]Kind=]path`, and loads the (JSON or YAML) schema from
+// path.
+func ParseJSONSchemaRule(s string) (*JSONSchemaRule, error) {
+	r := JSONSchemaRule{Path: s}
+	if kv := strings.SplitN(s, "=", 2); len(kv) == 2 {
+		r.Path = kv[1]
+		r.Kind = kv[0]
+		if i := strings.LastIndex(kv[0], "/"); i >= 0 {
+			r.Group, r.Kind = kv[0][:i], kv[0][i+1:]
+		}
+	}
+
+	data, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", r.Path, err)
+	}
+	r.schema, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Error loading JSON schema %s: %v", r.Path, err)
+	}
+
+	return &r, nil
+}
+
+// Matches returns true if this rule applies to objects of kind gvk
+func (r *JSONSchemaRule) Matches(gvk schema.GroupVersionKind) bool {
+	return (r.Kind == "" || r.Kind == gvk.Kind) &&
+		(r.Group == "" || r.Group == gvk.Group)
+}
+
+// Validate returns a list of schema violations, each prefixed with
+// the path of the failing field.
+func (r *JSONSchemaRule) Validate(obj *unstructured.Unstructured) []error {
+	result, err := r.schema.Validate(gojsonschema.NewGoLoader(obj.Object))
+	if err != nil {
+		return []error{err}
+	}
+
+	var allErrs []error
+	for _, e := range result.Errors() {
+		allErrs = append(allErrs, fmt.Errorf("%s: %s (%s)", e.Field(), e.Description(), r.Path))
+	}
+	return allErrs
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Every container must set resource limits
+const limitsSchema = `
+type: object
+properties:
+  spec:
+    type: object
+    properties:
+      template:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              containers:
+                type: array
+                items:
+                  type: object
+                  required: [resources]
+                  properties:
+                    resources:
+                      type: object
+                      required: [limits]
+`
+
+func deployment(t *testing.T, container string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	data := `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {"name": "myapp"},
+  "spec": {"template": {"spec": {"containers": [` + container + `]}}}
+}`
+	if err := json.Unmarshal([]byte(data), &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestJSONSchemaRule(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "jsonschema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "limits.yaml")
+	if err := ioutil.WriteFile(path, []byte(limitsSchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := ParseJSONSchemaRule("apps/Deployment=" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Group != "apps" || rule.Kind != "Deployment" || rule.Path != path {
+		t.Errorf("Unexpected rule %#v", rule)
+	}
+
+	good := deployment(t, `{"name": "app", "resources": {"limits": {"cpu": "1"}}}`)
+	if !rule.Matches(good.GroupVersionKind()) {
+		t.Errorf("Rule did not match Deployment")
+	}
+	if errs := rule.Validate(good); len(errs) != 0 {
+		t.Errorf("Compliant Deployment failed validation: %v", errs)
+	}
+
+	bad := deployment(t, `{"name": "app", "resources": {"requests": {"cpu": "1"}}}`)
+	errs := rule.Validate(bad)
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "spec.template.spec.containers.0.resources: ") {
+		t.Errorf("Error did not include failing path: %v", errs[0])
+	}
+
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	if rule.Matches(cm.GroupVersionKind()) {
+		t.Errorf("Rule matched ConfigMap")
+	}
+
+	rule, err = ParseJSONSchemaRule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Matches(cm.GroupVersionKind()) {
+		t.Errorf("Unrestricted rule did not match ConfigMap")
+	}
+
+	if _, err := ParseJSONSchemaRule(filepath.Join(tmpdir, "missing.json")); err == nil {
+		t.Errorf("Missing schema file was accepted")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// LoadObjects evaluates each of paths ("-" is e.Stdin, and http(s)
+// URLs are fetched if e.AllowRemoteInputs), and returns the combined
+// objects, in kind order (see sortForApply).  Lists are flattened.
+// If several inputs define the same object (by group, kind, namespace
+// and name), the last definition wins, in place of the first, and a
+// warning is logged.
+func (e *Evaluator) LoadObjects(paths []string) ([]*unstructured.Unstructured, error) {
+	var res []*unstructured.Unstructured
+	sources := []string{}
+	index := map[string]int{} // objectKey -> index into res
+	for _, path := range paths {
+		objs, err := e.evaluatePath(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %s: %v", path, err)
+		}
+		for _, o := range FlattenToV1(objs) {
+			key := objectKey(o)
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(res)
+				res = append(res, o)
+				sources = append(sources, path)
+				continue
+			}
+			log.Warningf("%s is defined in both %s and %s, using the latter", describeObject(o), sources[i], path)
+			res[i], sources[i] = o, path
+		}
+	}
+	return sortForApply(res), nil
+}
+
+func (e *Evaluator) evaluatePath(path string) ([]runtime.Object, error) {
+	span := e.Tracer.Start(nil, "evaluate")
+	span.SetAttribute("kubecfg.path", path)
+	defer span.End()
+
+	if IsRemoteInput(path) {
+		return e.evaluateURL(path)
+	}
+	if path != "-" {
+		objs, _, err := e.EvaluateFile(path)
+		return objs, err
+	}
+	stdin := e.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	objs, _, err := e.EvaluateReader(stdin, e.StdinFormat, e.StdinBase)
+	return objs, err
+}
+
+// evaluateURL fetches and decodes the JSON or YAML manifest at
+// rawurl.  Remote jsonnet is refused, since its imports would be
+// resolved against the local filesystem.
+func (e *Evaluator) evaluateURL(rawurl string) ([]runtime.Object, error) {
+	if !e.AllowRemoteInputs {
+		return nil, fmt.Errorf("Reading config from a URL is disabled")
+	}
+	data, err := e.fetcher().FetchInput(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	format := SniffFormat(data)
+	switch path.Ext(strings.SplitN(rawurl, "#", 2)[0]) {
+	case ".json":
+		format = FormatJSON
+	case ".yaml", ".yml":
+		format = FormatYAML
+	}
+	if format == FormatJsonnet {
+		return nil, fmt.Errorf("Remote config must be JSON or YAML")
+	}
+	return ReadFormat(bytes.NewReader(data), format)
+}
+
+// objectKey identifies an object by group, kind, namespace and name.
+// The version is ignored, since different versions of the same
+// object are still the same object.
+func objectKey(o *unstructured.Unstructured) string {
+	gk := o.GroupVersionKind().GroupKind()
+	return fmt.Sprintf("%s/%s/%s", gk.String(), o.GetNamespace(), o.GetName())
+}
+
+func describeObject(o *unstructured.Unstructured) string {
+	if ns := o.GetNamespace(); ns != "" {
+		return fmt.Sprintf("%s %s/%s", o.GetKind(), ns, o.GetName())
+	}
+	return fmt.Sprintf("%s %s", o.GetKind(), o.GetName())
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func objectNames(objs []*unstructured.Unstructured) []string {
+	ret := make([]string, len(objs))
+	for i, o := range objs {
+		ret[i] = describeObject(o)
+	}
+	return ret
+}
+
+func TestLoadObjects(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	files := map[string]string{
+		"a.jsonnet": `{
+  cm: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "myns"}, data: {from: "a"}},
+  list: {apiVersion: "v1", kind: "List", items: [
+    {apiVersion: "apps/v1", kind: "Deployment", metadata: {name: "app", namespace: "myns"}},
+  ]},
+}`,
+		"b.yaml": `apiVersion: v1
+kind: Namespace
+metadata:
+  name: myns
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: myns
+data:
+  from: b
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: otherns
+`,
+	}
+	var paths []string
+	for _, name := range []string{"a.jsonnet", "b.yaml"} {
+		path := filepath.Join(tmpdir, name)
+		if err := ioutil.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	e := &Evaluator{Stdin: strings.NewReader(`{"apiVersion": "apps/v1beta2", "kind": "Deployment", "metadata": {"name": "app", "namespace": "myns"}, "spec": {"replicas": 3}}`)}
+	objs, err := e.LoadObjects(append(paths, "-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"Namespace myns",
+		"ConfigMap myns/config",
+		"ConfigMap otherns/config",
+		"Deployment myns/app",
+	}
+	if names := objectNames(objs); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected objects %v, expected %v", names, expected)
+	}
+	if from := objs[1].Object["data"].(map[string]interface{})["from"]; from != "b" {
+		t.Errorf("Expected the last duplicate to win, got data from %q", from)
+	}
+	if v := objs[3].GetAPIVersion(); v != "apps/v1beta2" {
+		t.Errorf("Expected a duplicate in another version to win, got %s", v)
+	}
+
+	_, err = e.LoadObjects([]string{filepath.Join(tmpdir, "missing.jsonnet")})
+	if err == nil || !strings.Contains(err.Error(), "missing.jsonnet") {
+		t.Errorf("Unexpected error for missing file: %v", err)
+	}
+}
+
+func TestLoadObjectsURL(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle.yaml":
+			fmt.Fprint(w, remoteManifest)
+		case "/main.jsonnet":
+			fmt.Fprint(w, `import "/etc/passwd"`)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(remoteManifest))
+	digest := hex.EncodeToString(sum[:])
+
+	client := NewFetchClient(time.Minute)
+	client.Transport = srv.Client().Transport
+	e := &Evaluator{Fetcher: NewManifestFetcher(client)}
+
+	if _, err := e.LoadObjects([]string{srv.URL + "/bundle.yaml"}); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("Unexpected error without AllowRemoteInputs: %v", err)
+	}
+
+	e.AllowRemoteInputs = true
+	for _, path := range []string{srv.URL + "/bundle.yaml", srv.URL + "/bundle.yaml#sha256=" + digest} {
+		objs, err := e.LoadObjects([]string{path})
+		if err != nil {
+			t.Errorf("Error reading %s: %v", path, err)
+			continue
+		}
+		expected := []string{"ConfigMap first", "ConfigMap second"}
+		if names := objectNames(objs); !reflect.DeepEqual(names, expected) {
+			t.Errorf("Unexpected objects %v, expected %v", names, expected)
+		}
+	}
+
+	for path, msg := range map[string]string{
+		srv.URL + "/bundle.yaml#sha256=1234": "Digest mismatch",
+		srv.URL + "/bundle.yaml#latest":      "Unsupported URL fragment",
+		srv.URL + "/missing.yaml":            "404 Not Found",
+		srv.URL + "/loop":                    "Stopped after 5 redirects",
+		srv.URL + "/main.jsonnet":            "must be JSON or YAML",
+		"http://example.com/bundle.yaml":     "insecure http",
+	} {
+		_, err := e.LoadObjects([]string{path})
+		if err == nil || !strings.Contains(err.Error(), msg) || !strings.Contains(err.Error(), "Error reading ") {
+			t.Errorf("Expected error containing %q for %s, got %v", msg, path, err)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/emicklei/go-restful-swagger12"
+	"github.com/go-openapi/spec"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+type mergedDiscoveryClient struct {
+	sources []discovery.DiscoveryInterface
+
+	lock      sync.Mutex
+	conflicts sets.String
+}
+
+// NewMergedDiscoveryClient creates a DiscoveryClient that presents
+// the union of several discovery sources (eg: multiple apiservers
+// behind a federated or virtual cluster).  Sources are listed in
+// order of precedence: when the same resource is served by more
+// than one source, the earliest source wins.
+func NewMergedDiscoveryClient(sources ...discovery.DiscoveryInterface) discovery.CachedDiscoveryInterface {
+	return &mergedDiscoveryClient{
+		sources:   sources,
+		conflicts: sets.NewString(),
+	}
+}
+
+// Fresh is true if all cached sources are fresh
+func (c *mergedDiscoveryClient) Fresh() bool {
+	for _, s := range c.sources {
+		if cached, ok := s.(discovery.CachedDiscoveryInterface); ok && !cached.Fresh() {
+			return false
+		}
+	}
+	return true
+}
+
+// Invalidate invalidates all cached sources
+func (c *mergedDiscoveryClient) Invalidate() {
+	for _, s := range c.sources {
+		if cached, ok := s.(discovery.CachedDiscoveryInterface); ok {
+			cached.Invalidate()
+		}
+	}
+}
+
+// InvalidateResources invalidates the resources of all cached
+// sources
+func (c *mergedDiscoveryClient) InvalidateResources() {
+	for _, s := range c.sources {
+		if cached, ok := s.(SelectiveInvalidator); ok {
+			cached.InvalidateResources()
+		} else if cached, ok := s.(discovery.CachedDiscoveryInterface); ok {
+			cached.Invalidate()
+		}
+	}
+}
+
+// InvalidateSchema invalidates the schemas of all cached sources
+func (c *mergedDiscoveryClient) InvalidateSchema() {
+	for _, s := range c.sources {
+		if cached, ok := s.(SelectiveInvalidator); ok {
+			cached.InvalidateSchema()
+		} else if cached, ok := s.(discovery.CachedDiscoveryInterface); ok {
+			cached.Invalidate()
+		}
+	}
+}
+
+// InvalidateVersion invalidates the server version of all cached
+// sources
+func (c *mergedDiscoveryClient) InvalidateVersion() {
+	for _, s := range c.sources {
+		if cached, ok := s.(SelectiveInvalidator); ok {
+			cached.InvalidateVersion()
+		} else if cached, ok := s.(discovery.CachedDiscoveryInterface); ok {
+			cached.Invalidate()
+		}
+	}
+}
+
+var _ SelectiveInvalidator = &mergedDiscoveryClient{}
+
+func (c *mergedDiscoveryClient) RESTClient() rest.Interface {
+	return c.sources[0].RESTClient()
+}
+
+func (c *mergedDiscoveryClient) ServerGroups() (*metav1.APIGroupList, error) {
+	ret := &metav1.APIGroupList{}
+	index := map[string]int{}
+	for _, s := range c.sources {
+		groups, err := s.ServerGroups()
+		if err != nil {
+			return nil, err
+		}
+		if groups == nil {
+			continue
+		}
+		for _, g := range groups.Groups {
+			i, ok := index[g.Name]
+			if !ok {
+				index[g.Name] = len(ret.Groups)
+				ret.Groups = append(ret.Groups, g)
+				continue
+			}
+			merged := &ret.Groups[i]
+			if g.PreferredVersion.GroupVersion != merged.PreferredVersion.GroupVersion {
+				log.Warningf("Discovery sources disagree on the preferred version of group %q (%s and %s), using %s", g.Name, merged.PreferredVersion.GroupVersion, g.PreferredVersion.GroupVersion, merged.PreferredVersion.GroupVersion)
+			}
+			for _, v := range g.Versions {
+				if !hasGroupVersion(merged.Versions, v.GroupVersion) {
+					merged.Versions = append(merged.Versions, v)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func hasGroupVersion(versions []metav1.GroupVersionForDiscovery, gv string) bool {
+	for _, v := range versions {
+		if v.GroupVersion == gv {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeResourceLists merges lists (in precedence order) for the
+// same GroupVersion.  Resources that appear in more than one list
+// are taken from the earliest.
+func (c *mergedDiscoveryClient) mergeResourceLists(lists []*metav1.APIResourceList) *metav1.APIResourceList {
+	ret := &metav1.APIResourceList{GroupVersion: lists[0].GroupVersion}
+	seen := map[string]metav1.APIResource{}
+	for _, l := range lists {
+		for _, r := range l.APIResources {
+			if first, ok := seen[r.Name]; ok {
+				c.conflict(l.GroupVersion, first, r)
+				continue
+			}
+			seen[r.Name] = r
+			ret.APIResources = append(ret.APIResources, r)
+		}
+	}
+	return ret
+}
+
+// conflict logs (once) that a resource is served by multiple
+// sources.  This is only a warning if the sources disagree about it.
+func (c *mergedDiscoveryClient) conflict(gv string, first, other metav1.APIResource) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := fmt.Sprintf("%s/%s", gv, first.Name)
+	if c.conflicts.Has(key) {
+		return
+	}
+	c.conflicts.Insert(key)
+	if sameResource(first, other) {
+		log.Debugf("Resource %s %s is served by multiple discovery sources, using the first", gv, first.Name)
+		return
+	}
+	log.Warningf("Resource %s %s is served by multiple discovery sources with conflicting definitions (kind %s, namespaced %v, verbs %v and kind %s, namespaced %v, verbs %v), using the first",
+		gv, first.Name, first.Kind, first.Namespaced, first.Verbs, other.Kind, other.Namespaced, other.Verbs)
+}
+
+// sameResource returns true if a and b describe the same resource
+func sameResource(a, b metav1.APIResource) bool {
+	return a.Kind == b.Kind && a.Namespaced == b.Namespaced &&
+		sets.NewString(a.Verbs...).Equal(sets.NewString(b.Verbs...))
+}
+
+func (c *mergedDiscoveryClient) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	lists := []*metav1.APIResourceList{}
+	var lastErr error
+	for _, s := range c.sources {
+		l, err := s.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		lists = append(lists, l)
+	}
+	if len(lists) == 0 {
+		return nil, lastErr
+	}
+	return c.mergeResourceLists(lists), nil
+}
+
+// mergeAll merges the results of fn from each source, by
+// GroupVersion.  Group versions that a source failed to discover are
+// combined into a single discovery.ErrGroupDiscoveryFailed, returned
+// along with the lists that were discovered.
+func (c *mergedDiscoveryClient) mergeAll(fn func(discovery.DiscoveryInterface) ([]*metav1.APIResourceList, error)) ([]*metav1.APIResourceList, error) {
+	order := []string{}
+	byGv := map[string][]*metav1.APIResourceList{}
+	failed := map[schema.GroupVersion]error{}
+	for _, s := range c.sources {
+		lists, err := fn(s)
+		if groupErr, ok := err.(*discovery.ErrGroupDiscoveryFailed); ok {
+			for gv, err := range groupErr.Groups {
+				failed[gv] = err
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		for _, l := range lists {
+			if _, ok := byGv[l.GroupVersion]; !ok {
+				order = append(order, l.GroupVersion)
+			}
+			byGv[l.GroupVersion] = append(byGv[l.GroupVersion], l)
+		}
+	}
+
+	ret := make([]*metav1.APIResourceList, 0, len(order))
+	for _, gv := range order {
+		ret = append(ret, c.mergeResourceLists(byGv[gv]))
+	}
+	return ret, groupDiscoveryError(failed)
+}
+
+func (c *mergedDiscoveryClient) ServerResources() ([]*metav1.APIResourceList, error) {
+	return c.mergeAll(func(s discovery.DiscoveryInterface) ([]*metav1.APIResourceList, error) {
+		return s.ServerResources()
+	})
+}
+
+func (c *mergedDiscoveryClient) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return c.mergeAll(func(s discovery.DiscoveryInterface) ([]*metav1.APIResourceList, error) {
+		return s.ServerPreferredResources()
+	})
+}
+
+func (c *mergedDiscoveryClient) ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error) {
+	return c.mergeAll(func(s discovery.DiscoveryInterface) ([]*metav1.APIResourceList, error) {
+		return s.ServerPreferredNamespacedResources()
+	})
+}
+
+func (c *mergedDiscoveryClient) ServerVersion() (*version.Info, error) {
+	return c.sources[0].ServerVersion()
+}
+
+// SwaggerSchema returns the schema from the first source that
+// serves the requested GroupVersion
+func (c *mergedDiscoveryClient) SwaggerSchema(gv schema.GroupVersion) (*swagger.ApiDeclaration, error) {
+	var lastErr error
+	for _, s := range c.sources {
+		if _, err := s.ServerResourcesForGroupVersion(gv.String()); err != nil {
+			lastErr = err
+			continue
+		}
+		return s.SwaggerSchema(gv)
+	}
+	return nil, lastErr
+}
+
+func (c *mergedDiscoveryClient) OpenAPISchema() (*spec.Swagger, error) {
+	return c.sources[0].OpenAPISchema()
+}
+
+var _ discovery.CachedDiscoveryInterface = &mergedDiscoveryClient{}
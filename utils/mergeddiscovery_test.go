@@ -0,0 +1,162 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestMergedDiscovery(t *testing.T) {
+	core := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "pods", Kind: "Pod", Namespaced: true},
+				},
+			},
+			{
+				GroupVersion: "example.com/v1",
+				APIResources: []metav1.APIResource{
+					{Name: "widgets", Kind: "Widget", Namespaced: true},
+				},
+			},
+		},
+	}}
+	aggregated := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "example.com/v1",
+				APIResources: []metav1.APIResource{
+					{Name: "widgets", Kind: "Widget", Namespaced: false},
+					{Name: "gadgets", Kind: "Gadget", Namespaced: true},
+				},
+			},
+		},
+	}}
+
+	lookup := func(disco discovery.DiscoveryInterface, gvk schema.GroupVersionKind) *metav1.APIResource {
+		rsrc, err := serverResourceForGroupVersionKind(disco, gvk)
+		if err != nil {
+			t.Fatalf("Failed to find %s: %v", gvk, err)
+		}
+		return rsrc
+	}
+
+	gadget := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Gadget"}
+	widget := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	merged := NewMergedDiscoveryClient(core, aggregated)
+
+	// Only served by the second source
+	if r := lookup(merged, gadget); r.Name != "gadgets" {
+		t.Errorf("Unexpected resource %v", r)
+	}
+
+	// Served by both, first wins
+	if r := lookup(merged, widget); !r.Namespaced {
+		t.Errorf("Expected core widget, got %v", r)
+	}
+
+	// The sources disagree about widgets' scope
+	if !strings.Contains(logs.String(), "level=warning") || !strings.Contains(logs.String(), "example.com/v1 widgets is served by multiple discovery sources with conflicting definitions") {
+		t.Errorf("Expected a warning about conflicting widgets, got %q", logs.String())
+	}
+
+	// Precedence follows argument order
+	if r := lookup(NewMergedDiscoveryClient(aggregated, core), widget); r.Namespaced {
+		t.Errorf("Expected aggregated widget, got %v", r)
+	}
+
+	if _, err := merged.ServerResourcesForGroupVersion("bogus/v1"); err == nil {
+		t.Errorf("Unknown GroupVersion returned no error")
+	}
+
+	all, err := merged.ServerResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, l := range all {
+		count += len(l.APIResources)
+	}
+	if len(all) != 2 || count != 3 {
+		t.Errorf("Unexpected merged resources %v", all)
+	}
+}
+
+// partialDiscovery fails to discover broken, like a client-go
+// DiscoveryClient with an unavailable aggregated API
+type partialDiscovery struct {
+	fakediscovery.FakeDiscovery
+	broken schema.GroupVersion
+}
+
+func (d *partialDiscovery) ServerResources() ([]*metav1.APIResourceList, error) {
+	return d.Resources, &discovery.ErrGroupDiscoveryFailed{
+		Groups: map[schema.GroupVersion]error{d.broken: fmt.Errorf("the server is currently unable to handle the request")},
+	}
+}
+
+func TestMergedDiscoveryPartial(t *testing.T) {
+	core := &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods", Kind: "Pod", Namespaced: true}}},
+		},
+	}}
+	aggregated := &partialDiscovery{
+		FakeDiscovery: fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{GroupVersion: "example.com/v1", APIResources: []metav1.APIResource{{Name: "widgets", Kind: "Widget", Namespaced: true}}},
+			},
+		}},
+		broken: schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"},
+	}
+
+	lists, err := NewMergedDiscoveryClient(core, aggregated).ServerResources()
+	groupErr, ok := err.(*discovery.ErrGroupDiscoveryFailed)
+	if !ok {
+		t.Fatalf("Expected ErrGroupDiscoveryFailed, got %v", err)
+	}
+	if _, ok := groupErr.Groups[aggregated.broken]; !ok || len(groupErr.Groups) != 1 {
+		t.Errorf("Unexpected failed groups %v", groupErr.Groups)
+	}
+	if len(lists) != 2 || lists[0].GroupVersion != "v1" || lists[1].GroupVersion != "example.com/v1" {
+		t.Errorf("Expected discovered resources to be returned, got %v", lists)
+	}
+
+	if err := WarnOnPartialDiscovery(err); err != nil {
+		t.Errorf("Partial discovery was not tolerated: %v", err)
+	}
+	if err := WarnOnPartialDiscovery(fmt.Errorf("connection refused")); err == nil {
+		t.Errorf("Other errors should not be tolerated")
+	}
+}
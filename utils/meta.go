@@ -7,7 +7,10 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/discovery"
 )
@@ -69,6 +72,24 @@ func (v ServerVersion) String() string {
 	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
 }
 
+// ParseGroupVersionKind parses "group/version/Kind" (eg:
+// "apps/v1beta1/Deployment").  The core group may be written as
+// "core/v1/Kind" or just "v1/Kind".
+func ParseGroupVersionKind(s string) (schema.GroupVersionKind, error) {
+	parts := strings.Split(s, "/")
+	switch {
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return schema.GroupVersionKind{Version: parts[0], Kind: parts[1]}, nil
+	case len(parts) == 3 && parts[1] != "" && parts[2] != "":
+		group := parts[0]
+		if group == "core" {
+			group = ""
+		}
+		return schema.GroupVersionKind{Group: group, Version: parts[1], Kind: parts[2]}, nil
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("Unable to parse %q, expected group/version/Kind", s)
+}
+
 // SetMetaDataAnnotation sets an annotation value
 func SetMetaDataAnnotation(obj metav1.Object, key, value string) {
 	a := obj.GetAnnotations()
@@ -106,3 +127,81 @@ func FqName(o metav1.Object) string {
 	}
 	return fmt.Sprintf("%s.%s", o.GetNamespace(), o.GetName())
 }
+
+// ServerManagedMetadata are the metadata fields maintained by the
+// server, which are never meaningful in config
+var ServerManagedMetadata = []string{"resourceVersion", "uid", "generation", "creationTimestamp", "managedFields"}
+
+// StripServerManagedFields removes status and ServerManagedMetadata
+// from obj.  Config (almost) never contains a meaningful status, but
+// live objects always do.
+func StripServerManagedFields(obj *unstructured.Unstructured) {
+	delete(obj.Object, "status")
+	StripServerManagedMetadata(obj)
+}
+
+// StripServerManagedMetadata is StripServerManagedFields, except
+// status is kept
+func StripServerManagedMetadata(obj *unstructured.Unstructured) {
+	metadata, ok := obj.Object["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, f := range ServerManagedMetadata {
+		delete(metadata, f)
+	}
+}
+
+// ApplyCommonMetadata merges labels and annotations into the
+// metadata of every object in objs, including the items of List
+// objects (recursively).  Keys already present on an object are only
+// replaced if overwrite is true.
+func ApplyCommonMetadata(objs []*unstructured.Unstructured, labels, annotations map[string]string, overwrite bool) {
+	for _, obj := range objs {
+		if items, ok := obj.Object["items"].([]interface{}); ok && strings.HasSuffix(obj.GetKind(), "List") {
+			var list []*unstructured.Unstructured
+			for _, item := range items {
+				if m, ok := item.(map[string]interface{}); ok {
+					list = append(list, &unstructured.Unstructured{Object: m})
+				}
+			}
+			ApplyCommonMetadata(list, labels, annotations, overwrite)
+			continue
+		}
+		if len(labels) > 0 {
+			obj.SetLabels(mergeStringMap(obj.GetLabels(), labels, overwrite))
+		}
+		if len(annotations) > 0 {
+			obj.SetAnnotations(mergeStringMap(obj.GetAnnotations(), annotations, overwrite))
+		}
+	}
+}
+
+// mergeStringMap adds the entries of from to into, replacing existing
+// keys only if overwrite is true
+func mergeStringMap(into, from map[string]string, overwrite bool) map[string]string {
+	if into == nil {
+		into = make(map[string]string, len(from))
+	}
+	for k, v := range from {
+		if _, ok := into[k]; ok && !overwrite {
+			continue
+		}
+		into[k] = v
+	}
+	return into
+}
+
+// FilterBySelector returns the objects in objs whose labels match
+// sel.  Objects that don't match are logged (at debug level).
+func FilterBySelector(objs []*unstructured.Unstructured, sel labels.Selector) []*unstructured.Unstructured {
+	ret := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, o := range objs {
+		if !sel.Matches(labels.Set(o.GetLabels())) {
+			log.Debugf("Skipping %s %s: doesn't match selector %q", o.GetKind(), FqName(o), sel)
+			continue
+		}
+		ret = append(ret, o)
+	}
+	return ret
+}
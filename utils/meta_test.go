@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"reflect"
 	"testing"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/version"
 	fakediscovery "k8s.io/client-go/discovery/fake"
@@ -53,6 +55,47 @@ func TestParseVersion(t *testing.T) {
 	}
 }
 
+func TestParseGroupVersionKind(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected schema.GroupVersionKind
+		error    bool
+	}{
+		{
+			input:    "apps/v1beta1/Deployment",
+			expected: schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+		},
+		{
+			input:    "core/v1/ConfigMap",
+			expected: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		},
+		{
+			input:    "v1/ConfigMap",
+			expected: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		},
+		{input: "ConfigMap", error: true},
+		{input: "apps//Deployment", error: true},
+		{input: "a/b/c/d", error: true},
+	}
+
+	for _, test := range tests {
+		gvk, err := ParseGroupVersionKind(test.input)
+		if test.error {
+			if err == nil {
+				t.Errorf("test %s should have failed and did not", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %s failed: %v", test.input, err)
+			continue
+		}
+		if gvk != test.expected {
+			t.Errorf("Expected %v, got %v", test.expected, gvk)
+		}
+	}
+}
+
 func TestVersionCompare(t *testing.T) {
 	v := ServerVersion{Major: 2, Minor: 3}
 	tests := []struct {
@@ -135,3 +178,162 @@ func TestFqName(t *testing.T) {
 		t.Errorf("Got %q for %v", n, obj)
 	}
 }
+
+func TestStripServerManagedFields(t *testing.T) {
+	deployment := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "apps/v1beta1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name":              "web",
+					"namespace":         "default",
+					"labels":            map[string]interface{}{"app": "web"},
+					"resourceVersion":   "123",
+					"uid":               "abc",
+					"generation":        int64(3),
+					"creationTimestamp": "2017-01-01T00:00:00Z",
+					"managedFields":     []interface{}{map[string]interface{}{"manager": "kubectl"}},
+				},
+				"spec":   map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{"replicas": int64(2)},
+			},
+		}
+	}
+	expectedMeta := map[string]interface{}{
+		"name":      "web",
+		"namespace": "default",
+		"labels":    map[string]interface{}{"app": "web"},
+	}
+
+	obj := deployment()
+	StripServerManagedFields(obj)
+	if _, ok := obj.Object["status"]; ok {
+		t.Errorf("Status was not stripped")
+	}
+	if !reflect.DeepEqual(obj.Object["metadata"], expectedMeta) {
+		t.Errorf("Expected metadata %v, got %v", expectedMeta, obj.Object["metadata"])
+	}
+	if _, ok := obj.Object["spec"]; !ok {
+		t.Errorf("Spec was stripped")
+	}
+
+	obj = deployment()
+	StripServerManagedMetadata(obj)
+	if _, ok := obj.Object["status"]; !ok {
+		t.Errorf("Status was stripped")
+	}
+	if !reflect.DeepEqual(obj.Object["metadata"], expectedMeta) {
+		t.Errorf("Expected metadata %v, got %v", expectedMeta, obj.Object["metadata"])
+	}
+
+	// Objects without metadata are left alone
+	obj = &unstructured.Unstructured{Object: map[string]interface{}{"kind": "List"}}
+	StripServerManagedFields(obj)
+}
+
+func TestApplyCommonMetadata(t *testing.T) {
+	objs := []*unstructured.Unstructured{
+		{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":   "plain",
+				"labels": map[string]interface{}{"env": "dev"},
+			},
+		}},
+		{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items": []interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata":   map[string]interface{}{"name": "item"},
+				},
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "List",
+					"items": []interface{}{
+						map[string]interface{}{
+							"apiVersion": "v1",
+							"kind":       "Secret",
+							"metadata": map[string]interface{}{
+								"name":        "nested",
+								"annotations": map[string]interface{}{"note": "mine"},
+							},
+						},
+					},
+				},
+			},
+		}},
+	}
+	labels := map[string]string{"app.kubernetes.io/managed-by": "kubecfg", "env": "prod"}
+	annotations := map[string]string{"note": "common"}
+
+	ApplyCommonMetadata(objs, labels, annotations, false)
+
+	item := &unstructured.Unstructured{Object: objs[1].Object["items"].([]interface{})[0].(map[string]interface{})}
+	nested := objs[1].Object["items"].([]interface{})[1].(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})
+	nestedObj := &unstructured.Unstructured{Object: nested}
+
+	if l := objs[0].GetLabels(); !reflect.DeepEqual(l, map[string]string{"app.kubernetes.io/managed-by": "kubecfg", "env": "dev"}) {
+		t.Errorf("Unexpected labels %v", l)
+	}
+	if l := item.GetLabels(); !reflect.DeepEqual(l, labels) {
+		t.Errorf("Unexpected labels on list item %v", l)
+	}
+	if a := nestedObj.GetAnnotations(); a["note"] != "mine" {
+		t.Errorf("Existing annotation was replaced: %v", a)
+	}
+	if l := nestedObj.GetLabels(); !reflect.DeepEqual(l, labels) {
+		t.Errorf("Unexpected labels on nested list item %v", l)
+	}
+	if _, ok := objs[1].Object["metadata"]; ok {
+		t.Errorf("Metadata was added to the List itself")
+	}
+
+	ApplyCommonMetadata(objs, labels, annotations, true)
+	if l := objs[0].GetLabels(); l["env"] != "prod" {
+		t.Errorf("Existing label was not replaced with overwrite: %v", l)
+	}
+	if a := nestedObj.GetAnnotations(); a["note"] != "common" {
+		t.Errorf("Existing annotation was not replaced with overwrite: %v", a)
+	}
+}
+
+func TestFilterBySelector(t *testing.T) {
+	newObj := func(name string, l map[string]string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": name},
+		}}
+		o.SetLabels(l)
+		return o
+	}
+	objs := []*unstructured.Unstructured{
+		newObj("frontend", map[string]string{"tier": "frontend"}),
+		newObj("backend", map[string]string{"tier": "backend"}),
+		newObj("unlabelled", nil),
+	}
+
+	for selector, expected := range map[string][]string{
+		"tier=frontend": {"frontend"},
+		"tier!=backend": {"frontend", "unlabelled"},
+		"tier":          {"frontend", "backend"},
+		"":              {"frontend", "backend", "unlabelled"},
+	} {
+		sel, err := labels.Parse(selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, o := range FilterBySelector(objs, sel) {
+			names = append(names, o.GetName())
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("Selector %q: expected %v, got %v", selector, expected, names)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Recorder receives metrics about discovery and apply operations.
+// Implementations must be safe for concurrent use.  Recorder is
+// deliberately small, so callers can wire it to prometheus (or
+// anything else) without kubecfg depending on it.
+type Recorder interface {
+	// CacheHit counts a discovery call (eg: "ServerGroups")
+	// answered from the cache
+	CacheHit(method string)
+	// CacheMiss counts a discovery call that had to be fetched
+	// from the server
+	CacheMiss(method string)
+	// DiscoveryFetch observes the latency of a discovery fetch
+	// from the server, whether or not it succeeded
+	DiscoveryFetch(method string, latency time.Duration)
+	// Apply counts the result of applying an object of kind gvk.
+	// err is nil on success.
+	Apply(gvk schema.GroupVersionKind, err error)
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) CacheHit(string)                      {}
+func (nopRecorder) CacheMiss(string)                     {}
+func (nopRecorder) DiscoveryFetch(string, time.Duration) {}
+func (nopRecorder) Apply(schema.GroupVersionKind, error) {}
+
+var (
+	metricsLock     sync.RWMutex
+	metricsRecorder Recorder = nopRecorder{}
+)
+
+// SetMetricsRecorder registers r to receive metrics from this
+// package and its callers.  A nil r restores the default, which
+// discards everything.
+func SetMetricsRecorder(r Recorder) {
+	if r == nil {
+		r = nopRecorder{}
+	}
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metricsRecorder = r
+}
+
+// Metrics returns the registered Recorder
+func Metrics() Recorder {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	return metricsRecorder
+}
+
+// timeDiscoveryFetch reports the time since start as the latency of
+// a discovery fetch by method
+func timeDiscoveryFetch(method string, start time.Time) {
+	Metrics().DiscoveryFetch(method, time.Since(start))
+}
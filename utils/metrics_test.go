@@ -0,0 +1,131 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+type fakeRecorder struct {
+	mu      sync.Mutex
+	hits    map[string]int
+	misses  map[string]int
+	fetches map[string]int
+	applies map[string]int
+}
+
+func newFakeRecorder() *fakeRecorder {
+	return &fakeRecorder{
+		hits:    map[string]int{},
+		misses:  map[string]int{},
+		fetches: map[string]int{},
+		applies: map[string]int{},
+	}
+}
+
+func (r *fakeRecorder) CacheHit(method string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hits[method]++
+}
+
+func (r *fakeRecorder) CacheMiss(method string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.misses[method]++
+}
+
+func (r *fakeRecorder) DiscoveryFetch(method string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fetches[method]++
+}
+
+func (r *fakeRecorder) Apply(gvk schema.GroupVersionKind, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	r.applies[gvk.Kind+" "+result]++
+}
+
+func TestDiscoveryMetrics(t *testing.T) {
+	rec := newFakeRecorder()
+	SetMetricsRecorder(rec)
+	defer SetMetricsRecorder(nil)
+
+	fake := &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{{Name: "configmaps", Kind: "ConfigMap"}},
+			},
+		},
+	}
+	c := NewMemcachedDiscoveryClient(&fakediscovery.FakeDiscovery{Fake: fake})
+	for i := 0; i < 3; i++ {
+		if _, err := c.ServerResourcesForGroupVersion("v1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.SwaggerSchema(schema.GroupVersion{Version: "v1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]int{"ServerResourcesForGroupVersion": 2, "SwaggerSchema": 2}
+	if !reflect.DeepEqual(rec.hits, expected) {
+		t.Errorf("Unexpected cache hits %v", rec.hits)
+	}
+	expected = map[string]int{"ServerResourcesForGroupVersion": 1, "SwaggerSchema": 1}
+	if !reflect.DeepEqual(rec.misses, expected) {
+		t.Errorf("Unexpected cache misses %v", rec.misses)
+	}
+	if !reflect.DeepEqual(rec.fetches, expected) {
+		t.Errorf("Unexpected discovery fetches %v", rec.fetches)
+	}
+}
+
+func TestSetMetricsRecorder(t *testing.T) {
+	if _, ok := Metrics().(nopRecorder); !ok {
+		t.Errorf("Default recorder is %T", Metrics())
+	}
+
+	rec := newFakeRecorder()
+	SetMetricsRecorder(rec)
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	Metrics().Apply(gvk, nil)
+	Metrics().Apply(gvk, errors.New("fail"))
+	Metrics().Apply(gvk, nil)
+	if expected := map[string]int{"ConfigMap success": 2, "ConfigMap error": 1}; !reflect.DeepEqual(rec.applies, expected) {
+		t.Errorf("Unexpected apply counts %v", rec.applies)
+	}
+
+	SetMetricsRecorder(nil)
+	if _, ok := Metrics().(nopRecorder); !ok {
+		t.Errorf("Recorder was not reset: %T", Metrics())
+	}
+}
@@ -17,15 +17,18 @@ package utils
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"regexp"
 	"strings"
 
 	goyaml "github.com/ghodss/yaml"
 
-	jsonnet "github.com/strickyak/jsonnet_cgo"
 	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
 )
 
 func resolveImage(resolver Resolver, image string) (string, error) {
@@ -41,6 +44,14 @@ func resolveImage(resolver Resolver, image string) (string, error) {
 	return n.String(), nil
 }
 
+func base64Decode(s string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base64 input: %v", err)
+	}
+	return data, nil
+}
+
 // RegisterNativeFuncs adds kubecfg's native jsonnet functions to provided VM
 func RegisterNativeFuncs(vm *jsonnet.VM, resolver Resolver) {
 	// NB: libjsonnet native functions can only pass primitive
@@ -88,6 +99,27 @@ func RegisterNativeFuncs(vm *jsonnet.VM, resolver Resolver) {
 		return string(output), err
 	})
 
+	vm.NativeCallback("base64", []string{"str"}, func(s string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(s)), nil
+	})
+
+	vm.NativeCallback("base64Decode", []string{"str"}, func(s string) (string, error) {
+		data, err := base64Decode(s)
+		return string(data), err
+	})
+
+	vm.NativeCallback("base64DecodeBytes", []string{"str"}, func(s string) ([]interface{}, error) {
+		data, err := base64Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]interface{}, len(data))
+		for i, b := range data {
+			ret[i] = float64(b)
+		}
+		return ret, nil
+	})
+
 	vm.NativeCallback("resolveImage", []string{"image"}, func(image string) (string, error) {
 		return resolveImage(resolver, image)
 	})
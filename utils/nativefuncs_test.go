@@ -18,7 +18,7 @@ package utils
 import (
 	"testing"
 
-	jsonnet "github.com/strickyak/jsonnet_cgo"
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
 )
 
 // check there is no err, and a == b.
@@ -71,6 +71,34 @@ func TestParseYaml(t *testing.T) {
     local a = std.native("parseYaml")("---\nhello\n---\nworld");
     a[0] + a[1]`)
 	check(t, err, x, "\"helloworld\"\n")
+
+	// Empty documents (eg: a trailing separator) are skipped
+	x, err = vm.EvaluateSnippet("test", `
+    std.length(std.native("parseYaml")("---\na: 1\n---\n---\nb: 2\n---\n"))`)
+	check(t, err, x, "2\n")
+}
+
+func TestBase64(t *testing.T) {
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	RegisterNativeFuncs(vm, NewIdentityResolver())
+
+	x, err := vm.EvaluateSnippet("test", `std.native("base64")("hunter2")`)
+	check(t, err, x, "\"aHVudGVyMg==\"\n")
+
+	x, err = vm.EvaluateSnippet("test", `std.native("base64Decode")("aHVudGVyMg==")`)
+	check(t, err, x, "\"hunter2\"\n")
+
+	x, err = vm.EvaluateSnippet("test", `std.native("base64DecodeBytes")("AP8K")`)
+	check(t, err, x, "[\n   0,\n   255,\n   10\n]\n")
+
+	x, err = vm.EvaluateSnippet("test", `std.native("base64DecodeBytes")("")`)
+	check(t, err, x, "[ ]\n")
+
+	_, err = vm.EvaluateSnippet("failtest", `std.native("base64Decode")("not base64!")`)
+	if err == nil {
+		t.Errorf("base64Decode succeeded on invalid input")
+	}
 }
 
 func TestRegexMatch(t *testing.T) {
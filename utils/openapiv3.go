@@ -0,0 +1,139 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-openapi/spec"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// OpenAPIV3SchemaInterface is implemented by discovery clients that
+// can fetch the OpenAPI v3 schema of a group version, served by
+// Kubernetes >= 1.24.  Unlike the (v2) OpenAPISchema, the v3 schema
+// includes the full structural schema of custom resources.
+type OpenAPIV3SchemaInterface interface {
+	// OpenAPIV3Schema returns the schema of gv.  The schema's
+	// components are returned as the Definitions of a
+	// spec.Swagger, so it can be used with ValidateOpenAPI.
+	OpenAPIV3Schema(gv schema.GroupVersion) (*spec.Swagger, error)
+}
+
+var _ OpenAPIV3SchemaInterface = &memcachedDiscoveryClient{}
+
+// openAPIV3Index is the response to /openapi/v3
+type openAPIV3Index struct {
+	Paths map[string]struct {
+		ServerRelativeURL string `json:"serverRelativeURL"`
+	} `json:"paths"`
+}
+
+// openAPIV3Document is the part of an OpenAPI v3 document that we
+// use
+type openAPIV3Document struct {
+	Components struct {
+		Schemas map[string]spec.Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// openAPIV3Path returns the key of gv in the /openapi/v3 index
+func openAPIV3Path(gv schema.GroupVersion) string {
+	if gv.Group == "" {
+		return "api/" + gv.Version
+	}
+	return "apis/" + gv.Group + "/" + gv.Version
+}
+
+// fetchOpenAPIV3Index returns the URL of the schema of each group
+// version (by openAPIV3Path)
+func fetchOpenAPIV3Index(rc rest.Interface) (map[string]string, error) {
+	data, err := rc.Get().AbsPath("/openapi/v3").Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	var index openAPIV3Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("Error parsing OpenAPI v3 index: %v", err)
+	}
+	ret := make(map[string]string, len(index.Paths))
+	for path, p := range index.Paths {
+		ret[path] = p.ServerRelativeURL
+	}
+	return ret, nil
+}
+
+// fetchOpenAPIV3Schema fetches the OpenAPI v3 document at the server
+// relative url
+func fetchOpenAPIV3Schema(rc rest.Interface, url string) (*spec.Swagger, error) {
+	data, err := rc.Get().RequestURI(url).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	var doc openAPIV3Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Error parsing OpenAPI v3 schema: %v", err)
+	}
+	ret := &spec.Swagger{}
+	ret.Definitions = spec.Definitions(doc.Components.Schemas)
+	return ret, nil
+}
+
+// OpenAPIV3Schema is part of OpenAPIV3SchemaInterface.  The index of
+// group versions and each group version's schema are cached, and
+// discarded along with the other schemas.
+func (c *memcachedDiscoveryClient) OpenAPIV3Schema(gv schema.GroupVersion) (*spec.Swagger, error) {
+	key := gv.String()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.schemasV3[key] != nil && !c.expired(c.schemasV3At[key]) {
+		Metrics().CacheHit("OpenAPIV3Schema")
+		return c.schemasV3[key], nil
+	}
+	Metrics().CacheMiss("OpenAPIV3Schema")
+	defer timeDiscoveryFetch("OpenAPIV3Schema", time.Now())
+
+	rc := c.cl.RESTClient()
+	if rc == nil {
+		return nil, fmt.Errorf("OpenAPI v3 schema is not available without a REST client")
+	}
+	if c.v3index == nil || c.expired(c.v3indexAt) {
+		fetched := c.now()
+		index, err := fetchOpenAPIV3Index(rc)
+		if err != nil {
+			return nil, err
+		}
+		c.v3index, c.v3indexAt = index, fetched
+	}
+	url, ok := c.v3index[openAPIV3Path(gv)]
+	if !ok {
+		return nil, fmt.Errorf("Server has no OpenAPI v3 schema for %s", gv)
+	}
+
+	fetched := c.now()
+	doc, err := fetchOpenAPIV3Schema(rc, url)
+	if err != nil {
+		return nil, err
+	}
+	c.schemasV3[key] = doc
+	c.schemasV3At[key] = fetched
+	return doc, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+const openAPIV3TestIndex = `{"paths": {
+  "api/v1": {"serverRelativeURL": "/openapi/v3/api/v1?hash=AAAA"},
+  "apis/tests/v1alpha1": {"serverRelativeURL": "/openapi/v3/apis/tests/v1alpha1?hash=BBBB"}
+}}`
+
+const openAPIV3TestSchema = `{
+  "openapi": "3.0.0",
+  "components": {"schemas": {
+    "io.example.tests.v1alpha1.Test": {
+      "type": "object",
+      "x-kubernetes-group-version-kind": [{"group": "tests", "version": "v1alpha1", "kind": "Test"}],
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"type": "object"},
+        "spec": {"description": "Wrapped reference", "default": {}, "allOf": [{"$ref": "#/components/schemas/io.example.tests.v1alpha1.TestSpec"}]}
+      }
+    },
+    "io.example.tests.v1alpha1.TestSpec": {
+      "type": "object",
+      "required": ["replicas"],
+      "properties": {"replicas": {"type": "integer"}}
+    }
+  }}
+}`
+
+func TestOpenAPIV3Schema(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.RequestURI()]++
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.RequestURI() {
+		case "/openapi/v3":
+			fmt.Fprint(w, openAPIV3TestIndex)
+		case "/openapi/v3/apis/tests/v1alpha1?hash=BBBB":
+			fmt.Fprint(w, openAPIV3TestSchema)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMemcachedDiscoveryClient(disco).(*memcachedDiscoveryClient)
+
+	gv := schema.GroupVersion{Group: "tests", Version: "v1alpha1"}
+	doc, err := c.OpenAPIV3Schema(gv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SchemaHasKind(doc, gv.WithKind("Test")) {
+		t.Errorf("Test kind not found in v3 schema")
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "tests/v1alpha1",
+		"kind":       "Test",
+		"metadata":   map[string]interface{}{"name": "myobj"},
+		"spec":       map[string]interface{}{"replicas": "many", "bogus": true},
+	}}
+	var msgs []string
+	for _, err := range ValidateOpenAPI(obj, doc) {
+		msgs = append(msgs, err.Error())
+	}
+	for _, expected := range []string{".spec.replicas: expected integer", ".spec.bogus: unknown field"} {
+		if !strings.Contains(strings.Join(msgs, "\n"), expected) {
+			t.Errorf("Expected %q in validation errors %v", expected, msgs)
+		}
+	}
+
+	if _, err := c.OpenAPIV3Schema(gv); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests["/openapi/v3/apis/tests/v1alpha1?hash=BBBB"]; n != 1 {
+		t.Errorf("Expected the v3 schema to be cached, got %d fetches", n)
+	}
+
+	if _, err := c.OpenAPIV3Schema(schema.GroupVersion{Group: "other", Version: "v1"}); err == nil {
+		t.Errorf("Expected an error for a group version without a v3 schema")
+	}
+	if n := requests["/openapi/v3"]; n != 1 {
+		t.Errorf("Expected the v3 index to be cached, got %d fetches", n)
+	}
+
+	c.InvalidateSchema()
+	if _, err := c.OpenAPIV3Schema(gv); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests["/openapi/v3"]; n != 2 {
+		t.Errorf("Expected the v3 index to be refetched after InvalidateSchema, got %d fetches", n)
+	}
+	if n := requests["/openapi/v3/apis/tests/v1alpha1?hash=BBBB"]; n != 2 {
+		t.Errorf("Expected the v3 schema to be refetched after InvalidateSchema, got %d fetches", n)
+	}
+}
+
+func TestOpenAPIV3SchemaUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMemcachedDiscoveryClient(disco).(OpenAPIV3SchemaInterface)
+	if _, err := c.OpenAPIV3Schema(schema.GroupVersion{Version: "v1"}); err == nil {
+		t.Errorf("Expected an error from a server without OpenAPI v3")
+	}
+}
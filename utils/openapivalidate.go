@@ -0,0 +1,337 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-openapi/spec"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var jsonPathIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonPathField appends the field key to the JSONPath expression p
+func jsonPathField(p, key string) string {
+	if jsonPathIdent.MatchString(key) {
+		return p + "." + key
+	}
+	return fmt.Sprintf("%s['%s']", p, strings.Replace(key, "'", `\'`, -1))
+}
+
+// JSONPath formats path as a JSONPath expression, eg:
+// `.metadata.labels['app.kubernetes.io/name']`
+func JSONPath(path []string) string {
+	ret := ""
+	for _, p := range path {
+		ret = jsonPathField(ret, p)
+	}
+	if ret == "" {
+		return "."
+	}
+	return ret
+}
+
+// FieldError is a schema violation at a particular field
+type FieldError struct {
+	// Path is the JSONPath of the offending field
+	Path    string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// openAPIDefinitionFor returns the OpenAPI definition of the kind
+// gvk, or nil if there is none
+func openAPIDefinitionFor(doc *spec.Swagger, gvk schema.GroupVersionKind) *spec.Schema {
+	if doc == nil {
+		return nil
+	}
+	for name := range doc.Definitions {
+		def := doc.Definitions[name]
+		if definitionHasKind(&def, gvk) {
+			return &def
+		}
+	}
+	return nil
+}
+
+// definitionHasKind returns true if def is the definition of gvk
+func definitionHasKind(def *spec.Schema, gvk schema.GroupVersionKind) bool {
+	gvks, _ := def.Extensions["x-kubernetes-group-version-kind"].([]interface{})
+	for _, v := range gvks {
+		m, _ := v.(map[string]interface{})
+		if m["group"] == gvk.Group && m["version"] == gvk.Version && m["kind"] == gvk.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// SchemaHasKind returns true if doc has a definition for gvk.
+// Custom resources without a structural schema are not included in
+// the server's OpenAPI document.
+func SchemaHasKind(doc *spec.Swagger, gvk schema.GroupVersionKind) bool {
+	return openAPIDefinitionFor(doc, gvk) != nil
+}
+
+// builtinDefinitionPrefixes are the prefixes of the OpenAPI
+// definition names of built-in kinds.  Custom resources are named
+// after their (reversed) group, eg: com.example.stable.v1.CronTab.
+var builtinDefinitionPrefixes = []string{
+	"io.k8s.api.",
+	"io.k8s.kubernetes.pkg.",
+	"io.k8s.kube-aggregator.",
+	"io.k8s.apiextensions-apiserver.",
+}
+
+// definitionName returns the name of the definition that ref refers
+// to, or "" if ref is not a local reference
+func definitionName(ref string) string {
+	for _, prefix := range []string{"#/definitions/", "#/components/schemas/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return ""
+}
+
+// PatchMergeKeys returns the lists in the definition of gvk in doc
+// that a strategic merge patch merges rather than replaces, keyed by
+// their dotted path (eg: "spec.template.spec.containers", ignoring
+// list indices), with the patch merge key of each ("" for lists of
+// primitives).  Returns nil unless gvk is a built-in kind: the server
+// only supports strategic merge patches for these kinds.
+//
+// The apimachinery definitions (eg: ObjectMeta) are not searched,
+// since every kind refers to them.
+func PatchMergeKeys(doc *spec.Swagger, gvk schema.GroupVersionKind) map[string]string {
+	if doc == nil {
+		return nil
+	}
+	for name := range doc.Definitions {
+		def := doc.Definitions[name]
+		if !definitionHasKind(&def, gvk) {
+			continue
+		}
+		builtin := false
+		for _, prefix := range builtinDefinitionPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				builtin = true
+			}
+		}
+		if !builtin {
+			return nil
+		}
+		v := openAPIValidator{doc: doc}
+		ret := map[string]string{}
+		v.mergeKeys(nil, &def, map[string]bool{}, ret)
+		if len(ret) == 0 {
+			return nil
+		}
+		return ret
+	}
+	return nil
+}
+
+// mergeKeys adds the merged lists found in s (at path) to ret.
+// stack holds the definitions being searched, to stop recursive
+// definitions.
+func (v *openAPIValidator) mergeKeys(path []string, s *spec.Schema, stack map[string]bool, ret map[string]string) {
+	if ref := unwrapRef(s).Ref.String(); ref != "" {
+		name := definitionName(ref)
+		if stack[name] || strings.HasPrefix(name, "io.k8s.apimachinery.") {
+			return
+		}
+		stack[name] = true
+		defer delete(stack, name)
+	}
+	s = v.resolve(s)
+	if s == nil {
+		return
+	}
+	for name := range s.Properties {
+		p := s.Properties[name]
+		ppath := append(path[:len(path):len(path)], name)
+		if strategy, _ := p.Extensions.GetString("x-kubernetes-patch-strategy"); strings.Contains(strategy, "merge") {
+			key, _ := p.Extensions.GetString("x-kubernetes-patch-merge-key")
+			ret[strings.Join(ppath, ".")] = key
+		}
+		v.mergeKeys(ppath, &p, stack, ret)
+	}
+	if s.Items != nil && s.Items.Schema != nil {
+		v.mergeKeys(path, s.Items.Schema, stack, ret)
+	}
+}
+
+// ValidateOpenAPI validates obj against the definition of its kind in
+// the OpenAPI document doc, and returns a *FieldError for every
+// missing required field, unknown field and type mismatch.  Objects
+// of kinds not in doc are not validated (see SchemaHasKind).
+func ValidateOpenAPI(obj *unstructured.Unstructured, doc *spec.Swagger) []error {
+	def := openAPIDefinitionFor(doc, obj.GroupVersionKind())
+	if def == nil {
+		return nil
+	}
+	v := openAPIValidator{doc: doc}
+	v.validate("", obj.Object, def)
+	return v.errs
+}
+
+type openAPIValidator struct {
+	doc  *spec.Swagger
+	errs []error
+}
+
+func (v *openAPIValidator) errorf(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "."
+	}
+	v.errs = append(v.errs, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// resolve follows local references to other definitions.  OpenAPI
+// v3 schemas (see OpenAPIV3Schema) refer to "#/components/schemas/",
+// and wrap references in a single-element allOf so they can carry
+// a description and default.
+func (v *openAPIValidator) resolve(s *spec.Schema) *spec.Schema {
+	for i := 0; s != nil && i < 10; i++ {
+		s = unwrapRef(s)
+		ref := s.Ref.String()
+		if ref == "" {
+			break
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(ref, "#/definitions/"), "#/components/schemas/")
+		def, ok := v.doc.Definitions[name]
+		if !ok {
+			return nil
+		}
+		s = &def
+	}
+	return s
+}
+
+// unwrapRef returns the reference wrapped by s, if s is an OpenAPI v3
+// single-element allOf, or s
+func unwrapRef(s *spec.Schema) *spec.Schema {
+	if s.Ref.String() == "" && len(s.AllOf) == 1 && len(s.Properties) == 0 && len(s.Type) == 0 {
+		return &s.AllOf[0]
+	}
+	return s
+}
+
+func (v *openAPIValidator) validate(path string, value interface{}, s *spec.Schema) {
+	s = v.resolve(s)
+	if s == nil || value == nil {
+		return
+	}
+	if p, _ := s.Extensions["x-kubernetes-preserve-unknown-fields"].(bool); p {
+		return
+	}
+
+	typ := ""
+	if len(s.Type) > 0 {
+		typ = s.Type[0]
+	} else if len(s.Properties) > 0 {
+		typ = "object"
+	}
+
+	switch typ {
+	case "object":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			v.errorf(path, "expected object, got %s", jsonTypeName(value))
+			return
+		}
+		for _, req := range s.Required {
+			if _, ok := fields[req]; !ok {
+				v.errorf(jsonPathField(path, req), "required field is missing")
+			}
+		}
+		for key, fv := range fields {
+			fpath := jsonPathField(path, key)
+			if prop, ok := s.Properties[key]; ok {
+				v.validate(fpath, fv, &prop)
+			} else if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+				v.validate(fpath, fv, s.AdditionalProperties.Schema)
+			} else if len(s.Properties) > 0 && (s.AdditionalProperties == nil || !s.AdditionalProperties.Allows) {
+				v.errorf(fpath, "unknown field")
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.errorf(path, "expected array, got %s", jsonTypeName(value))
+			return
+		}
+		if s.Items == nil || s.Items.Schema == nil {
+			return
+		}
+		for i, item := range items {
+			v.validate(fmt.Sprintf("%s[%d]", path, i), item, s.Items.Schema)
+		}
+	case "string":
+		// Be loose about what we accept for 'string', since
+		// IntOrString and Quantity are both "strings"
+		switch value.(type) {
+		case string, int, int64, float64:
+		default:
+			v.errorf(path, "expected string, got %s", jsonTypeName(value))
+		}
+	case "integer":
+		switch n := value.(type) {
+		case int, int64:
+		case float64:
+			if n != float64(int64(n)) {
+				v.errorf(path, "expected integer, got %v", n)
+			}
+		default:
+			v.errorf(path, "expected integer, got %s", jsonTypeName(value))
+		}
+	case "number":
+		switch value.(type) {
+		case int, int64, float64:
+		default:
+			v.errorf(path, "expected number, got %s", jsonTypeName(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.errorf(path, "expected boolean, got %s", jsonTypeName(value))
+		}
+	}
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, float64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const testOpenAPI = `{
+  "swagger": "2.0",
+  "definitions": {
+    "io.k8s.api.apps.v1.Deployment": {
+      "required": ["spec"],
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DeploymentSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "Deployment", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.DeploymentSpec": {
+      "required": ["selector"],
+      "properties": {
+        "replicas": {"type": "integer", "format": "int32"},
+        "paused": {"type": "boolean"},
+        "selector": {"type": "object", "additionalProperties": {"type": "string"}},
+        "template": {"type": "array", "items": {"type": "integer"}},
+        "extra": {"x-kubernetes-preserve-unknown-fields": true}
+      }
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "properties": {
+        "name": {"type": "string"},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}}
+      }
+    }
+  }
+}`
+
+func TestValidateOpenAPI(t *testing.T) {
+	var doc spec.Swagger
+	if err := json.Unmarshal([]byte(testOpenAPI), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	if !SchemaHasKind(&doc, gvk) {
+		t.Errorf("Deployment not found in schema")
+	}
+	if SchemaHasKind(&doc, schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}) {
+		t.Errorf("Unexpected Widget found in schema")
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":   "myapp",
+			"labels": map[string]interface{}{"app.kubernetes.io/name": "myapp"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 2.0,
+			"selector": map[string]interface{}{"app": "myapp"},
+			"template": []interface{}{1.0, 2.0},
+			"extra":    map[string]interface{}{"anything": true},
+		},
+	}}
+	if errs := ValidateOpenAPI(obj, &doc); len(errs) != 0 {
+		t.Errorf("Unexpected errors for valid object: %v", errs)
+	}
+
+	obj.Object["metadata"] = map[string]interface{}{
+		"name":   "myapp",
+		"labels": map[string]interface{}{"ok": []interface{}{}},
+		"bogus":  "x",
+	}
+	obj.Object["spec"] = map[string]interface{}{
+		"replicas": 1.5,
+		"paused":   "yes",
+		"template": []interface{}{1.0, "two"},
+	}
+
+	var got []string
+	for _, err := range ValidateOpenAPI(obj, &doc) {
+		if _, ok := err.(*FieldError); !ok {
+			t.Errorf("Unexpected error type %T", err)
+		}
+		got = append(got, err.Error())
+	}
+	sort.Strings(got)
+	expected := []string{
+		".metadata.bogus: unknown field",
+		".metadata.labels.ok: expected string, got array",
+		".spec.paused: expected boolean, got string",
+		".spec.replicas: expected integer, got 1.5",
+		".spec.selector: required field is missing",
+		".spec.template[1]: expected integer, got string",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected errors %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected error %q, got %q", expected[i], got[i])
+		}
+	}
+}
+func TestJSONPath(t *testing.T) {
+	for _, tc := range []struct {
+		path     []string
+		expected string
+	}{
+		{nil, "."},
+		{[]string{"spec", "replicas"}, ".spec.replicas"},
+		{[]string{"metadata", "labels", "app.kubernetes.io/name"}, ".metadata.labels['app.kubernetes.io/name']"},
+		{[]string{"data", "it's"}, `.data['it\'s']`},
+	} {
+		if got := JSONPath(tc.path); got != tc.expected {
+			t.Errorf("JSONPath(%q) = %q, expected %q", tc.path, got, tc.expected)
+		}
+	}
+}
+
+const patchStrategyOpenAPI = `{
+  "swagger": "2.0",
+  "definitions": {
+    "io.k8s.api.core.v1.Pod": {
+      "properties": {
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.PodSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Pod", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.PodSpec": {
+      "properties": {
+        "containers": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Container"},
+          "x-kubernetes-patch-merge-key": "name",
+          "x-kubernetes-patch-strategy": "merge"
+        },
+        "args": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "io.k8s.api.core.v1.Container": {
+      "properties": {
+        "name": {"type": "string"},
+        "ports": {
+          "type": "array",
+          "items": {"type": "object"},
+          "x-kubernetes-patch-merge-key": "containerPort",
+          "x-kubernetes-patch-strategy": "merge"
+        }
+      }
+    },
+    "io.k8s.networking.gateway.v1.Gateway": {
+      "properties": {
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "gateway.networking.k8s.io", "kind": "Gateway", "version": "v1"}]
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "properties": {
+        "finalizers": {
+          "type": "array",
+          "items": {"type": "string"},
+          "x-kubernetes-patch-strategy": "merge"
+        }
+      }
+    }
+  }
+}`
+
+func TestPatchMergeKeys(t *testing.T) {
+	var doc spec.Swagger
+	if err := json.Unmarshal([]byte(patchStrategyOpenAPI), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := PatchMergeKeys(&doc, schema.GroupVersionKind{Version: "v1", Kind: "Pod"})
+	expected := map[string]string{
+		"spec.containers":       "name",
+		"spec.containers.ports": "containerPort",
+	}
+	if len(keys) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+	for path, key := range expected {
+		if k, ok := keys[path]; !ok || k != key {
+			t.Errorf("Expected %s merge key %q, got %q (%v)", path, key, k, ok)
+		}
+	}
+
+	// A custom resource only has ObjectMeta's metadata
+	if keys := PatchMergeKeys(&doc, schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}); keys != nil {
+		t.Errorf("Unexpected merge keys for custom resource: %v", keys)
+	}
+	if keys := PatchMergeKeys(nil, schema.GroupVersionKind{Version: "v1", Kind: "Pod"}); keys != nil {
+		t.Errorf("Unexpected merge keys without a schema: %v", keys)
+	}
+}
@@ -0,0 +1,509 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Supported Patch types
+const (
+	PatchTypeStrategic = "strategic"
+	PatchTypeJSON6902  = "json6902"
+)
+
+// PatchTarget selects the objects a Patch applies to.  Empty fields
+// match any object.
+type PatchTarget struct {
+	Group         string `json:"group"`
+	Version       string `json:"version"`
+	Kind          string `json:"kind"`
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	LabelSelector string `json:"labelSelector"`
+}
+
+// Patch is a kustomize-style patch, applied to evaluated objects.
+// If Type is empty, it is inferred from Patch: a list of operations
+// is a JSON6902 patch, and an object is a strategic merge patch.  A
+// strategic merge patch without a Target applies to the object named
+// by its own apiVersion, kind and metadata.
+type Patch struct {
+	Target PatchTarget `json:"target"`
+	Type   string      `json:"type"`
+	// Patch may also be a string containing YAML or JSON
+	Patch interface{} `json:"patch"`
+
+	// source is used in error messages
+	source string
+}
+
+// ReadPatches reads the (multi-document) YAML or JSON patch file
+// at path.  Each document is either a Patch, or a bare strategic
+// merge patch.
+func ReadPatches(path string) ([]Patch, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := parseYAMLStream(data, path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Patch, 0, len(docs))
+	for i, doc := range docs {
+		p := Patch{source: fmt.Sprintf("%s[%d]", path, i)}
+		if m, ok := doc.(map[string]interface{}); ok && m["patch"] == nil {
+			// A bare strategic merge patch, as in kustomize's
+			// patchesStrategicMerge
+			p.Patch = doc
+		} else {
+			buf, err := json.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(buf, &p); err != nil {
+				return nil, fmt.Errorf("Error parsing patch %s: %v", p.source, err)
+			}
+		}
+		if err := p.normalise(); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// normalise parses string patches, and infers Type and Target
+func (p *Patch) normalise() error {
+	if p.source == "" {
+		p.source = "(inline)"
+	}
+	if s, ok := p.Patch.(string); ok {
+		if err := yaml.NewYAMLOrJSONDecoder(strings.NewReader(s), len(s)).Decode(&p.Patch); err != nil {
+			return fmt.Errorf("Error parsing patch %s: %v", p.source, err)
+		}
+	}
+
+	_, isList := p.Patch.([]interface{})
+	patchObj, isMap := p.Patch.(map[string]interface{})
+	if p.Type == "" {
+		if isList {
+			p.Type = PatchTypeJSON6902
+		} else {
+			p.Type = PatchTypeStrategic
+		}
+	}
+
+	switch p.Type {
+	case PatchTypeJSON6902:
+		if !isList {
+			return fmt.Errorf("Patch %s: a %s patch must be a list of operations", p.source, p.Type)
+		}
+	case PatchTypeStrategic:
+		if !isMap {
+			return fmt.Errorf("Patch %s: a %s patch must be an object", p.source, p.Type)
+		}
+		if p.Target == (PatchTarget{}) {
+			u := unstructured.Unstructured{Object: patchObj}
+			gvk := u.GroupVersionKind()
+			p.Target = PatchTarget{
+				Group:     gvk.Group,
+				Version:   gvk.Version,
+				Kind:      gvk.Kind,
+				Namespace: u.GetNamespace(),
+				Name:      u.GetName(),
+			}
+		}
+	default:
+		return fmt.Errorf("Patch %s: unknown patch type %q", p.source, p.Type)
+	}
+
+	if p.Target == (PatchTarget{}) {
+		return fmt.Errorf("Patch %s has no target", p.source)
+	}
+	return nil
+}
+
+// Matches returns true if obj is selected by t
+func (t PatchTarget) Matches(obj *unstructured.Unstructured) (bool, error) {
+	gvk := obj.GroupVersionKind()
+	for _, f := range []struct{ want, got string }{
+		{t.Group, gvk.Group},
+		{t.Version, gvk.Version},
+		{t.Kind, gvk.Kind},
+		{t.Namespace, obj.GetNamespace()},
+		{t.Name, obj.GetName()},
+	} {
+		if f.want != "" && f.want != f.got {
+			return false, nil
+		}
+	}
+	if t.LabelSelector != "" {
+		selector, err := labels.Parse(t.LabelSelector)
+		if err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(obj.GetLabels())) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ApplyPatches modifies objs in place, applying each patch to the
+// objects selected by its target.  It is an error for a patch to
+// select no objects.
+func ApplyPatches(patches []Patch, objs []*unstructured.Unstructured) error {
+	for _, p := range patches {
+		if err := p.normalise(); err != nil {
+			return err
+		}
+		matched := 0
+		for _, obj := range objs {
+			ok, err := p.Target.Matches(obj)
+			if err != nil {
+				return fmt.Errorf("Patch %s: %v", p.source, err)
+			}
+			if !ok {
+				continue
+			}
+			log.Debugf("Applying %s patch %s to %s", p.Type, p.source, FqName(obj))
+			matched++
+
+			switch p.Type {
+			case PatchTypeStrategic:
+				obj.Object = strategicMerge(obj.Object, p.Patch).(map[string]interface{})
+			case PatchTypeJSON6902:
+				if err := applyJSON6902(obj.Object, p.Patch.([]interface{})); err != nil {
+					return fmt.Errorf("Error applying patch %s to %s: %v", p.source, FqName(obj), err)
+				}
+			}
+		}
+		if matched == 0 {
+			return fmt.Errorf("Patch %s matched no objects", p.source)
+		}
+	}
+	return nil
+}
+
+// mergeKeys are the fields used to identify list members in a
+// strategic merge, in order of preference.  The first field present
+// in every member of both lists is used.
+var mergeKeys = []string{"name", "mountPath", "devicePath", "containerPort"}
+
+// strategicMerge returns orig with patch merged into it.  Without
+// schema information, lists are merged when their members can be
+// identified by one of mergeKeys, and replaced otherwise.  A null
+// value deletes a field, and a list member containing
+// `$patch: delete` deletes the matching member.
+func strategicMerge(orig, patch interface{}) interface{} {
+	switch p := patch.(type) {
+	case map[string]interface{}:
+		o, ok := orig.(map[string]interface{})
+		if !ok || p["$patch"] == "replace" {
+			return withoutDirectives(p)
+		}
+		for k, v := range p {
+			if k == "$patch" {
+				continue
+			}
+			if v == nil {
+				delete(o, k)
+				continue
+			}
+			o[k] = strategicMerge(o[k], v)
+		}
+		return o
+	case []interface{}:
+		o, ok := orig.([]interface{})
+		key := listMergeKey(o, p)
+		if !ok || key == "" {
+			return withoutDirectives(p)
+		}
+		for _, item := range p {
+			m := item.(map[string]interface{})
+			i := indexByKey(o, key, m[key])
+			switch {
+			case m["$patch"] == "delete":
+				if i >= 0 {
+					o = append(o[:i], o[i+1:]...)
+				}
+			case i >= 0:
+				o[i] = strategicMerge(o[i], m)
+			default:
+				o = append(o, withoutDirectives(m))
+			}
+		}
+		return o
+	default:
+		return p
+	}
+}
+
+func listMergeKey(orig, patch []interface{}) string {
+	if len(patch) == 0 {
+		return ""
+	}
+outer:
+	for _, key := range mergeKeys {
+		for _, list := range [][]interface{}{orig, patch} {
+			for _, item := range list {
+				m, ok := item.(map[string]interface{})
+				if !ok {
+					return ""
+				}
+				if _, ok := m[key]; !ok {
+					continue outer
+				}
+			}
+		}
+		return key
+	}
+	return ""
+}
+
+func indexByKey(list []interface{}, key string, value interface{}) int {
+	for i, item := range list {
+		if m, ok := item.(map[string]interface{}); ok && reflect.DeepEqual(m[key], value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// withoutDirectives returns a deep copy of v, without any `$patch`
+// directives
+func withoutDirectives(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, v2 := range t {
+			if k != "$patch" {
+				ret[k] = withoutDirectives(v2)
+			}
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i, v2 := range t {
+			ret[i] = withoutDirectives(v2)
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
+// applyJSON6902 applies the RFC 6902 JSON Patch ops to doc
+func applyJSON6902(doc map[string]interface{}, ops []interface{}) error {
+	for _, o := range ops {
+		op, ok := o.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Bad patch operation %v", o)
+		}
+		name, _ := op["op"].(string)
+		path, err := parsePointer(op["path"])
+		if err != nil {
+			return err
+		}
+		value := withoutDirectives(op["value"])
+
+		switch name {
+		case "add":
+			err = pointerUpdate(doc, path, addValue(value))
+		case "remove":
+			err = pointerUpdate(doc, path, removeValue)
+		case "replace":
+			err = pointerUpdate(doc, path, replaceValue(value))
+		case "test":
+			var got interface{}
+			got, err = pointerGet(doc, path)
+			if err == nil && !reflect.DeepEqual(got, value) {
+				err = fmt.Errorf("test failed at %v: %v != %v", op["path"], got, value)
+			}
+		case "move", "copy":
+			var from []string
+			from, err = parsePointer(op["from"])
+			if err != nil {
+				return err
+			}
+			value, err = pointerGet(doc, from)
+			if err != nil {
+				return err
+			}
+			if name == "move" {
+				err = pointerUpdate(doc, from, removeValue)
+			} else {
+				value = withoutDirectives(value)
+			}
+			if err == nil {
+				err = pointerUpdate(doc, path, addValue(value))
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its tokens
+func parsePointer(v interface{}) ([]string, error) {
+	s, ok := v.(string)
+	if !ok || !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("bad JSON pointer %v", v)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func listIndex(list []interface{}, token string, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return len(list), nil
+	}
+	i, err := strconv.Atoi(token)
+	max := len(list) - 1
+	if allowEnd {
+		max = len(list)
+	}
+	if err != nil || i < 0 || i > max {
+		return 0, fmt.Errorf("bad list index %q", token)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch t := doc.(type) {
+		case map[string]interface{}:
+			v, ok := t[token]
+			if !ok {
+				return nil, fmt.Errorf("no field %q", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := listIndex(t, token, false)
+			if err != nil {
+				return nil, err
+			}
+			doc = t[i]
+		default:
+			return nil, fmt.Errorf("cannot index %T with %q", doc, token)
+		}
+	}
+	return doc, nil
+}
+
+// containerOp modifies the member key of container, and returns the
+// new container
+type containerOp func(container interface{}, key string) (interface{}, error)
+
+// pointerUpdate applies op to the final token of path
+func pointerUpdate(doc interface{}, path []string, op containerOp) error {
+	_, err := pointerUpdateAt(doc, path, op)
+	return err
+}
+
+func pointerUpdateAt(node interface{}, path []string, op containerOp) (interface{}, error) {
+	if len(path) == 1 {
+		return op(node, path[0])
+	}
+	child, err := pointerGet(node, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = pointerUpdateAt(child, path[1:], op)
+	if err != nil {
+		return nil, err
+	}
+	return replaceValue(child)(node, path[0])
+}
+
+func addValue(value interface{}) containerOp {
+	return func(container interface{}, key string) (interface{}, error) {
+		switch t := container.(type) {
+		case map[string]interface{}:
+			t[key] = value
+			return t, nil
+		case []interface{}:
+			i, err := listIndex(t, key, true)
+			if err != nil {
+				return nil, err
+			}
+			t = append(t, nil)
+			copy(t[i+1:], t[i:])
+			t[i] = value
+			return t, nil
+		}
+		return nil, fmt.Errorf("cannot add %q to %T", key, container)
+	}
+}
+
+func replaceValue(value interface{}) containerOp {
+	return func(container interface{}, key string) (interface{}, error) {
+		switch t := container.(type) {
+		case map[string]interface{}:
+			if _, ok := t[key]; !ok {
+				return nil, fmt.Errorf("no field %q", key)
+			}
+			t[key] = value
+			return t, nil
+		case []interface{}:
+			i, err := listIndex(t, key, false)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = value
+			return t, nil
+		}
+		return nil, fmt.Errorf("cannot replace %q in %T", key, container)
+	}
+}
+
+func removeValue(container interface{}, key string) (interface{}, error) {
+	switch t := container.(type) {
+	case map[string]interface{}:
+		if _, ok := t[key]; !ok {
+			return nil, fmt.Errorf("no field %q", key)
+		}
+		delete(t, key)
+		return t, nil
+	case []interface{}:
+		i, err := listIndex(t, key, false)
+		if err != nil {
+			return nil, err
+		}
+		return append(t[:i], t[i+1:]...), nil
+	}
+	return nil, fmt.Errorf("cannot remove %q from %T", key, container)
+}
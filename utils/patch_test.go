@@ -0,0 +1,176 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testPatches = `
+# Strategic merge, target inferred from the patch itself
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: envoy:1.5
+      - name: web
+        env:
+        - name: DEBUG
+          value: "1"
+---
+target:
+  kind: Deployment
+  labelSelector: tier=frontend
+patch: |
+  - op: replace
+    path: /spec/replicas
+    value: 5
+  - op: add
+    path: /metadata/labels/patched
+    value: "true"
+`
+
+func TestApplyPatches(t *testing.T) {
+	newObj := func(kind, name, tier string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		err := json.Unmarshal([]byte(`{
+  "spec": {
+    "replicas": 1,
+    "template": {"spec": {"containers": [{"name": "web", "image": "nginx"}]}}
+  }
+}`), &obj.Object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj.SetAPIVersion("apps/v1")
+		obj.SetKind(kind)
+		obj.SetName(name)
+		obj.SetLabels(map[string]string{"tier": tier})
+		return obj
+	}
+	objs := []*unstructured.Unstructured{
+		newObj("Deployment", "web", "frontend"),
+		newObj("Deployment", "api", "backend"),
+		newObj("StatefulSet", "web", "frontend"),
+	}
+
+	f, err := ioutil.TempFile("", "patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testPatches)
+	f.Close()
+
+	patches, err := ReadPatches(f.Name())
+	if err != nil {
+		t.Fatalf("ReadPatches failed: %v", err)
+	}
+	if len(patches) != 2 || patches[0].Type != PatchTypeStrategic || patches[1].Type != PatchTypeJSON6902 {
+		t.Fatalf("Unexpected patches %v", patches)
+	}
+	if err := ApplyPatches(patches, objs); err != nil {
+		t.Fatalf("ApplyPatches failed: %v", err)
+	}
+
+	containers := func(obj *unstructured.Unstructured) interface{} {
+		return obj.Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"]
+	}
+	replicas := func(obj *unstructured.Unstructured) interface{} {
+		return obj.Object["spec"].(map[string]interface{})["replicas"]
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{
+			"name":  "web",
+			"image": "nginx",
+			"env":   []interface{}{map[string]interface{}{"name": "DEBUG", "value": "1"}},
+		},
+		map[string]interface{}{"name": "sidecar", "image": "envoy:1.5"},
+	}
+	if c := containers(objs[0]); !reflect.DeepEqual(c, expected) {
+		t.Errorf("Unexpected containers after strategic merge: %v", c)
+	}
+	if r := replicas(objs[0]); r != 5.0 {
+		t.Errorf("Expected replicas to be replaced, got %v", r)
+	}
+	if objs[0].GetLabels()["patched"] != "true" {
+		t.Errorf("Expected label to be added, got %v", objs[0].GetLabels())
+	}
+
+	// Not selected
+	for _, obj := range objs[1:] {
+		if c := containers(obj).([]interface{}); len(c) != 1 {
+			t.Errorf("%s %s was patched: %v", obj.GetKind(), obj.GetName(), c)
+		}
+		if r := replicas(obj); r != 1.0 {
+			t.Errorf("%s %s was patched: replicas %v", obj.GetKind(), obj.GetName(), r)
+		}
+	}
+
+	// Patches must select something
+	err = ApplyPatches([]Patch{{Target: PatchTarget{Name: "missing"}, Patch: map[string]interface{}{}}}, objs)
+	if err == nil {
+		t.Errorf("Patch with no matching objects was accepted")
+	}
+}
+
+func TestJSON6902(t *testing.T) {
+	doc := map[string]interface{}{}
+	json.Unmarshal([]byte(`{"a": {"b~c": [1, 2, 3]}, "d": "x"}`), &doc)
+
+	ops := []interface{}{}
+	json.Unmarshal([]byte(`[
+  {"op": "test", "path": "/d", "value": "x"},
+  {"op": "add", "path": "/a/b~0c/1", "value": 9},
+  {"op": "add", "path": "/a/b~0c/-", "value": 4},
+  {"op": "remove", "path": "/a/b~0c/0"},
+  {"op": "copy", "from": "/d", "path": "/e"},
+  {"op": "move", "from": "/d", "path": "/a~1f"}
+]`), &ops)
+
+	if err := applyJSON6902(doc, ops); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{}
+	json.Unmarshal([]byte(`{"a": {"b~c": [9, 2, 3, 4]}, "e": "x", "a/f": "x"}`), &expected)
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Expected %v, got %v", expected, doc)
+	}
+
+	for _, bad := range []string{
+		`[{"op": "replace", "path": "/missing", "value": 1}]`,
+		`[{"op": "remove", "path": "/a/b~0c/10"}]`,
+		`[{"op": "test", "path": "/e", "value": "y"}]`,
+		`[{"op": "frobnicate", "path": "/e"}]`,
+	} {
+		json.Unmarshal([]byte(bad), &ops)
+		if err := applyJSON6902(doc, ops); err == nil {
+			t.Errorf("%s succeeded", bad)
+		}
+	}
+}
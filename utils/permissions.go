@@ -0,0 +1,141 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+var selfSubjectAccessReviewGvk = schema.GroupVersionKind{Group: "authorization.k8s.io", Version: "v1", Kind: "SelfSubjectAccessReview"}
+
+// ResourceVerbs returns the resource that serves gvk, and the verbs
+// that resource supports according to discovery.  Older servers
+// don't report verbs, in which case the list is empty.
+func ResourceVerbs(disco discovery.ServerResourcesInterface, gvk schema.GroupVersionKind) (schema.GroupResource, []string, error) {
+	rsrc, err := serverResourceForGroupVersionKind(disco, gvk)
+	if err != nil {
+		return schema.GroupResource{}, nil, err
+	}
+	return schema.GroupResource{Group: gvk.Group, Resource: rsrc.Name}, rsrc.Verbs, nil
+}
+
+// PermissionError is returned when the user may not perform Verb on
+// Resource
+type PermissionError struct {
+	Verb      string
+	Resource  schema.GroupResource
+	Namespace string
+	// Reason is the authorizer's explanation, if any
+	Reason string
+}
+
+func (e *PermissionError) Error() string {
+	msg := fmt.Sprintf("you lack %s on %s", e.Verb, e.Resource.String())
+	if e.Namespace != "" {
+		msg += " in namespace " + e.Namespace
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// IsPermissionError returns true if err is a PermissionError
+func IsPermissionError(err error) bool {
+	_, ok := err.(*PermissionError)
+	return ok
+}
+
+// CanI returns nil if the server supports verb on gvk's resource,
+// according to discovery, and a PermissionError otherwise.  Servers
+// that don't report verbs are assumed to support everything.
+func CanI(disco discovery.ServerResourcesInterface, gvk schema.GroupVersionKind, verb string) error {
+	gr, verbs, err := ResourceVerbs(disco, gvk)
+	if err != nil {
+		return err
+	}
+	if len(verbs) == 0 {
+		log.Debugf("No verbs reported for %s, assuming %s is supported", gr.String(), verb)
+		return nil
+	}
+	for _, v := range verbs {
+		if v == verb {
+			return nil
+		}
+	}
+	return &PermissionError{Verb: verb, Resource: gr, Reason: "not supported by the server"}
+}
+
+// CanIAccess is CanI, and then also asks the server whether the
+// current user may perform verb on gvk's resource in namespace
+// (empty for cluster-scoped resources, or all namespaces), using a
+// SelfSubjectAccessReview.
+func CanIAccess(pool dynamic.ClientPool, disco discovery.ServerResourcesInterface, gvk schema.GroupVersionKind, namespace, verb string) error {
+	if err := CanI(disco, gvk, verb); err != nil {
+		return err
+	}
+	gr, _, err := ResourceVerbs(disco, gvk)
+	if err != nil {
+		return err
+	}
+
+	client, err := pool.ClientForGroupVersionKind(selfSubjectAccessReviewGvk)
+	if err != nil {
+		return err
+	}
+	rc := client.Resource(&metav1.APIResource{Name: "selfsubjectaccessreviews", Kind: selfSubjectAccessReviewGvk.Kind}, metav1.NamespaceNone)
+
+	attrs := map[string]interface{}{
+		"group":    gr.Group,
+		"resource": gr.Resource,
+		"verb":     verb,
+	}
+	if parts := strings.SplitN(gr.Resource, "/", 2); len(parts) == 2 {
+		attrs["resource"] = parts[0]
+		attrs["subresource"] = parts[1]
+	}
+	if namespace != "" {
+		attrs["namespace"] = namespace
+	}
+	review := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": selfSubjectAccessReviewGvk.GroupVersion().String(),
+			"kind":       selfSubjectAccessReviewGvk.Kind,
+			"spec": map[string]interface{}{
+				"resourceAttributes": attrs,
+			},
+		},
+	}
+	result, err := rc.Create(review)
+	if err != nil {
+		return fmt.Errorf("Error checking %s permission on %s: %v", verb, gr.String(), err)
+	}
+
+	status, _ := result.Object["status"].(map[string]interface{})
+	if allowed, _ := status["allowed"].(bool); allowed {
+		return nil
+	}
+	reason, _ := status["reason"].(string)
+	return &PermissionError{Verb: verb, Resource: gr, Namespace: namespace, Reason: reason}
+}
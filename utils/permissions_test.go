@@ -0,0 +1,121 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func permissionsTestDiscovery() *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{Fake: &ktesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "apps/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: []string{"create", "get", "list", "patch"}},
+					{Name: "deployments/scale", Kind: "Scale", Namespaced: true, Verbs: []string{"get", "patch"}},
+				},
+			},
+			{
+				GroupVersion: "tests/v1alpha1",
+				APIResources: []metav1.APIResource{
+					{Name: "tests", Kind: "Test", Namespaced: true},
+				},
+			},
+		},
+	}}
+}
+
+func TestCanI(t *testing.T) {
+	disco := permissionsTestDiscovery()
+	deploy := schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"}
+
+	gr, verbs, err := ResourceVerbs(disco, deploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gr.String() != "deployments.apps" || fmt.Sprint(verbs) != "[create get list patch]" {
+		t.Errorf("Unexpected resource %s with verbs %v", gr, verbs)
+	}
+
+	if err := CanI(disco, deploy, "patch"); err != nil {
+		t.Errorf("Unexpected error for patch: %v", err)
+	}
+	err = CanI(disco, deploy, "delete")
+	if !IsPermissionError(err) || err.Error() != "you lack delete on deployments.apps: not supported by the server" {
+		t.Errorf("Unexpected error for delete: %v", err)
+	}
+	if err := CanI(disco, schema.GroupVersionKind{Group: "tests", Version: "v1alpha1", Kind: "Test"}, "delete"); err != nil {
+		t.Errorf("Resource without verbs should allow everything, got %v", err)
+	}
+	if err := CanI(disco, schema.GroupVersionKind{Group: "tests", Version: "v1alpha1", Kind: "Missing"}, "get"); !IsResourceNotFound(err) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+}
+
+func TestCanIAccess(t *testing.T) {
+	var reviews []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" || r.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" {
+			t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var review map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			t.Error(err)
+		}
+		reviews = append(reviews, review)
+		attrs := review["spec"].(map[string]interface{})["resourceAttributes"].(map[string]interface{})
+		allowed := attrs["namespace"] == "mine"
+		review["status"] = map[string]interface{}{"allowed": allowed, "reason": "RBAC says so"}
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer srv.Close()
+
+	pool := dynamic.NewDynamicClientPool(&rest.Config{Host: srv.URL})
+	disco := permissionsTestDiscovery()
+	deploy := schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"}
+
+	if err := CanIAccess(pool, disco, deploy, "mine", "patch"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	err := CanIAccess(pool, disco, deploy, "theirs", "patch")
+	if !IsPermissionError(err) || err.Error() != "you lack patch on deployments.apps in namespace theirs: RBAC says so" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CanIAccess(pool, disco, deploy, "mine", "delete"); !IsPermissionError(err) || len(reviews) != 2 {
+		t.Errorf("Unsupported verb should fail without a review, got %v after %d reviews", err, len(reviews))
+	}
+
+	attrs := reviews[0]["spec"].(map[string]interface{})["resourceAttributes"]
+	expected := map[string]interface{}{"group": "apps", "resource": "deployments", "verb": "patch", "namespace": "mine"}
+	if fmt.Sprint(attrs) != fmt.Sprint(expected) {
+		t.Errorf("Expected attributes %v, got %v", expected, attrs)
+	}
+}
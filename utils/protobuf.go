@@ -0,0 +1,133 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// ContentTypeProtobuf is the protobuf encoding of built-in
+// Kubernetes types
+const ContentTypeProtobuf = "application/vnd.kubernetes.protobuf"
+
+// NewProtobufTransport returns a RoundTripper that asks for list
+// responses of built-in kinds in protobuf, which is much smaller than
+// JSON for large lists.  Protobuf responses are converted back to
+// JSON for the (JSON-only) dynamic client.  Kinds not known to
+// client-go (including all custom resources), gets, watches and
+// subresources are left as JSON.
+//
+// Responses are decoded into client-go's compiled-in types, so
+// fields that client-go doesn't know about are silently lost.  Only
+// use this for lists where nothing but object metadata is read (eg:
+// garbage collection).
+//
+// NB: responses are already gzip-compressed in transit, by
+// net/http's default Transport.
+func NewProtobufTransport(rt http.RoundTripper) http.RoundTripper {
+	return &protobufTransport{
+		Transport:  rt,
+		serializer: protobuf.NewSerializer(scheme.Scheme, scheme.Scheme, ContentTypeProtobuf),
+	}
+}
+
+type protobufTransport struct {
+	Transport  http.RoundTripper
+	serializer *protobuf.Serializer
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *protobufTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || !acceptsProtobuf(req) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	req2 := *req
+	req2.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		req2.Header[k] = v
+	}
+	req2.Header.Set("Accept", ContentTypeProtobuf+", application/json")
+
+	resp, err := t.Transport.RoundTrip(&req2)
+	if err != nil || !strings.HasPrefix(resp.Header.Get("Content-Type"), ContentTypeProtobuf) {
+		return resp, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	obj, gvk, err := t.serializer.Decode(data, nil, nil)
+	if err != nil {
+		// Probably a kind newer than client-go
+		log.Debugf("Unable to decode protobuf response from %s, retrying as JSON: %v", req.URL.Path, err)
+		return t.Transport.RoundTrip(req)
+	}
+	obj.GetObjectKind().SetGroupVersionKind(*gvk)
+	data, err = json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Converted protobuf %s response to %d bytes of JSON", gvk, len(data))
+
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	resp.ContentLength = int64(len(data))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// acceptsProtobuf returns true if req is a list (not watch) of a
+// resource with a group version known to client-go.
+func acceptsProtobuf(req *http.Request) bool {
+	if w := req.URL.Query().Get("watch"); w == "true" || w == "1" {
+		return false
+	}
+
+	// /api/v1/..., or /apis/group/version/...
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	var gv schema.GroupVersion
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		gv, parts = schema.GroupVersion{Version: parts[1]}, parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		gv, parts = schema.GroupVersion{Group: parts[1], Version: parts[2]}, parts[3:]
+	default:
+		return false
+	}
+	if len(scheme.Scheme.KnownTypes(gv)) == 0 {
+		return false
+	}
+
+	// Leaves just the resource for a list.  Anything longer is
+	// a get, or a subresource.
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	return len(parts) == 1
+}
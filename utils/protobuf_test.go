@@ -0,0 +1,133 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestProtobufTransport(t *testing.T) {
+	serializer := protobuf.NewSerializer(scheme.Scheme, scheme.Scheme, ContentTypeProtobuf)
+	protobufServed := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), ContentTypeProtobuf) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"apiVersion":"example.com/v1","kind":"WidgetList","items":[{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"name":"json"}}]}`)
+			return
+		}
+		if r.URL.Path == "/api/v1/namespaces/default/secrets" {
+			// Not decodable, eg: a kind newer than client-go
+			w.Header().Set("Content-Type", ContentTypeProtobuf)
+			fmt.Fprint(w, "garbage")
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/default/configmaps" {
+			t.Errorf("Unexpected protobuf request for %s", r.URL)
+		}
+		protobufServed++
+		list := &v1.ConfigMapList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMapList"}, Items: []v1.ConfigMap{
+			{ObjectMeta: metav1.ObjectMeta{Name: "one", Namespace: "default"}, Data: map[string]string{"foo": "bar"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "two", Namespace: "default"}},
+		}}
+		w.Header().Set("Content-Type", ContentTypeProtobuf)
+		if err := serializer.Encode(list, w); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	pool := dynamic.NewDynamicClientPool(&rest.Config{
+		Host:          srv.URL,
+		WrapTransport: NewProtobufTransport,
+	})
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	client, err := pool.ClientForGroupVersionKind(gvk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := client.Resource(&metav1.APIResource{Name: "configmaps", Namespaced: true}, "default").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ulist := list.(*unstructured.UnstructuredList)
+	if protobufServed != 1 || len(ulist.Items) != 2 {
+		t.Fatalf("Unexpected list (%d protobuf responses): %v", protobufServed, ulist)
+	}
+	cm := ulist.Items[0]
+	if cm.GetKind() != "ConfigMap" || cm.GetName() != "one" || cm.Object["data"].(map[string]interface{})["foo"] != "bar" {
+		t.Errorf("Unexpected item %v", cm.Object)
+	}
+
+	list, err = client.Resource(&metav1.APIResource{Name: "secrets", Namespaced: true}, "default").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := list.(*unstructured.UnstructuredList).Items; len(items) != 1 || items[0].GetName() != "json" {
+		t.Errorf("Undecodable protobuf response was not retried as JSON: %v", items)
+	}
+
+	client, err = pool.ClientForGroupVersionKind(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err = client.Resource(&metav1.APIResource{Name: "widgets", Namespaced: true}, "default").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := list.(*unstructured.UnstructuredList).Items; len(items) != 1 || items[0].GetName() != "json" {
+		t.Errorf("Unexpected custom resource list %v", items)
+	}
+}
+
+func TestAcceptsProtobuf(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"/api/v1/namespaces/default/configmaps":            true,
+		"/api/v1/namespaces/default/configmaps/foo":        false,
+		"/api/v1/namespaces/default":                       false,
+		"/api/v1/namespaces":                               true,
+		"/api/v1/nodes":                                    true,
+		"/apis/extensions/v1beta1/deployments":             true,
+		"/api/v1/namespaces/default/configmaps?watch=true": false,
+		"/api/v1/namespaces/default/pods/foo/log":          false,
+		"/apis/example.com/v1/namespaces/default/widgets":  false,
+		"/api/v1":  false,
+		"/apis":    false,
+		"/version": false,
+	} {
+		u, err := url.Parse(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := acceptsProtobuf(&http.Request{Method: "GET", URL: u}); got != expected {
+			t.Errorf("acceptsProtobuf(%s) returned %v, expected %v", path, got, expected)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+// ManifestFetcher downloads remote manifests on behalf of the
+// importManifest native function.  Responses are cached for the
+// lifetime of the fetcher.
+type ManifestFetcher struct {
+	Client *http.Client
+	// AllowUnpinned permits fetches over plain http, or without an
+	// expected sha256 digest.
+	AllowUnpinned bool
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// NewManifestFetcher returns a ManifestFetcher using the given
+// http.Client
+func NewManifestFetcher(client *http.Client) *ManifestFetcher {
+	return &ManifestFetcher{
+		Client: client,
+		cache:  map[string][]byte{},
+	}
+}
+
+// maxRedirects is the number of redirects followed by a client from
+// NewFetchClient
+const maxRedirects = 5
+
+// MaxFetchSize is the largest response a ManifestFetcher accepts
+var MaxFetchSize int64 = 64 << 20
+
+// NewFetchClient returns an http.Client for a ManifestFetcher, which
+// gives up after timeout (zero means no limit), or maxRedirects
+// redirects.
+func NewFetchClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("Stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// Fetch returns the contents of rawurl.  If digest is non-empty, the
+// (hex-encoded) sha256 sum of the contents must match.
+func (f *ManifestFetcher) Fetch(rawurl, digest string) ([]byte, error) {
+	return f.fetch(rawurl, digest, !f.AllowUnpinned)
+}
+
+// FetchInput returns the contents of rawurl, for use as a
+// manifest input.  rawurl may end with a "#sha256=<hex>" fragment,
+// which the contents must match.  Unlike Fetch, the digest is
+// optional.
+func (f *ManifestFetcher) FetchInput(rawurl string) ([]byte, error) {
+	digest := ""
+	if i := strings.LastIndex(rawurl, "#"); i >= 0 {
+		fragment := rawurl[i+1:]
+		if !strings.HasPrefix(fragment, "sha256=") && !strings.HasPrefix(fragment, "sha256:") {
+			return nil, fmt.Errorf("Unsupported URL fragment %q in %s, expected sha256=<digest>", fragment, rawurl)
+		}
+		rawurl, digest = rawurl[:i], fragment[len("sha256="):]
+	}
+	return f.fetch(rawurl, digest, false)
+}
+
+// IsRemoteInput returns true if path is an http(s) URL, rather than
+// a local file
+func IsRemoteInput(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// client returns f.Client, except that it also refuses redirects
+// from https to plain http, unless AllowUnpinned is set.
+func (f *ManifestFetcher) client() *http.Client {
+	if f.AllowUnpinned {
+		return f.Client
+	}
+	client := *f.Client
+	checkRedirect := f.Client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("Refusing to follow redirect from %s to insecure %s", via[len(via)-1].URL, req.URL)
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			// http.Client's default policy
+			return fmt.Errorf("Stopped after %d redirects", len(via))
+		}
+		return nil
+	}
+	return &client
+}
+
+func (f *ManifestFetcher) fetch(rawurl, digest string, requireDigest bool) ([]byte, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !f.AllowUnpinned {
+			return nil, fmt.Errorf("Refusing to fetch %s over insecure http", rawurl)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported URL scheme %q in %s", u.Scheme, rawurl)
+	}
+	if digest == "" && requireDigest {
+		return nil, fmt.Errorf("Refusing to fetch %s without a sha256 digest", rawurl)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.cache[rawurl]
+	if !ok {
+		log.Debugf("Fetching %s", rawurl)
+		resp, err := f.client().Get(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Error fetching %s: %s", rawurl, resp.Status)
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, MaxFetchSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > MaxFetchSize {
+			return nil, fmt.Errorf("Error fetching %s: response is larger than %d bytes", rawurl, MaxFetchSize)
+		}
+		f.cache[rawurl] = data
+	}
+
+	if digest != "" {
+		sum := sha256.Sum256(data)
+		actual := hex.EncodeToString(sum[:])
+		if !strings.EqualFold(strings.TrimPrefix(digest, "sha256:"), actual) {
+			return nil, fmt.Errorf("Digest mismatch for %s: expected %s, got sha256:%s", rawurl, digest, actual)
+		}
+	}
+
+	return data, nil
+}
+
+// ImportManifest fetches rawurl and parses it as a YAML (or JSON)
+// stream.  Empty documents are omitted.
+func (f *ManifestFetcher) ImportManifest(rawurl, digest string) ([]interface{}, error) {
+	data, err := f.Fetch(rawurl, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseYAMLStream(data, rawurl)
+}
+
+// parseYAMLStream parses a YAML (or JSON) stream, omitting empty
+// documents.  name is used in error messages.
+func parseYAMLStream(data []byte, name string) ([]interface{}, error) {
+	ret := []interface{}{}
+	d := yaml.NewYAMLToJSONDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		if err := d.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("Error parsing %s: %v", name, err)
+		}
+		if doc != nil {
+			ret = append(ret, doc)
+		}
+	}
+	return ret, nil
+}
+
+// RegisterRemoteFuncs adds the native jsonnet functions that fetch
+// remote content to the provided VM
+func RegisterRemoteFuncs(vm *jsonnet.VM, fetcher *ManifestFetcher) {
+	vm.NativeCallback("importManifest", []string{"url", "sha256"}, fetcher.ImportManifest)
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+const remoteManifest = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: first
+---
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: second
+`
+
+func TestImportManifest(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprint(w, remoteManifest)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(remoteManifest))
+	digest := hex.EncodeToString(sum[:])
+
+	fetcher := NewManifestFetcher(srv.Client())
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	RegisterRemoteFuncs(vm, fetcher)
+
+	tmpdir, err := ioutil.TempDir("", "importmanifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "test.jsonnet")
+	src := fmt.Sprintf(`{
+  bundle: std.native("importManifest")(%q, %q),
+  again: std.native("importManifest")(%q, %q),
+}`, srv.URL+"/bundle.yaml", digest, srv.URL+"/bundle.yaml", digest)
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := Read(vm, path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	names := []string{}
+	for _, o := range FlattenToV1(objs) {
+		names = append(names, o.GetName())
+	}
+	counts := map[string]int{}
+	for _, n := range names {
+		counts[n]++
+	}
+	if len(names) != 4 || counts["first"] != 2 || counts["second"] != 2 {
+		t.Errorf("Unexpected objects: %v", names)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected 1 fetch, got %d", fetches)
+	}
+
+	// Wrong digest
+	if _, err := fetcher.ImportManifest(srv.URL+"/bundle.yaml", "sha256:0000"); err == nil {
+		t.Errorf("digest mismatch was accepted")
+	}
+
+	// Unpinned
+	if _, err := fetcher.ImportManifest(srv.URL+"/other.yaml", ""); err == nil {
+		t.Errorf("unpinned fetch was accepted")
+	}
+
+	// Insecure
+	if _, err := fetcher.ImportManifest("http://example.com/bundle.yaml", digest); err == nil {
+		t.Errorf("insecure fetch was accepted")
+	}
+
+	fetcher.AllowUnpinned = true
+	if objs, err := fetcher.ImportManifest(srv.URL+"/other.yaml", ""); err != nil {
+		t.Errorf("unpinned fetch failed: %v", err)
+	} else if len(objs) != 2 {
+		t.Errorf("Unexpected objects: %v", objs)
+	}
+}
+
+func TestFetchRedirect(t *testing.T) {
+	insecure := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, remoteManifest)
+	}))
+	defer insecure.Close()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/downgrade":
+			http.Redirect(w, r, insecure.URL+"/bundle.yaml", http.StatusFound)
+		case "/moved":
+			http.Redirect(w, r, "/bundle.yaml", http.StatusFound)
+		case "/large":
+			fmt.Fprint(w, strings.Repeat("x", 100))
+		default:
+			fmt.Fprint(w, remoteManifest)
+		}
+	}))
+	defer srv.Close()
+
+	fetcher := NewManifestFetcher(srv.Client())
+	if _, err := fetcher.FetchInput(srv.URL + "/moved"); err != nil {
+		t.Errorf("https redirect failed: %v", err)
+	}
+	if _, err := fetcher.FetchInput(srv.URL + "/downgrade"); err == nil {
+		t.Errorf("redirect to insecure http was followed")
+	}
+	unpinned := NewManifestFetcher(srv.Client())
+	unpinned.AllowUnpinned = true
+	if _, err := unpinned.FetchInput(srv.URL + "/downgrade"); err != nil {
+		t.Errorf("redirect to http with AllowUnpinned failed: %v", err)
+	}
+
+	defer func(orig int64) { MaxFetchSize = orig }(MaxFetchSize)
+	MaxFetchSize = 10
+	if _, err := fetcher.FetchInput(srv.URL + "/large"); err == nil {
+		t.Errorf("oversized response was accepted")
+	}
+	MaxFetchSize = 100
+	if _, err := fetcher.FetchInput(srv.URL + "/large"); err != nil {
+		t.Errorf("response at the size limit failed: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// RESTMapper returns a meta.RESTMapper backed by the cached
+// discovery results.  The mapper is built on first use, and rebuilt
+// after Invalidate (or InvalidateResources) or once the results
+// expire.  A failed mapping
+// from stale results invalidates the cache and is retried once.
+//
+// NB: The RESTMapper in this client-go version guesses resource
+// names from kinds, which is wrong for some irregular plurals.  Use
+// ClientForResource when the exact resource name matters.
+func (c *memcachedDiscoveryClient) RESTMapper() meta.RESTMapper {
+	return &cachedRESTMapper{c: c}
+}
+
+// RESTMapperFor returns a meta.RESTMapper for disco, reusing the
+// cached mapper of clients from NewMemcachedDiscoveryClient.
+func RESTMapperFor(disco discovery.DiscoveryInterface) (meta.RESTMapper, error) {
+	if c, ok := disco.(interface {
+		RESTMapper() meta.RESTMapper
+	}); ok {
+		return c.RESTMapper(), nil
+	}
+	groupResources, err := discovery.GetAPIGroupResources(disco)
+	if err != nil {
+		return nil, err
+	}
+	return discovery.NewRESTMapper(groupResources, dynamic.VersionInterfaces), nil
+}
+
+func (c *memcachedDiscoveryClient) restMapper() (meta.RESTMapper, error) {
+	c.mapperLock.Lock()
+	defer c.mapperLock.Unlock()
+
+	if c.mapper != nil && !c.expired(c.mapperAt) {
+		return c.mapper, nil
+	}
+
+	groups, err := c.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	resources, failed := c.allServerResources(groups)
+	if len(failed) > 0 {
+		log.Debugf("Building RESTMapper from partial discovery: %v", groupDiscoveryError(failed))
+	}
+
+	groupResources := make([]*discovery.APIGroupResources, 0, len(groups.Groups))
+	for _, group := range groups.Groups {
+		gr := &discovery.APIGroupResources{
+			Group:              group,
+			VersionedResources: map[string][]metav1.APIResource{},
+		}
+		for _, v := range group.Versions {
+			list, ok := resources[v.GroupVersion]
+			if !ok {
+				continue
+			}
+			rs := []metav1.APIResource{}
+			for _, r := range list.APIResources {
+				// Subresources would map their parent
+				// (or eg: Scale) kind to a bogus resource
+				if !strings.Contains(r.Name, "/") {
+					rs = append(rs, r)
+				}
+			}
+			gr.VersionedResources[v.Version] = rs
+		}
+		groupResources = append(groupResources, gr)
+	}
+
+	c.mapper = discovery.NewRESTMapper(groupResources, dynamic.VersionInterfaces)
+	c.mapperAt = c.now()
+	return c.mapper, nil
+}
+
+// cachedRESTMapper implements meta.RESTMapper using the current
+// mapper of a memcachedDiscoveryClient
+type cachedRESTMapper struct {
+	c *memcachedDiscoveryClient
+}
+
+var _ meta.RESTMapper = &cachedRESTMapper{}
+
+// do calls f with the current mapper, retrying once with fresh
+// discovery results if f fails and the results may be stale.
+func (r *cachedRESTMapper) do(f func(meta.RESTMapper) error) error {
+	m, err := r.c.restMapper()
+	if err != nil {
+		return err
+	}
+	err = f(m)
+	if err != nil && !r.c.Fresh() {
+		log.Debugf("Retrying REST mapping with fresh discovery: %v", err)
+		r.c.InvalidateResources()
+		if m, err = r.c.restMapper(); err != nil {
+			return err
+		}
+		err = f(m)
+	}
+	return err
+}
+
+func (r *cachedRESTMapper) KindFor(resource schema.GroupVersionResource) (gvk schema.GroupVersionKind, err error) {
+	err = r.do(func(m meta.RESTMapper) (err error) {
+		gvk, err = m.KindFor(resource)
+		return
+	})
+	return
+}
+
+func (r *cachedRESTMapper) KindsFor(resource schema.GroupVersionResource) (gvks []schema.GroupVersionKind, err error) {
+	err = r.do(func(m meta.RESTMapper) (err error) {
+		gvks, err = m.KindsFor(resource)
+		return
+	})
+	return
+}
+
+func (r *cachedRESTMapper) ResourceFor(input schema.GroupVersionResource) (gvr schema.GroupVersionResource, err error) {
+	err = r.do(func(m meta.RESTMapper) (err error) {
+		gvr, err = m.ResourceFor(input)
+		return
+	})
+	return
+}
+
+func (r *cachedRESTMapper) ResourcesFor(input schema.GroupVersionResource) (gvrs []schema.GroupVersionResource, err error) {
+	err = r.do(func(m meta.RESTMapper) (err error) {
+		gvrs, err = m.ResourcesFor(input)
+		return
+	})
+	return
+}
+
+func (r *cachedRESTMapper) RESTMapping(gk schema.GroupKind, versions ...string) (mapping *meta.RESTMapping, err error) {
+	err = r.do(func(m meta.RESTMapper) (err error) {
+		mapping, err = m.RESTMapping(gk, versions...)
+		return
+	})
+	return
+}
+
+func (r *cachedRESTMapper) RESTMappings(gk schema.GroupKind, versions ...string) (mappings []*meta.RESTMapping, err error) {
+	err = r.do(func(m meta.RESTMapper) (err error) {
+		mappings, err = m.RESTMappings(gk, versions...)
+		return
+	})
+	return
+}
+
+func (r *cachedRESTMapper) ResourceSingularizer(resource string) (singular string, err error) {
+	err = r.do(func(m meta.RESTMapper) (err error) {
+		singular, err = m.ResourceSingularizer(resource)
+		return
+	})
+	return
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+func TestRESTMapper(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	withCRD := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+				{"name":"pods","kind":"Pod","namespaced":true,"verbs":["get"]},
+				{"name":"pods/log","kind":"Pod","namespaced":true,"verbs":["get"]},
+				{"name":"namespaces","kind":"Namespace","namespaced":false,"verbs":["get"]}]}`)
+		case "/apis":
+			crd := ""
+			if withCRD {
+				crd = `,{"name":"example.com","versions":[{"groupVersion":"example.com/v1","version":"v1"}],"preferredVersion":{"groupVersion":"example.com/v1","version":"v1"}}`
+			}
+			fmt.Fprintf(w, `{"kind":"APIGroupList","groups":[{"name":"apps","versions":[{"groupVersion":"apps/v1beta1","version":"v1beta1"}],"preferredVersion":{"groupVersion":"apps/v1beta1","version":"v1beta1"}}%s]}`, crd)
+		case "/apis/apps/v1beta1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"apps/v1beta1","resources":[
+				{"name":"deployments","kind":"Deployment","namespaced":true,"verbs":["get"]},
+				{"name":"deployments/scale","kind":"Scale","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis/example.com/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"example.com/v1","resources":[
+				{"name":"widgets","kind":"Widget","namespaced":true,"verbs":["get"]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMemcachedDiscoveryClient(disco).(*memcachedDiscoveryClient)
+	mapper := c.RESTMapper()
+
+	m, err := mapper.RESTMapping(schema.GroupKind{Group: "apps", Kind: "Deployment"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Resource != "deployments" || m.GroupVersionKind.Version != "v1beta1" || m.Scope.Name() != meta.RESTScopeNameNamespace {
+		t.Errorf("Unexpected mapping for Deployment: %s %v %s", m.Resource, m.GroupVersionKind, m.Scope.Name())
+	}
+
+	m, err = mapper.RESTMapping(schema.GroupKind{Kind: "Namespace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Scope.Name() != meta.RESTScopeNameRoot {
+		t.Errorf("Expected Namespace to be cluster scoped, got %s", m.Scope.Name())
+	}
+
+	gvk, err := mapper.KindFor(schema.GroupVersionResource{Resource: "pods"})
+	if err != nil || gvk != (schema.GroupVersionKind{Version: "v1", Kind: "Pod"}) {
+		t.Errorf("Unexpected kind for pods: %v, %v", gvk, err)
+	}
+
+	// Subresource kinds are not mapped
+	if _, err := mapper.RESTMapping(schema.GroupKind{Group: "apps", Kind: "Scale"}); err == nil {
+		t.Errorf("Unexpected mapping for subresource kind Scale")
+	}
+
+	// The mapper is built once from cached discovery
+	mu.Lock()
+	before := requests
+	withCRD = true
+	mu.Unlock()
+	if _, err := mapper.RESTMapping(schema.GroupKind{Group: "example.com", Kind: "Widget"}); err == nil {
+		t.Errorf("Unexpected mapping for Widget before Invalidate")
+	}
+	mu.Lock()
+	if requests != before {
+		t.Errorf("Mapping made %d discovery requests, expected none", requests-before)
+	}
+	mu.Unlock()
+
+	// ... and rebuilt in lockstep with discovery
+	c.Invalidate()
+	m, err = mapper.RESTMapping(schema.GroupKind{Group: "example.com", Kind: "Widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Resource != "widgets" {
+		t.Errorf("Unexpected resource for Widget: %s", m.Resource)
+	}
+}
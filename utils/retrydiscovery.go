@@ -0,0 +1,161 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful-swagger12"
+	"github.com/go-openapi/spec"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// DiscoveryBackoff configures how transient discovery failures are
+// retried
+type DiscoveryBackoff struct {
+	// Retries is the number of retries after the first attempt
+	Retries int
+	// Delay is the wait before the first retry.  Each later
+	// retry waits Factor times longer than the previous one.
+	Delay  time.Duration
+	Factor float64
+	// Sleep is time.Sleep if nil
+	Sleep func(time.Duration)
+}
+
+// DefaultDiscoveryBackoff retries for up to ~7.5s
+var DefaultDiscoveryBackoff = DiscoveryBackoff{
+	Retries: 4,
+	Delay:   500 * time.Millisecond,
+	Factor:  2,
+}
+
+// isRetryableDiscoveryError returns true if err is likely to be
+// transient, eg: an overloaded or restarting API server, or a load
+// balancer dropping a connection.
+func isRetryableDiscoveryError(err error) bool {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) {
+		return true
+	}
+	if status, ok := err.(apierrors.APIStatus); ok {
+		return status.Status().Code == http.StatusServiceUnavailable
+	}
+	return utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err)
+}
+
+// retry calls f until it succeeds, fails with a non-retryable
+// error, or the retries are exhausted
+func (b DiscoveryBackoff) retry(desc string, f func() error) error {
+	sleep := b.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	delay := b.Delay
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= b.Retries || !isRetryableDiscoveryError(err) {
+			return err
+		}
+		log.Debugf("Error fetching %s, retrying in %s: %v", desc, delay, err)
+		sleep(delay)
+		delay = time.Duration(float64(delay) * b.Factor)
+	}
+}
+
+// retryingDiscoveryClient retries transient failures of the
+// underlying client's individual requests.  Methods that are
+// implemented in terms of several requests (eg: ServerResources) are
+// not retried as a whole; use NewMemcachedDiscoveryClient on top,
+// which builds them from retried ServerGroups and
+// ServerResourcesForGroupVersion calls.
+type retryingDiscoveryClient struct {
+	cl      discovery.DiscoveryInterface
+	backoff DiscoveryBackoff
+}
+
+// NewRetryingDiscoveryClient returns a DiscoveryClient that retries
+// requests to cl that fail with transient errors (503, 429, server
+// timeouts and reset connections), according to backoff.  Other
+// errors (eg: 404 or 403) are returned immediately.
+func NewRetryingDiscoveryClient(cl discovery.DiscoveryInterface, backoff DiscoveryBackoff) discovery.DiscoveryInterface {
+	return &retryingDiscoveryClient{cl: cl, backoff: backoff}
+}
+
+var _ discovery.DiscoveryInterface = &retryingDiscoveryClient{}
+
+func (c *retryingDiscoveryClient) RESTClient() rest.Interface {
+	return c.cl.RESTClient()
+}
+
+func (c *retryingDiscoveryClient) ServerGroups() (groups *metav1.APIGroupList, err error) {
+	err = c.backoff.retry("API groups", func() (err error) {
+		groups, err = c.cl.ServerGroups()
+		return
+	})
+	return
+}
+
+func (c *retryingDiscoveryClient) ServerResourcesForGroupVersion(groupVersion string) (resources *metav1.APIResourceList, err error) {
+	err = c.backoff.retry("resources for "+groupVersion, func() (err error) {
+		resources, err = c.cl.ServerResourcesForGroupVersion(groupVersion)
+		return
+	})
+	return
+}
+
+func (c *retryingDiscoveryClient) ServerResources() ([]*metav1.APIResourceList, error) {
+	return c.cl.ServerResources()
+}
+
+func (c *retryingDiscoveryClient) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return c.cl.ServerPreferredResources()
+}
+
+func (c *retryingDiscoveryClient) ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error) {
+	return c.cl.ServerPreferredNamespacedResources()
+}
+
+func (c *retryingDiscoveryClient) ServerVersion() (info *version.Info, err error) {
+	err = c.backoff.retry("server version", func() (err error) {
+		info, err = c.cl.ServerVersion()
+		return
+	})
+	return
+}
+
+func (c *retryingDiscoveryClient) SwaggerSchema(gv schema.GroupVersion) (api *swagger.ApiDeclaration, err error) {
+	err = c.backoff.retry("schema for "+gv.String(), func() (err error) {
+		api, err = c.cl.SwaggerSchema(gv)
+		return
+	})
+	return
+}
+
+func (c *retryingDiscoveryClient) OpenAPISchema() (s *spec.Swagger, err error) {
+	err = c.backoff.retry("OpenAPI schema", func() (err error) {
+		s, err = c.cl.OpenAPISchema()
+		return
+	})
+	return
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+func TestRetryingDiscoveryClient(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/flaky.example.com/v1":
+			// Fails twice, then succeeds
+			if n <= 2 {
+				http.Error(w, "upstream connect error", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"flaky.example.com/v1","resources":[{"name":"things","kind":"Thing","namespaced":true,"verbs":["get"]}]}`)
+		case "/apis/throttled.example.com/v1":
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case "/apis/reset.example.com/v1":
+			// Drop the connection without a response
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+		case "/apis/denied.example.com/v1":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delays []time.Duration
+	c := NewRetryingDiscoveryClient(disco, DiscoveryBackoff{
+		Retries: 3,
+		Delay:   10 * time.Millisecond,
+		Factor:  2,
+		Sleep:   func(d time.Duration) { delays = append(delays, d) },
+	})
+
+	list, err := c.ServerResourcesForGroupVersion("flaky.example.com/v1")
+	if err != nil {
+		t.Fatalf("Transient failures were not retried: %v", err)
+	}
+	if len(list.APIResources) != 1 {
+		t.Errorf("Unexpected resources %v", list.APIResources)
+	}
+	if expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}; !reflect.DeepEqual(delays, expected) {
+		t.Errorf("Expected delays %v, got %v", expected, delays)
+	}
+
+	for _, test := range []struct {
+		gv      string
+		retries int
+	}{
+		{"throttled.example.com/v1", 3},
+		{"reset.example.com/v1", 3},
+		// Non-retryable errors fail fast
+		{"denied.example.com/v1", 0},
+		{"missing.example.com/v1", 0},
+	} {
+		delays = nil
+		if _, err := c.ServerResourcesForGroupVersion(test.gv); err == nil {
+			t.Errorf("%s: expected an error", test.gv)
+		}
+		if len(delays) != test.retries {
+			t.Errorf("%s: expected %d retries, got delays %v", test.gv, test.retries, delays)
+		}
+		mu.Lock()
+		n := requests["/apis/"+test.gv]
+		mu.Unlock()
+		// net/http may itself retry a dropped connection
+		if n < test.retries+1 || (test.retries == 0 && n != 1) {
+			t.Errorf("%s: unexpected number of requests %d", test.gv, n)
+		}
+	}
+}
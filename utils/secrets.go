@@ -0,0 +1,160 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+// SecretBackend fetches secret values from an external secret
+// manager.  Implementations must never log the returned value.
+type SecretBackend interface {
+	Fetch(ref string) (string, error)
+}
+
+// SecretFetcher implements the externalSecret native function.
+// Values are cached for the lifetime of the fetcher.
+type SecretFetcher struct {
+	// Backend is nil if no secret backend was configured
+	Backend SecretBackend
+
+	// Redact replaces each fetched value with RedactedSecret(ref),
+	// for output that is displayed rather than applied.
+	Redact bool
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewSecretFetcher returns a SecretFetcher using backend
+func NewSecretFetcher(backend SecretBackend) *SecretFetcher {
+	return &SecretFetcher{
+		Backend: backend,
+		cache:   map[string]string{},
+	}
+}
+
+// RedactedSecret is the placeholder returned in place of the secret
+// identified by ref, when secrets are redacted
+func RedactedSecret(ref string) string {
+	return fmt.Sprintf("<redacted secret %s>", ref)
+}
+
+// Fetch returns the secret value identified by ref
+func (f *SecretFetcher) Fetch(ref string) (string, error) {
+	v, err := f.fetch(ref)
+	if err != nil || !f.Redact {
+		return v, err
+	}
+	return RedactedSecret(ref), nil
+}
+
+func (f *SecretFetcher) fetch(ref string) (string, error) {
+	if f.Backend == nil {
+		return "", fmt.Errorf("Unable to fetch secret %s: no secret backend configured", ref)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if v, ok := f.cache[ref]; ok {
+		return v, nil
+	}
+	log.Debugf("Fetching secret %s", ref)
+	v, err := f.Backend.Fetch(ref)
+	if err != nil {
+		return "", err
+	}
+	f.cache[ref] = v
+	return v, nil
+}
+
+// RegisterSecretFuncs adds the native jsonnet functions that fetch
+// external secrets to the provided VM
+func RegisterSecretFuncs(vm *jsonnet.VM, fetcher *SecretFetcher) {
+	vm.NativeCallback("externalSecret", []string{"ref"}, fetcher.Fetch)
+}
+
+// VaultBackend reads secrets from a HashiCorp Vault server.  Refs
+// are of the form `path#field`, eg "secret/data/myapp#password".  If
+// the field is omitted, it defaults to "value".  Both KV version 1
+// and 2 secret engines are supported.
+type VaultBackend struct {
+	Address string
+	Token   string
+	Client  *http.Client
+}
+
+// NewVaultBackend returns a VaultBackend for the server at address
+func NewVaultBackend(address, token string, client *http.Client) *VaultBackend {
+	return &VaultBackend{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Client:  client,
+	}
+}
+
+// Fetch implements SecretBackend
+func (v *VaultBackend) Fetch(ref string) (string, error) {
+	path, field := ref, "value"
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, field = ref[:i], ref[i+1:]
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/%s", v.Address, strings.TrimPrefix(path, "/")), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Error fetching secret %s from vault: %v", ref, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error fetching secret %s from vault: %s", ref, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("Error parsing vault response for %s: %v", ref, err)
+	}
+
+	data := body.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			// KV version 2
+			data = inner
+		}
+	}
+
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("Secret %s has no string field %q", path, field)
+	}
+	return value, nil
+}
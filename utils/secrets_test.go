@@ -0,0 +1,100 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ksonnet/kubecfg/pkg/jsonnet"
+)
+
+func TestExternalSecret(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("X-Vault-Token") != "mytoken" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/myapp":
+			fmt.Fprint(w, `{"data": {"data": {"password": "hunter2"}, "metadata": {"version": 3}}}`)
+		case "/v1/kv/legacy":
+			fmt.Fprint(w, `{"data": {"value": "s3kr1t"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	RegisterSecretFuncs(vm, NewSecretFetcher(NewVaultBackend(srv.URL, "mytoken", srv.Client())))
+
+	x, err := vm.EvaluateSnippet("test", `
+    local s = std.native("externalSecret");
+    [s("secret/data/myapp#password"), s("secret/data/myapp#password"), s("kv/legacy")]`)
+	check(t, err, x, "[\n   \"hunter2\",\n   \"hunter2\",\n   \"s3kr1t\"\n]\n")
+	if fetches != 2 {
+		t.Errorf("Expected secrets to be fetched once each, got %d fetches", fetches)
+	}
+
+	redacted := jsonnet.Make()
+	defer redacted.Destroy()
+	fetcher := NewSecretFetcher(NewVaultBackend(srv.URL, "mytoken", srv.Client()))
+	fetcher.Redact = true
+	RegisterSecretFuncs(redacted, fetcher)
+	x, err = redacted.EvaluateSnippet("test", `std.native("externalSecret")("kv/legacy")`)
+	check(t, err, x, "\"<redacted secret kv/legacy>\"\n")
+	_, err = redacted.EvaluateSnippet("test", `std.native("externalSecret")("secret/data/other")`)
+	if err == nil {
+		t.Errorf("Redacted fetch of a missing secret succeeded")
+	}
+
+	_, err = vm.EvaluateSnippet("test", `std.native("externalSecret")("secret/data/myapp#missing")`)
+	if err == nil || !strings.Contains(err.Error(), `no string field "missing"`) {
+		t.Errorf("Unexpected error for missing field: %v", err)
+	}
+
+	_, err = vm.EvaluateSnippet("test", `std.native("externalSecret")("secret/data/other")`)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Unexpected error for missing secret: %v", err)
+	}
+}
+
+func TestExternalSecretUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	vm := jsonnet.Make()
+	defer vm.Destroy()
+	RegisterSecretFuncs(vm, NewSecretFetcher(NewVaultBackend(url, "mytoken", http.DefaultClient)))
+
+	_, err := vm.EvaluateSnippet("test", `std.native("externalSecret")("secret/data/myapp#password")`)
+	if err == nil || !strings.Contains(err.Error(), "Error fetching secret secret/data/myapp#password from vault") {
+		t.Errorf("Unexpected error for unreachable backend: %v", err)
+	}
+
+	fetcher := NewSecretFetcher(nil)
+	if _, err := fetcher.Fetch("secret/data/myapp"); err == nil {
+		t.Errorf("Fetch without a backend succeeded")
+	}
+}
@@ -31,6 +31,14 @@ import (
 var (
 	gkTpr = schema.GroupKind{Group: "extensions", Kind: "ThirdPartyResource"}
 	gkCrd = schema.GroupKind{Group: "apiextensions", Kind: "CustomResourceDefinition"}
+	// The group CRDs are actually served from
+	gkCrdServed = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+	gkServiceAccount     = schema.GroupKind{Group: "", Kind: "ServiceAccount"}
+	gkRole               = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "Role"}
+	gkClusterRole        = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}
+	gkRoleBinding        = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}
+	gkClusterRoleBinding = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}
 )
 
 // Heh: Swagger. Walk. :P
@@ -130,7 +138,7 @@ func containsPodSpec(disco discovery.SwaggerSchemaInterface, gvk schema.GroupVer
 // Arbitrary numbers used to do a simple topological sort of resources.
 func depTier(disco ServerResourcesSwaggerSchema, o schema.ObjectKind) (int, error) {
 	gvk := o.GroupVersionKind()
-	if gk := gvk.GroupKind(); gk == gkTpr || gk == gkCrd {
+	if gk := gvk.GroupKind(); gk == gkTpr || gk == gkCrd || gk == gkCrdServed {
 		// Special case: these create other types
 		return 10, nil
 	}
@@ -153,6 +161,44 @@ func depTier(disco ServerResourcesSwaggerSchema, o schema.ObjectKind) (int, erro
 	}
 }
 
+// applyPriorities orders well-known kinds within a dependency tier,
+// following helm's install order.  Lower values are applied first;
+// other kinds are defaultApplyPriority.
+var applyPriorities = map[schema.GroupKind]int{
+	{Kind: "Namespace"}:           10,
+	gkCrd:                         20,
+	gkCrdServed:                   20,
+	gkServiceAccount:              30,
+	{Kind: "Secret"}:              40,
+	{Kind: "ConfigMap"}:           40,
+	{Group: "batch", Kind: "Job"}: 90,
+	{Kind: "Pod"}:                 90,
+}
+
+const defaultApplyPriority = 50
+
+func applyPriority(gk schema.GroupKind) int {
+	if p, ok := applyPriorities[gk]; ok {
+		return p
+	}
+	return defaultApplyPriority
+}
+
+// sortForApply returns objs ordered by kind, so Namespaces and
+// CustomResourceDefinitions come before the objects that need them:
+// Namespace, CustomResourceDefinition, ServiceAccount, Secret and
+// ConfigMap, everything else, then Job and Pod.  Objects of equal
+// priority keep their relative order.  Unlike DependencyOrder, this
+// does not consult the server.
+func sortForApply(objs []*unstructured.Unstructured) []*unstructured.Unstructured {
+	ret := make([]*unstructured.Unstructured, len(objs))
+	copy(ret, objs)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return applyPriority(ret[i].GroupVersionKind().GroupKind()) < applyPriority(ret[j].GroupVersionKind().GroupKind())
+	})
+	return ret
+}
+
 // A subset of discovery.DiscoveryInterface
 type ServerResourcesSwaggerSchema interface {
 	discovery.ServerResourcesInterface
@@ -162,38 +208,163 @@ type ServerResourcesSwaggerSchema interface {
 // DependencyOrder is a `sort.Interface` that *best-effort* sorts the
 // objects so that known dependencies appear earlier in the list.  The
 // idea is to prevent *some* of the "crash-restart" loops when
-// creating inter-dependent resources.
+// creating inter-dependent resources.  Within each tier, objects are
+// in the same kind order as LoadObjects returns them.
 func DependencyOrder(disco ServerResourcesSwaggerSchema, list []*unstructured.Unstructured) (sort.Interface, error) {
+	return dependencyOrder(disco, list)
+}
+
+func dependencyOrder(disco ServerResourcesSwaggerSchema, list []*unstructured.Unstructured) (*mappedSort, error) {
 	sortKeys := make([]int, len(list))
+	priorities := make([]int, len(list))
 	for i, item := range list {
 		var err error
 		sortKeys[i], err = depTier(disco, item.GetObjectKind())
 		if err != nil {
 			return nil, err
 		}
+		priorities[i] = applyPriority(item.GroupVersionKind().GroupKind())
 	}
+	subKeys := bindingOrder(list, sortKeys)
 	log.Debugf("sortKeys is %v", sortKeys)
-	return &mappedSort{sortKeys: sortKeys, items: list}, nil
+	return &mappedSort{sortKeys: sortKeys, priorities: priorities, subKeys: subKeys, items: list}, nil
+}
+
+// DependencyWaves sorts list in place (see DependencyOrder), and
+// splits the result into "waves" of objects with the same tier and
+// kind priority.  Objects in a wave have no known dependencies on
+// each other, so may be applied concurrently, but each wave must be
+// complete before the next begins.
+func DependencyWaves(disco ServerResourcesSwaggerSchema, list []*unstructured.Unstructured) ([][]*unstructured.Unstructured, error) {
+	order, err := dependencyOrder(disco, list)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(order)
+
+	var waves [][]*unstructured.Unstructured
+	start := 0
+	for i := 1; i <= len(list); i++ {
+		if i < len(list) && order.sameWave(start, i) {
+			continue
+		}
+		waves = append(waves, list[start:i])
+		start = i
+	}
+	return waves, nil
+}
+
+// rbacRef identifies a Role, ClusterRole or ServiceAccount
+type rbacRef struct {
+	kind, namespace, name string
+}
+
+// bindingOrder raises the tier of each RBAC binding to at least that
+// of the Roles, ClusterRoles and ServiceAccounts in list that it
+// refers to.  The returned keys place bindings after the other
+// objects in the same tier.
+func bindingOrder(list []*unstructured.Unstructured, sortKeys []int) []int {
+	tiers := map[rbacRef]int{}
+	for i, item := range list {
+		switch gk := item.GroupVersionKind().GroupKind(); gk {
+		case gkServiceAccount, gkRole:
+			tiers[rbacRef{gk.Kind, item.GetNamespace(), item.GetName()}] = sortKeys[i]
+		case gkClusterRole:
+			tiers[rbacRef{gk.Kind, "", item.GetName()}] = sortKeys[i]
+		}
+	}
+
+	subKeys := make([]int, len(list))
+	for i, item := range list {
+		gk := item.GroupVersionKind().GroupKind()
+		if gk != gkRoleBinding && gk != gkClusterRoleBinding {
+			continue
+		}
+		subKeys[i] = 1
+		for _, ref := range bindingRefs(item) {
+			if tier, ok := tiers[ref]; ok && tier > sortKeys[i] {
+				sortKeys[i] = tier
+			}
+		}
+	}
+	return subKeys
+}
+
+// bindingRefs returns the roleRef and ServiceAccount subjects of an
+// RBAC binding
+func bindingRefs(binding *unstructured.Unstructured) []rbacRef {
+	var refs []rbacRef
+	if roleRef, ok := binding.Object["roleRef"].(map[string]interface{}); ok {
+		kind, _ := roleRef["kind"].(string)
+		name, _ := roleRef["name"].(string)
+		ns := ""
+		if kind == gkRole.Kind {
+			ns = binding.GetNamespace()
+		}
+		refs = append(refs, rbacRef{kind, ns, name})
+	}
+	subjects, _ := binding.Object["subjects"].([]interface{})
+	for _, s := range subjects {
+		subject, ok := s.(map[string]interface{})
+		if !ok || subject["kind"] != gkServiceAccount.Kind {
+			continue
+		}
+		name, _ := subject["name"].(string)
+		ns, _ := subject["namespace"].(string)
+		if ns == "" {
+			ns = binding.GetNamespace()
+		}
+		refs = append(refs, rbacRef{gkServiceAccount.Kind, ns, name})
+	}
+	return refs
+}
+
+// SortForDelete sorts list in place so that dependents appear before
+// the objects they depend on (eg: namespaced objects before their
+// Namespace).  This is the reverse of DependencyOrder.
+func SortForDelete(disco ServerResourcesSwaggerSchema, list []*unstructured.Unstructured) error {
+	order, err := DependencyOrder(disco, list)
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(order))
+	return nil
 }
 
 type mappedSort struct {
-	sortKeys []int
-	items    []*unstructured.Unstructured
+	sortKeys   []int
+	priorities []int
+	subKeys    []int
+	items      []*unstructured.Unstructured
 }
 
 func (l *mappedSort) Len() int { return len(l.items) }
 func (l *mappedSort) Swap(i, j int) {
 	l.sortKeys[i], l.sortKeys[j] = l.sortKeys[j], l.sortKeys[i]
+	l.priorities[i], l.priorities[j] = l.priorities[j], l.priorities[i]
+	l.subKeys[i], l.subKeys[j] = l.subKeys[j], l.subKeys[i]
 	l.items[i], l.items[j] = l.items[j], l.items[i]
 }
 func (l *mappedSort) Less(i, j int) bool {
 	if l.sortKeys[i] != l.sortKeys[j] {
 		return l.sortKeys[i] < l.sortKeys[j]
 	}
+	if l.priorities[i] != l.priorities[j] {
+		return l.priorities[i] < l.priorities[j]
+	}
+	if l.subKeys[i] != l.subKeys[j] {
+		return l.subKeys[i] < l.subKeys[j]
+	}
 	// Fall back to alpha sort, to give persistent order
 	return AlphabeticalOrder(l.items).Less(i, j)
 }
 
+func (l *mappedSort) sameWave(i, j int) bool {
+	return l.sortKeys[i] == l.sortKeys[j] &&
+		l.priorities[i] == l.priorities[j] &&
+		l.subKeys[i] == l.subKeys[j]
+}
+
 // AlphabeticalOrder is a `sort.Interface` that sorts the
 // objects by namespace/name/kind alphabetical order
 type AlphabeticalOrder []*unstructured.Unstructured
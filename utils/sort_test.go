@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/emicklei/go-restful-swagger12"
@@ -76,6 +77,29 @@ func (c *FakeDiscovery) ServerResourcesForGroupVersion(gv string) (*metav1.APIRe
 				Namespaced: true,
 			},
 		}
+	case "rbac.authorization.k8s.io/v1":
+		rsrcs = []metav1.APIResource{
+			{
+				Name:       "clusterroles",
+				Kind:       "ClusterRole",
+				Namespaced: false,
+			},
+			{
+				Name:       "clusterrolebindings",
+				Kind:       "ClusterRoleBinding",
+				Namespaced: false,
+			},
+			{
+				Name:       "roles",
+				Kind:       "Role",
+				Namespaced: true,
+			},
+			{
+				Name:       "rolebindings",
+				Kind:       "RoleBinding",
+				Namespaced: true,
+			},
+		}
 	default:
 		return nil, fmt.Errorf("gv %v not found in test implementation", gv)
 	}
@@ -153,6 +177,124 @@ func TestDepSort(t *testing.T) {
 	}
 }
 
+func TestDependencyWaves(t *testing.T) {
+	disco := NewFakeDiscovery(schemaFromFile{dir: filepath.FromSlash("../testdata")})
+
+	newObj := func(apiVersion, kind, name string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		o.SetAPIVersion(apiVersion)
+		o.SetKind(kind)
+		o.SetName(name)
+		return o
+	}
+
+	objs := []*unstructured.Unstructured{
+		newObj("v1", "ReplicationController", "b"),
+		newObj("v1", "ConfigMap", "b"),
+		newObj("v1", "Namespace", "a"),
+		newObj("v1", "ReplicationController", "a"),
+		newObj("v1", "ConfigMap", "a"),
+		newObj("v1", "Namespace", "b"),
+	}
+
+	waves, err := DependencyWaves(disco, objs)
+	if err != nil {
+		t.Fatalf("DependencyWaves error: %v", err)
+	}
+
+	var got []string
+	for _, wave := range waves {
+		var names []string
+		for _, o := range wave {
+			names = append(names, o.GetKind()+"/"+o.GetName())
+		}
+		got = append(got, strings.Join(names, ","))
+	}
+	expected := []string{
+		"Namespace/a,Namespace/b",
+		"ConfigMap/a,ConfigMap/b",
+		"ReplicationController/a,ReplicationController/b",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected waves %q, got %q", expected, got)
+	}
+
+	// list is sorted in place
+	if objs[0].GetKind() != "Namespace" || objs[5].GetKind() != "ReplicationController" {
+		t.Errorf("List was not sorted in place")
+	}
+
+	waves, err = DependencyWaves(disco, nil)
+	if err != nil || len(waves) != 0 {
+		t.Errorf("Expected no waves for an empty list, got %v, %v", waves, err)
+	}
+}
+
+func TestRBACSort(t *testing.T) {
+	disco := NewFakeDiscovery(schemaFromFile{dir: filepath.FromSlash("../testdata")})
+
+	newObj := func(apiVersion, kind, name string, fields map[string]interface{}) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{Object: fields}
+		if o.Object == nil {
+			o.Object = map[string]interface{}{}
+		}
+		o.SetAPIVersion(apiVersion)
+		o.SetKind(kind)
+		o.SetName(name)
+		if kind != "ClusterRole" && kind != "ClusterRoleBinding" {
+			o.SetNamespace("myns")
+		}
+		return o
+	}
+	subjects := []interface{}{
+		map[string]interface{}{"kind": "ServiceAccount", "name": "z-account"},
+	}
+
+	// Emission order has bindings before the objects they refer to
+	objs := []*unstructured.Unstructured{
+		newObj("rbac.authorization.k8s.io/v1", "ClusterRoleBinding", "a-clusterbinding", map[string]interface{}{
+			"roleRef":  map[string]interface{}{"kind": "ClusterRole", "name": "z-clusterrole"},
+			"subjects": []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "z-account", "namespace": "myns"}},
+		}),
+		newObj("rbac.authorization.k8s.io/v1", "RoleBinding", "a-binding", map[string]interface{}{
+			"roleRef":  map[string]interface{}{"kind": "Role", "name": "z-role"},
+			"subjects": subjects,
+		}),
+		newObj("rbac.authorization.k8s.io/v1", "ClusterRole", "z-clusterrole", nil),
+		newObj("rbac.authorization.k8s.io/v1", "Role", "z-role", nil),
+		newObj("v1", "ServiceAccount", "z-account", nil),
+		newObj("v1", "ConfigMap", "b-config", nil),
+	}
+
+	sorter, err := DependencyOrder(disco, objs)
+	if err != nil {
+		t.Fatalf("DependencyOrder error: %v", err)
+	}
+	sort.Sort(sorter)
+
+	pos := map[string]int{}
+	for i, o := range objs {
+		t.Logf("obj[%d] after sort is %s %s", i, o.GetKind(), o.GetName())
+		pos[o.GetKind()] = i
+	}
+
+	for _, test := range []struct{ before, after string }{
+		{"Role", "RoleBinding"},
+		{"ServiceAccount", "RoleBinding"},
+		{"ClusterRole", "ClusterRoleBinding"},
+		// ClusterRoleBinding is otherwise placed before all
+		// namespaced objects
+		{"ServiceAccount", "ClusterRoleBinding"},
+	} {
+		if pos[test.before] > pos[test.after] {
+			t.Errorf("%s should be sorted before %s", test.before, test.after)
+		}
+	}
+	if pos["ClusterRole"] != 0 {
+		t.Errorf("ClusterRole should still be sorted first")
+	}
+}
+
 func TestAlphaSort(t *testing.T) {
 	newObj := func(ns, name, kind string) *unstructured.Unstructured {
 		o := unstructured.Unstructured{}
@@ -184,3 +326,66 @@ func TestAlphaSort(t *testing.T) {
 		t.Errorf("actual != expected: %v != %v", objs, expected)
 	}
 }
+
+func TestSortForApply(t *testing.T) {
+	newObj := func(apiVersion, kind, name string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		o.SetAPIVersion(apiVersion)
+		o.SetKind(kind)
+		o.SetName(name)
+		return o
+	}
+
+	objs := []*unstructured.Unstructured{
+		newObj("v1", "Pod", "a-pod"),
+		newObj("example.com/v1", "Widget", "z-widget"),
+		newObj("apps/v1", "Deployment", "a-deploy"),
+		newObj("batch/v1", "Job", "a-job"),
+		newObj("v1", "ConfigMap", "z-config"),
+		newObj("apiextensions.k8s.io/v1", "CustomResourceDefinition", "widgets.example.com"),
+		newObj("v1", "Secret", "b-secret"),
+		newObj("v1", "ConfigMap", "a-config"),
+		newObj("v1", "ServiceAccount", "z-account"),
+		newObj("v1", "Namespace", "myns"),
+	}
+
+	sorted := sortForApply(objs)
+
+	var names []string
+	for _, o := range sorted {
+		names = append(names, o.GetName())
+	}
+	// Equal priorities keep their input order
+	expected := []string{"myns", "widgets.example.com", "z-account", "z-config", "b-secret", "a-config", "z-widget", "a-deploy", "a-pod", "a-job"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+	if objs[0].GetName() != "a-pod" {
+		t.Errorf("sortForApply modified its input")
+	}
+
+	// DependencyOrder uses the same kind order within a tier
+	disco := NewFakeDiscovery(schemaFromFile{dir: filepath.FromSlash("../testdata")})
+	list := []*unstructured.Unstructured{
+		newObj("bogus/v1", "UnknownKind", "a-unknown"),
+		newObj("v1", "ConfigMap", "b-config"),
+		newObj("v1", "ServiceAccount", "c-account"),
+		newObj("v1", "Secret", "d-secret"),
+	}
+	expected = nil
+	for _, o := range sortForApply(list) {
+		expected = append(expected, o.GetName())
+	}
+	sorter, err := DependencyOrder(disco, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(sorter)
+	names = nil
+	for _, o := range list {
+		names = append(names, o.GetName())
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("DependencyOrder gave %v, sortForApply gave %v", names, expected)
+	}
+}
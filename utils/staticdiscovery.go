@@ -0,0 +1,200 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/emicklei/go-restful-swagger12"
+	"github.com/go-openapi/spec"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// DiscoverySnapshot is a captured copy of a server's discovery
+// information, for resolving resources without a cluster (see
+// NewStaticDiscoveryClient).
+type DiscoverySnapshot struct {
+	Version *version.Info `json:"version,omitempty"`
+	// Groups is the server's API groups.  The legacy core group
+	// ("v1") may be omitted, as it is from the /apis response.
+	Groups    metav1.APIGroupList       `json:"groups"`
+	Resources []*metav1.APIResourceList `json:"resources"`
+	// OpenAPI is the server's OpenAPI (v2) schema, if captured
+	OpenAPI json.RawMessage `json:"openapi,omitempty"`
+}
+
+// ReadDiscoverySnapshot reads a JSON DiscoverySnapshot from path
+func ReadDiscoverySnapshot(path string) (*DiscoverySnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot DiscoverySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("Unable to parse discovery snapshot %s: %v", path, err)
+	}
+	return &snapshot, nil
+}
+
+// CaptureDiscoverySnapshot returns a DiscoverySnapshot of disco.
+// The OpenAPI schema is included if the server publishes one.
+func CaptureDiscoverySnapshot(disco discovery.DiscoveryInterface) (*DiscoverySnapshot, error) {
+	snapshot := &DiscoverySnapshot{}
+	var err error
+	snapshot.Version, err = disco.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := disco.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Groups = *groups
+	snapshot.Resources, err = disco.ServerResources()
+	if err = WarnOnPartialDiscovery(err); err != nil {
+		return nil, err
+	}
+	if doc, err := disco.OpenAPISchema(); err == nil && len(doc.Definitions) > 0 {
+		snapshot.OpenAPI, err = json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+type staticDiscoveryClient struct {
+	snapshot DiscoverySnapshot
+	byGv     map[string]*metav1.APIResourceList
+}
+
+// NewStaticDiscoveryClient returns a DiscoveryClient that answers
+// from snapshot, and never contacts a server.
+func NewStaticDiscoveryClient(snapshot *DiscoverySnapshot) discovery.DiscoveryInterface {
+	c := &staticDiscoveryClient{
+		snapshot: *snapshot,
+		byGv:     map[string]*metav1.APIResourceList{},
+	}
+	for _, l := range snapshot.Resources {
+		c.byGv[l.GroupVersion] = l
+	}
+
+	hasCore := false
+	for _, g := range snapshot.Groups.Groups {
+		if g.Name == "" {
+			hasCore = true
+		}
+	}
+	if _, ok := c.byGv["v1"]; ok && !hasCore {
+		core := metav1.GroupVersionForDiscovery{GroupVersion: "v1", Version: "v1"}
+		c.snapshot.Groups.Groups = append([]metav1.APIGroup{{
+			Versions:         []metav1.GroupVersionForDiscovery{core},
+			PreferredVersion: core,
+		}}, snapshot.Groups.Groups...)
+	}
+	return c
+}
+
+// RESTClient returns nil, since there is no server
+func (c *staticDiscoveryClient) RESTClient() rest.Interface {
+	return nil
+}
+
+func (c *staticDiscoveryClient) ServerGroups() (*metav1.APIGroupList, error) {
+	groups := c.snapshot.Groups
+	return &groups, nil
+}
+
+func (c *staticDiscoveryClient) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	l, ok := c.byGv[groupVersion]
+	if !ok {
+		gv, _ := schema.ParseGroupVersion(groupVersion)
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: gv.Group}, groupVersion)
+	}
+	return l, nil
+}
+
+func (c *staticDiscoveryClient) ServerResources() ([]*metav1.APIResourceList, error) {
+	return c.snapshot.Resources, nil
+}
+
+// ServerPreferredResources returns the resources of the preferred
+// version of each group
+func (c *staticDiscoveryClient) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	ret := []*metav1.APIResourceList{}
+	for _, g := range c.snapshot.Groups.Groups {
+		gv := g.PreferredVersion.GroupVersion
+		if gv == "" && len(g.Versions) > 0 {
+			gv = g.Versions[0].GroupVersion
+		}
+		if l, ok := c.byGv[gv]; ok {
+			ret = append(ret, l)
+		}
+	}
+	return ret, nil
+}
+
+func (c *staticDiscoveryClient) ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error) {
+	all, err := c.ServerPreferredResources()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*metav1.APIResourceList, 0, len(all))
+	for _, l := range all {
+		namespaced := &metav1.APIResourceList{GroupVersion: l.GroupVersion}
+		for _, r := range l.APIResources {
+			if r.Namespaced {
+				namespaced.APIResources = append(namespaced.APIResources, r)
+			}
+		}
+		ret = append(ret, namespaced)
+	}
+	return ret, nil
+}
+
+func (c *staticDiscoveryClient) ServerVersion() (*version.Info, error) {
+	if c.snapshot.Version == nil {
+		return nil, fmt.Errorf("Discovery snapshot has no server version")
+	}
+	v := *c.snapshot.Version
+	return &v, nil
+}
+
+// SwaggerSchema is not supported: snapshots only include the
+// OpenAPI schema
+func (c *staticDiscoveryClient) SwaggerSchema(gv schema.GroupVersion) (*swagger.ApiDeclaration, error) {
+	return nil, fmt.Errorf("Discovery snapshot has no swagger schema for %s", gv)
+}
+
+func (c *staticDiscoveryClient) OpenAPISchema() (*spec.Swagger, error) {
+	if len(c.snapshot.OpenAPI) == 0 {
+		return nil, fmt.Errorf("Discovery snapshot has no OpenAPI schema")
+	}
+	doc := &spec.Swagger{}
+	if err := json.Unmarshal(c.snapshot.OpenAPI, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+var _ discovery.DiscoveryInterface = &staticDiscoveryClient{}
@@ -0,0 +1,171 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const testDiscoverySnapshot = `{
+  "version": {"major": "1", "minor": "8", "gitVersion": "v1.8.0"},
+  "groups": {"groups": [
+    {"name": "apps", "versions": [{"groupVersion": "apps/v1beta1", "version": "v1beta1"}, {"groupVersion": "apps/v1beta2", "version": "v1beta2"}],
+     "preferredVersion": {"groupVersion": "apps/v1beta1", "version": "v1beta1"}}
+  ]},
+  "resources": [
+    {"groupVersion": "v1", "resources": [
+      {"name": "configmaps", "kind": "ConfigMap", "namespaced": true, "verbs": ["get", "patch"]},
+      {"name": "namespaces", "kind": "Namespace", "namespaced": false}
+    ]},
+    {"groupVersion": "apps/v1beta1", "resources": [
+      {"name": "deployments", "kind": "Deployment", "namespaced": true},
+      {"name": "deployments/scale", "kind": "Scale", "namespaced": true}
+    ]},
+    {"groupVersion": "apps/v1beta2", "resources": [
+      {"name": "deployments", "kind": "Deployment", "namespaced": true}
+    ]}
+  ],
+  "openapi": {"swagger": "2.0", "info": {"title": "Kubernetes", "version": "v1.8.0"}, "paths": {},
+    "definitions": {"io.k8s.api.core.v1.ConfigMap": {"type": "object"}}}
+}`
+
+func writeTestSnapshot(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kubecfg-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "snapshot.json")
+	if err := ioutil.WriteFile(path, []byte(testDiscoverySnapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStaticDiscoveryClient(t *testing.T) {
+	path := writeTestSnapshot(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	snapshot, err := ReadDiscoverySnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disco := NewStaticDiscoveryClient(snapshot)
+
+	rsrc, err := serverResourceForGroupVersionKind(disco, schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"})
+	if err != nil || rsrc.Name != "deployments" {
+		t.Errorf("Unexpected resource %v, %v", rsrc, err)
+	}
+	_, err = serverResourceForGroupVersionKind(disco, schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+	if !IsResourceNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+
+	groups, err := disco.ServerGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups.Groups) != 2 || groups.Groups[0].Name != "" || groups.Groups[0].PreferredVersion.GroupVersion != "v1" {
+		t.Errorf("Core group was not added: %v", groups.Groups)
+	}
+
+	preferred, err := disco.ServerPreferredResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gvs := []string{}
+	for _, l := range preferred {
+		gvs = append(gvs, l.GroupVersion)
+	}
+	if !reflect.DeepEqual(gvs, []string{"v1", "apps/v1beta1"}) {
+		t.Errorf("Unexpected preferred group versions %v", gvs)
+	}
+
+	namespaced, err := disco.ServerPreferredNamespacedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaced[0].APIResources) != 1 || namespaced[0].APIResources[0].Name != "configmaps" {
+		t.Errorf("Unexpected namespaced resources %v", namespaced[0])
+	}
+
+	mapper, err := RESTMapperFor(NewMemcachedDiscoveryClient(disco))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := mapper.RESTMapping(schema.GroupKind{Kind: "ConfigMap"}, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Resource != "configmaps" || mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		t.Errorf("Unexpected mapping %v", mapping)
+	}
+
+	v, err := FetchVersion(disco)
+	if err != nil || v.String() != "1.8" {
+		t.Errorf("Unexpected version %v, %v", v, err)
+	}
+	doc, err := disco.OpenAPISchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc.Definitions["io.k8s.api.core.v1.ConfigMap"]; !ok {
+		t.Errorf("Missing OpenAPI definition: %v", doc.Definitions)
+	}
+	if disco.RESTClient() != nil {
+		t.Errorf("Static client has a REST client")
+	}
+}
+
+func TestCaptureDiscoverySnapshot(t *testing.T) {
+	path := writeTestSnapshot(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	snapshot, err := ReadDiscoverySnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captured, err := CaptureDiscoverySnapshot(NewStaticDiscoveryClient(snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTrip DiscoverySnapshot
+	if err := json.Unmarshal(data, &roundTrip); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(roundTrip.Resources, snapshot.Resources) {
+		t.Errorf("Resources changed: %v", roundTrip.Resources)
+	}
+	if len(roundTrip.Groups.Groups) != 2 || roundTrip.Version.GitVersion != "v1.8.0" {
+		t.Errorf("Unexpected snapshot %v", roundTrip)
+	}
+	doc, err := NewStaticDiscoveryClient(&roundTrip).OpenAPISchema()
+	if err != nil || len(doc.Definitions) != 1 {
+		t.Errorf("OpenAPI schema was not captured: %v", err)
+	}
+}
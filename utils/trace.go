@@ -0,0 +1,312 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Tracer records timing spans, and passes them to an exporter when
+// flushed.  A nil *Tracer is valid, and records nothing.
+type Tracer struct {
+	Exporter SpanExporter
+	// Root is the parent of spans started without an explicit
+	// parent (eg: by NewTracingTransport)
+	Root *Span
+
+	mu      sync.Mutex
+	traceID string
+	ended   []*Span
+}
+
+// NewTracer returns a Tracer that sends spans to exporter
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{
+		Exporter: exporter,
+		traceID:  randomID(16),
+	}
+}
+
+// Span is a single timed operation
+type Span struct {
+	Name         string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]string
+
+	tracer *Tracer
+}
+
+// Start begins a new span.  If parent is nil, the span is a child of
+// t.Root (if any).
+func (t *Tracer) Start(parent *Span, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	if parent == nil {
+		parent = t.Root
+	}
+	s := &Span{
+		Name:       name,
+		TraceID:    t.traceID,
+		SpanID:     randomID(8),
+		StartTime:  time.Now(),
+		Attributes: map[string]string{},
+		tracer:     t,
+	}
+	if parent != nil {
+		s.ParentSpanID = parent.SpanID
+	}
+	return s
+}
+
+// SetAttribute records a key/value attribute on the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// SetObjectAttributes records the GroupVersionKind and name of obj
+// on the span
+func (s *Span) SetObjectAttributes(obj *unstructured.Unstructured) {
+	if s == nil {
+		return
+	}
+	gvk := obj.GroupVersionKind()
+	s.SetAttribute("k8s.group", gvk.Group)
+	s.SetAttribute("k8s.version", gvk.Version)
+	s.SetAttribute("k8s.kind", gvk.Kind)
+	s.SetAttribute("k8s.name", obj.GetName())
+	if ns := obj.GetNamespace(); ns != "" {
+		s.SetAttribute("k8s.namespace", ns)
+	}
+}
+
+// SetError records err on the span
+func (s *Span) SetError(err error) {
+	s.SetAttribute("error", err.Error())
+}
+
+// End completes the span
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.EndTime = time.Now()
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ended = append(t.ended, s)
+}
+
+// Flush exports all completed spans
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.ended
+	t.ended = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.Exporter.ExportSpans(spans)
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// SpanExporter receives completed spans
+type SpanExporter interface {
+	ExportSpans(spans []*Span) error
+}
+
+// InMemoryExporter is a SpanExporter that just remembers all spans
+type InMemoryExporter struct {
+	Spans []*Span
+}
+
+// ExportSpans implements SpanExporter
+func (e *InMemoryExporter) ExportSpans(spans []*Span) error {
+	e.Spans = append(e.Spans, spans...)
+	return nil
+}
+
+// OTLPExporter is a SpanExporter that sends spans to an
+// OpenTelemetry collector, using OTLP/HTTP with JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the collector base URL (eg:
+	// http://localhost:4318).  `/v1/traces` is appended if no
+	// path is given.
+	Endpoint    string
+	ServiceName string
+	Client      *http.Client
+}
+
+// NewOTLPExporter returns an OTLPExporter for the given endpoint
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint:    endpoint,
+		ServiceName: "kubecfg",
+		Client:      http.DefaultClient,
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, otlpKeyValue{Key: k, Value: otlpValue{StringValue: attrs[k]}})
+	}
+	return ret
+}
+
+func (e *OTLPExporter) url() string {
+	u := strings.TrimRight(e.Endpoint, "/")
+	if i := strings.Index(u, "://"); i < 0 || !strings.Contains(u[i+3:], "/") {
+		u += "/v1/traces"
+	}
+	return u
+}
+
+// ExportSpans implements SpanExporter
+func (e *OTLPExporter) ExportSpans(spans []*Span) error {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "github.com/ksonnet/kubecfg"
+	for _, s := range spans {
+		scope.Spans = append(scope.Spans, otlpSpan{
+			TraceID:      s.TraceID,
+			SpanID:       s.SpanID,
+			ParentSpanID: s.ParentSpanID,
+			Name:         s.Name,
+			// SPAN_KIND_INTERNAL
+			Kind:              1,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		})
+	}
+
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	rs.Resource.Attributes = otlpAttributes(map[string]string{"service.name": e.ServiceName})
+
+	data, err := json.Marshal(otlpTraceRequest{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.Client.Post(e.url(), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Error exporting traces to %s: %s", e.url(), resp.Status)
+	}
+	return nil
+}
+
+// NewTracingTransport returns a RoundTripper that records a span
+// for each request, as a child of tracer.Root.
+func NewTracingTransport(tracer *Tracer, rt http.RoundTripper) http.RoundTripper {
+	return &tracingTransport{Transport: rt, Tracer: tracer}
+}
+
+type tracingTransport struct {
+	Transport http.RoundTripper
+	Tracer    *Tracer
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := t.Tracer.Start(nil, fmt.Sprintf("%s %s", req.Method, req.URL.Path))
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.String())
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	}
+	span.End()
+	return resp, err
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start(nil, "noop")
+	span.SetAttribute("foo", "bar")
+	span.End()
+	if err := tracer.Flush(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+	tracer.Root = tracer.Start(nil, "root")
+
+	child := tracer.Start(nil, "child")
+	grandchild := tracer.Start(child, "grandchild")
+	grandchild.SetAttribute("foo", "bar")
+	grandchild.End()
+	child.End()
+	tracer.Root.End()
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exporter.Spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(exporter.Spans))
+	}
+	g, c, r := exporter.Spans[0], exporter.Spans[1], exporter.Spans[2]
+	if g.Name != "grandchild" || g.ParentSpanID != c.SpanID || g.Attributes["foo"] != "bar" {
+		t.Errorf("Unexpected span %#v", g)
+	}
+	if c.Name != "child" || c.ParentSpanID != r.SpanID {
+		t.Errorf("Unexpected span %#v", c)
+	}
+	if r.ParentSpanID != "" || r.TraceID != c.TraceID {
+		t.Errorf("Unexpected span %#v", r)
+	}
+	if g.EndTime.Before(g.StartTime) {
+		t.Errorf("Span ended before it started")
+	}
+
+	// Already flushed
+	exporter.Spans = nil
+	tracer.Flush()
+	if len(exporter.Spans) != 0 {
+		t.Errorf("Spans were exported twice")
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"pods","namespaced":true,"kind":"Pod"}]}`)
+	}))
+	defer srv.Close()
+
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+	tracer.Root = tracer.Start(nil, "root")
+
+	disco, err := discovery.NewDiscoveryClientForConfig(&rest.Config{
+		Host: srv.URL,
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return NewTracingTransport(tracer, rt)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := NewMemcachedDiscoveryClient(disco)
+
+	// Second call is a cache hit
+	for i := 0; i < 2; i++ {
+		if _, err := cached.ServerResourcesForGroupVersion("v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tracer.Flush()
+
+	if len(exporter.Spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(exporter.Spans))
+	}
+	s := exporter.Spans[0]
+	if s.Name != "GET /api/v1" || s.ParentSpanID != tracer.Root.SpanID || s.Attributes["http.status_code"] != "200" {
+		t.Errorf("Unexpected span %#v", s)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var req otlpTraceRequest
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	tracer := NewTracer(NewOTLPExporter(srv.URL))
+	span := tracer.Start(nil, "test")
+	span.SetAttribute("foo", "bar")
+	span.End()
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v1/traces" {
+		t.Errorf("Unexpected path %s", path)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected request %#v", req)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].Name != "test" || spans[0].TraceID != span.TraceID || len(spans[0].Attributes) != 1 {
+		t.Errorf("Unexpected spans %#v", spans)
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Values accepted by the server for the `fieldValidation` query
+// parameter (Kubernetes >= 1.25).  Older servers ignore the
+// parameter entirely.
+const (
+	FieldValidationStrict = "Strict"
+	FieldValidationWarn   = "Warn"
+	FieldValidationIgnore = "Ignore"
+)
+
+// ParseFieldValidation converts a (case-insensitive) command line
+// value into the form expected by the server.
+func ParseFieldValidation(s string) (string, error) {
+	for _, v := range []string{FieldValidationStrict, FieldValidationWarn, FieldValidationIgnore} {
+		if strings.EqualFold(s, v) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown field validation level: %s", s)
+}
+
+// WarningHandler is notified of each `Warning` header returned by
+// the server.
+type WarningHandler interface {
+	HandleWarningHeader(code int, agent string, text string)
+}
+
+// WarningLogger is a WarningHandler that logs each warning
+type WarningLogger struct{}
+
+// HandleWarningHeader implements WarningHandler
+func (WarningLogger) HandleWarningHeader(code int, agent string, text string) {
+	if code != 299 || text == "" {
+		return
+	}
+	log.Warn(text)
+}
+
+// NewWarningTransport returns a RoundTripper that passes any
+// `Warning` response headers to handler.
+func NewWarningTransport(handler WarningHandler, rt http.RoundTripper) http.RoundTripper {
+	return &warningTransport{Transport: rt, Handler: handler}
+}
+
+type warningTransport struct {
+	Transport http.RoundTripper
+	Handler   WarningHandler
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *warningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err == nil {
+		for _, h := range resp.Header[http.CanonicalHeaderKey("Warning")] {
+			code, agent, text, err := parseWarningHeader(h)
+			if err != nil {
+				log.Debugf("Ignoring malformed Warning header %q: %v", h, err)
+				continue
+			}
+			if h, ok := t.Handler.(objectWarningHandler); ok {
+				h.handleObjectWarning(warningObject(req), code, agent, text)
+			} else {
+				t.Handler.HandleWarningHeader(code, agent, text)
+			}
+		}
+	}
+	return resp, err
+}
+
+// parseWarningHeader parses a single RFC7234 warning value, of the
+// form `299 - "some text"`.
+func parseWarningHeader(h string) (int, string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(h), " ", 3)
+	if len(parts) != 3 {
+		return 0, "", "", fmt.Errorf("expected 3 fields")
+	}
+	code, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", "", err
+	}
+	text := strings.TrimSpace(parts[2])
+	// Trailing warn-date is optional
+	if i := strings.LastIndex(text, `" "`); i >= 0 {
+		text = text[:i+1]
+	}
+	text, err = strconv.Unquote(text)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return code, parts[1], text, nil
+}
+
+// NewFieldValidationTransport returns a RoundTripper that requests
+// the given level of server-side field validation on every write
+// (POST/PUT/PATCH) request.
+func NewFieldValidationTransport(level string, rt http.RoundTripper) http.RoundTripper {
+	return &fieldValidationTransport{Transport: rt, Level: level}
+}
+
+type fieldValidationTransport struct {
+	Transport http.RoundTripper
+	Level     string
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *fieldValidationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		// RoundTrippers must not modify the original request
+		r := new(http.Request)
+		*r = *req
+		u := *req.URL
+		q := u.Query()
+		q.Set("fieldValidation", t.Level)
+		u.RawQuery = q.Encode()
+		r.URL = &u
+		req = r
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// NewDryRunTransport returns a RoundTripper that makes every write
+// (POST/PUT/PATCH/DELETE) request a server-side dry-run.  Requires
+// Kubernetes 1.13 or later.
+func NewDryRunTransport(rt http.RoundTripper) http.RoundTripper {
+	return &dryRunTransport{Transport: rt}
+}
+
+type dryRunTransport struct {
+	Transport http.RoundTripper
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		// RoundTrippers must not modify the original request
+		r := new(http.Request)
+		*r = *req
+		u := *req.URL
+		q := u.Query()
+		q.Set("dryRun", "All")
+		u.RawQuery = q.Encode()
+		r.URL = &u
+		req = r
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// ApplyPatchType is the content type of server-side apply patches
+// (Kubernetes >= 1.16).  The vendored apimachinery predates it.
+const ApplyPatchType types.PatchType = "application/apply-patch+yaml"
+
+// NewServerSideApplyTransport returns a RoundTripper that adds the
+// field manager, and optionally `force`, to every server-side apply
+// (ApplyPatchType) request.  The dynamic client offers no other way
+// to set these query parameters.
+func NewServerSideApplyTransport(fieldManager string, force bool, rt http.RoundTripper) http.RoundTripper {
+	return &serverSideApplyTransport{Transport: rt, FieldManager: fieldManager, Force: force}
+}
+
+type serverSideApplyTransport struct {
+	Transport    http.RoundTripper
+	FieldManager string
+	Force        bool
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *serverSideApplyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPatch && req.Header.Get("Content-Type") == string(ApplyPatchType) {
+		// RoundTrippers must not modify the original request
+		r := new(http.Request)
+		*r = *req
+		u := *req.URL
+		q := u.Query()
+		q.Set("fieldManager", t.FieldManager)
+		if t.Force {
+			q.Set("force", "true")
+		}
+		u.RawQuery = q.Encode()
+		r.URL = &u
+		req = r
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// NewFieldManagerTransport returns a RoundTripper that adds the
+// field manager to every write (POST/PUT/PATCH) request that doesn't
+// already specify one.  Without it, the server derives the manager
+// from the User-Agent.
+func NewFieldManagerTransport(fieldManager string, rt http.RoundTripper) http.RoundTripper {
+	return &fieldManagerTransport{Transport: rt, FieldManager: fieldManager}
+}
+
+type fieldManagerTransport struct {
+	Transport    http.RoundTripper
+	FieldManager string
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *fieldManagerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		if req.URL.Query().Get("fieldManager") != "" {
+			break
+		}
+		// RoundTrippers must not modify the original request
+		r := new(http.Request)
+		*r = *req
+		u := *req.URL
+		q := u.Query()
+		q.Set("fieldManager", t.FieldManager)
+		u.RawQuery = q.Encode()
+		r.URL = &u
+		req = r
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// ImpersonateUIDHeader is the header used to impersonate a user's
+// UID (Kubernetes >= 1.22).  The vendored client-go only supports
+// impersonating user names, groups and extra fields.
+const ImpersonateUIDHeader = "Impersonate-Uid"
+
+// NewImpersonateUIDTransport returns a RoundTripper that adds an
+// ImpersonateUIDHeader to every request.  The server rejects
+// requests that impersonate a UID without also impersonating a user.
+func NewImpersonateUIDTransport(uid string, rt http.RoundTripper) http.RoundTripper {
+	return &impersonateUIDTransport{Transport: rt, UID: uid}
+}
+
+type impersonateUIDTransport struct {
+	Transport http.RoundTripper
+	UID       string
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *impersonateUIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the original request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set(ImpersonateUIDHeader, t.UID)
+	return t.Transport.RoundTrip(r)
+}
+
+// NewContextTransport returns a RoundTripper that aborts requests
+// when ctx is done.  The client-go version used by kubecfg predates
+// per-request contexts, so this is how clients (and discovery) are
+// bound to a caller's deadline.
+func NewContextTransport(ctx context.Context, rt http.RoundTripper) http.RoundTripper {
+	return &contextTransport{Transport: rt, ctx: ctx}
+}
+
+type contextTransport struct {
+	Transport http.RoundTripper
+	ctx       context.Context
+}
+
+// RoundTrip is required for the http.RoundTripper interface
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-t.ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	var once sync.Once
+	done := func() {
+		once.Do(func() { close(stop) })
+		cancel()
+	}
+
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		done()
+		if ctxErr := t.ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	// ctx continues to apply while reading the body
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: done}
+	return resp, nil
+}
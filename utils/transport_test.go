@@ -0,0 +1,266 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+type recordingWarningHandler []string
+
+func (h *recordingWarningHandler) HandleWarningHeader(code int, agent string, text string) {
+	*h = append(*h, text)
+}
+
+func TestParseFieldValidation(t *testing.T) {
+	for in, expected := range map[string]string{
+		"strict": FieldValidationStrict,
+		"Warn":   FieldValidationWarn,
+		"IGNORE": FieldValidationIgnore,
+	} {
+		v, err := ParseFieldValidation(in)
+		if err != nil {
+			t.Errorf("%s failed: %v", in, err)
+		} else if v != expected {
+			t.Errorf("%s: expected %s, got %s", in, expected, v)
+		}
+	}
+
+	if _, err := ParseFieldValidation("bogus"); err == nil {
+		t.Errorf("bogus value was accepted")
+	}
+}
+
+func TestParseWarningHeader(t *testing.T) {
+	code, agent, text, err := parseWarningHeader(`299 - "unknown field \"spec.foo\""`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if code != 299 || agent != "-" || text != `unknown field "spec.foo"` {
+		t.Errorf("Unexpected result: %d %q %q", code, agent, text)
+	}
+
+	if _, _, _, err := parseWarningHeader(`garbage`); err == nil {
+		t.Errorf("malformed header was accepted")
+	}
+}
+
+func fieldValidationServer(t *testing.T, seen *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		level := r.URL.Query().Get("fieldValidation")
+		*seen = append(*seen, fmt.Sprintf("%s=%s", r.Method, level))
+
+		w.Header().Set("Content-Type", "application/json")
+		switch level {
+		case FieldValidationStrict:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"BadRequest","code":400,"message":"strict decoding error: unknown field \"spec.foo\""}`)
+		case FieldValidationWarn:
+			w.Header().Add("Warning", `299 - "unknown field \"spec.foo\""`)
+			fmt.Fprint(w, `{"kind":"Test","apiVersion":"tests/v1alpha1","metadata":{"name":"myobj"}}`)
+		default:
+			fmt.Fprint(w, `{"kind":"Test","apiVersion":"tests/v1alpha1","metadata":{"name":"myobj"}}`)
+		}
+	}))
+}
+
+func fieldValidationClient(t *testing.T, host, level string, handler WarningHandler) *dynamic.ResourceClient {
+	gv := schema.GroupVersion{Group: "tests", Version: "v1alpha1"}
+	conf := &rest.Config{
+		Host:    host,
+		APIPath: "/apis",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion: &gv,
+		},
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return NewWarningTransport(handler, NewFieldValidationTransport(level, rt))
+		},
+	}
+	client, err := dynamic.NewClient(conf)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return client.Resource(&metav1.APIResource{Name: "tests", Namespaced: true}, "default")
+}
+
+func TestFieldValidationTransport(t *testing.T) {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "tests/v1alpha1",
+			"kind":       "Test",
+			"metadata": map[string]interface{}{
+				"name": "myobj",
+			},
+			"spec": map[string]interface{}{
+				"foo": "typo",
+			},
+		},
+	}
+
+	var seen []string
+	srv := fieldValidationServer(t, &seen)
+	defer srv.Close()
+
+	var warnings recordingWarningHandler
+
+	rc := fieldValidationClient(t, srv.URL, FieldValidationStrict, &warnings)
+	if _, err := rc.Get("myobj", metav1.GetOptions{}); err != nil {
+		t.Errorf("Get failed: %v", err)
+	}
+	_, err := rc.Create(obj)
+	if !errors.IsBadRequest(err) || !strings.Contains(err.Error(), `unknown field "spec.foo"`) {
+		t.Errorf("Expected unknown field error, got %v", err)
+	}
+
+	rc = fieldValidationClient(t, srv.URL, FieldValidationWarn, &warnings)
+	if _, err := rc.Patch("myobj", types.MergePatchType, []byte(`{"spec":{"foo":"typo"}}`)); err != nil {
+		t.Errorf("Patch failed: %v", err)
+	}
+
+	expected := []string{"GET=", "POST=Strict", "PATCH=Warn"}
+	if strings.Join(seen, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected requests: %v", seen)
+	}
+	if len(warnings) != 1 || warnings[0] != `unknown field "spec.foo"` {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+}
+
+func TestDryRunTransport(t *testing.T) {
+	var seen []string
+	rt := NewDryRunTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		seen = append(seen, fmt.Sprintf("%s %s", req.Method, req.URL.RequestURI()))
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}))
+
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		req, _ := http.NewRequest(method, "http://example.com/api/v1/pods?fieldValidation=Strict", nil)
+		if _, err := rt.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		if req.URL.RawQuery != "fieldValidation=Strict" {
+			t.Errorf("Original %s request was modified: %s", method, req.URL)
+		}
+	}
+
+	expected := []string{
+		"GET /api/v1/pods?fieldValidation=Strict",
+		"POST /api/v1/pods?dryRun=All&fieldValidation=Strict",
+		"PUT /api/v1/pods?dryRun=All&fieldValidation=Strict",
+		"PATCH /api/v1/pods?dryRun=All&fieldValidation=Strict",
+		"DELETE /api/v1/pods?dryRun=All&fieldValidation=Strict",
+	}
+	if strings.Join(seen, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected requests:\n%s", strings.Join(seen, "\n"))
+	}
+}
+
+func TestImpersonateUIDTransport(t *testing.T) {
+	var seen http.Header
+	rt := NewImpersonateUIDTransport("1234", roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		seen = req.Header
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}))
+
+	req, _ := http.NewRequest("GET", "http://example.com/api", nil)
+	req.Header.Set("Impersonate-User", "jane")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if seen.Get(ImpersonateUIDHeader) != "1234" || seen.Get("Impersonate-User") != "jane" {
+		t.Errorf("Unexpected headers: %v", seen)
+	}
+	if req.Header.Get(ImpersonateUIDHeader) != "" {
+		t.Errorf("Original request was modified: %v", req.Header)
+	}
+}
+
+func TestServerSideApplyTransport(t *testing.T) {
+	var seen []string
+	for _, force := range []bool{false, true} {
+		rt := NewServerSideApplyTransport("kubecfg", force, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			seen = append(seen, fmt.Sprintf("%s %s", req.Method, req.URL.RequestURI()))
+			return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+		}))
+		for _, pt := range []string{"application/merge-patch+json", string(ApplyPatchType)} {
+			req, _ := http.NewRequest("PATCH", "http://example.com/api/v1/pods/x", nil)
+			req.Header.Set("Content-Type", pt)
+			if _, err := rt.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if req.URL.RawQuery != "" {
+				t.Errorf("Original request was modified: %s", req.URL)
+			}
+		}
+	}
+
+	expected := []string{
+		"PATCH /api/v1/pods/x",
+		"PATCH /api/v1/pods/x?fieldManager=kubecfg",
+		"PATCH /api/v1/pods/x",
+		"PATCH /api/v1/pods/x?fieldManager=kubecfg&force=true",
+	}
+	if strings.Join(seen, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected requests:\n%s", strings.Join(seen, "\n"))
+	}
+}
+
+func TestFieldManagerTransport(t *testing.T) {
+	var seen []string
+	rt := NewFieldManagerTransport("my-pipeline", roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		seen = append(seen, fmt.Sprintf("%s %s", req.Method, req.URL.RequestURI()))
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}))
+	for _, r := range []struct{ method, url string }{
+		{"GET", "http://example.com/api/v1/pods/x"},
+		{"PATCH", "http://example.com/api/v1/pods/x"},
+		{"POST", "http://example.com/api/v1/pods"},
+		{"PATCH", "http://example.com/api/v1/pods/x?fieldManager=other"},
+		{"DELETE", "http://example.com/api/v1/pods/x"},
+	} {
+		req, _ := http.NewRequest(r.method, r.url, nil)
+		orig := req.URL.RawQuery
+		if _, err := rt.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		if req.URL.RawQuery != orig {
+			t.Errorf("Original request was modified: %s", req.URL)
+		}
+	}
+
+	expected := []string{
+		"GET /api/v1/pods/x",
+		"PATCH /api/v1/pods/x?fieldManager=my-pipeline",
+		"POST /api/v1/pods?fieldManager=my-pipeline",
+		"PATCH /api/v1/pods/x?fieldManager=other",
+		"DELETE /api/v1/pods/x",
+	}
+	if strings.Join(seen, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected requests:\n%s", strings.Join(seen, "\n"))
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// objectWarningHandler is implemented by WarningHandlers that want
+// to know which object each warning was about
+type objectWarningHandler interface {
+	handleObjectWarning(object string, code int, agent string, text string)
+}
+
+var createSeq struct {
+	sync.Mutex
+	n int
+}
+
+// warningObject returns a key identifying the object req is about.
+// Requests to the same object path (eg: a GET followed by a PATCH)
+// share a key.  Each create is a different object, even though it
+// is posted to the collection path.
+func warningObject(req *http.Request) string {
+	if req.Method != http.MethodPost {
+		return req.URL.Path
+	}
+	createSeq.Lock()
+	defer createSeq.Unlock()
+	createSeq.n++
+	return fmt.Sprintf("%s#%d", req.URL.Path, createSeq.n)
+}
+
+// WarningCollector is a WarningHandler that aggregates warnings over
+// a whole run, so they can be summarised (once each) at the end.
+type WarningCollector struct {
+	mu sync.Mutex
+	// objects records the objects each warning was returned for
+	// (warning text -> set of objects)
+	objects map[string]map[string]bool
+}
+
+// NewWarningCollector returns an empty WarningCollector
+func NewWarningCollector() *WarningCollector {
+	return &WarningCollector{objects: map[string]map[string]bool{}}
+}
+
+// HandleWarningHeader implements WarningHandler
+func (c *WarningCollector) HandleWarningHeader(code int, agent string, text string) {
+	c.handleObjectWarning("", code, agent, text)
+}
+
+func (c *WarningCollector) handleObjectWarning(object string, code int, agent string, text string) {
+	if code != 299 || text == "" {
+		return
+	}
+	log.Debugf("Warning for %s: %s", object, text)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.objects[text] == nil {
+		c.objects[text] = map[string]bool{}
+	}
+	c.objects[text][object] = true
+}
+
+// Len returns the number of distinct warnings collected
+func (c *WarningCollector) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.objects)
+}
+
+// deprecatedRegexp matches the server's deprecated API version
+// warnings, eg: "extensions/v1beta1 Ingress is deprecated in v1.14+,
+// unavailable in v1.22+; use networking.k8s.io/v1 Ingress"
+var deprecatedRegexp = regexp.MustCompile(`^(\S+ \S+) is deprecated`)
+
+// Summary returns one line for each distinct warning, in a stable
+// order
+func (c *WarningCollector) Summary() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ret := make([]string, 0, len(c.objects))
+	for text, objects := range c.objects {
+		n := len(objects)
+		objs := "objects"
+		if n == 1 {
+			objs = "object"
+		}
+		if m := deprecatedRegexp.FindStringSubmatch(text); m != nil {
+			use := "use"
+			if n == 1 {
+				use = "uses"
+			}
+			ret = append(ret, fmt.Sprintf("%d %s %s deprecated %s (%s)", n, objs, use, m[1], text))
+		} else {
+			ret = append(ret, fmt.Sprintf("%s (%d %s)", text, n, objs))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
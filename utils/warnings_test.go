@@ -0,0 +1,95 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestWarningCollector(t *testing.T) {
+	const deprecated = `extensions/v1beta1 Ingress is deprecated in v1.14+, unavailable in v1.22+; use networking.k8s.io/v1 Ingress`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Warning", fmt.Sprintf("299 - %q", deprecated))
+		if r.Method == "GET" && r.URL.Path == "/apis/extensions/v1beta1/namespaces/default/ingresses/other" {
+			w.Header().Add("Warning", `299 - "unknown field \"spec.foo\""`)
+		}
+		fmt.Fprint(w, `{"kind":"Ingress","apiVersion":"extensions/v1beta1","metadata":{"name":"myobj"}}`)
+	}))
+	defer srv.Close()
+
+	collector := NewWarningCollector()
+	gv := schema.GroupVersion{Group: "extensions", Version: "v1beta1"}
+	client, err := dynamic.NewClient(&rest.Config{
+		Host:    srv.URL,
+		APIPath: "/apis",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion: &gv,
+		},
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return NewWarningTransport(collector, rt)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := client.Resource(&metav1.APIResource{Name: "ingresses", Namespaced: true}, "default")
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "extensions/v1beta1",
+		"kind":       "Ingress",
+		"metadata":   map[string]interface{}{"name": "myobj"},
+	}}
+	// Two requests for the same object, two creates, and a
+	// different object
+	for _, f := range []func() error{
+		func() error { _, err := rc.Get("myobj", metav1.GetOptions{}); return err },
+		func() error { _, err := rc.Update(obj); return err },
+		func() error { _, err := rc.Create(obj); return err },
+		func() error { _, err := rc.Create(obj); return err },
+		func() error { _, err := rc.Get("other", metav1.GetOptions{}); return err },
+	} {
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"4 objects use deprecated extensions/v1beta1 Ingress (" + deprecated + ")",
+		`unknown field "spec.foo" (1 object)`,
+	}
+	if summary := collector.Summary(); !reflect.DeepEqual(summary, expected) {
+		t.Errorf("Unexpected summary:\n%q\nexpected:\n%q", summary, expected)
+	}
+	if collector.Len() != 2 {
+		t.Errorf("Expected 2 distinct warnings, got %d", collector.Len())
+	}
+
+	collector.HandleWarningHeader(199, "-", "miscellaneous warning")
+	if collector.Len() != 2 {
+		t.Errorf("Non-299 warning was collected")
+	}
+}